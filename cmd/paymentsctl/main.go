@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/ctl"
+	"log"
+	"os"
+	"os/signal"
+)
+
+// main runs the paymentsctl command given in the arguments. The config is read from the same environment variables
+// used by the payments service.
+func main() {
+	logger := log.New(os.Stderr, "[paymentsctl] ", log.LstdFlags|log.Lmsgprefix)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	setup := func() (ctl.Environment, error) {
+		var cfg conf.Config
+		if err := cfg.Parse(); err != nil {
+			return ctl.Environment{}, fmt.Errorf("failed to parse config: %w", err)
+		}
+		return ctl.NewEnvironment(cfg, logger)
+	}
+
+	if err := ctl.Run(ctx, os.Args[1:], os.Stdout, setup); err != nil {
+		logger.Println(err)
+		cancel()
+		os.Exit(1)
+	}
+}
@@ -1,26 +1,286 @@
 package conf
 
 import (
+	"errors"
+	"fmt"
 	"github.com/caarlos0/env/v6"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// KeyValues is a set of key-value pairs that can be parsed from an environment variable.
+// Pairs are separated by commas, keys and values are separated by colons. Example: key1:value1,key2:value2
+type KeyValues map[string]string
+
+// UnmarshalText fills the current KeyValues from the given text.
+func (kv *KeyValues) UnmarshalText(text []byte) error {
+	m := make(KeyValues)
+	for _, pair := range strings.Split(string(text), ",") {
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return errors.New("invalid key-value pair")
+		}
+		m[parts[0]] = parts[1]
+	}
+	*kv = m
+	return nil
+}
+
+// ErrMissingSigningKey is returned when no webhook signing key has been configured and it can't be obtained by
+// registering the webhook endpoint.
+var ErrMissingSigningKey = errors.New("missing PAYMENTS_STRIPE_SIGNING_KEY or PAYMENTS_STRIPE_SIGNING_KEYS, required unless PAYMENTS_STRIPE_WEBHOOK_URL is set")
+
 // Stripe contains the needed config to interact with the stripe API.
 type Stripe struct {
-	// SigningKey is the key used when checking webhook event signatures.
-	SigningKey string `env:"PAYMENTS_STRIPE_SIGNING_KEY,required"`
+	// SigningKey is the key used when checking webhook event signatures. It's only optional if WebhookURL is set.
+	SigningKey string `env:"PAYMENTS_STRIPE_SIGNING_KEY"`
+
+	// SigningKeys contains additional keys accepted when checking webhook event signatures. It's used to rotate
+	// signing keys without dropping events: the previous key is kept here until every replica uses the new one.
+	// Example: whsec_old1,whsec_old2
+	SigningKeys []string `env:"PAYMENTS_STRIPE_SIGNING_KEYS" envSeparator:","`
 
 	// SecretKey is the key used to allow the stripe client use the stripe API.
 	SecretKey string `env:"PAYMENTS_STRIPE_SECRET_KEY,required"`
 
 	// URL is the backend stripe API url, only used for testing purposes.
 	URL string `env:"PAYMENTS_STRIPE_URL"`
+
+	// WebhookURL is the public URL of the Stripe webhook handler. If set, a webhook endpoint subscribed to the handled
+	// events is registered in Stripe on startup. Example: https://payments.example.com/payments/webhooks/stripe
+	WebhookURL string `env:"PAYMENTS_STRIPE_WEBHOOK_URL"`
+
+	// WebhookSecretFile is the file where the signing secret of the webhook endpoint registered on startup is stored.
+	// It's read on startup if SigningKey is empty.
+	WebhookSecretFile string `env:"PAYMENTS_STRIPE_WEBHOOK_SECRET_FILE"`
+
+	// WebhookUpdateEvents enables updating the webhook endpoints registered on startup if they're subscribed to
+	// different events than the handled ones, or disabled. The differences are only logged otherwise.
+	WebhookUpdateEvents bool `env:"PAYMENTS_STRIPE_WEBHOOK_UPDATE_EVENTS" envDefault:"false"`
+
+	// ConnectWebhookSecretFile is the file where the signing secret of the Connect webhook endpoint registered on
+	// startup is stored. The Connect endpoint is only registered if Stripe Connect is enabled.
+	ConnectWebhookSecretFile string `env:"PAYMENTS_STRIPE_CONNECT_WEBHOOK_SECRET_FILE"`
+
+	// WebhookTolerance is the maximum age of the timestamp of a webhook event signature. Older events are rejected.
+	WebhookTolerance time.Duration `env:"PAYMENTS_STRIPE_WEBHOOK_TOLERANCE" envDefault:"5m"`
+
+	// WebhookReplayWindow is the amount of time the IDs and signatures of received webhook events are remembered in
+	// order to reject replayed events. Replay protection is disabled if set to 0.
+	WebhookReplayWindow time.Duration `env:"PAYMENTS_STRIPE_WEBHOOK_REPLAY_WINDOW" envDefault:"72h"`
+}
+
+// ConnectWebhookURL returns the public URL of the Stripe webhook handler of connected account events. It's the
+// WebhookURL followed by /connect.
+func (c Stripe) ConnectWebhookURL() string {
+	return strings.TrimSuffix(c.WebhookURL, "/") + "/connect"
 }
 
 // Parse fills Stripe data from an external source.
 func (c *Stripe) Parse() error {
-	return env.Parse(c)
+	if err := env.Parse(c); err != nil {
+		return err
+	}
+	if len(c.AllSigningKeys()) == 0 && len(c.WebhookURL) == 0 {
+		return ErrMissingSigningKey
+	}
+	return nil
+}
+
+// AllSigningKeys returns the list of keys accepted when checking webhook event signatures. SigningKey goes first,
+// empty and duplicated keys are skipped.
+func (c Stripe) AllSigningKeys() []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, key := range append([]string{c.SigningKey}, c.SigningKeys...) {
+		key = strings.TrimSpace(key)
+		if len(key) == 0 || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, key)
+	}
+	return out
+}
+
+// Livemode returns true if SecretKey is a live mode key. Test mode keys are used otherwise.
+func (c Stripe) Livemode() bool {
+	return strings.HasPrefix(c.SecretKey, "sk_live_") || strings.HasPrefix(c.SecretKey, "rk_live_")
+}
+
+// Auth contains the needed config to authenticate callers of the payments API.
+// If no authentication method is configured, authentication is disabled.
+type Auth struct {
+	// APIKeys maps static API keys to the application they have been issued for. Example: 6d1f3a:fuel,9b2c4e:cloudsim
+	APIKeys KeyValues `env:"PAYMENTS_AUTH_API_KEYS"`
+
+	// HMACSecrets maps applications to the shared secret used to sign their requests. Example: fuel:secret1,cloudsim:secret2
+	HMACSecrets KeyValues `env:"PAYMENTS_AUTH_HMAC_SECRETS"`
+
+	// HMACTolerance is the maximum clock difference allowed for HMAC signed requests.
+	HMACTolerance time.Duration `env:"PAYMENTS_AUTH_HMAC_TOLERANCE" envDefault:"5m"`
+
+	// JWTSecret is the key used to verify HS256 signed JSON Web Tokens.
+	JWTSecret string `env:"PAYMENTS_AUTH_JWT_SECRET"`
+
+	// JWTIssuer is the expected issuer of JSON Web Tokens. It's not checked if empty.
+	JWTIssuer string `env:"PAYMENTS_AUTH_JWT_ISSUER"`
+
+	// JWTAudience is the expected audience of JSON Web Tokens. It's not checked if empty.
+	JWTAudience string `env:"PAYMENTS_AUTH_JWT_AUDIENCE"`
+
+	// JWTMaxLifetime is the maximum time a JSON Web Token can be valid for. Tokens expiring later are rejected. It's not
+	// checked if set to 0.
+	JWTMaxLifetime time.Duration `env:"PAYMENTS_AUTH_JWT_MAX_LIFETIME" envDefault:"24h"`
+}
+
+// Enabled returns true if at least one authentication method has been configured.
+func (c Auth) Enabled() bool {
+	return len(c.APIKeys) > 0 || len(c.HMACSecrets) > 0 || len(c.JWTSecret) > 0
+}
+
+// RateLimit contains the limits applied when creating payment sessions. A limit is disabled if any of its values is 0.
+type RateLimit struct {
+	// ApplicationPerMinute is the amount of sessions an application can create per minute.
+	ApplicationPerMinute uint `env:"PAYMENTS_RATE_LIMIT_APPLICATION_PER_MINUTE" envDefault:"0"`
+
+	// ApplicationBurst is the amount of sessions an application can create at once.
+	ApplicationBurst uint `env:"PAYMENTS_RATE_LIMIT_APPLICATION_BURST" envDefault:"0"`
+
+	// HandlePerMinute is the amount of sessions that can be created per minute for a single handle.
+	HandlePerMinute uint `env:"PAYMENTS_RATE_LIMIT_HANDLE_PER_MINUTE" envDefault:"0"`
+
+	// HandleBurst is the amount of sessions that can be created at once for a single handle.
+	HandleBurst uint `env:"PAYMENTS_RATE_LIMIT_HANDLE_BURST" envDefault:"0"`
+}
+
+// Backfill contains the config of the job that recovers payment service events missed by the webhook handler.
+type Backfill struct {
+	// Interval is the time between scheduled backfill runs. Scheduled backfills are disabled if set to 0.
+	Interval time.Duration `env:"PAYMENTS_BACKFILL_INTERVAL" envDefault:"0"`
+
+	// Window is how far back in time each scheduled run looks for events. Stripe only keeps events for 30 days.
+	Window time.Duration `env:"PAYMENTS_BACKFILL_WINDOW" envDefault:"720h"`
+
+	// Delay is the age an event must reach before a scheduled run processes it. It should match the period the
+	// payment service keeps retrying webhook deliveries, 3 days for Stripe.
+	Delay time.Duration `env:"PAYMENTS_BACKFILL_DELAY" envDefault:"72h"`
+}
+
+// Breaker contains the config of the circuit breakers protecting the credits service, the customers service and the
+// payment service. Breakers are disabled if Failures is 0.
+type Breaker struct {
+	// Failures is the amount of consecutive failed calls to a dependency that open its breaker.
+	Failures uint `env:"PAYMENTS_CIRCUIT_BREAKER_FAILURES" envDefault:"5"`
+
+	// Cooldown is the amount of time an open breaker rejects calls before letting a probe call through.
+	Cooldown time.Duration `env:"PAYMENTS_CIRCUIT_BREAKER_COOLDOWN" envDefault:"30s"`
+}
+
+// Cache contains the config of the caches of the lookups made to the credits service and the customers service. Values
+// are not kept if a TTL is 0, but concurrent lookups of the same value are still coalesced.
+type Cache struct {
+	// UnitPriceTTL is the amount of time the unit prices returned by the credits service are kept.
+	UnitPriceTTL time.Duration `env:"PAYMENTS_CACHE_UNIT_PRICE_TTL" envDefault:"5m"`
+
+	// CustomerTTL is the amount of time the customers returned by the customers service are kept.
+	CustomerTTL time.Duration `env:"PAYMENTS_CACHE_CUSTOMER_TTL" envDefault:"1h"`
+
+	// CustomerMaxEntries is the maximum amount of customers kept. It's not limited if set to 0.
+	CustomerMaxEntries int `env:"PAYMENTS_CACHE_CUSTOMER_MAX_ENTRIES" envDefault:"10000"`
+}
+
+// Catalog contains the config of the credits products and prices defined in Stripe. Checkout sessions use the synced
+// prices of the listed applications, and an inline price otherwise. The catalog is disabled if no application is
+// listed.
+type Catalog struct {
+	// Applications contains the applications that have a credits product. Example: fuel,cloudsim
+	Applications []string `env:"PAYMENTS_CATALOG_APPLICATIONS" envSeparator:","`
+
+	// Currencies contains the ISO 4217 currencies, in lowercase format, each product has a price in.
+	Currencies []string `env:"PAYMENTS_CATALOG_CURRENCIES" envSeparator:"," envDefault:"usd"`
+}
+
+// Enabled returns true if at least one application has a credits product.
+func (c Catalog) Enabled() bool {
+	return len(c.Applications) > 0
+}
+
+// Database contains the config for initializing an SQL database. The database is used to record the credits granted
+// to customers, it's disabled if no host is defined.
+type Database struct {
+	// Username is the database username.
+	Username string `env:"PAYMENTS_DATABASE_USERNAME"`
+
+	// Password is the database password.
+	Password string `env:"PAYMENTS_DATABASE_PASSWORD"`
+
+	// Host is host on which the SQL server instance is running.
+	Host string `env:"PAYMENTS_DATABASE_HOST"`
+
+	// Port is the TPC/IP network port on which the target SQL server is listening for connections.
+	Port uint `env:"PAYMENTS_DATABASE_PORT" envDefault:"3306"`
+
+	// Name is the name of the database for the connection.
+	Name string `env:"PAYMENTS_DATABASE_NAME"`
+
+	// Charset is the name of the set of characters that are legal in a string.
+	// Defaults to UTF-8.
+	Charset string `env:"PAYMENTS_DATABASE_CHARSET" envDefault:"utf8"`
+
+	// LedgerSince is the time since which every grant has been recorded in the ledger, in RFC 3339 format, e.g. the
+	// time the ledger was rolled out. Payments created before it are never charged by backfills nor reconciliation
+	// repairs, as there's no record of whether their credits have been granted. Example: 2021-11-01T00:00:00Z
+	LedgerSince time.Time `env:"PAYMENTS_LEDGER_SINCE"`
+}
+
+// Enabled returns true if a database has been configured.
+func (db Database) Enabled() bool {
+	return len(db.Host) > 0
+}
+
+// ToDSN converts the Database config into a valid MySQL data source name.
+func (db Database) ToDSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=True&loc=Local",
+		db.Username, db.Password, db.Host, db.Port, db.Name, db.Charset,
+	)
+}
+
+// ErrInvalidApplicationFee is returned when an application fee is not a percentage between 0 and 100.
+var ErrInvalidApplicationFee = errors.New("invalid application fee in PAYMENTS_CONNECT_APPLICATION_FEES")
+
+// Connect contains the config of Stripe Connect, used to pay application owners a share of the payments made in
+// their applications.
+type Connect struct {
+	// ApplicationFees maps applications to the percentage of each payment kept by the platform. Only the listed
+	// applications can create a connected account. Connect is disabled if empty. Example: fuel:10,cloudsim:12.5
+	ApplicationFees KeyValues `env:"PAYMENTS_CONNECT_APPLICATION_FEES"`
+
+	// Country is the ISO 3166-1 alpha-2 country code used when creating connected accounts.
+	Country string `env:"PAYMENTS_CONNECT_COUNTRY" envDefault:"US"`
+}
+
+// Enabled returns true if at least one application can create a connected account.
+func (c Connect) Enabled() bool {
+	return len(c.ApplicationFees) > 0
+}
+
+// Fees returns the application fee percentage of every application listed in ApplicationFees.
+func (c Connect) Fees() (map[string]float64, error) {
+	out := make(map[string]float64, len(c.ApplicationFees))
+	for app, value := range c.ApplicationFees {
+		fee, err := strconv.ParseFloat(value, 64)
+		if err != nil || fee < 0 || fee > 100 {
+			return nil, fmt.Errorf("%w: %s:%s", ErrInvalidApplicationFee, app, value)
+		}
+		out[app] = fee
+	}
+	return out, nil
 }
 
 // Config contains the needed config to start the Payments HTTP server.
@@ -28,11 +288,46 @@ type Config struct {
 	// Stripe contains configuration for the stripe client.
 	Stripe Stripe
 
+	// Auth contains configuration to authenticate callers of the payments API.
+	Auth Auth
+
+	// RateLimit contains configuration to limit the amount of payment sessions created.
+	RateLimit RateLimit
+
+	// Backfill contains configuration to recover payment service events missed by the webhook handler.
+	Backfill Backfill
+
+	// Database contains the configuration needed to open an SQL connection.
+	Database Database
+
+	// Connect contains configuration to share payments with application owners.
+	Connect Connect
+
+	// Breaker contains configuration to stop calling dependencies that keep failing.
+	Breaker Breaker
+
+	// Cache contains configuration to keep the values returned by lookups to other services.
+	Cache Cache
+
+	// Catalog contains configuration to sell credits with the products and prices defined in the payment service.
+	Catalog Catalog
+
 	// Port is the TCP port to listen to for incoming HTTP requests.
 	Port uint `env:"PAYMENTS_HTTP_SERVER_PORT" envDefault:"80"`
 
+	// GRPCPort is the TCP port to listen to for incoming gRPC requests. The gRPC server is disabled if set to 0.
+	GRPCPort uint `env:"PAYMENTS_GRPC_SERVER_PORT" envDefault:"0"`
+
+	// IdempotencyTTL is the amount of time the responses of requests made with an idempotency key are remembered.
+	// Idempotency keys are ignored if set to 0.
+	IdempotencyTTL time.Duration `env:"PAYMENTS_IDEMPOTENCY_TTL" envDefault:"24h"`
+
+	// Currency is the ISO 4217 currency, in lowercase format, checkout sessions are paid in. The unit price of a credit
+	// in this currency is requested to the credits service.
+	Currency string `env:"PAYMENTS_CURRENCY" envDefault:"usd"`
+
 	// Timeout is used as the amount of time requests originated from the payments service should wait until it fails due
-	// to timeout.
+	// to timeout. It bounds each call, consecutive failures are handled by the circuit breakers configured in Breaker.
 	Timeout time.Duration `env:"PAYMENTS_CIRCUIT_BREAKER_TIMEOUT" envDefault:"30s"`
 
 	// CreditsURL contains the URL to the credits service.
@@ -47,5 +342,11 @@ func (c *Config) Parse() error {
 	if err := c.Stripe.Parse(); err != nil {
 		return err
 	}
-	return env.Parse(c)
+	if err := env.Parse(c); err != nil {
+		return err
+	}
+	if _, err := c.Connect.Fees(); err != nil {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,78 @@
+package ctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/backfill"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// ErrInvalidFormat is returned when an unsupported output format is requested.
+var ErrInvalidFormat = errors.New("invalid format")
+
+func runBackfill(ctx context.Context, out io.Writer, setup func() (Environment, error), args []string) error {
+	fs := flags("backfill", out)
+	since := fs.Duration("since", 720*time.Hour, "check events created after this period ago")
+	until := fs.Duration("until", 0, "check events created before this period ago")
+	dryRun := fs.Bool("dry-run", false, "report the missed events without processing them")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("%w: %s", ErrInvalidFormat, *format)
+	}
+
+	env, err := setup()
+	if err != nil {
+		return err
+	}
+	// The ledger is the only record of the payments charged whose metadata couldn't be updated.
+	if env.Ledger == nil && !*dryRun {
+		return ErrLedgerDisabled
+	}
+
+	now := time.Now()
+	b := backfill.NewBackfiller(backfill.Options{
+		Inspector: env.Inspector,
+		Charger:   env.Charger,
+		Events:    env.Events,
+		Since:     env.LedgerSince,
+	})
+	report, runErr := b.Run(ctx, now.Add(-*since), now.Add(-*until), *dryRun)
+	if runErr != nil && !errors.Is(runErr, backfill.ErrIncomplete) {
+		return runErr
+	}
+
+	if *format == "json" {
+		err = writeJSON(out, report)
+	} else {
+		err = writeBackfillReport(out, report)
+	}
+	if err != nil {
+		return err
+	}
+	return runErr
+}
+
+// writeBackfillReport writes the given report to out as a table followed by a summary.
+func writeBackfillReport(out io.Writer, report backfill.Report) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "EVENT\tCREATED\tSTATUS\tPAYMENT\tAPPLICATION\tAMOUNT\tCURRENCY\tERROR")
+	for _, e := range report.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", e.Event, e.Created.Format(time.RFC3339), e.Status,
+			e.Payment, e.Application, e.Amount, e.Currency, e.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(out, "\nRecovered: %d, Pending: %d, Skipped: %d, Invalid: %d, Unverified: %d, Failed: %d\n",
+		report.Count(backfill.StatusRecovered), report.Count(backfill.StatusPending),
+		report.Count(backfill.StatusSkipped), report.Count(backfill.StatusInvalid),
+		report.Count(backfill.StatusUnverified), report.Count(backfill.StatusFailed))
+	return err
+}
@@ -0,0 +1,65 @@
+package ctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/catalog"
+	"io"
+	"text/tabwriter"
+)
+
+// ErrCatalogDisabled is returned when a command needs the catalog but no applications have been configured.
+var ErrCatalogDisabled = errors.New("catalog disabled: PAYMENTS_CATALOG_APPLICATIONS is not set")
+
+func runSyncCatalog(ctx context.Context, out io.Writer, setup func() (Environment, error), args []string) error {
+	fs := flags("sync-catalog", out)
+	dryRun := fs.Bool("dry-run", false, "report the changes without making them")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("%w: %s", ErrInvalidFormat, *format)
+	}
+
+	env, err := setup()
+	if err != nil {
+		return err
+	}
+	// Syncing an empty catalog would archive every product, so it's rejected instead.
+	if env.Syncer == nil {
+		return ErrCatalogDisabled
+	}
+
+	report, syncErr := env.Syncer.Sync(ctx, *dryRun)
+	if *format == "json" {
+		err = writeJSON(out, report)
+	} else {
+		err = writeCatalogReport(out, report)
+	}
+	if err != nil {
+		return err
+	}
+	return syncErr
+}
+
+// writeCatalogReport writes the given report to out as a table followed by a summary.
+func writeCatalogReport(out io.Writer, report catalog.Report) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tOBJECT\tID\tAPPLICATION\tCURRENCY\tUNIT AMOUNT")
+	for _, c := range report.Changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", c.Action, c.Object, c.ID, c.Application, c.Currency, c.UnitAmount)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	summary := "\nCreated: %d, Updated: %d, Archived: %d\n"
+	if report.DryRun {
+		summary = "\nDry run, no changes made. Created: %d, Updated: %d, Archived: %d\n"
+	}
+	_, err := fmt.Fprintf(out, summary, report.Count(catalog.ActionCreate), report.Count(catalog.ActionUpdate),
+		report.Count(catalog.ActionArchive))
+	return err
+}
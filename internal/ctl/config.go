@@ -0,0 +1,146 @@
+package ctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"io"
+	"strings"
+)
+
+// ErrInvalidConfig is returned when the config defined in the environment has errors.
+var ErrInvalidConfig = errors.New("invalid config")
+
+// problem is an issue found when validating a config.
+type problem struct {
+	// fatal is true if the payments service cannot work with the current config.
+	fatal bool
+
+	// message describes the issue.
+	message string
+}
+
+// checkConfig returns the list of problems found in the given config. A config that can be parsed may still
+// contain values that are likely wrong, such as keys with an unexpected format.
+func checkConfig(cfg conf.Config) []problem {
+	var problems []problem
+	warn := func(format string, args ...interface{}) {
+		problems = append(problems, problem{message: fmt.Sprintf(format, args...)})
+	}
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, problem{fatal: true, message: fmt.Sprintf(format, args...)})
+	}
+
+	if !strings.HasPrefix(cfg.Stripe.SecretKey, "sk_") && !strings.HasPrefix(cfg.Stripe.SecretKey, "rk_") {
+		warn("PAYMENTS_STRIPE_SECRET_KEY doesn't look like a Stripe secret or restricted key")
+	}
+	for _, key := range cfg.Stripe.AllSigningKeys() {
+		if !strings.HasPrefix(key, "whsec_") {
+			warn("PAYMENTS_STRIPE_SIGNING_KEY or PAYMENTS_STRIPE_SIGNING_KEYS contain a key that doesn't look like a Stripe webhook signing secret")
+			break
+		}
+	}
+	if len(cfg.Stripe.WebhookURL) > 0 && !strings.HasPrefix(cfg.Stripe.WebhookURL, "https://") {
+		warn("PAYMENTS_STRIPE_WEBHOOK_URL should be an HTTPS URL, Stripe doesn't send live events to HTTP endpoints")
+	}
+	if len(cfg.Stripe.WebhookURL) > 0 && len(cfg.Stripe.AllSigningKeys()) == 0 && len(cfg.Stripe.WebhookSecretFile) == 0 {
+		warn("PAYMENTS_STRIPE_WEBHOOK_SECRET_FILE is not set, the signing secret of the registered webhook endpoint will be lost on restart")
+	}
+	if len(cfg.Stripe.WebhookURL) > 0 && cfg.Connect.Enabled() && len(cfg.Stripe.ConnectWebhookSecretFile) == 0 {
+		warn("PAYMENTS_STRIPE_CONNECT_WEBHOOK_SECRET_FILE is not set, the signing secret of the registered Connect webhook endpoint will be lost on restart")
+	}
+	if len(cfg.Stripe.URL) > 0 {
+		warn("PAYMENTS_STRIPE_URL is set, requests won't be sent to the Stripe API")
+	}
+	if cfg.Timeout <= 0 {
+		fail("PAYMENTS_CIRCUIT_BREAKER_TIMEOUT must be greater than 0")
+	}
+	if cfg.Breaker.Failures > 0 && cfg.Breaker.Cooldown <= 0 {
+		fail("PAYMENTS_CIRCUIT_BREAKER_COOLDOWN must be greater than 0, or PAYMENTS_CIRCUIT_BREAKER_FAILURES set to 0")
+	}
+	if cfg.Cache.UnitPriceTTL < 0 || cfg.Cache.CustomerTTL < 0 {
+		fail("PAYMENTS_CACHE_UNIT_PRICE_TTL and PAYMENTS_CACHE_CUSTOMER_TTL must not be negative")
+	}
+	if cfg.Cache.CustomerMaxEntries < 0 {
+		fail("PAYMENTS_CACHE_CUSTOMER_MAX_ENTRIES must not be negative")
+	}
+	if cfg.GRPCPort != 0 && cfg.GRPCPort == cfg.Port {
+		fail("PAYMENTS_GRPC_SERVER_PORT and PAYMENTS_HTTP_SERVER_PORT must be different")
+	}
+	if cfg.CreditsURL != nil && len(cfg.CreditsURL.Host) == 0 {
+		fail("PAYMENTS_CREDITS_SERVICE_URL must be an absolute URL")
+	}
+	if cfg.CustomersURL != nil && len(cfg.CustomersURL.Host) == 0 {
+		fail("PAYMENTS_CUSTOMERS_SERVICE_URL must be an absolute URL")
+	}
+	if !cfg.Auth.Enabled() {
+		warn("No authentication method configured, requests to the payments API won't be authenticated")
+	}
+	if cfg.Auth.HMACTolerance <= 0 && len(cfg.Auth.HMACSecrets) > 0 {
+		fail("PAYMENTS_AUTH_HMAC_TOLERANCE must be greater than 0")
+	}
+	if cfg.Auth.JWTMaxLifetime < 0 {
+		fail("PAYMENTS_AUTH_JWT_MAX_LIFETIME can't be negative")
+	}
+	if (cfg.RateLimit.HandlePerMinute == 0) != (cfg.RateLimit.HandleBurst == 0) {
+		warn("Handle rate limit is disabled, both PAYMENTS_RATE_LIMIT_HANDLE_PER_MINUTE and PAYMENTS_RATE_LIMIT_HANDLE_BURST must be set")
+	}
+	if (cfg.RateLimit.ApplicationPerMinute == 0) != (cfg.RateLimit.ApplicationBurst == 0) {
+		warn("Application rate limit is disabled, both PAYMENTS_RATE_LIMIT_APPLICATION_PER_MINUTE and PAYMENTS_RATE_LIMIT_APPLICATION_BURST must be set")
+	}
+	if cfg.Backfill.Interval > 0 && cfg.Backfill.Window <= cfg.Backfill.Delay {
+		warn("Scheduled backfill won't check any event, PAYMENTS_BACKFILL_WINDOW must be greater than PAYMENTS_BACKFILL_DELAY")
+	}
+	if cfg.Backfill.Interval > 0 && (len(cfg.Database.Host) == 0 || cfg.Database.LedgerSince.IsZero()) {
+		fail("PAYMENTS_BACKFILL_INTERVAL requires PAYMENTS_DATABASE_HOST and PAYMENTS_LEDGER_SINCE, missed events are checked against the ledger")
+	}
+	if len(cfg.Database.Host) > 0 && cfg.Database.LedgerSince.IsZero() {
+		warn("PAYMENTS_LEDGER_SINCE is not set, backfills and reconciliation repairs won't charge any payment")
+	}
+	if cfg.Connect.Enabled() && len(cfg.Database.Host) == 0 {
+		fail("PAYMENTS_CONNECT_APPLICATION_FEES requires PAYMENTS_DATABASE_HOST, connected accounts are stored in the database")
+	}
+	if cfg.Catalog.Enabled() && !containsString(cfg.Catalog.Currencies, cfg.Currency) {
+		warn("PAYMENTS_CATALOG_CURRENCIES doesn't include PAYMENTS_CURRENCY, checkout sessions will use inline prices")
+	}
+	return problems
+}
+
+// containsString returns true if the given list contains s.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func runValidateConfig(_ context.Context, out io.Writer, _ func() (Environment, error), args []string) error {
+	fs := flags("validate-config", out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var cfg conf.Config
+	if err := cfg.Parse(); err != nil {
+		fmt.Fprintln(out, "ERROR:", err)
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+	}
+
+	var fatal int
+	for _, p := range checkConfig(cfg) {
+		if p.fatal {
+			fatal++
+			fmt.Fprintln(out, "ERROR:", p.message)
+		} else {
+			fmt.Fprintln(out, "WARNING:", p.message)
+		}
+	}
+	if fatal > 0 {
+		return fmt.Errorf("%w: %d error(s) found", ErrInvalidConfig, fatal)
+	}
+	fmt.Fprintln(out, "Config OK")
+	return nil
+}
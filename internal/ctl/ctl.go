@@ -0,0 +1,412 @@
+package ctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	creditsclient "gitlab.com/ignitionrobotics/billing/credits/pkg/client"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	customersclient "gitlab.com/ignitionrobotics/billing/customers/pkg/client"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/application"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/catalog"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ledger"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/replay"
+	"io"
+	"log"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	// ErrUnknownCommand is returned when the given command doesn't exist.
+	ErrUnknownCommand = errors.New("unknown command")
+
+	// ErrMissingArgument is returned when a command is called without one of its required arguments.
+	ErrMissingArgument = errors.New("missing argument")
+)
+
+// Environment contains the components used by the paymentsctl commands.
+type Environment struct {
+	// Customers contains a customers.CustomersV1 implementation used to look up customers.
+	Customers customers.CustomersV1
+
+	// Inspector is used to read sessions, payment intents and charges from the payment service.
+	Inspector adapter.Inspector
+
+	// Charger is used to re-run charges.
+	Charger api.ChargerV1
+
+	// Ledger contains the credits granted to customers. It's nil if no database has been configured.
+	Ledger ledger.Store
+
+	// LedgerSince is the time since which every grant has been recorded in the ledger. Backfills don't process the
+	// events created before it.
+	LedgerSince time.Time
+
+	// Events is used to record the payment service events processed by backfills, sharing them with the webhook
+	// handler of the payments service. It's nil if no database has been configured.
+	Events *replay.Tracker
+
+	// Syncer is used to sync the products and prices of the payment service. It's nil if no catalog applications have
+	// been configured.
+	Syncer *catalog.Syncer
+}
+
+// NewEnvironment initializes the components used by the paymentsctl commands using the given config.
+func NewEnvironment(cfg conf.Config, logger *log.Logger) (Environment, error) {
+	var store ledger.Store
+	var events *replay.Tracker
+	if cfg.Database.Enabled() {
+		db, err := ledger.OpenConn(cfg.Database)
+		if err != nil {
+			return Environment{}, err
+		}
+		if store, err = ledger.NewGormStore(db); err != nil {
+			return Environment{}, err
+		}
+		replays, err := replay.NewGormCache(db)
+		if err != nil {
+			return Environment{}, err
+		}
+		events = replay.NewTracker(replay.TrackerOptions{
+			Cache:         replays,
+			Window:        cfg.Stripe.WebhookReplayWindow,
+			ProcessingTTL: cfg.Timeout + replay.ProcessingMargin,
+		})
+	}
+
+	creditsClient := creditsclient.NewCreditsClientV1(cfg.CreditsURL, cfg.Timeout)
+	var syncer *catalog.Syncer
+	var stripeCatalog adapter.Catalog
+	if cfg.Catalog.Enabled() {
+		stripeCatalog = adapter.NewStripeCatalog(cfg.Stripe)
+		syncer = catalog.NewSyncer(catalog.Options{
+			Catalog:      stripeCatalog,
+			Credits:      creditsClient,
+			Applications: cfg.Catalog.Applications,
+			Currencies:   cfg.Catalog.Currencies,
+			Logger:       logger,
+		})
+	}
+
+	customersClient := customersclient.NewCustomersClientV1(cfg.CustomersURL, cfg.Timeout)
+	return Environment{
+		Customers: customersClient,
+		Inspector: adapter.NewStripeInspector(cfg.Stripe),
+		Charger: application.NewPaymentsService(application.Options{
+			Credits:   creditsClient,
+			Customers: customersClient,
+			Adapter:   adapter.NewStripeAdapter(cfg.Stripe),
+			Logger:    logger,
+			Timeout:   cfg.Timeout,
+			Ledger:    store,
+			Catalog:   stripeCatalog,
+		}),
+		Ledger:      store,
+		LedgerSince: cfg.Database.LedgerSince,
+		Events:      events,
+		Syncer:      syncer,
+	}, nil
+}
+
+// command is a paymentsctl subcommand.
+type command struct {
+	// usage describes the flags and arguments of the command.
+	usage string
+
+	// description is a short explanation of what the command does.
+	description string
+
+	// run runs the command. The environment is only initialized by the commands that need it.
+	run func(ctx context.Context, out io.Writer, setup func() (Environment, error), args []string) error
+}
+
+// commands contains the list of paymentsctl subcommands.
+var commands = map[string]command{
+	"customer": {
+		usage:       "-application <application> <handle>",
+		description: "Look up a customer by handle",
+		run:         runCustomer,
+	},
+	"session": {
+		usage:       "<session_id>",
+		description: "Show a checkout session",
+		run:         runSession,
+	},
+	"payment-intent": {
+		usage:       "<payment_intent_id>",
+		description: "Show a payment intent",
+		run:         runPaymentIntent,
+	},
+	"charges": {
+		usage:       "[-since 24h] [-limit 20]",
+		description: "List recent charges",
+		run:         runCharges,
+	},
+	"replay-charge": {
+		usage:       "[-dry-run] [-force] <event_id>",
+		description: "Re-run Charge for the given payment service event",
+		run:         runReplayCharge,
+	},
+	"backfill": {
+		usage:       "[-since 720h] [-until 0s] [-dry-run] [-format text|json]",
+		description: "Recover charge events missed by the webhook handler",
+		run:         runBackfill,
+	},
+	"export": {
+		usage:       "[-from YYYY-MM-DD] [-to YYYY-MM-DD] [-application <application>] [-format csv|jsonl]",
+		description: "Export successful payments for finance, defaults to the previous month",
+		run:         runExport,
+	},
+	"reconcile": {
+		usage:       "[-since 720h] [-until 0s] [-repair] [-format json|csv]",
+		description: "Compare charges with the credits granted to customers",
+		run:         runReconcile,
+	},
+	"sync-catalog": {
+		usage:       "[-dry-run] [-format text|json]",
+		description: "Create or update the Stripe products and prices of the catalog, archiving stale ones",
+		run:         runSyncCatalog,
+	},
+	"trigger": {
+		usage:       "[-url <webhook_url>] [-secret <signing_secret>] [-application <application>] [-handle <handle>] [-dry-run] <event_type>",
+		description: "Send a synthetic signed Stripe event to a running payments service",
+		run:         runTrigger,
+	},
+	"validate-config": {
+		usage:       "",
+		description: "Validate the config defined in the environment",
+		run:         runValidateConfig,
+	},
+}
+
+// Run runs the paymentsctl command given in args, writing its output to out. The setup function is called to
+// initialize the Environment of the commands that need it.
+func Run(ctx context.Context, args []string, out io.Writer, setup func() (Environment, error)) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		Usage(out)
+		return nil
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		Usage(out)
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, args[0])
+	}
+	return cmd.run(ctx, out, setup, args[1:])
+}
+
+// Usage writes the list of available commands to out.
+func Usage(out io.Writer) {
+	fmt.Fprintln(out, "Usage: paymentsctl <command> [flags] [arguments]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, name := range commandNames() {
+		cmd := commands[name]
+		fmt.Fprintf(w, "  %s %s\t%s\n", name, cmd.usage, cmd.description)
+	}
+	w.Flush()
+}
+
+// commandNames returns the sorted list of command names.
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// flags initializes a flag set for the given command.
+func flags(name string, out io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	return fs
+}
+
+// argument returns the single positional argument of the given flag set.
+func argument(fs *flag.FlagSet, name string) (string, error) {
+	if fs.NArg() != 1 || len(fs.Arg(0)) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrMissingArgument, name)
+	}
+	return fs.Arg(0), nil
+}
+
+// writeJSON writes the given value to out as indented JSON.
+func writeJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runCustomer(ctx context.Context, out io.Writer, setup func() (Environment, error), args []string) error {
+	fs := flags("customer", out)
+	app := fs.String("application", "", "application the customer belongs to")
+	service := fs.String("service", string(api.PaymentServiceStripe), "payment service the customer is registered in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	handle, err := argument(fs, "handle")
+	if err != nil {
+		return err
+	}
+	if len(*app) == 0 {
+		return fmt.Errorf("%w: application", ErrMissingArgument)
+	}
+
+	env, err := setup()
+	if err != nil {
+		return err
+	}
+	res, err := env.Customers.GetCustomerByHandle(ctx, customers.GetCustomerByHandleRequest{
+		Handle:      handle,
+		Service:     *service,
+		Application: *app,
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, res)
+}
+
+func runSession(ctx context.Context, out io.Writer, setup func() (Environment, error), args []string) error {
+	fs := flags("session", out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	id, err := argument(fs, "session_id")
+	if err != nil {
+		return err
+	}
+
+	env, err := setup()
+	if err != nil {
+		return err
+	}
+	res, err := env.Inspector.GetSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, res)
+}
+
+func runPaymentIntent(ctx context.Context, out io.Writer, setup func() (Environment, error), args []string) error {
+	fs := flags("payment-intent", out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	id, err := argument(fs, "payment_intent_id")
+	if err != nil {
+		return err
+	}
+
+	env, err := setup()
+	if err != nil {
+		return err
+	}
+	res, err := env.Inspector.GetPaymentIntent(ctx, id)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, res)
+}
+
+func runCharges(ctx context.Context, out io.Writer, setup func() (Environment, error), args []string) error {
+	fs := flags("charges", out)
+	since := fs.Duration("since", 24*time.Hour, "list charges created within this period")
+	limit := fs.Int("limit", 20, "maximum amount of charges to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	env, err := setup()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	charges, err := env.Inspector.ListCharges(ctx, now.Add(-*since), now, *limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tCUSTOMER\tAMOUNT\tREFUNDED\tCURRENCY\tSTATUS\tAPPLICATION\tHANDLE")
+	for _, c := range charges {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n", c.ID, c.Created.Format(time.RFC3339), c.Customer,
+			c.Amount, c.AmountRefunded, c.Currency, c.Status, c.Metadata["application"], c.Metadata["handle"])
+	}
+	return w.Flush()
+}
+
+func runReplayCharge(ctx context.Context, out io.Writer, setup func() (Environment, error), args []string) error {
+	fs := flags("replay-charge", out)
+	dryRun := fs.Bool("dry-run", false, "print the charge request without running it")
+	force := fs.Bool("force", false, "run the charge even if the payment has already been charged")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	id, err := argument(fs, "event_id")
+	if err != nil {
+		return err
+	}
+
+	env, err := setup()
+	if err != nil {
+		return err
+	}
+	req, err := env.Inspector.GetChargeRequest(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = writeJSON(out, req); err != nil {
+		return err
+	}
+	if !*force {
+		if err = checkCharged(ctx, env, req); err != nil {
+			return err
+		}
+	}
+	if *dryRun {
+		fmt.Fprintln(out, "Dry run, charge not performed")
+		return nil
+	}
+
+	req.Force = *force
+	if _, err = env.Charger.Charge(ctx, req); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Charge performed")
+	return nil
+}
+
+// checkCharged returns api.ErrAlreadyCharged if the payment of the given charge has a grant in the ledger, or has been
+// marked as charged in the payment service.
+func checkCharged(ctx context.Context, env Environment, req api.ChargeRequest) error {
+	if len(req.Payment) == 0 {
+		return nil
+	}
+	if env.Ledger != nil {
+		grants, err := env.Ledger.FindByPayment(ctx, req.Payment)
+		if err != nil {
+			return err
+		}
+		if len(grants) > 0 {
+			return fmt.Errorf("%w by event %s, use -force to charge it again", api.ErrAlreadyCharged, grants[0].Event)
+		}
+	}
+	pi, err := env.Inspector.GetPaymentIntent(ctx, req.Payment)
+	if err != nil {
+		return err
+	}
+	if event, ok := pi.Metadata[adapter.MetadataChargedEvent]; ok {
+		return fmt.Errorf("%w by event %s, use -force to charge it again", api.ErrAlreadyCharged, event)
+	}
+	return nil
+}
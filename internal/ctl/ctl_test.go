@@ -0,0 +1,405 @@
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	fakecredits "gitlab.com/ignitionrobotics/billing/credits/pkg/fake"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	fakecustomers "gitlab.com/ignitionrobotics/billing/customers/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/backfill"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/catalog"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ledger"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/reconcile"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type ctlTestSuite struct {
+	suite.Suite
+	Customers *fakecustomers.Fake
+	Inspector *fake.Inspector
+	Charger   *fake.Charger
+	Ledger    ledger.Store
+	Since     time.Time
+	Syncer    *catalog.Syncer
+	Output    *bytes.Buffer
+}
+
+func TestCtlSuite(t *testing.T) {
+	suite.Run(t, new(ctlTestSuite))
+}
+
+func (s *ctlTestSuite) SetupTest() {
+	s.Customers = fakecustomers.NewClient()
+	s.Inspector = &fake.Inspector{}
+	s.Charger = &fake.Charger{}
+	s.Ledger = ledger.NewMemoryStore()
+	s.Since = time.Now().Add(-24 * time.Hour)
+	s.Syncer = nil
+	s.Output = &bytes.Buffer{}
+}
+
+func (s *ctlTestSuite) run(args ...string) error {
+	return Run(context.Background(), args, s.Output, func() (Environment, error) {
+		return Environment{
+			Customers:   s.Customers,
+			Inspector:   s.Inspector,
+			Charger:     s.Charger,
+			Ledger:      s.Ledger,
+			LedgerSince: s.Since,
+			Syncer:      s.Syncer,
+		}, nil
+	})
+}
+
+func (s *ctlTestSuite) TestUsage() {
+	s.Require().NoError(s.run())
+	for name := range commands {
+		s.Assert().Contains(s.Output.String(), name)
+	}
+}
+
+func (s *ctlTestSuite) TestUnknownCommand() {
+	err := s.run("test")
+	s.Assert().True(errors.Is(err, ErrUnknownCommand))
+}
+
+func (s *ctlTestSuite) TestCustomer() {
+	res := customers.CustomerResponse{
+		ID:          "cus_123",
+		Handle:      "test",
+		Service:     "stripe",
+		Application: "fuel",
+	}
+	s.Customers.On("GetCustomerByHandle", mock.Anything, customers.GetCustomerByHandleRequest{
+		Handle:      "test",
+		Service:     "stripe",
+		Application: "fuel",
+	}).Return(res, error(nil))
+
+	s.Require().NoError(s.run("customer", "-application", "fuel", "test"))
+
+	var out customers.CustomerResponse
+	s.Require().NoError(json.Unmarshal(s.Output.Bytes(), &out))
+	s.Assert().Equal(res, out)
+}
+
+func (s *ctlTestSuite) TestCustomerMissingArguments() {
+	s.Assert().True(errors.Is(s.run("customer", "test"), ErrMissingArgument))
+	s.Assert().True(errors.Is(s.run("customer", "-application", "fuel"), ErrMissingArgument))
+}
+
+func (s *ctlTestSuite) TestSession() {
+	s.Inspector.On("GetSession", mock.Anything, "cs_123").Return(adapter.Session{ID: "cs_123", PaymentStatus: "paid"}, error(nil))
+
+	s.Require().NoError(s.run("session", "cs_123"))
+
+	var out adapter.Session
+	s.Require().NoError(json.Unmarshal(s.Output.Bytes(), &out))
+	s.Assert().Equal("paid", out.PaymentStatus)
+}
+
+func (s *ctlTestSuite) TestPaymentIntentFails() {
+	s.Inspector.On("GetPaymentIntent", mock.Anything, "pi_123").Return(adapter.PaymentIntent{}, errors.New("not found"))
+	s.Assert().Error(s.run("payment-intent", "pi_123"))
+}
+
+func (s *ctlTestSuite) TestCharges() {
+	s.Inspector.On("ListCharges", mock.Anything, mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time"), 5).Return([]adapter.Charge{
+		{
+			ID:       "ch_123",
+			Amount:   1000,
+			Currency: "usd",
+			Status:   "succeeded",
+			Metadata: map[string]string{"application": "fuel", "handle": "test"},
+			Created:  time.Now(),
+		},
+	}, error(nil))
+
+	s.Require().NoError(s.run("charges", "-limit", "5"))
+	s.Assert().Contains(s.Output.String(), "ch_123")
+	s.Assert().Contains(s.Output.String(), "fuel")
+}
+
+func (s *ctlTestSuite) TestReplayChargeDryRun() {
+	req := api.ChargeRequest{Amount: 1000, Currency: "usd", Customer: "cus_123", Service: api.PaymentServiceStripe, Application: "fuel"}
+	s.Inspector.On("GetChargeRequest", mock.Anything, "evt_123").Return(req, error(nil))
+
+	s.Require().NoError(s.run("replay-charge", "-dry-run", "evt_123"))
+	s.Charger.AssertNotCalled(s.T(), "Charge", mock.Anything, mock.Anything)
+}
+
+func (s *ctlTestSuite) TestReplayCharge() {
+	req := api.ChargeRequest{Amount: 1000, Currency: "usd", Customer: "cus_123", Service: api.PaymentServiceStripe, Application: "fuel"}
+	s.Inspector.On("GetChargeRequest", mock.Anything, "evt_123").Return(req, error(nil))
+	s.Charger.On("Charge", mock.Anything, req).Return(api.ChargeResponse{}, error(nil))
+
+	s.Require().NoError(s.run("replay-charge", "evt_123"))
+	s.Charger.AssertExpectations(s.T())
+}
+
+func (s *ctlTestSuite) TestReplayChargeAlreadyCharged() {
+	req := api.ChargeRequest{Amount: 1000, Currency: "usd", Customer: "cus_123", Service: api.PaymentServiceStripe, Application: "fuel", Event: "evt_123", Payment: "pi_123"}
+	s.Inspector.On("GetChargeRequest", mock.Anything, "evt_123").Return(req, error(nil))
+	s.Inspector.On("GetPaymentIntent", mock.Anything, "pi_123").Return(adapter.PaymentIntent{
+		ID:       "pi_123",
+		Metadata: map[string]string{adapter.MetadataChargedEvent: "evt_123"},
+	}, error(nil))
+
+	s.Assert().True(errors.Is(s.run("replay-charge", "evt_123"), api.ErrAlreadyCharged))
+
+	// Payments recorded in the ledger are refused even if they haven't been marked in the payment service.
+	s.Require().NoError(s.Ledger.Record(context.Background(), ledger.Grant{Payment: "pi_123", Event: "evt_123"}))
+	s.Inspector = &fake.Inspector{}
+	s.Inspector.On("GetChargeRequest", mock.Anything, "evt_123").Return(req, error(nil))
+	s.Assert().True(errors.Is(s.run("replay-charge", "-dry-run", "evt_123"), api.ErrAlreadyCharged))
+	s.Inspector.AssertNotCalled(s.T(), "GetPaymentIntent", mock.Anything, mock.Anything)
+	s.Charger.AssertNotCalled(s.T(), "Charge", mock.Anything, mock.Anything)
+
+	forced := req
+	forced.Force = true
+	s.Charger.On("Charge", mock.Anything, forced).Return(api.ChargeResponse{}, error(nil))
+	s.Require().NoError(s.run("replay-charge", "-force", "evt_123"))
+	s.Charger.AssertExpectations(s.T())
+}
+
+func (s *ctlTestSuite) TestReplayChargeFails() {
+	req := api.ChargeRequest{Amount: 1000, Currency: "usd", Customer: "cus_123", Service: api.PaymentServiceStripe, Application: "fuel"}
+	s.Inspector.On("GetChargeRequest", mock.Anything, "evt_123").Return(req, error(nil))
+	s.Charger.On("Charge", mock.Anything, req).Return(api.ChargeResponse{}, api.ErrUpstream)
+
+	s.Assert().True(errors.Is(s.run("replay-charge", "evt_123"), api.ErrUpstream))
+}
+
+func (s *ctlTestSuite) TestBackfill() {
+	req := api.ChargeRequest{Amount: 1000, Currency: "usd", Customer: "cus_123", Service: api.PaymentServiceStripe, Application: "fuel", Event: "evt_123", Payment: "pi_123"}
+	s.Inspector.On("ListChargeEvents", mock.Anything, mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time")).Return([]adapter.ChargeEvent{
+		{ID: "evt_123", Created: time.Now(), Request: req},
+	}, error(nil))
+
+	s.Require().NoError(s.run("backfill", "-dry-run", "-format", "json"))
+	s.Charger.AssertNotCalled(s.T(), "Charge", mock.Anything, mock.Anything)
+
+	var report backfill.Report
+	s.Require().NoError(json.Unmarshal(s.Output.Bytes(), &report))
+	s.Require().Len(report.Entries, 1)
+	s.Assert().Equal(backfill.StatusPending, report.Entries[0].Status)
+}
+
+func (s *ctlTestSuite) TestBackfillIncomplete() {
+	req := api.ChargeRequest{Amount: 1000, Currency: "usd", Customer: "cus_123", Service: api.PaymentServiceStripe, Application: "fuel", Event: "evt_123", Payment: "pi_123"}
+	s.Inspector.On("ListChargeEvents", mock.Anything, mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time")).Return([]adapter.ChargeEvent{
+		{ID: "evt_123", Created: time.Now(), Request: req},
+	}, error(nil))
+	s.Charger.On("Charge", mock.Anything, req).Return(api.ChargeResponse{}, api.ErrUpstream)
+
+	err := s.run("backfill")
+	s.Assert().True(errors.Is(err, backfill.ErrIncomplete))
+	s.Assert().Contains(s.Output.String(), "Failed: 1")
+}
+
+func (s *ctlTestSuite) TestBackfillBeforeLedger() {
+	req := api.ChargeRequest{Amount: 1000, Currency: "usd", Customer: "cus_123", Service: api.PaymentServiceStripe, Application: "fuel", Event: "evt_123", Payment: "pi_123"}
+	s.Inspector.On("ListChargeEvents", mock.Anything, mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time")).Return([]adapter.ChargeEvent{
+		{ID: "evt_123", Created: s.Since.Add(-time.Hour), Request: req},
+	}, error(nil))
+
+	s.Require().NoError(s.run("backfill"))
+	s.Charger.AssertNotCalled(s.T(), "Charge", mock.Anything, mock.Anything)
+	s.Assert().Contains(s.Output.String(), "Unverified: 1")
+}
+
+func (s *ctlTestSuite) TestBackfillLedgerDisabled() {
+	s.Ledger = nil
+	s.Assert().True(errors.Is(s.run("backfill"), ErrLedgerDisabled))
+}
+
+func (s *ctlTestSuite) TestReconcile() {
+	s.Require().NoError(s.Ledger.Record(context.Background(), ledger.Grant{Payment: "pi_1", Amount: 1000, Currency: "usd"}))
+	s.Inspector.On("ListCharges", mock.Anything, mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time"), 0).Return([]adapter.Charge{
+		{ID: "ch_1", PaymentIntent: "pi_1", Status: "succeeded", Amount: 1000, Currency: "usd"},
+		{ID: "ch_2", PaymentIntent: "pi_2", Status: "succeeded", Amount: 1000, Currency: "usd"},
+	}, error(nil))
+
+	err := s.run("reconcile")
+	s.Assert().True(errors.Is(err, ErrUnresolved))
+	s.Charger.AssertNotCalled(s.T(), "Charge", mock.Anything, mock.Anything)
+
+	var report reconcile.Report
+	s.Require().NoError(json.Unmarshal(s.Output.Bytes(), &report))
+	s.Assert().Equal(2, report.Charges)
+	s.Require().Len(report.Entries, 1)
+	s.Assert().Equal(reconcile.KindMissingGrant, report.Entries[0].Kind)
+	s.Assert().Equal("pi_2", report.Entries[0].Payment)
+}
+
+func (s *ctlTestSuite) TestReconcileCSV() {
+	s.Inspector.On("ListCharges", mock.Anything, mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time"), 0).Return([]adapter.Charge{}, error(nil))
+
+	s.Require().NoError(s.run("reconcile", "-format", "csv"))
+	s.Assert().Contains(s.Output.String(), "kind,payment,charge")
+}
+
+func (s *ctlTestSuite) TestReconcileRepairDisabled() {
+	s.Since = time.Time{}
+	s.Assert().True(errors.Is(s.run("reconcile", "-repair"), ErrRepairDisabled))
+	s.Inspector.AssertNotCalled(s.T(), "ListCharges", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *ctlTestSuite) TestReconcileLedgerDisabled() {
+	s.Ledger = nil
+	s.Assert().True(errors.Is(s.run("reconcile"), ErrLedgerDisabled))
+}
+
+func (s *ctlTestSuite) TestExport() {
+	from := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 11, 30, 23, 59, 59, 0, time.UTC)
+	s.Inspector.On("ListPayments", mock.Anything, from, to, "fuel", mock.Anything).Return([]adapter.Payment{
+		{ID: "pi_1", Application: "fuel", Gross: 1000, Currency: "usd"},
+	}, error(nil))
+
+	s.Require().NoError(s.run("export", "-from", "2021-11-01", "-to", "2021-12-01", "-application", "fuel", "-format", "jsonl"))
+
+	var p adapter.Payment
+	s.Require().NoError(json.Unmarshal(s.Output.Bytes(), &p))
+	s.Assert().Equal("pi_1", p.ID)
+}
+
+func (s *ctlTestSuite) TestExportInvalidFormat() {
+	s.Assert().True(errors.Is(s.run("export", "-format", "xlsx"), ErrInvalidFormat))
+}
+
+func (s *ctlTestSuite) TestSyncCatalog() {
+	c := &fake.Catalog{}
+	c.On("ListProducts", mock.Anything).Return([]adapter.Product{
+		{ID: "credits_fuel", Application: "fuel", Name: "Credits (fuel)", Active: true},
+	}, error(nil))
+	c.On("ListPrices", mock.Anything, "credits_fuel").Return([]adapter.Price{
+		{ID: "price_1", Product: "credits_fuel", LookupKey: "credits_fuel_usd", Currency: "usd", UnitAmount: 1, Active: true},
+	}, error(nil))
+	creditsClient := fakecredits.NewClient()
+	creditsClient.On("GetUnitPrice", mock.Anything, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{Amount: 2, Currency: "usd"}, error(nil))
+	s.Syncer = catalog.NewSyncer(catalog.Options{
+		Catalog:      c,
+		Credits:      creditsClient,
+		Applications: []string{"fuel"},
+		Currencies:   []string{"usd"},
+	})
+
+	s.Require().NoError(s.run("sync-catalog", "-dry-run"))
+	c.AssertNotCalled(s.T(), "CreatePrice", mock.Anything, mock.Anything)
+	c.AssertNotCalled(s.T(), "ArchivePrice", mock.Anything, mock.Anything)
+	s.Assert().Contains(s.Output.String(), "Dry run, no changes made. Created: 1, Updated: 0, Archived: 1")
+
+	s.Output.Reset()
+	c.On("CreatePrice", mock.Anything, mock.Anything).Return(adapter.Price{ID: "price_2"}, error(nil))
+	c.On("ArchivePrice", mock.Anything, "price_1").Return(error(nil))
+	s.Require().NoError(s.run("sync-catalog", "-format", "json"))
+
+	var report catalog.Report
+	s.Require().NoError(json.Unmarshal(s.Output.Bytes(), &report))
+	s.Require().Len(report.Changes, 2)
+	s.Assert().Equal("price_2", report.Changes[0].ID)
+	s.Assert().Equal(catalog.ActionArchive, report.Changes[1].Action)
+}
+
+func (s *ctlTestSuite) TestSyncCatalogDisabled() {
+	s.Assert().True(errors.Is(s.run("sync-catalog"), ErrCatalogDisabled))
+}
+
+func TestCheckConfig(t *testing.T) {
+	u, err := url.Parse("http://localhost:8082")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := conf.Config{
+		Stripe:       conf.Stripe{SecretKey: "sk_test_123", SigningKey: "whsec_123"},
+		Auth:         conf.Auth{JWTSecret: "secret"},
+		RateLimit:    conf.RateLimit{HandlePerMinute: 10, HandleBurst: 5},
+		Port:         8001,
+		Timeout:      time.Second,
+		CreditsURL:   u,
+		CustomersURL: u,
+	}
+	if problems := checkConfig(cfg); len(problems) != 0 {
+		t.Errorf("expected no problems, got: %+v", problems)
+	}
+
+	cfg.GRPCPort = cfg.Port
+	cfg.Stripe.SecretKey = "secret"
+	problems := checkConfig(cfg)
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got: %+v", problems)
+	}
+	if problems[0].fatal || !problems[1].fatal {
+		t.Errorf("unexpected problems: %+v", problems)
+	}
+}
+
+func (s *ctlTestSuite) TestTrigger() {
+	var body []byte
+	var signature string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		s.Require().NoError(err)
+		signature = r.Header.Get("Stripe-Signature")
+	}))
+	defer target.Close()
+
+	s.Require().NoError(s.run("trigger", "-url", target.URL, "-secret", "whsec_trigger", "-application", "fuel",
+		"-handle", "alice", "-customer", "cus_1", "-amount", "500", "payment_intent.succeeded"))
+
+	a := adapter.NewStripeAdapter(conf.Stripe{SigningKey: "whsec_trigger", SecretKey: "sk_test"})
+	req, err := a.GenerateChargeRequest(context.Background(), body, map[string][]string{"Stripe-Signature": {signature}})
+	s.Require().NoError(err)
+	s.Assert().Equal(uint(500), req.Amount)
+	s.Assert().Equal("cus_1", req.Customer)
+	s.Assert().Equal("fuel", req.Application)
+	s.Assert().Contains(s.Output.String(), req.Event)
+}
+
+func (s *ctlTestSuite) TestTriggerRejected() {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid signature", http.StatusBadRequest)
+	}))
+	defer target.Close()
+
+	err := s.run("trigger", "-url", target.URL, "-secret", "whsec_trigger", "-application", "fuel", "-handle", "alice",
+		"charge.refunded")
+	s.Assert().True(errors.Is(err, ErrWebhookRejected))
+}
+
+func (s *ctlTestSuite) TestTriggerMissingMetadata() {
+	err := s.run("trigger", "-secret", "whsec_trigger", "-handle", "alice", "payment_intent.succeeded")
+	s.Assert().True(errors.Is(err, ErrMissingArgument))
+	s.Assert().Contains(err.Error(), "application")
+
+	err = s.run("trigger", "-secret", "whsec_trigger", "-application", "fuel", "charge.succeeded")
+	s.Assert().True(errors.Is(err, ErrMissingArgument))
+	s.Assert().Contains(err.Error(), "handle")
+}
+
+func (s *ctlTestSuite) TestTriggerDryRun() {
+	s.Require().NoError(s.run("trigger", "-url", "http://localhost:0", "-secret", "whsec_trigger", "-dry-run", "account.updated"))
+	s.Assert().Contains(s.Output.String(), "Stripe-Signature: t=")
+	s.Assert().Contains(s.Output.String(), `"type":"account.updated"`)
+}
+
+func (s *ctlTestSuite) TestTriggerUnknownEvent() {
+	err := s.run("trigger", "-secret", "whsec_trigger", "customer.created")
+	s.Assert().True(errors.Is(err, ErrUnknownEvent))
+}
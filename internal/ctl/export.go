@@ -0,0 +1,54 @@
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/export"
+	"io"
+	"time"
+)
+
+func runExport(ctx context.Context, out io.Writer, setup func() (Environment, error), args []string) error {
+	// Default to the previous calendar month
+	now := time.Now().UTC()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	fs := flags("export", out)
+	from := fs.String("from", thisMonth.AddDate(0, -1, 0).Format("2006-01-02"), "beginning of the time window, RFC 3339 or YYYY-MM-DD")
+	to := fs.String("to", thisMonth.Format("2006-01-02"), "end of the time window (exclusive), RFC 3339 or YYYY-MM-DD")
+	application := fs.String("application", "", "only export the payments of this application")
+	format := fs.String("format", string(export.FormatCSV), "output format: csv or jsonl")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f := export.Format(*format)
+	if err := f.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidFormat, *format)
+	}
+	start, end, err := export.ParseRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	env, err := setup()
+	if err != nil {
+		return err
+	}
+
+	w, err := export.NewWriter(f, out)
+	if err != nil {
+		return err
+	}
+	err = env.Inspector.ListPayments(ctx, start, end, *application, func(p adapter.Payment) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return w.Write(p)
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
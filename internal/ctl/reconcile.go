@@ -0,0 +1,73 @@
+package ctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/reconcile"
+	"io"
+	"time"
+)
+
+var (
+	// ErrLedgerDisabled is returned when a command needs the ledger but no database has been configured.
+	ErrLedgerDisabled = errors.New("ledger disabled: PAYMENTS_DATABASE_HOST is not set")
+
+	// ErrRepairDisabled is returned when a reconciliation is asked to repair missing grants but the ledger cut-off hasn't
+	// been configured.
+	ErrRepairDisabled = errors.New("repairs disabled: PAYMENTS_LEDGER_SINCE is not set")
+
+	// ErrUnresolved is returned when a reconciliation finds discrepancies that haven't been repaired.
+	ErrUnresolved = errors.New("unresolved discrepancies")
+)
+
+func runReconcile(ctx context.Context, out io.Writer, setup func() (Environment, error), args []string) error {
+	fs := flags("reconcile", out)
+	since := fs.Duration("since", 720*time.Hour, "check charges created after this period ago")
+	until := fs.Duration("until", 0, "check charges created before this period ago")
+	repair := fs.Bool("repair", false, "grant the credits of successful charges without grants")
+	format := fs.String("format", "json", "output format: json or csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("%w: %s", ErrInvalidFormat, *format)
+	}
+
+	env, err := setup()
+	if err != nil {
+		return err
+	}
+	if env.Ledger == nil {
+		return ErrLedgerDisabled
+	}
+	if *repair && env.LedgerSince.IsZero() {
+		return ErrRepairDisabled
+	}
+
+	now := time.Now()
+	r := reconcile.NewReconciler(reconcile.Options{
+		Inspector: env.Inspector,
+		Ledger:    env.Ledger,
+		Charger:   env.Charger,
+		Since:     env.LedgerSince,
+	})
+	report, err := r.Run(ctx, now.Add(-*since), now.Add(-*until), *repair)
+	if err != nil {
+		return err
+	}
+
+	if *format == "csv" {
+		err = reconcile.WriteCSV(out, report)
+	} else {
+		err = writeJSON(out, report)
+	}
+	if err != nil {
+		return err
+	}
+
+	if n := report.Unresolved(); n > 0 {
+		return fmt.Errorf("%w: %d", ErrUnresolved, n)
+	}
+	return nil
+}
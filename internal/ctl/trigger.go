@@ -0,0 +1,169 @@
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/stripetest"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnknownEvent is returned when the trigger command is called with an event type it can't build.
+	ErrUnknownEvent = errors.New("unknown event")
+
+	// ErrWebhookRejected is returned when the webhook endpoint doesn't accept a triggered event.
+	ErrWebhookRejected = errors.New("webhook rejected event")
+)
+
+// triggerParams contains the values used to build the events sent by the trigger command.
+type triggerParams struct {
+	customer      string
+	application   string
+	handle        string
+	amount        int64
+	currency      string
+	paymentIntent string
+	account       string
+}
+
+// triggerEvents contains the builders of the events supported by the trigger command.
+var triggerEvents = map[string]func(p triggerParams) stripetest.Event{
+	"payment_intent.succeeded": func(p triggerParams) stripetest.Event {
+		return stripetest.PaymentIntentSucceeded(p.paymentIntentInput())
+	},
+	"payment_intent.amount_capturable_updated": func(p triggerParams) stripetest.Event {
+		return stripetest.PaymentIntentAmountCapturableUpdated(p.paymentIntentInput())
+	},
+	"charge.succeeded": func(p triggerParams) stripetest.Event {
+		return stripetest.ChargeSucceeded(p.chargeInput())
+	},
+	"charge.refunded": func(p triggerParams) stripetest.Event {
+		return stripetest.ChargeRefunded(p.chargeInput())
+	},
+	"account.updated": func(p triggerParams) stripetest.Event {
+		return stripetest.AccountUpdated(stripetest.Account{
+			ID:               p.account,
+			ChargesEnabled:   true,
+			PayoutsEnabled:   true,
+			DetailsSubmitted: true,
+			Metadata:         map[string]string{"application": p.application},
+		})
+	},
+}
+
+// paymentIntentInput returns the payment intent included in payment intent events.
+func (p triggerParams) paymentIntentInput() stripetest.PaymentIntent {
+	return stripetest.PaymentIntent{
+		ID:          p.paymentIntent,
+		Customer:    p.customer,
+		Amount:      p.amount,
+		Currency:    p.currency,
+		Application: p.application,
+		Handle:      p.handle,
+	}
+}
+
+// chargeInput returns the charge included in charge events.
+func (p triggerParams) chargeInput() stripetest.Charge {
+	return stripetest.Charge{
+		PaymentIntent: p.paymentIntent,
+		Customer:      p.customer,
+		Amount:        p.amount,
+		Currency:      p.currency,
+		Metadata: map[string]string{
+			"application": p.application,
+			"handle":      p.handle,
+		},
+	}
+}
+
+// chargeEvent returns true if events of the given type carry a payment, which the webhook handler can only process
+// with its application and handle.
+func chargeEvent(typ string) bool {
+	return strings.HasPrefix(typ, "payment_intent.") || strings.HasPrefix(typ, "charge.")
+}
+
+// triggerEventNames returns the sorted list of event types supported by the trigger command.
+func triggerEventNames() []string {
+	names := make([]string, 0, len(triggerEvents))
+	for name := range triggerEvents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runTrigger sends a synthetic, signed Stripe event to a running payments service. It doesn't need access to Stripe.
+func runTrigger(ctx context.Context, out io.Writer, _ func() (Environment, error), args []string) error {
+	fs := flags("trigger", out)
+	target := fs.String("url", "http://localhost/payments/webhooks/stripe", "webhook endpoint the event is sent to")
+	secret := fs.String("secret", os.Getenv("PAYMENTS_STRIPE_SIGNING_KEY"), "webhook signing secret, defaults to PAYMENTS_STRIPE_SIGNING_KEY")
+	var p triggerParams
+	fs.StringVar(&p.customer, "customer", "cus_trigger", "customer paying")
+	fs.StringVar(&p.application, "application", "", "application metadata value, required for payment events")
+	fs.StringVar(&p.handle, "handle", "", "handle metadata value, required for payment events")
+	fs.Int64Var(&p.amount, "amount", 1000, "amount in cents")
+	fs.StringVar(&p.currency, "currency", "usd", "ISO 4217 currency code")
+	fs.StringVar(&p.paymentIntent, "payment-intent", "", "payment intent ID, random if empty")
+	fs.StringVar(&p.account, "account", "", "connected account ID for account events, random if empty")
+	livemode := fs.Bool("livemode", false, "mark the event as generated with live keys")
+	dryRun := fs.Bool("dry-run", false, "print the signed event without sending it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	typ, err := argument(fs, "event")
+	if err != nil {
+		return err
+	}
+	build, ok := triggerEvents[typ]
+	if !ok {
+		return fmt.Errorf("%w: %s, supported events: %s", ErrUnknownEvent, typ, strings.Join(triggerEventNames(), ", "))
+	}
+	if len(*secret) == 0 {
+		return fmt.Errorf("%w: secret", ErrMissingArgument)
+	}
+	if chargeEvent(typ) && len(p.application) == 0 {
+		return fmt.Errorf("%w: application", ErrMissingArgument)
+	}
+	if chargeEvent(typ) && len(p.handle) == 0 {
+		return fmt.Errorf("%w: handle", ErrMissingArgument)
+	}
+
+	event := build(p)
+	event.Livemode = *livemode
+	signed, err := event.Sign(*secret, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Fprintf(out, "Stripe-Signature: %s\n%s\n", signed.Signature, signed.Body)
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *target, bytes.NewReader(signed.Body))
+	if err != nil {
+		return err
+	}
+	req.Header = signed.Header()
+
+	res, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(res.Body)
+
+	fmt.Fprintf(out, "%s %s: %d %s\n", event.Type, event.ID, res.StatusCode, bytes.TrimSpace(msg))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%w: %s returned %d", ErrWebhookRejected, *target, res.StatusCode)
+	}
+	return nil
+}
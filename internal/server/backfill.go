@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// scheduleBackfill runs a backfill every configured interval until the server is shut down. Each run checks the
+// events created within the configured window, leaving out the most recent ones that the payment service may still
+// be retrying.
+func (s *Server) scheduleBackfill() {
+	ticker := time.NewTicker(s.backfillConfig.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.runBackfill()
+		}
+	}
+}
+
+// runBackfill runs a single scheduled backfill.
+func (s *Server) runBackfill() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	now := time.Now()
+	from, to := now.Add(-s.backfillConfig.Window), now.Add(-s.backfillConfig.Delay)
+	if !from.Before(to) {
+		s.logger.Println("Skipping scheduled backfill, the backfill window is shorter than the delay")
+		return
+	}
+	if _, err := s.backfill.Run(ctx, from, to, false); err != nil {
+		s.logger.Println("Scheduled backfill failed:", err)
+	}
+}
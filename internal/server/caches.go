@@ -0,0 +1,55 @@
+package server
+
+import (
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/cache"
+	"net/http"
+)
+
+// CacheStatsResponse is the response of the cache stats endpoint.
+type CacheStatsResponse struct {
+	// Caches contains the stats of the caches of the lookups made to the credits service and the customers service.
+	Caches []cache.Stats `json:"caches"`
+}
+
+// CacheStats is an HTTP handler that returns the hit and miss stats of the lookup caches. It requires an authenticated
+// caller with access to all applications.
+func (s *Server) CacheStats(w http.ResponseWriter, r *http.Request) {
+	if err := s.authorizeAdmin(r.Context()); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	out := CacheStatsResponse{
+		Caches: []cache.Stats{},
+	}
+	for _, c := range s.caches {
+		out.Caches = append(out.Caches, c.Stats())
+	}
+	s.writeResponse(w, &out)
+}
+
+// PurgeCache is an HTTP handler that removes every value kept in the given lookup cache, so the next lookups are made
+// to the service again. Only the cache of the replica handling the request is purged. It requires an authenticated
+// caller with access to all applications.
+//
+//	URL parameters:
+//		name: Name of the cache, unit_prices, customers or prices.
+func (s *Server) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	if err := s.authorizeAdmin(r.Context()); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	for _, c := range s.caches {
+		if c.Name() == name {
+			c.Purge()
+			s.logger.Println("Purged cache:", name)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	s.writeError(w, r, api.ErrNotFound)
+}
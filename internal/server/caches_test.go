@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/cache"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCaches(t *testing.T) {
+	prices := cache.NewCache(cache.Options{Name: "unit_prices", TTL: time.Minute})
+	s := NewServer(Options{
+		config: conf.Config{},
+		logger: log.New(io.Discard, "", 0),
+		authenticator: auth.NewAPIKeyAuthenticator(map[string]string{
+			"key1": "fuel",
+			"key2": auth.AnyApplication,
+		}),
+		caches: []*cache.Cache{prices},
+	})
+
+	load := func(ctx context.Context) (interface{}, error) { return uint(100), nil }
+	for i := 0; i < 3; i++ {
+		_, err := prices.Get(context.Background(), "usd", load)
+		require.NoError(t, err)
+	}
+
+	serve := func(method, path string, signer auth.Signer) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if signer != nil {
+			require.NoError(t, signer.Sign(req, nil))
+		}
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Reading the stats requires access to all applications
+	rr := serve(http.MethodGet, "/payments/caches", nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = serve(http.MethodGet, "/payments/caches", auth.NewAPIKeySigner("key1"))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = serve(http.MethodGet, "/payments/caches", auth.NewAPIKeySigner("key2"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var out CacheStatsResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	require.Len(t, out.Caches, 1)
+	assert.Equal(t, cache.Stats{Name: "unit_prices", Hits: 2, Misses: 1, Entries: 1}, out.Caches[0])
+
+	// Purging a cache requires access to all applications
+	rr = serve(http.MethodDelete, "/payments/caches/unit_prices", nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = serve(http.MethodDelete, "/payments/caches/unit_prices", auth.NewAPIKeySigner("key1"))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, 1, prices.Stats().Entries)
+
+	rr = serve(http.MethodDelete, "/payments/caches/unit_prices", auth.NewAPIKeySigner("key2"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Zero(t, prices.Stats().Entries)
+
+	rr = serve(http.MethodDelete, "/payments/caches/balances", auth.NewAPIKeySigner("key2"))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestCachesWithoutAuthentication(t *testing.T) {
+	prices := cache.NewCache(cache.Options{Name: "unit_prices", TTL: time.Minute})
+	_, err := prices.Get(context.Background(), "usd", func(ctx context.Context) (interface{}, error) { return uint(100), nil })
+	require.NoError(t, err)
+
+	s := NewServer(Options{
+		config: conf.Config{},
+		logger: log.New(io.Discard, "", 0),
+		caches: []*cache.Cache{prices},
+	})
+
+	// Caches can't be read or purged when authentication is disabled.
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		path := "/payments/caches"
+		if method == http.MethodDelete {
+			path += "/unit_prices"
+		}
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code, method)
+	}
+	assert.Equal(t, 1, prices.Stats().Entries)
+
+	// Handlers refuse callers that haven't been authenticated, even if they're mounted elsewhere.
+	rr := httptest.NewRecorder()
+	s.PurgeCache(rr, httptest.NewRequest(http.MethodDelete, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, 1, prices.Stats().Entries)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	fakecredits "gitlab.com/ignitionrobotics/billing/credits/pkg/fake"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	fakecustomers "gitlab.com/ignitionrobotics/billing/customers/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/application"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/stripetest"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckoutFlow(t *testing.T) {
+	// The payments server is started first, so Stripe can send events to it
+	var payments http.Handler
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payments.ServeHTTP(w, r)
+	}))
+	defer target.Close()
+
+	stripeServer := stripetest.NewServer(stripetest.Options{
+		WebhookURL:    target.URL + "/payments/webhooks/stripe",
+		WebhookEvents: adapter.WebhookEvents,
+	})
+	defer stripeServer.Close()
+
+	var cfg conf.Config
+	cfg.Stripe = stripeServer.Config()
+	cfg.Stripe.WebhookReplayWindow = time.Hour
+	a := adapter.NewStripeAdapter(cfg.Stripe)
+
+	cus, err := a.CreateCustomer(context.Background(), "fuel", "alice")
+	require.NoError(t, err)
+
+	creditsClient := fakecredits.NewClient()
+	customersClient := fakecustomers.NewClient()
+	customer := customers.CustomerResponse{
+		Handle:      "alice",
+		ID:          cus,
+		Service:     string(api.PaymentServiceStripe),
+		Application: "fuel",
+	}
+	customersClient.On("GetCustomerByHandle", mock.Anything, customers.GetCustomerByHandleRequest{
+		Handle:      "alice",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "fuel",
+	}).Return(customer, error(nil))
+	customersClient.On("GetCustomerByID", mock.Anything, customers.GetCustomerByIDRequest{
+		ID:          cus,
+		Service:     string(api.PaymentServiceStripe),
+		Application: "fuel",
+	}).Return(customer, error(nil))
+	creditsClient.On("GetUnitPrice", mock.Anything, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   100,
+		Currency: "usd",
+	}, error(nil))
+	creditsClient.On("IncreaseCredits", mock.Anything, credits.IncreaseCreditsRequest{
+		Transaction: credits.Transaction{
+			Handle:      "alice",
+			Amount:      500,
+			Currency:    "usd",
+			Application: "fuel",
+		},
+	}).Return(credits.IncreaseCreditsResponse{}, error(nil)).Once()
+
+	logger := log.New(io.Discard, "", 0)
+	s := NewServer(Options{
+		config: cfg,
+		logger: logger,
+		payments: application.NewPaymentsService(application.Options{
+			Credits:   creditsClient,
+			Customers: customersClient,
+			Adapter:   a,
+			Logger:    logger,
+			Timeout:   time.Second,
+		}),
+		adapter: a,
+	})
+	payments = s.router
+
+	body := `{"service": "stripe", "application": "fuel", "handle": "alice", "success_url": "https://fuel.example.com/success", "cancel_url": "https://fuel.example.com/cancel"}`
+	res, err := http.Post(target.URL+"/payments/session", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var session api.CreateSessionResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&session))
+	require.NotEmpty(t, session.Session)
+
+	// The customer buys 5 credits
+	pi, err := stripeServer.Pay(session.Session, 5)
+	require.NoError(t, err)
+	creditsClient.AssertExpectations(t)
+
+	charged, ok := stripeServer.PaymentIntent(pi.ID)
+	require.True(t, ok)
+	assert.NotEmpty(t, charged.Metadata[adapter.MetadataChargedEvent])
+
+	// Further deliveries of the same event don't grant credits again
+	deliveries := stripeServer.Deliveries()
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+	require.NoError(t, stripeServer.Resend(deliveries[0].Event))
+	creditsClient.AssertNumberOfCalls(t, "IncreaseCredits", 1)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"net/http"
+)
+
+// CreateAccountLink is an HTTP handler to call the api.AccountsV1's CreateAccountLink method.
+func (s *Server) CreateAccountLink(w http.ResponseWriter, r *http.Request) {
+	var in api.CreateAccountLinkRequest
+	if err := s.readBodyJSON(w, r, &in); err != nil {
+		return
+	}
+
+	if err := s.authorize(r.Context(), in.Application); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	out, err := s.payments.CreateAccountLink(r.Context(), in)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	s.writeResponse(w, &out)
+}
+
+// GetAccount is an HTTP handler to call the api.AccountsV1's GetAccount method.
+func (s *Server) GetAccount(w http.ResponseWriter, r *http.Request) {
+	in := api.GetAccountRequest{
+		Application: chi.URLParam(r, "application"),
+	}
+
+	if err := s.authorize(r.Context(), in.Application); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	out, err := s.payments.GetAccount(r.Context(), in)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	s.writeResponse(w, &out)
+}
+
+// connectWebhook processes the Stripe webhook events related to connected accounts: account updates and payments to
+// connected accounts that must be captured.
+func (s *Server) connectWebhook(w http.ResponseWriter, r *http.Request, body []byte) {
+	event, err := s.connector.GenerateConnectEvent(r.Context(), body, r.Header)
+	if rejectedEvent(err) {
+		s.logger.Println("Rejected connect event:", err)
+		http.Error(w, fmt.Sprintf("%s - %v", http.StatusText(http.StatusBadRequest), err), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, adapter.ErrUnhandledEvent) {
+		s.ignoreEvent(w, err)
+		return
+	}
+	if err != nil {
+		s.logger.Println("Failed to generate connect event:", err)
+		http.Error(w, fmt.Sprintf("%s - %s: %v", http.StatusText(http.StatusInternalServerError), "Failed to generate connect event", err), http.StatusInternalServerError)
+		return
+	}
+
+	var id string
+	switch {
+	case event.Account != nil:
+		id = event.Account.Event
+	case event.Capture != nil:
+		id = event.Capture.Event
+	}
+
+	if !s.checkReplay(w, r, id) {
+		return
+	}
+
+	switch {
+	case event.Account != nil:
+		err = s.payments.UpdateAccount(r.Context(), *event.Account)
+	case event.Capture != nil:
+		err = s.payments.Capture(r.Context(), *event.Capture)
+	}
+	if err != nil {
+		s.logger.Println("Failed to process connect event:", err)
+		s.forgetEvent(r.Context(), id)
+		http.Error(w, fmt.Sprintf("%s - %s: %v", http.StatusText(http.StatusInternalServerError), "Failed to process connect event", err), http.StatusInternalServerError)
+		return
+	}
+	s.finishEvent(r.Context(), id)
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(fmt.Sprintf("%s - Event processed", http.StatusText(http.StatusOK))))
+}
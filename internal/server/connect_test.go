@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/application"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/connect"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func connectServer(a adapter.Client, connector adapter.Connector, accounts connect.Store) *Server {
+	var cfg conf.Config
+	cfg.Stripe.WebhookReplayWindow = time.Hour
+
+	logger := log.New(io.Discard, "", 0)
+	return NewServer(Options{
+		config: cfg,
+		logger: logger,
+		payments: application.NewPaymentsService(application.Options{
+			Adapter:         a,
+			Logger:          logger,
+			Timeout:         200 * time.Millisecond,
+			Connector:       connector,
+			Accounts:        accounts,
+			ApplicationFees: map[string]float64{"fuel": 10},
+		}),
+		adapter:   a,
+		connector: connector,
+	})
+}
+
+func TestCreateAccountLinkHandler(t *testing.T) {
+	connector := &fake.Connector{}
+	connector.On("CreateAccount", mock.Anything, "fuel").Return("acct_1", nil)
+	connector.On("CreateAccountLink", mock.Anything, "acct_1", mock.Anything, mock.Anything).Return("https://connect.stripe.com/setup/e/acct_1", nil)
+	s := connectServer(&fake.Adapter{}, connector, connect.NewMemoryStore())
+
+	body := `{"service": "stripe", "application": "fuel", "refresh_url": "https://fuel.example.com/refresh", "return_url": "https://fuel.example.com/return"}`
+	req, err := http.NewRequest(http.MethodPost, "/payments/connect/accounts", strings.NewReader(body))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var out api.CreateAccountLinkResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	assert.Equal(t, "acct_1", out.Account.ID)
+	assert.Equal(t, "https://connect.stripe.com/setup/e/acct_1", out.URL)
+
+	req, err = http.NewRequest(http.MethodGet, "/payments/connect/accounts/fuel", http.NoBody)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var account api.Account
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &account))
+	assert.Equal(t, api.Account{Application: "fuel", ID: "acct_1"}, account)
+}
+
+func TestCreateAccountLinkHandlerUnavailable(t *testing.T) {
+	s := connectServer(&fake.Adapter{}, &fake.Connector{}, connect.NewMemoryStore())
+
+	body := `{"service": "stripe", "application": "cloudsim", "refresh_url": "https://cloudsim.example.com/refresh", "return_url": "https://cloudsim.example.com/return"}`
+	req, err := http.NewRequest(http.MethodPost, "/payments/connect/accounts", strings.NewReader(body))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), string(api.ErrorCodeConnectUnavailable))
+}
+
+func TestStripeWebhookAccountUpdated(t *testing.T) {
+	body := []byte(`{"id": "evt_1", "type": "account.updated"}`)
+	header := http.Header{}
+	header.Set("Stripe-Signature", "t=1,v1=abc")
+
+	a := &fake.Adapter{}
+	a.On("GenerateChargeRequest", mock.Anything, body, mock.Anything).Return(api.ChargeRequest{}, fmt.Errorf("%w: account.updated", adapter.ErrUnhandledEvent))
+
+	update := api.AccountUpdate{Event: "evt_1", Created: time.Now(), ID: "acct_1", DetailsSubmitted: true, ChargesEnabled: true, PayoutsEnabled: true}
+	connector := &fake.Connector{}
+	connector.On("GenerateConnectEvent", mock.Anything, body, mock.Anything).Return(adapter.ConnectEvent{Account: &update}, nil)
+
+	accounts := connect.NewMemoryStore()
+	require.NoError(t, accounts.Save(context.Background(), connect.Account{Application: "fuel", ID: "acct_1"}))
+
+	s := connectServer(a, connector, accounts)
+
+	for _, code := range []int{http.StatusOK, http.StatusBadRequest} {
+		req, err := http.NewRequest(http.MethodPost, "/payments/webhooks/stripe/connect", strings.NewReader(string(body)))
+		require.NoError(t, err)
+		req.Header = header
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, req)
+		assert.Equal(t, code, rr.Code)
+	}
+
+	account, err := accounts.Find(context.Background(), "fuel")
+	require.NoError(t, err)
+	assert.True(t, account.PayoutsEnabled)
+}
+
+func TestStripeWebhookConnectUnhandledEvent(t *testing.T) {
+	body := []byte(`{"id": "evt_1", "type": "customer.created"}`)
+	header := http.Header{}
+	header.Set("Stripe-Signature", "t=1,v1=abc")
+
+	a := &fake.Adapter{}
+	a.On("GenerateChargeRequest", mock.Anything, body, mock.Anything).Return(api.ChargeRequest{}, fmt.Errorf("%w: customer.created", adapter.ErrUnhandledEvent))
+
+	connector := &fake.Connector{}
+	connector.On("GenerateConnectEvent", mock.Anything, body, mock.Anything).Return(adapter.ConnectEvent{}, fmt.Errorf("%w: customer.created", adapter.ErrUnhandledEvent))
+
+	s := connectServer(a, connector, connect.NewMemoryStore())
+
+	req, err := http.NewRequest(http.MethodPost, "/payments/webhooks/stripe", strings.NewReader(string(body)))
+	require.NoError(t, err)
+	req.Header = header
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
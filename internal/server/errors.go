@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/breaker"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ratelimit"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// statusCodes maps api.ErrorCode values to HTTP status codes.
+var statusCodes = map[api.ErrorCode]int{
+	api.ErrorCodeEmptyService:       http.StatusUnprocessableEntity,
+	api.ErrorCodeInvalidService:     http.StatusUnprocessableEntity,
+	api.ErrorCodeEmptyCallbacks:     http.StatusUnprocessableEntity,
+	api.ErrorCodeInvalidURL:         http.StatusUnprocessableEntity,
+	api.ErrorCodeEmptyHandle:        http.StatusUnprocessableEntity,
+	api.ErrorCodeEmptyApplication:   http.StatusUnprocessableEntity,
+	api.ErrorCodeInvalidUnitPrice:   http.StatusBadGateway,
+	api.ErrorCodeConnectUnavailable: http.StatusUnprocessableEntity,
+	api.ErrorCodeMalformedRequest:   http.StatusBadRequest,
+	api.ErrorCodeUnauthenticated:    http.StatusUnauthorized,
+	api.ErrorCodeForbidden:          http.StatusForbidden,
+	api.ErrorCodeNotFound:           http.StatusNotFound,
+	api.ErrorCodeConflict:           http.StatusConflict,
+	api.ErrorCodeRateLimited:        http.StatusTooManyRequests,
+	api.ErrorCodeUpstream:           http.StatusBadGateway,
+	api.ErrorCodeProvider:           http.StatusBadGateway,
+	api.ErrorCodeTimeout:            http.StatusGatewayTimeout,
+	api.ErrorCodeInternal:           http.StatusInternalServerError,
+}
+
+// statusCode returns the HTTP status code for the given api.ErrorCode.
+func statusCode(code api.ErrorCode) int {
+	status, ok := statusCodes[code]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// writeError writes the given error as a JSON api.Error body, using the HTTP status code that matches the error code.
+// The Retry-After header is set if the error has been caused by an exceeded rate limit or an open circuit breaker.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var exceeded *ratelimit.ExceededError
+	if errors.As(err, &exceeded) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds()))))
+	}
+	var open *breaker.OpenError
+	if errors.As(err, &open) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(open.RetryAfter.Seconds()))))
+	}
+
+	code := api.ErrorCodeOf(err)
+	out := api.Error{
+		Code:      code,
+		Message:   api.Message(err),
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	if out.Message != err.Error() {
+		s.logger.Printf("Request %s failed: %v\n", out.RequestID, err)
+	}
+
+	body, err := json.Marshal(out)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode(code))
+	if _, err = w.Write(body); err != nil {
+		s.logger.Println("Failed to write error body:", err)
+	}
+}
+
+// notFound is an HTTP handler used when no route matches the incoming request.
+func (s *Server) notFound(w http.ResponseWriter, r *http.Request) {
+	s.writeError(w, r, api.ErrNotFound)
+}
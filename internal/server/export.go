@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/export"
+	"net/http"
+)
+
+// ExportPayments is an HTTP handler that streams the successful payments of a time window as CSV or JSON Lines.
+//
+//	Query parameters:
+//		from: Beginning of the time window, in RFC 3339 or YYYY-MM-DD format.
+//		to: End of the time window (exclusive), in RFC 3339 or YYYY-MM-DD format.
+//		application: Only export the payments of this application. Exporting every application requires an
+//		authenticated caller with access to all applications.
+//		format: csv (default) or jsonl.
+//
+// Errors found after the first payment has been written can't be reported to the caller, the response is truncated
+// instead.
+func (s *Server) ExportPayments(w http.ResponseWriter, r *http.Request) {
+	if s.inspector == nil {
+		s.writeError(w, r, api.ErrNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	from, to, err := export.ParseRange(q.Get("from"), q.Get("to"))
+	if err != nil {
+		s.writeError(w, r, api.WrapError(api.ErrorCodeMalformedRequest, err))
+		return
+	}
+
+	format := export.FormatCSV
+	if f := q.Get("format"); len(f) > 0 {
+		format = export.Format(f)
+	}
+	if err = format.Validate(); err != nil {
+		s.writeError(w, r, api.WrapError(api.ErrorCodeMalformedRequest, err))
+		return
+	}
+
+	application := q.Get("application")
+	if len(application) == 0 {
+		err = s.authorizeAdmin(r.Context())
+	} else {
+		err = s.authorize(r.Context(), application)
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	writer, err := export.NewWriter(format, w)
+	if err != nil {
+		s.writeError(w, r, api.WrapError(api.ErrorCodeInternal, err))
+		return
+	}
+
+	var count int
+	flusher, _ := w.(http.Flusher)
+	writeHeaders := func() {
+		name := fmt.Sprintf("payments-%s-%s.%s", from.Format("20060102"), to.Format("20060102"), format)
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		w.WriteHeader(http.StatusOK)
+	}
+
+	err = s.inspector.ListPayments(r.Context(), from, to, application, func(p adapter.Payment) error {
+		if count == 0 {
+			writeHeaders()
+		}
+		count++
+		if err := writer.Write(p); err != nil {
+			return err
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Println("Failed to export payments:", err)
+		if count == 0 {
+			s.writeError(w, r, api.WrapError(api.ErrorCodeProvider, err))
+		}
+		return
+	}
+
+	if count == 0 {
+		writeHeaders()
+	}
+	if err = writer.Flush(); err != nil {
+		s.logger.Println("Failed to write export:", err)
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminSigner signs the requests of a caller with access to all applications.
+var adminSigner = auth.NewAPIKeySigner("admin")
+
+func exportServer(inspector adapter.Inspector) *Server {
+	return NewServer(Options{
+		config:    conf.Config{},
+		logger:    log.New(io.Discard, "", 0),
+		inspector: inspector,
+		authenticator: auth.NewAPIKeyAuthenticator(map[string]string{
+			"admin": auth.AnyApplication,
+			"key1":  "fuel",
+		}),
+	})
+}
+
+func exportRequest(t *testing.T, s *Server, query string, signer auth.Signer) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(http.MethodGet, "/payments/export?"+query, http.NoBody)
+	require.NoError(t, err)
+	if signer != nil {
+		require.NoError(t, signer.Sign(req, nil))
+	}
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestExportPaymentsCSV(t *testing.T) {
+	from := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 11, 30, 23, 59, 59, 0, time.UTC)
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListPayments", mock.Anything, from, to, "fuel", mock.Anything).Return([]adapter.Payment{
+		{ID: "pi_1", Application: "fuel", Gross: 1000, Currency: "usd", RefundStatus: adapter.RefundStatusNone},
+		{ID: "pi_2", Application: "fuel", Gross: 2000, Currency: "usd", RefundStatus: adapter.RefundStatusFull},
+	}, error(nil))
+
+	s := exportServer(inspector)
+	rr := exportRequest(t, s, "from=2021-11-01&to=2021-12-01&application=fuel", adminSigner)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), "payments-20211101-20211130.csv")
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "id,charge,"))
+	assert.True(t, strings.HasPrefix(lines[1], "pi_1,"))
+	assert.Contains(t, lines[2], ",full,")
+}
+
+func TestExportPaymentsJSONL(t *testing.T) {
+	inspector := &fake.Inspector{}
+	inspector.On("ListPayments", mock.Anything, mock.Anything, mock.Anything, "", mock.Anything).Return([]adapter.Payment{
+		{ID: "pi_1", Gross: 1000},
+	}, error(nil))
+
+	s := exportServer(inspector)
+	rr := exportRequest(t, s, "from=2021-11-01&to=2021-12-01&format=jsonl", adminSigner)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+
+	var p adapter.Payment
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+	assert.Equal(t, "pi_1", p.ID)
+}
+
+func TestExportPaymentsEmpty(t *testing.T) {
+	inspector := &fake.Inspector{}
+	inspector.On("ListPayments", mock.Anything, mock.Anything, mock.Anything, "", mock.Anything).Return([]adapter.Payment{}, error(nil))
+
+	s := exportServer(inspector)
+	rr := exportRequest(t, s, "from=2021-11-01&to=2021-12-01", adminSigner)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "id,charge,"))
+}
+
+func TestExportPaymentsInvalidQuery(t *testing.T) {
+	s := exportServer(&fake.Inspector{})
+
+	for _, query := range []string{
+		"to=2021-12-01",
+		"from=2021-12-01&to=2021-11-01",
+		"from=2021-11-01&to=2021-12-01&format=xlsx",
+	} {
+		rr := exportRequest(t, s, query, adminSigner)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+
+		var out api.Error
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+		assert.Equal(t, api.ErrorCodeMalformedRequest, out.Code)
+	}
+}
+
+func TestExportPaymentsForbidden(t *testing.T) {
+	s := exportServer(&fake.Inspector{})
+	signer := auth.NewAPIKeySigner("key1")
+
+	// Exporting every application requires access to all of them
+	rr := exportRequest(t, s, "from=2021-11-01&to=2021-12-01", signer)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = exportRequest(t, s, "from=2021-11-01&to=2021-12-01&application=test", signer)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = exportRequest(t, s, "from=2021-11-01&to=2021-12-01&application=fuel", nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestExportPaymentsProviderError(t *testing.T) {
+	inspector := &fake.Inspector{}
+	inspector.On("ListPayments", mock.Anything, mock.Anything, mock.Anything, "", mock.Anything).Return([]adapter.Payment{}, errors.New("stripe unavailable"))
+
+	s := exportServer(inspector)
+	rr := exportRequest(t, s, "from=2021-11-01&to=2021-12-01", adminSigner)
+
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+}
+
+func TestExportPaymentsWithoutAuthentication(t *testing.T) {
+	s := NewServer(Options{
+		config:    conf.Config{},
+		logger:    log.New(io.Discard, "", 0),
+		inspector: &fake.Inspector{},
+	})
+
+	// Exports aren't served when authentication is disabled.
+	rr := exportRequest(t, s, "from=2021-11-01&to=2021-12-01", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
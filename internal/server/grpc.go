@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api/pb"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// grpcPayments exposes the api.PaymentsV1 methods through gRPC. It performs the same authorization and rate limiting
+// checks as the HTTP handlers.
+type grpcPayments struct {
+	pb.UnimplementedPaymentsV1Server
+
+	// server contains the components shared with the HTTP transport.
+	server *Server
+}
+
+// CreateSession calls the api.PaymentsV1's CreateSession method.
+func (g *grpcPayments) CreateSession(ctx context.Context, in *pb.CreateSessionRequest) (*pb.CreateSessionResponse, error) {
+	requestID := middleware.GetReqID(ctx)
+	req := rpc.CreateSessionRequestFromPB(in)
+
+	if err := g.server.authorize(ctx, req.Application); err != nil {
+		return nil, rpc.ToStatus(err, requestID)
+	}
+
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(api.HeaderIdempotencyKey); len(values) > 0 {
+			key = values[0]
+		}
+	}
+
+	out, _, err := g.server.createSession(ctx, key, req)
+	if err != nil {
+		if api.Message(err) != err.Error() {
+			g.server.logger.Printf("Request %s failed: %v\n", requestID, err)
+		}
+		return nil, rpc.ToStatus(err, requestID)
+	}
+	return rpc.CreateSessionResponseToPB(out), nil
+}
+
+// requestIDInterceptor is a gRPC interceptor that assigns a request ID to every call, in the same way
+// middleware.RequestID does for HTTP requests.
+func requestIDInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx = context.WithValue(ctx, middleware.RequestIDKey, fmt.Sprintf("grpc-%06d", middleware.NextRequestID()))
+	return handler(ctx, req)
+}
+
+// newGRPCServer initializes a gRPC server that serves the api.PaymentsV1 methods using the components of the given
+// Server.
+func newGRPCServer(s *Server) *grpc.Server {
+	interceptors := []grpc.UnaryServerInterceptor{requestIDInterceptor}
+	if s.authenticator != nil {
+		interceptors = append(interceptors, rpc.UnaryServerInterceptor(s.authenticator))
+	}
+
+	gs := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	pb.RegisterPaymentsV1Server(gs, &grpcPayments{server: s})
+	return gs
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	fakecredits "gitlab.com/ignitionrobotics/billing/credits/pkg/fake"
+	fakecustomers "gitlab.com/ignitionrobotics/billing/customers/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/application"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+type grpcTestSuite struct {
+	suite.Suite
+	Credits   *fakecredits.Fake
+	Customers *fakecustomers.Fake
+	Payments  application.Service
+	Listener  *bufconn.Listener
+	Server    *Server
+}
+
+func TestGRPCSuite(t *testing.T) {
+	suite.Run(t, new(grpcTestSuite))
+}
+
+func (s *grpcTestSuite) SetupTest() {
+	logger := log.New(io.Discard, "", 0)
+	s.Credits = fakecredits.NewClient()
+	s.Customers = fakecustomers.NewClient()
+	s.Payments = application.NewPaymentsService(application.Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   adapter.NewStripeAdapter(conf.Stripe{}),
+		Logger:    logger,
+		Timeout:   200 * time.Millisecond,
+	})
+}
+
+// serve starts a gRPC server in memory using the given authenticator and returns a client connected to it.
+func (s *grpcTestSuite) serve(authenticator auth.Authenticator, opts ...grpc.DialOption) client.Client {
+	s.Server = NewServer(Options{
+		payments:      s.Payments,
+		logger:        log.New(io.Discard, "", 0),
+		authenticator: authenticator,
+	})
+
+	s.Listener = bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = s.Server.grpcServer.Serve(s.Listener)
+	}()
+
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return s.Listener.Dial()
+	}
+	opts = append(opts, grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	conn, err := grpc.Dial("bufnet", opts...)
+	s.Require().NoError(err)
+
+	return client.NewPaymentsClientV1GRPC(conn)
+}
+
+func (s *grpcTestSuite) TearDownTest() {
+	if s.Server != nil {
+		s.Server.grpcServer.Stop()
+	}
+}
+
+func (s *grpcTestSuite) TestCreateSessionValidationError() {
+	c := s.serve(nil)
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+
+	_, err := c.CreateSession(context.Background(), api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Application: "test",
+	})
+	s.Require().Error(err)
+	s.Assert().True(errors.Is(err, api.ErrEmptyHandle))
+
+	var apiErr *api.Error
+	s.Require().True(errors.As(err, &apiErr))
+	s.Assert().Equal(api.ErrorCodeEmptyHandle, apiErr.Code)
+	s.Assert().NotEmpty(apiErr.RequestID)
+}
+
+func (s *grpcTestSuite) TestCreateSessionUpstreamError() {
+	c := s.serve(nil)
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{}, errors.New("credits service failed"))
+
+	_, err := c.CreateSession(context.Background(), api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().Error(err)
+	s.Assert().Equal(api.ErrorCodeUpstream, api.ErrorCodeOf(err))
+	s.Assert().NotContains(err.Error(), "credits service failed")
+}
+
+func (s *grpcTestSuite) TestCreateSessionUnauthenticated() {
+	c := s.serve(auth.NewAPIKeyAuthenticator(map[string]string{"key1": "test"}))
+
+	_, err := c.CreateSession(context.Background(), api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().Error(err)
+	s.Assert().True(errors.Is(err, api.ErrUnauthenticated))
+
+	// Errors include the request ID, as HTTP error bodies do
+	var apiErr *api.Error
+	s.Require().True(errors.As(err, &apiErr))
+	s.Assert().NotEmpty(apiErr.RequestID)
+}
+
+func (s *grpcTestSuite) TestCreateSessionForbiddenApplication() {
+	c := s.serve(
+		auth.NewAPIKeyAuthenticator(map[string]string{"key1": "fuel"}),
+		client.GRPCCredentials(auth.NewAPIKeySigner("key1")),
+	)
+
+	_, err := c.CreateSession(context.Background(), api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().Error(err)
+	s.Assert().True(errors.Is(err, api.ErrForbidden))
+}
+
+func (s *grpcTestSuite) TestCreateSessionAuthenticated() {
+	c := s.serve(
+		auth.NewHMACAuthenticator(map[string]string{"test": "secret"}, time.Minute),
+		client.GRPCCredentials(auth.NewHMACSigner("test", "secret")),
+	)
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+
+	// Credentials are accepted, the request reaches the validation step.
+	_, err := c.CreateSession(context.Background(), api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Application: "test",
+	})
+	s.Require().Error(err)
+	s.Assert().True(errors.Is(err, api.ErrEmptyHandle))
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
 	"io"
 	"net/http"
@@ -29,19 +31,45 @@ func (s *Server) StripeWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req, err := s.adapter.GenerateChargeRequest(body, r.Header)
+	req, err := s.adapter.GenerateChargeRequest(r.Context(), body, r.Header)
+	if rejectedEvent(err) {
+		s.logger.Println("Rejected webhook event:", err)
+		http.Error(w, fmt.Sprintf("%s - %v", http.StatusText(http.StatusBadRequest), err), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, adapter.ErrUnhandledEvent) && s.connector != nil {
+		s.connectWebhook(w, r, body)
+		return
+	}
+	if errors.Is(err, adapter.ErrUnhandledEvent) {
+		s.ignoreEvent(w, err)
+		return
+	}
 	if err != nil {
 		s.logger.Println("Failed to generate charge request:", err)
 		http.Error(w, fmt.Sprintf("%s - %s: %v", http.StatusText(http.StatusInternalServerError), "Failed to generate charge request", err), http.StatusInternalServerError)
 		return
 	}
 
+	if !s.checkReplay(w, r, req.Event) {
+		return
+	}
+
 	_, err = s.payments.Charge(r.Context(), req)
+	if errors.Is(err, api.ErrAlreadyCharged) {
+		s.logger.Println("Payment already charged:", req.Payment)
+		s.finishEvent(r.Context(), req.Event)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(fmt.Sprintf("%s - Payment already processed", http.StatusText(http.StatusOK))))
+		return
+	}
 	if err != nil {
 		s.logger.Println("Failed to process charge:", err)
+		s.forgetEvent(r.Context(), req.Event)
 		http.Error(w, fmt.Sprintf("%s - %s: %v", http.StatusText(http.StatusInternalServerError), "Failed to charge customer", err), http.StatusInternalServerError)
 		return
 	}
+	s.finishEvent(r.Context(), req.Event)
 
 	w.WriteHeader(http.StatusOK)
 	if _, err = w.Write([]byte(fmt.Sprintf("%s - Payment processed", http.StatusText(http.StatusCreated)))); err != nil {
@@ -49,6 +77,21 @@ func (s *Server) StripeWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// rejectedEvent returns true if the given error has been caused by a webhook event that can't be processed, such as
+// events with an invalid signature, malformed data or without the metadata set by the payments service. Processing them
+// again would fail the same way.
+func rejectedEvent(err error) bool {
+	return errors.Is(err, adapter.ErrLivemodeMismatch) || errors.Is(err, adapter.ErrInvalidSignature) ||
+		errors.Is(err, adapter.ErrMissingMetadata) || errors.Is(err, adapter.ErrMalformedEvent)
+}
+
+// ignoreEvent acknowledges a webhook event of a type that isn't handled, so Stripe doesn't keep retrying it.
+func (s *Server) ignoreEvent(w http.ResponseWriter, err error) {
+	s.logger.Println("Ignoring webhook event:", err)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(fmt.Sprintf("%s - Event ignored", http.StatusText(http.StatusOK))))
+}
+
 // CreateSession is an HTTP handler to call the api.PaymentsV1's CreateSession method.
 func (s *Server) CreateSession(w http.ResponseWriter, r *http.Request) {
 	var in api.CreateSessionRequest
@@ -56,11 +99,19 @@ func (s *Server) CreateSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	out, err := s.payments.CreateSession(r.Context(), in)
+	if err := s.authorize(r.Context(), in.Application); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	out, replayed, err := s.createSession(r.Context(), r.Header.Get(api.HeaderIdempotencyKey), in)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.writeError(w, r, err)
 		return
 	}
+	if replayed {
+		w.Header().Set(headerIdempotentReplayed, "true")
+	}
 
 	s.writeResponse(w, &out)
 }
@@ -83,12 +134,12 @@ func (s *Server) writeResponse(w http.ResponseWriter, out interface{}) {
 func (s *Server) readBodyJSON(w http.ResponseWriter, r *http.Request, in interface{}) error {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("%s - %s", http.StatusText(http.StatusBadRequest), "Failed to read body"), http.StatusBadRequest)
+		s.writeError(w, r, api.WrapError(api.ErrorCodeMalformedRequest, err))
 		return err
 	}
 
 	if err = json.Unmarshal(body, &in); err != nil {
-		http.Error(w, fmt.Sprintf("%s - %s", http.StatusText(http.StatusInternalServerError), "Failed to read JSON body"), http.StatusInternalServerError)
+		s.writeError(w, r, api.WrapError(api.ErrorCodeMalformedRequest, err))
 		return err
 	}
 
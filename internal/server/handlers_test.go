@@ -2,10 +2,12 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"github.com/stripe/stripe-go/v72"
@@ -18,10 +20,14 @@ import (
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/application"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ledger"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ratelimit"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"testing"
 	"time"
@@ -77,6 +83,34 @@ func (s *handlersTestSuite) TearDownSuite() {
 }
 
 func (s *handlersTestSuite) TestWebhookEventReceived() {
+	// The processed payment intent is marked as charged in Stripe
+	var marked url.Values
+	stripeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Require().NoError(r.ParseForm())
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/payment_intents/pi_5DpcTV1eZvKYlo3Cy7cIe9am" {
+			marked = r.PostForm
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": "pi_5DpcTV1eZvKYlo3Cy7cIe9am", "object": "payment_intent"}`))
+	}))
+	defer stripeServer.Close()
+
+	cfg := s.Config.Stripe
+	cfg.URL = stripeServer.URL
+	s.Adapter = adapter.NewStripeAdapter(cfg)
+	s.Payments = application.NewPaymentsService(application.Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   s.Adapter,
+		Logger:    s.Logger,
+		Timeout:   200 * time.Millisecond,
+	})
+	s.Server = NewServer(Options{
+		config:   s.Config,
+		payments: s.Payments,
+		logger:   s.Logger,
+		adapter:  s.Adapter,
+	})
 	s.handler = http.HandlerFunc(s.Server.StripeWebhook)
 
 	body, now := s.prepareEvent(EventPaymentIntentSucceeded, stripe.PaymentIntentStatusSucceeded)
@@ -117,6 +151,54 @@ func (s *handlersTestSuite) TestWebhookEventReceived() {
 	s.handler.ServeHTTP(rr, req)
 
 	s.Assert().Equal(http.StatusOK, rr.Code)
+	s.Assert().Equal("evt_1CiPtv2eZvKYlo2CcUZsDcO6", marked.Get("metadata[charged_event]"))
+}
+
+func (s *handlersTestSuite) TestWebhookAlreadyCharged() {
+	stripeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": "pi_5DpcTV1eZvKYlo3Cy7cIe9am", "object": "payment_intent"}`))
+	}))
+	defer stripeServer.Close()
+
+	// The payment has already been charged by another event.
+	store := ledger.NewMemoryStore()
+	s.Require().NoError(store.Record(context.Background(), ledger.Grant{
+		Payment: "pi_5DpcTV1eZvKYlo3Cy7cIe9am",
+		Event:   "evt_first",
+		Amount:  100,
+	}))
+
+	cfg := s.Config.Stripe
+	cfg.URL = stripeServer.URL
+	s.Adapter = adapter.NewStripeAdapter(cfg)
+	s.Server = NewServer(Options{
+		config: s.Config,
+		payments: application.NewPaymentsService(application.Options{
+			Credits:   s.Credits,
+			Customers: s.Customers,
+			Adapter:   s.Adapter,
+			Logger:    s.Logger,
+			Timeout:   200 * time.Millisecond,
+			Ledger:    store,
+		}),
+		logger:  s.Logger,
+		adapter: s.Adapter,
+	})
+	s.handler = http.HandlerFunc(s.Server.StripeWebhook)
+
+	body, now := s.prepareEvent(EventPaymentIntentSucceeded, stripe.PaymentIntentStatusSucceeded)
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+	sig := webhook.ComputeSignature(now, body, s.Config.Stripe.SigningKey)
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(sig)))
+
+	rr := httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+
+	s.Assert().Equal(http.StatusOK, rr.Code)
+	s.Customers.AssertNotCalled(s.T(), "GetCustomerByID", mock.Anything, mock.Anything)
+	s.Credits.AssertNotCalled(s.T(), "IncreaseCredits", mock.Anything, mock.Anything)
 }
 
 func (s *handlersTestSuite) TestWebhookGetIdentityFails() {
@@ -226,8 +308,9 @@ func (s *handlersTestSuite) TestWebhookTimeout() {
 			Amount:      100,
 			Currency:    "usd",
 		},
-	}).Return(credits.IncreaseCreditsResponse{}, error(nil)).Run(func(args mock.Arguments) {
-		time.Sleep(1 * time.Second)
+	}).Return(credits.IncreaseCreditsResponse{}, context.DeadlineExceeded).Run(func(args mock.Arguments) {
+		// The credits client returns once the deadline of the request has passed.
+		<-args.Get(0).(context.Context).Done()
 	})
 
 	s.handler.ServeHTTP(rr, req)
@@ -252,7 +335,185 @@ func (s *handlersTestSuite) TestWebhookEventFailed() {
 
 	s.handler.ServeHTTP(rr, req)
 
-	s.Assert().Equal(http.StatusInternalServerError, rr.Code)
+	// Events that aren't handled are acknowledged so Stripe doesn't retry them.
+	s.Assert().Equal(http.StatusOK, rr.Code)
+	s.Customers.AssertNotCalled(s.T(), "GetCustomerByID", mock.Anything, mock.Anything)
+}
+
+func (s *handlersTestSuite) TestWebhookMalformedEvent() {
+	s.handler = http.HandlerFunc(s.Server.StripeWebhook)
+
+	now := time.Now()
+	body, err := json.Marshal(stripe.Event{
+		Created: now.Unix(),
+		Data:    &stripe.EventData{Raw: []byte(`{"id": "pi_5DpcTV1eZvKYlo3Cy7cIe9am", "amount": "one hundred"}`)},
+		ID:      "evt_1CiPtv2eZvKYlo2CcUZsDcO6",
+		Type:    EventPaymentIntentSucceeded,
+	})
+	s.Require().NoError(err)
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+
+	sig := webhook.ComputeSignature(now, body, s.Config.Stripe.SigningKey)
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(sig)))
+
+	rr := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(rr, req)
+
+	s.Assert().Equal(http.StatusBadRequest, rr.Code)
+	s.Assert().Contains(rr.Body.String(), adapter.ErrMalformedEvent.Error())
+	s.Customers.AssertNotCalled(s.T(), "GetCustomerByID", mock.Anything, mock.Anything)
+}
+
+func (s *handlersTestSuite) TestWebhookEmptyApplication() {
+	now := time.Now()
+	data, err := json.Marshal(stripe.PaymentIntent{
+		Amount:   100,
+		Currency: "usd",
+		Customer: &stripe.Customer{ID: "cus_CDQTvYK1POcCHA"},
+		ID:       "pi_5DpcTV1eZvKYlo3Cy7cIe9am",
+		Metadata: map[string]string{"application": "", "handle": ""},
+	})
+	s.Require().NoError(err)
+	body, err := json.Marshal(stripe.Event{
+		Created: now.Unix(),
+		Data:    &stripe.EventData{Raw: data},
+		ID:      "evt_1CiPtv2eZvKYlo2CcUZsDcO6",
+		Type:    EventPaymentIntentSucceeded,
+	})
+	s.Require().NoError(err)
+
+	sig := webhook.ComputeSignature(now, body, s.Config.Stripe.SigningKey)
+	header := http.Header{}
+	header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(sig)))
+
+	_, err = s.Adapter.GenerateChargeRequest(context.Background(), body, header)
+	s.Assert().ErrorIs(err, adapter.ErrMissingMetadata)
+}
+
+func (s *handlersTestSuite) TestWebhookRotatedSigningKey() {
+	cfg := s.Config.Stripe
+	cfg.SigningKeys = []string{"whsec_previous_key_1234"}
+	a := adapter.NewStripeAdapter(cfg)
+
+	body, now := s.prepareEvent(EventPaymentIntentSucceeded, stripe.PaymentIntentStatusSucceeded)
+
+	sig := webhook.ComputeSignature(now, body, "whsec_previous_key_1234")
+	header := http.Header{}
+	header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(sig)))
+
+	req, err := a.GenerateChargeRequest(context.Background(), body, header)
+	s.Require().NoError(err)
+	s.Assert().Equal("evt_1CiPtv2eZvKYlo2CcUZsDcO6", req.Event)
+	s.Assert().Equal("...1234", req.SigningKey)
+}
+
+func (s *handlersTestSuite) TestWebhookUnknownSigningKey() {
+	cfg := s.Config.Stripe
+	cfg.SigningKeys = []string{"whsec_previous_key_1234"}
+	a := adapter.NewStripeAdapter(cfg)
+
+	body, now := s.prepareEvent(EventPaymentIntentSucceeded, stripe.PaymentIntentStatusSucceeded)
+
+	sig := webhook.ComputeSignature(now, body, "whsec_unknown_key_5678")
+	header := http.Header{}
+	header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(sig)))
+
+	_, err := a.GenerateChargeRequest(context.Background(), body, header)
+	s.Assert().ErrorIs(err, webhook.ErrNoValidSignature)
+}
+
+func (s *handlersTestSuite) TestWebhookTolerance() {
+	body, now := s.prepareEvent(EventPaymentIntentSucceeded, stripe.PaymentIntentStatusSucceeded)
+	signed := now.Add(-10 * time.Minute)
+
+	sig := webhook.ComputeSignature(signed, body, s.Config.Stripe.SigningKey)
+	header := http.Header{}
+	header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", signed.Unix(), hex.EncodeToString(sig)))
+
+	_, err := s.Adapter.GenerateChargeRequest(context.Background(), body, header)
+	s.Assert().ErrorIs(err, webhook.ErrTooOld)
+
+	cfg := s.Config.Stripe
+	cfg.WebhookTolerance = 15 * time.Minute
+	_, err = adapter.NewStripeAdapter(cfg).GenerateChargeRequest(context.Background(), body, header)
+	s.Assert().NoError(err)
+}
+
+func (s *handlersTestSuite) TestWebhookLivemodeMismatch() {
+	cfg := s.Config.Stripe
+	cfg.SecretKey = "sk_live_123"
+	s.Server = NewServer(Options{
+		config:   s.Config,
+		payments: s.Payments,
+		logger:   s.Logger,
+		adapter:  adapter.NewStripeAdapter(cfg),
+	})
+	s.handler = http.HandlerFunc(s.Server.StripeWebhook)
+
+	body, now := s.prepareEvent(EventPaymentIntentSucceeded, stripe.PaymentIntentStatusSucceeded)
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+
+	sig := webhook.ComputeSignature(now, body, s.Config.Stripe.SigningKey)
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(sig)))
+
+	rr := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(rr, req)
+
+	s.Assert().Equal(http.StatusBadRequest, rr.Code)
+	s.Assert().Contains(rr.Body.String(), "livemode")
+}
+
+func (s *handlersTestSuite) TestWebhookInvalidSignature() {
+	s.handler = http.HandlerFunc(s.Server.StripeWebhook)
+
+	body, now := s.prepareEvent(EventPaymentIntentSucceeded, stripe.PaymentIntentStatusSucceeded)
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+
+	sig := webhook.ComputeSignature(now, body, "whsec_unknown_key_5678")
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(sig)))
+
+	rr := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(rr, req)
+
+	s.Assert().Equal(http.StatusBadRequest, rr.Code)
+	s.Customers.AssertNotCalled(s.T(), "GetCustomerByID", mock.Anything, mock.Anything)
+}
+
+func (s *handlersTestSuite) TestWebhookReplayedSignature() {
+	s.handler = http.HandlerFunc(s.Server.StripeWebhook)
+
+	body, now := s.prepareEvent(EventPaymentIntentSucceeded, stripe.PaymentIntentStatusSucceeded)
+	sig := webhook.ComputeSignature(now, body, s.Config.Stripe.SigningKey)
+
+	s.Customers.On("GetCustomerByID", mock.AnythingOfType("*context.timerCtx"), customers.GetCustomerByIDRequest{
+		ID:          "cus_CDQTvYK1POcCHA",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+	}).Return(customers.CustomerResponse{}, errors.New("customer service failed"))
+
+	var codes []int
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+		s.Require().NoError(err)
+		req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(sig)))
+
+		rr := httptest.NewRecorder()
+		s.handler.ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+
+	// The first delivery fails while charging, the same payload is rejected afterwards.
+	s.Assert().Equal([]int{http.StatusInternalServerError, http.StatusBadRequest}, codes)
+	s.Customers.AssertNumberOfCalls(s.T(), "GetCustomerByID", 1)
 }
 
 func (s *handlersTestSuite) TestCreateSessionOK() {
@@ -302,6 +563,255 @@ func (s *handlersTestSuite) TestCreateSessionOK() {
 	s.Assert().Equal(api.PaymentServiceStripe, out.Service)
 }
 
+func (s *handlersTestSuite) TestCreateSessionMalformedBody() {
+	s.handler = http.HandlerFunc(s.Server.CreateSession)
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("{"))
+	s.Require().NoError(err)
+
+	rr := httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+
+	s.Assert().Equal(http.StatusBadRequest, rr.Code)
+
+	var out api.Error
+	s.Require().NoError(json.Unmarshal(rr.Body.Bytes(), &out))
+	s.Assert().Equal(api.ErrorCodeMalformedRequest, out.Code)
+}
+
+func (s *handlersTestSuite) TestCreateSessionValidationError() {
+	s.handler = middleware.RequestID(http.HandlerFunc(s.Server.CreateSession))
+
+	body, err := json.Marshal(api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Application: "test",
+	})
+	s.Require().NoError(err)
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+
+	rr := httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+
+	s.Assert().Equal(http.StatusUnprocessableEntity, rr.Code)
+
+	var out api.Error
+	s.Require().NoError(json.Unmarshal(rr.Body.Bytes(), &out))
+	s.Assert().Equal(api.ErrorCodeEmptyHandle, out.Code)
+	s.Assert().NotEmpty(out.RequestID)
+}
+
+func (s *handlersTestSuite) TestCreateSessionInvalidUnitPrice() {
+	s.handler = middleware.RequestID(http.HandlerFunc(s.Server.CreateSession))
+
+	body, err := json.Marshal(api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().NoError(err)
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   0,
+		Currency: "usd",
+	}, error(nil))
+
+	rr := httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+
+	// The unit price is returned by the credits service, the request is not at fault
+	s.Assert().Equal(http.StatusBadGateway, rr.Code)
+
+	var out api.Error
+	s.Require().NoError(json.Unmarshal(rr.Body.Bytes(), &out))
+	s.Assert().Equal(api.ErrorCodeInvalidUnitPrice, out.Code)
+}
+
+func (s *handlersTestSuite) TestCreateSessionUpstreamError() {
+	s.handler = http.HandlerFunc(s.Server.CreateSession)
+
+	body, err := json.Marshal(api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().NoError(err)
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{}, errors.New("credits service failed"))
+
+	rr := httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+
+	s.Assert().Equal(http.StatusBadGateway, rr.Code)
+
+	var out api.Error
+	s.Require().NoError(json.Unmarshal(rr.Body.Bytes(), &out))
+	s.Assert().Equal(api.ErrorCodeUpstream, out.Code)
+	s.Assert().Equal(api.ErrUpstream.Error(), out.Message)
+}
+
+func (s *handlersTestSuite) TestCreateSessionRateLimited() {
+	s.Server = NewServer(Options{
+		config:   s.Config,
+		payments: s.Payments,
+		logger:   s.Logger,
+		adapter:  s.Adapter,
+		limiter:  ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Limit{}, ratelimit.PerMinute(1, 1)),
+	})
+	s.handler = http.HandlerFunc(s.Server.CreateSession)
+
+	body, err := json.Marshal(api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().NoError(err)
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{}, errors.New("credits service failed"))
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+	rr := httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+	s.Assert().Equal(http.StatusBadGateway, rr.Code)
+
+	req, err = http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+	rr = httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+	s.Assert().Equal(http.StatusTooManyRequests, rr.Code)
+	s.Assert().Equal("60", rr.Header().Get("Retry-After"))
+
+	var out api.Error
+	s.Require().NoError(json.Unmarshal(rr.Body.Bytes(), &out))
+	s.Assert().Equal(api.ErrorCodeRateLimited, out.Code)
+}
+
+func (s *handlersTestSuite) TestCreateSessionRateLimitedInvalid() {
+	s.Server = NewServer(Options{
+		config:   s.Config,
+		payments: s.Payments,
+		logger:   s.Logger,
+		adapter:  s.Adapter,
+		limiter:  ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Limit{}, ratelimit.PerMinute(1, 1)),
+	})
+	s.handler = http.HandlerFunc(s.Server.CreateSession)
+
+	in := api.CreateSessionRequest{
+		Service:     "stripe",
+		Handle:      "test",
+		Application: "test",
+	}
+	body, err := json.Marshal(in)
+	s.Require().NoError(err)
+
+	// Invalid requests don't use up the quota.
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+	rr := httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+	s.Assert().Equal(http.StatusUnprocessableEntity, rr.Code)
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{}, errors.New("credits service failed"))
+
+	in.SuccessURL = "http://localhost"
+	in.CancelURL = "http://localhost"
+	body, err = json.Marshal(in)
+	s.Require().NoError(err)
+
+	req, err = http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+	rr = httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+	s.Assert().Equal(http.StatusBadGateway, rr.Code)
+}
+
+func (s *handlersTestSuite) TestCreateSessionUnauthorized() {
+	s.Server = NewServer(Options{
+		config:        s.Config,
+		payments:      s.Payments,
+		logger:        s.Logger,
+		adapter:       s.Adapter,
+		authenticator: auth.NewAPIKeyAuthenticator(map[string]string{"key1": "test"}),
+	})
+
+	body, err := json.Marshal(api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().NoError(err)
+
+	// Missing credentials
+	req, err := http.NewRequest(http.MethodPost, "/payments/session", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+	rr := httptest.NewRecorder()
+	s.Server.router.ServeHTTP(rr, req)
+	s.Assert().Equal(http.StatusUnauthorized, rr.Code)
+
+	// Invalid credentials
+	req, err = http.NewRequest(http.MethodPost, "/payments/session", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+	s.Require().NoError(auth.NewAPIKeySigner("key2").Sign(req, body))
+	rr = httptest.NewRecorder()
+	s.Server.router.ServeHTTP(rr, req)
+	s.Assert().Equal(http.StatusUnauthorized, rr.Code)
+}
+
+func (s *handlersTestSuite) TestCreateSessionForbiddenApplication() {
+	s.Server = NewServer(Options{
+		config:        s.Config,
+		payments:      s.Payments,
+		logger:        s.Logger,
+		adapter:       s.Adapter,
+		authenticator: auth.NewAPIKeyAuthenticator(map[string]string{"key1": "fuel"}),
+	})
+
+	body, err := json.Marshal(api.CreateSessionRequest{
+		Service:     "stripe",
+		SuccessURL:  "http://localhost",
+		CancelURL:   "http://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().NoError(err)
+
+	req, err := http.NewRequest(http.MethodPost, "/payments/session", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+	s.Require().NoError(auth.NewAPIKeySigner("key1").Sign(req, body))
+
+	rr := httptest.NewRecorder()
+	s.Server.router.ServeHTTP(rr, req)
+	s.Assert().Equal(http.StatusForbidden, rr.Code)
+}
+
 func (s *handlersTestSuite) prepareEvent(eventType string, status stripe.PaymentIntentStatus) ([]byte, time.Time) {
 	now := time.Now()
 
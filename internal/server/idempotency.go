@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"time"
+)
+
+var (
+	// errIdempotencyKeyInUse is returned when a request is made with the idempotency key of a request that is still
+	// being processed.
+	errIdempotencyKeyInUse = errors.New("a request with the same idempotency key is being processed")
+
+	// errIdempotencyKeyReused is returned when an idempotency key is reused for a different request.
+	errIdempotencyKeyReused = errors.New("idempotency key has already been used for a different request")
+
+	// errIdempotencyKeyTooLong is returned when an idempotency key is longer than maxIdempotencyKeyLength.
+	errIdempotencyKeyTooLong = fmt.Errorf("idempotency key is longer than %d characters", maxIdempotencyKeyLength)
+)
+
+const (
+	// headerIdempotentReplayed is the HTTP header set in responses recorded by a previous request made with the same
+	// idempotency key.
+	headerIdempotentReplayed = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength is the maximum length of the idempotency keys sent by callers.
+	maxIdempotencyKeyLength = 128
+
+	// idempotencyReservation is the amount of time an idempotency key is held while its request is processed. It
+	// frees keys held by requests that never finished, such as the ones being processed by a replica that crashed.
+	idempotencyReservation = 2 * time.Minute
+)
+
+// createSession creates a checkout session, enforcing the rate limits. If an idempotency key is given, the response is
+// recorded, and requests repeated with the same key get the recorded response without creating another session. It
+// returns true if the response has been recorded by a previous request.
+func (s *Server) createSession(ctx context.Context, key string, in api.CreateSessionRequest) (api.CreateSessionResponse, bool, error) {
+	if len(key) == 0 || s.idempotencyTTL <= 0 {
+		out, err := s.createSessionOnce(ctx, in)
+		return out, false, err
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return api.CreateSessionResponse{}, false, api.WrapError(api.ErrorCodeMalformedRequest, errIdempotencyKeyTooLong)
+	}
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return api.CreateSessionResponse{}, false, api.WrapError(api.ErrorCodeInternal, err)
+	}
+	sum := sha256.Sum256(body)
+	fingerprint := hex.EncodeToString(sum[:])
+	key = idempotencyStoreKey(ctx, "session", key)
+	// The scoped key is forwarded to the payment service, so requests of different callers are never deduplicated.
+	in.IdempotencyKey = key
+
+	rec, started, err := s.idempotency.Begin(ctx, key, fingerprint, idempotencyReservation)
+	if err != nil {
+		s.logger.Println("Failed to check idempotency key:", err)
+		return api.CreateSessionResponse{}, false, api.WrapError(api.ErrorCodeInternal, err)
+	}
+	if !started {
+		if rec.Fingerprint != fingerprint {
+			return api.CreateSessionResponse{}, false, api.WrapError(api.ErrorCodeConflict, errIdempotencyKeyReused)
+		}
+		if !rec.Completed() {
+			return api.CreateSessionResponse{}, false, api.WrapError(api.ErrorCodeConflict, errIdempotencyKeyInUse)
+		}
+		var out api.CreateSessionResponse
+		if err = json.Unmarshal(rec.Response, &out); err != nil {
+			return api.CreateSessionResponse{}, false, api.WrapError(api.ErrorCodeInternal, err)
+		}
+		return out, true, nil
+	}
+
+	out, err := s.createSessionOnce(ctx, in)
+	if err != nil {
+		// Free the key so the request can be retried.
+		if removeErr := s.idempotency.Remove(context.Background(), key); removeErr != nil {
+			s.logger.Println("Failed to free idempotency key:", removeErr)
+		}
+		return api.CreateSessionResponse{}, false, err
+	}
+
+	res, err := json.Marshal(out)
+	if err == nil {
+		err = s.idempotency.Complete(context.Background(), key, res, s.idempotencyTTL)
+	}
+	if err != nil {
+		// The session has been created, a retry would create another one but failing the request wouldn't help.
+		s.logger.Println("Failed to record idempotent response:", err)
+	}
+	return out, false, nil
+}
+
+// createSessionOnce creates a checkout session, enforcing the rate limits. Invalid requests are rejected before
+// checking the rate limits, so they don't use up the quota.
+func (s *Server) createSessionOnce(ctx context.Context, in api.CreateSessionRequest) (api.CreateSessionResponse, error) {
+	if err := in.ValidateInput(); err != nil {
+		return api.CreateSessionResponse{}, err
+	}
+	if err := s.limit(ctx, in.Application, in.Handle); err != nil {
+		return api.CreateSessionResponse{}, err
+	}
+	return s.payments.CreateSession(ctx, in)
+}
+
+// idempotencyStoreKey returns the key used to record the response of the given operation, made with the given
+// idempotency key. Keys are scoped to the caller, so callers can't read each other's responses.
+func idempotencyStoreKey(ctx context.Context, operation, key string) string {
+	var subject string
+	if id, ok := auth.IdentityFromContext(ctx); ok {
+		subject = id.Subject
+	}
+	sum := sha256.Sum256([]byte(subject + "\x00" + key))
+	return operation + ":" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	fakecredits "gitlab.com/ignitionrobotics/billing/credits/pkg/fake"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	fakecustomers "gitlab.com/ignitionrobotics/billing/customers/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/application"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/client"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/stripetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newIdempotencyTestServer returns a server creating sessions in a Stripe emulator for the customer alice.
+func newIdempotencyTestServer(t *testing.T) *Server {
+	stripeServer := stripetest.NewServer(stripetest.Options{})
+	t.Cleanup(stripeServer.Close)
+
+	var cfg conf.Config
+	cfg.Stripe = stripeServer.Config()
+	cfg.IdempotencyTTL = time.Hour
+	a := adapter.NewStripeAdapter(cfg.Stripe)
+
+	cus, err := a.CreateCustomer(context.Background(), "fuel", "alice")
+	require.NoError(t, err)
+
+	creditsClient := fakecredits.NewClient()
+	customersClient := fakecustomers.NewClient()
+	customersClient.On("GetCustomerByHandle", mock.Anything, mock.Anything).Return(customers.CustomerResponse{
+		Handle:      "alice",
+		ID:          cus,
+		Service:     string(api.PaymentServiceStripe),
+		Application: "fuel",
+	}, error(nil))
+	creditsClient.On("GetUnitPrice", mock.Anything, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   100,
+		Currency: "usd",
+	}, error(nil))
+
+	logger := log.New(io.Discard, "", 0)
+	return NewServer(Options{
+		config: cfg,
+		logger: logger,
+		payments: application.NewPaymentsService(application.Options{
+			Credits:   creditsClient,
+			Customers: customersClient,
+			Adapter:   a,
+			Logger:    logger,
+			Timeout:   time.Second,
+		}),
+		adapter: a,
+	})
+}
+
+// idempotencyTestRequest returns a session request for the customer alice.
+func idempotencyTestRequest(successURL string) api.CreateSessionRequest {
+	return api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  successURL,
+		CancelURL:   "https://fuel.example.com/cancel",
+		Handle:      "alice",
+		Application: "fuel",
+	}
+}
+
+// postSession sends a session request to the given server with the given idempotency key.
+func postSession(t *testing.T, s *Server, key string, in api.CreateSessionRequest) *httptest.ResponseRecorder {
+	body, err := json.Marshal(in)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/payments/session", bytes.NewReader(body))
+	if len(key) > 0 {
+		req.Header.Set(api.HeaderIdempotencyKey, key)
+	}
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCreateSessionIdempotencyKey(t *testing.T) {
+	s := newIdempotencyTestServer(t)
+	in := idempotencyTestRequest("https://fuel.example.com/success")
+
+	first := postSession(t, s, "purchase-1", in)
+	require.Equal(t, http.StatusOK, first.Code)
+	assert.Empty(t, first.Header().Get(headerIdempotentReplayed))
+
+	second := postSession(t, s, "purchase-1", in)
+	require.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, "true", second.Header().Get(headerIdempotentReplayed))
+	assert.JSONEq(t, first.Body.String(), second.Body.String())
+
+	// Other keys, and requests without a key, create new sessions.
+	third := postSession(t, s, "purchase-2", in)
+	require.Equal(t, http.StatusOK, third.Code)
+	assert.NotEqual(t, first.Body.String(), third.Body.String())
+
+	fourth := postSession(t, s, "", in)
+	require.Equal(t, http.StatusOK, fourth.Code)
+	assert.NotEqual(t, first.Body.String(), fourth.Body.String())
+}
+
+func TestCreateSessionIdempotencyKeyReused(t *testing.T) {
+	s := newIdempotencyTestServer(t)
+
+	rr := postSession(t, s, "purchase-1", idempotencyTestRequest("https://fuel.example.com/success"))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = postSession(t, s, "purchase-1", idempotencyTestRequest("https://fuel.example.com/other"))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	var out api.Error
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	assert.Equal(t, api.ErrorCodeConflict, out.Code)
+}
+
+func TestCreateSessionIdempotencyKeyFailedRequest(t *testing.T) {
+	s := newIdempotencyTestServer(t)
+
+	in := idempotencyTestRequest("https://fuel.example.com/success")
+	in.Handle = ""
+	rr := postSession(t, s, "purchase-1", in)
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	// Failed requests don't hold the key.
+	rr = postSession(t, s, "purchase-1", idempotencyTestRequest("https://fuel.example.com/success"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestCreateSessionIdempotencyKeyTooLong(t *testing.T) {
+	s := newIdempotencyTestServer(t)
+
+	rr := postSession(t, s, string(make([]byte, maxIdempotencyKeyLength+1)), idempotencyTestRequest("https://fuel.example.com/success"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestCreateSessionClientRetries(t *testing.T) {
+	s := newIdempotencyTestServer(t)
+
+	// The ingress fails after the first request has been processed.
+	var calls int32
+	var first bytes.Buffer
+	ingress := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			rr := httptest.NewRecorder()
+			s.router.ServeHTTP(rr, r)
+			first.Write(rr.Body.Bytes())
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		s.router.ServeHTTP(w, r)
+	}))
+	defer ingress.Close()
+
+	u, err := url.Parse(ingress.URL)
+	require.NoError(t, err)
+	c := client.NewPaymentsClientV1(u, time.Second, client.WithRetries(client.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}))
+
+	res, err := c.CreateSession(context.Background(), idempotencyTestRequest("https://fuel.example.com/success"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	var original api.CreateSessionResponse
+	require.NoError(t, json.Unmarshal(first.Bytes(), &original))
+	assert.Equal(t, original, res)
+}
+
+func TestCreateSessionIdempotencyKeyGRPC(t *testing.T) {
+	s := newIdempotencyTestServer(t)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = s.grpcServer.Serve(lis)
+	}()
+	defer s.grpcServer.Stop()
+
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	c := client.NewPaymentsClientV1GRPC(conn)
+
+	ctx := client.WithIdempotencyKey(context.Background(), "purchase-1")
+	first, err := c.CreateSession(ctx, idempotencyTestRequest("https://fuel.example.com/success"))
+	require.NoError(t, err)
+	second, err := c.CreateSession(ctx, idempotencyTestRequest("https://fuel.example.com/success"))
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	third, err := c.CreateSession(context.Background(), idempotencyTestRequest("https://fuel.example.com/success"))
+	require.NoError(t, err)
+	assert.NotEqual(t, first, third)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ratelimit"
+	"io"
+	"net/http"
+)
+
+// newAuthenticator initializes an auth.Authenticator with the authentication methods defined in the given config.
+// It returns nil if no authentication method has been configured.
+func newAuthenticator(cfg conf.Auth) auth.Authenticator {
+	if !cfg.Enabled() {
+		return nil
+	}
+	var authenticators []auth.Authenticator
+	if len(cfg.APIKeys) > 0 {
+		authenticators = append(authenticators, auth.NewAPIKeyAuthenticator(cfg.APIKeys))
+	}
+	if len(cfg.HMACSecrets) > 0 {
+		authenticators = append(authenticators, auth.NewHMACAuthenticator(cfg.HMACSecrets, cfg.HMACTolerance))
+	}
+	if len(cfg.JWTSecret) > 0 {
+		authenticators = append(authenticators, auth.NewJWTAuthenticator([]byte(cfg.JWTSecret), cfg.JWTIssuer, cfg.JWTAudience, cfg.JWTMaxLifetime))
+	}
+	return auth.NewChain(authenticators...)
+}
+
+// authenticate is a middleware that verifies the credentials of incoming requests using the server's
+// auth.Authenticator. The identity of the caller is stored in the request context.
+// Requests are let through if the server has no auth.Authenticator.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authenticator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.writeError(w, r, api.WrapError(api.ErrorCodeMalformedRequest, err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		id, err := s.authenticator.Authenticate(r, body)
+		if err != nil {
+			s.logger.Println("Failed to authenticate request:", err)
+			s.writeError(w, r, api.WrapError(api.ErrorCodeUnauthenticated, err))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+	})
+}
+
+// authorize returns an error if the server requires authentication and the caller identified in the given context is
+// not allowed to act for the given application.
+func (s *Server) authorize(ctx context.Context, application string) error {
+	if s.authenticator == nil {
+		return nil
+	}
+	id, ok := auth.IdentityFromContext(ctx)
+	if !ok || !id.CanActFor(application) {
+		return api.WrapError(api.ErrorCodeForbidden, auth.ErrForbiddenApplication)
+	}
+	return nil
+}
+
+// authorizeAdmin returns an error unless the caller identified in the given context is allowed to act for every
+// application. Unlike authorize, it also fails if the server doesn't require authentication.
+func (s *Server) authorizeAdmin(ctx context.Context) error {
+	id, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		return api.WrapError(api.ErrorCodeUnauthenticated, auth.ErrMissingCredentials)
+	}
+	if !id.CanActFor(auth.AnyApplication) {
+		return api.WrapError(api.ErrorCodeForbidden, auth.ErrForbiddenApplication)
+	}
+	return nil
+}
+
+// limit returns an error if the given application or handle exceeded the amount of sessions they're allowed to create.
+// Requests are let through if the rate limiter fails, to avoid blocking payments because of a faulty store.
+func (s *Server) limit(ctx context.Context, application, handle string) error {
+	if s.limiter == nil {
+		return nil
+	}
+
+	err := s.limiter.Allow(ctx, application, handle)
+	if err == nil {
+		return nil
+	}
+
+	var exceeded *ratelimit.ExceededError
+	if !errors.As(err, &exceeded) {
+		s.logger.Println("Failed to check rate limit:", err)
+		return nil
+	}
+
+	s.logger.Println("Rate limit exceeded:", err)
+	return api.WrapError(api.ErrorCodeRateLimited, err)
+}
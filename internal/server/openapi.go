@@ -0,0 +1,19 @@
+package server
+
+import (
+	_ "embed" // Used to embed the OpenAPI specification
+	"net/http"
+)
+
+// openAPI contains the OpenAPI specification of the HTTP endpoints exposed by Server.
+//
+//go:embed static/openapi.json
+var openAPI []byte
+
+// OpenAPI is an HTTP handler that returns the OpenAPI specification of the payments API.
+func (s *Server) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(openAPI); err != nil {
+		s.logger.Println("Failed to write OpenAPI specification:", err)
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/breaker"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/cache"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/paymentstest"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// openAPISpec contains the subset of an OpenAPI document used to check the specification against the Go types.
+type openAPISpec struct {
+	Paths      map[string]map[string]interface{} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+			Enum       []string               `json:"enum"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPISpec(t *testing.T) openAPISpec {
+	var spec openAPISpec
+	require.NoError(t, json.Unmarshal(openAPI, &spec))
+	return spec
+}
+
+// jsonFields returns the sorted list of JSON fields of the given struct type.
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// routesServer returns a server serving every route, including the ones that require authentication.
+func routesServer() *Server {
+	return NewServer(Options{
+		config:        conf.Config{},
+		logger:        log.New(io.Discard, "", 0),
+		authenticator: auth.NewAPIKeyAuthenticator(map[string]string{"admin": auth.AnyApplication}),
+	})
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	s := routesServer()
+
+	routes := make(map[string]bool)
+	err := chi.Walk(s.router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		key := strings.ToLower(method) + " " + route
+		routes[key] = true
+
+		ops, ok := spec.Paths[route]
+		if assert.True(t, ok, "route %s is missing in the OpenAPI specification", route) {
+			assert.Contains(t, ops, strings.ToLower(method), "operation %s is missing in the OpenAPI specification", key)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			assert.True(t, routes[method+" "+path], "operation %s %s is not served", method, path)
+		}
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+
+	types := map[string]reflect.Type{
+		"CreateSessionRequest":      reflect.TypeOf(api.CreateSessionRequest{}),
+		"CreateSessionResponse":     reflect.TypeOf(api.CreateSessionResponse{}),
+		"CreateAccountLinkRequest":  reflect.TypeOf(api.CreateAccountLinkRequest{}),
+		"CreateAccountLinkResponse": reflect.TypeOf(api.CreateAccountLinkResponse{}),
+		"Account":                   reflect.TypeOf(api.Account{}),
+		"Error":                     reflect.TypeOf(api.Error{}),
+		"Payment":                   reflect.TypeOf(adapter.Payment{}),
+		"Readiness":                 reflect.TypeOf(Readiness{}),
+		"BreakerSnapshot":           reflect.TypeOf(breaker.Snapshot{}),
+		"CacheStatsResponse":        reflect.TypeOf(CacheStatsResponse{}),
+		"CacheStats":                reflect.TypeOf(cache.Stats{}),
+	}
+
+	for name, typ := range types {
+		schema, ok := spec.Components.Schemas[name]
+		require.True(t, ok, "schema %s is missing in the OpenAPI specification", name)
+
+		var properties []string
+		for p := range schema.Properties {
+			properties = append(properties, p)
+		}
+		sort.Strings(properties)
+
+		assert.Equal(t, jsonFields(typ), properties, "schema %s doesn't match %s", name, typ)
+	}
+
+	var codes []string
+	for _, c := range api.ErrorCodes() {
+		codes = append(codes, string(c))
+	}
+	assert.ElementsMatch(t, codes, spec.Components.Schemas["ErrorCode"].Enum)
+	assert.ElementsMatch(t, []string{string(api.PaymentServiceStripe)}, spec.Components.Schemas["PaymentService"].Enum)
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	s := NewServer(Options{config: conf.Config{}, logger: log.New(io.Discard, "", 0)})
+
+	req, err := http.NewRequest(http.MethodGet, "/payments/openapi.json", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.True(t, json.Valid(rr.Body.Bytes()))
+}
+
+func TestPaymentsTestRoutes(t *testing.T) {
+	s := routesServer()
+	fake := paymentstest.NewServer(paymentstest.Options{})
+	defer fake.Close()
+
+	walk := func(router chi.Routes) map[string]bool {
+		routes := make(map[string]bool)
+		err := chi.Walk(router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			routes[method+" "+route] = true
+			return nil
+		})
+		require.NoError(t, err)
+		return routes
+	}
+
+	expected := walk(s.router)
+	delete(expected, http.MethodGet+" /payments/openapi.json")
+	delete(expected, http.MethodGet+" /payments/ready")
+	delete(expected, http.MethodGet+" /payments/caches")
+	delete(expected, http.MethodDelete+" /payments/caches/{name}")
+
+	router, ok := fake.Handler().(chi.Routes)
+	require.True(t, ok)
+	assert.Equal(t, expected, walk(router))
+}
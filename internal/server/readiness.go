@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/breaker"
+	"net/http"
+)
+
+// Readiness is the response of the readiness endpoint.
+type Readiness struct {
+	// Ready is false if the breaker of any dependency is open.
+	Ready bool `json:"ready"`
+
+	// Breakers contains the state of the circuit breakers of the dependencies of the payments service.
+	Breakers []breaker.Snapshot `json:"breakers"`
+}
+
+// Ready is an HTTP handler that returns the state of the circuit breakers. It responds with a 503 status code while
+// any breaker is open, as requests depending on it fail until its cool-down has passed. Open breakers are reported as
+// half-open once their cool-down has passed, so the service is ready again to let the probe call through.
+func (s *Server) Ready(w http.ResponseWriter, r *http.Request) {
+	out := Readiness{
+		Ready:    true,
+		Breakers: []breaker.Snapshot{},
+	}
+	for _, b := range s.breakers {
+		snapshot := b.Snapshot()
+		if snapshot.State == breaker.StateOpen {
+			out.Ready = false
+		}
+		out.Breakers = append(out.Breakers, snapshot)
+	}
+
+	body, err := json.Marshal(out)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !out.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if _, err = w.Write(body); err != nil {
+		s.logger.Println("Failed to write readiness body:", err)
+	}
+}
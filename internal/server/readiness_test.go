@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/breaker"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	credits := breaker.NewBreaker(breaker.Options{Name: "credits", Failures: 1, Cooldown: time.Minute})
+	stripe := breaker.NewBreaker(breaker.Options{Name: "stripe", Failures: 1, Cooldown: time.Minute})
+	s := NewServer(Options{
+		config:   conf.Config{},
+		logger:   log.New(io.Discard, "", 0),
+		breakers: []*breaker.Breaker{credits, stripe},
+	})
+
+	ready := func() (int, Readiness) {
+		req, err := http.NewRequest(http.MethodGet, "/payments/ready", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, req)
+
+		var out Readiness
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+		return rr.Code, out
+	}
+
+	code, out := ready()
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, out.Ready)
+	require.Len(t, out.Breakers, 2)
+	assert.Equal(t, "credits", out.Breakers[0].Name)
+	assert.Equal(t, breaker.StateClosed, out.Breakers[0].State)
+
+	credits.Do(context.Background(), func() error { return errors.New("connection refused") })
+
+	code, out = ready()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, out.Ready)
+	assert.Equal(t, breaker.StateOpen, out.Breakers[0].State)
+	assert.NotNil(t, out.Breakers[0].OpenedAt)
+	assert.Equal(t, breaker.StateClosed, out.Breakers[1].State)
+}
+
+func TestWriteErrorBreakerOpen(t *testing.T) {
+	s := NewServer(Options{config: conf.Config{}, logger: log.New(io.Discard, "", 0)})
+	err := api.WrapError(api.ErrorCodeUpstream, &breaker.OpenError{Name: "credits", RetryAfter: 1500 * time.Millisecond})
+
+	req, reqErr := http.NewRequest(http.MethodPost, "/payments/session", nil)
+	require.NoError(t, reqErr)
+	rr := httptest.NewRecorder()
+	s.writeError(rr, req, err)
+
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+}
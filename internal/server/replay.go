@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/replay"
+	"net/http"
+)
+
+// errReplayedSignature is returned when the signature of a webhook event has already been received. The payment
+// service signs every delivery again, so a repeated signature means the payload has been captured and sent again.
+var errReplayedSignature = errors.New("webhook event signature has already been received")
+
+// newEventTracker initializes the tracker of the payment service events processed by the webhook handler and the
+// scheduled backfills, recording them in the given cache.
+func newEventTracker(cache replay.Cache, config conf.Config) *replay.Tracker {
+	return replay.NewTracker(replay.TrackerOptions{
+		Cache:         cache,
+		Window:        config.Stripe.WebhookReplayWindow,
+		ProcessingTTL: config.Timeout + replay.ProcessingMargin,
+	})
+}
+
+// checkReplay records the given verified webhook event as being processed, and writes a response if it must not be
+// processed: replayed signatures are rejected, further deliveries of an event that has already been processed are
+// acknowledged, and deliveries of an event that is being processed are rejected with a conflict so the payment service
+// delivers them again later. It returns true if the event must be processed, the caller must then either call
+// finishEvent or forgetEvent.
+func (s *Server) checkReplay(w http.ResponseWriter, r *http.Request, event string) bool {
+	err := s.recordSignature(r.Context(), r.Header.Get("Stripe-Signature"))
+	if errors.Is(err, errReplayedSignature) {
+		s.logger.Println("Rejected webhook event:", event, err)
+		http.Error(w, fmt.Sprintf("%s - %v", http.StatusText(http.StatusBadRequest), err), http.StatusBadRequest)
+		return false
+	}
+	if err == nil {
+		err = s.events.Begin(r.Context(), event)
+	}
+	switch {
+	case errors.Is(err, replay.ErrProcessed):
+		s.logger.Println("Webhook event already received:", event)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(fmt.Sprintf("%s - Event already received", http.StatusText(http.StatusOK))))
+		return false
+	case errors.Is(err, replay.ErrInProgress):
+		s.logger.Println("Webhook event is being processed:", event)
+		http.Error(w, fmt.Sprintf("%s - %s", http.StatusText(http.StatusConflict), "Event is being processed"), http.StatusConflict)
+		return false
+	case err != nil:
+		s.logger.Println("Failed to check webhook event replay:", err)
+		http.Error(w, fmt.Sprintf("%s - %s", http.StatusText(http.StatusInternalServerError), "Failed to check webhook event replay"), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// recordSignature remembers the signature of a verified webhook event. It returns errReplayedSignature if the
+// signature has already been received. The payment service signs every delivery of an event again.
+func (s *Server) recordSignature(ctx context.Context, signature string) error {
+	if s.replayWindow <= 0 {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(signature))
+	first, err := s.replay.Add(ctx, "stripe:signature:"+hex.EncodeToString(sum[:]), s.replayWindow)
+	if err != nil {
+		return err
+	}
+	if !first {
+		return errReplayedSignature
+	}
+	return nil
+}
+
+// finishEvent remembers a webhook event that has been processed, so further deliveries are acknowledged without
+// processing it again. Errors are only logged, the event has already been processed.
+func (s *Server) finishEvent(ctx context.Context, event string) {
+	if err := s.events.Finish(ctx, event); err != nil {
+		s.logger.Println("Failed to record processed webhook event:", event, err)
+	}
+}
+
+// forgetEvent forgets a webhook event that failed to be processed, so it's processed when the payment service
+// delivers it again.
+func (s *Server) forgetEvent(ctx context.Context, event string) {
+	if err := s.events.Abort(ctx, event); err != nil {
+		s.logger.Println("Failed to forget webhook event:", event, err)
+	}
+}
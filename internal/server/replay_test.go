@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecordSignature(t *testing.T) {
+	var cfg conf.Config
+	cfg.Stripe.WebhookReplayWindow = time.Hour
+	s := NewServer(Options{
+		config: cfg,
+		logger: log.New(io.Discard, "", 0),
+	})
+	ctx := context.Background()
+
+	require.NoError(t, s.recordSignature(ctx, "t=1,v1=abc"))
+	require.NoError(t, s.recordSignature(ctx, "t=2,v1=def"))
+	assert.ErrorIs(t, s.recordSignature(ctx, "t=1,v1=abc"), errReplayedSignature)
+}
+
+func TestCheckReplay(t *testing.T) {
+	var cfg conf.Config
+	cfg.Stripe.WebhookReplayWindow = time.Hour
+	s := NewServer(Options{
+		config: cfg,
+		logger: log.New(io.Discard, "", 0),
+	})
+	check := func(signature, event string) (bool, int) {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Stripe-Signature", signature)
+		rr := httptest.NewRecorder()
+		ok := s.checkReplay(rr, r, event)
+		return ok, rr.Code
+	}
+
+	ok, _ := check("t=1,v1=abc", "evt_1")
+	require.True(t, ok)
+
+	// Deliveries of the same event are signed again, they're retried later while the event is being processed
+	ok, status := check("t=2,v1=def", "evt_1")
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusConflict, status)
+
+	// Once processed, further deliveries are acknowledged
+	s.finishEvent(context.Background(), "evt_1")
+	ok, status = check("t=3,v1=ghi", "evt_1")
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusOK, status)
+
+	ok, status = check("t=1,v1=abc", "evt_1")
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// Events that failed to be processed are processed again
+	ok, _ = check("t=4,v1=jkl", "evt_2")
+	require.True(t, ok)
+	s.forgetEvent(context.Background(), "evt_2")
+	ok, _ = check("t=5,v1=mno", "evt_2")
+	assert.True(t, ok)
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -11,8 +12,22 @@ import (
 	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/application"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/backfill"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/breaker"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/cache"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/connect"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/idempotency"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ledger"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ratelimit"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/replay"
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
 	"log"
+	"net"
 	"net/http"
+	"sync"
+	"time"
 )
 
 // Setup initializes the conf.Config to run the web server.
@@ -26,33 +41,197 @@ func Setup(logger *log.Logger) (conf.Config, error) {
 	return c, nil
 }
 
+// ErrConnectWithoutDatabase is returned when Stripe Connect is enabled but no database has been configured to record
+// the connected accounts.
+var ErrConnectWithoutDatabase = errors.New("PAYMENTS_CONNECT_APPLICATION_FEES requires PAYMENTS_DATABASE_HOST")
+
+// ErrBackfillWithoutLedger is returned when scheduled backfills are enabled but there's no ledger to check whether the
+// missed events have already been charged.
+var ErrBackfillWithoutLedger = errors.New("PAYMENTS_BACKFILL_INTERVAL requires PAYMENTS_DATABASE_HOST and PAYMENTS_LEDGER_SINCE")
+
 // Run runs the web server using the given config.
 func Run(config conf.Config, logger *log.Logger) error {
+	if config.Breaker.Failures > 0 {
+		logger.Println("Initializing circuit breakers:", config.Breaker.Failures, "failures,", config.Breaker.Cooldown, "cool-down")
+	} else {
+		logger.Println("Circuit breakers disabled, failing dependencies will be called on every request")
+	}
+	creditsBreaker := newBreaker("credits", config.Breaker, breaker.IsCreditsFailure, logger)
+	customersBreaker := newBreaker("customers", config.Breaker, breaker.IsCustomersFailure, logger)
+	stripeBreaker := newBreaker("stripe", config.Breaker, breaker.IsProviderFailure, logger)
+
 	logger.Println("Initializing Credits HTTP client:", config.CreditsURL)
 
-	creditsClient := credits.NewCreditsClientV1(config.CreditsURL, config.Timeout)
+	creditsClient := breaker.NewCredits(creditsBreaker, credits.NewCreditsClientV1(config.CreditsURL, config.Timeout))
 
 	logger.Println("Initializing Customers HTTP client")
-	customersClient := customers.NewCustomersClientV1(config.CustomersURL, config.Timeout)
+	customersClient := breaker.NewCustomers(customersBreaker, customers.NewCustomersClientV1(config.CustomersURL, config.Timeout))
+
+	logger.Println("Initializing lookup caches:", config.Cache.UnitPriceTTL, "unit price TTL,", config.Cache.CustomerTTL, "customer TTL")
+	unitPrices := cache.NewCache(cache.Options{
+		Name: "unit_prices",
+		TTL:  config.Cache.UnitPriceTTL,
+	})
+	customerCache := cache.NewCache(cache.Options{
+		Name:       "customers",
+		TTL:        config.Cache.CustomerTTL,
+		MaxEntries: config.Cache.CustomerMaxEntries,
+	})
+	creditsClient = cache.NewCredits(unitPrices, creditsClient)
+	customersClient = cache.NewCustomers(customerCache, customersClient)
+
+	if len(config.Stripe.WebhookURL) > 0 {
+		events := adapter.WebhookEvents
+		if config.Connect.Enabled() {
+			events = append(append([]string{}, events...), adapter.ConnectWebhookEvents...)
+		}
+		if err := registerWebhook(context.Background(), &config.Stripe, events, logger); err != nil {
+			return err
+		}
+		if config.Connect.Enabled() {
+			if err := registerConnectWebhook(context.Background(), &config.Stripe, logger); err != nil {
+				return err
+			}
+		}
+	}
 
 	logger.Println("Initializing Stripe adapter")
-	stripeAdapter := adapter.NewStripeAdapter(config.Stripe)
+	stripeAdapter := breaker.NewAdapter(stripeBreaker, adapter.NewStripeAdapter(config.Stripe))
+
+	prices := cache.NewCache(cache.Options{
+		Name: "prices",
+		TTL:  config.Cache.UnitPriceTTL,
+	})
+	var catalog adapter.Catalog
+	if config.Catalog.Enabled() {
+		logger.Println("Initializing Stripe catalog:", config.Catalog.Applications, "applications")
+		catalog = cache.NewCatalog(prices, breaker.NewCatalog(stripeBreaker, adapter.NewStripeCatalog(config.Stripe)))
+	} else {
+		logger.Println("No catalog configured, checkout sessions will use inline prices")
+	}
+
+	var db *gorm.DB
+	var store ledger.Store
+	var replays replay.Cache
+	var idempotencyStore idempotency.Store
+	var rateLimits ratelimit.Store
+	if config.Database.Enabled() {
+		logger.Println("Initializing ledger database:", config.Database.Host)
+		var err error
+		db, err = ledger.OpenConn(config.Database)
+		if err != nil {
+			logger.Println("Failed to open ledger database:", err)
+			return err
+		}
+		if store, err = ledger.NewGormStore(db); err != nil {
+			logger.Println("Failed to migrate ledger database:", err)
+			return err
+		}
+		if replays, err = replay.NewGormCache(db); err != nil {
+			logger.Println("Failed to migrate webhook replay table:", err)
+			return err
+		}
+		if idempotencyStore, err = idempotency.NewGormStore(db); err != nil {
+			logger.Println("Failed to migrate idempotency key table:", err)
+			return err
+		}
+		if rateLimits, err = ratelimit.NewGormStore(db); err != nil {
+			logger.Println("Failed to migrate rate limit table:", err)
+			return err
+		}
+	} else {
+		logger.Println("No database configured, granted credits won't be recorded in the ledger")
+		logger.Println("Webhook replays will only be detected per replica")
+		replays = replay.NewMemoryCache()
+		logger.Println("Idempotency keys will only be honoured per replica")
+		idempotencyStore = idempotency.NewMemoryStore()
+		logger.Println("Rate limits will only be enforced per replica")
+		rateLimits = ratelimit.NewMemoryStore()
+	}
+
+	var connector adapter.Connector
+	var accounts connect.Store
+	fees, err := config.Connect.Fees()
+	if err != nil {
+		return err
+	}
+	if config.Connect.Enabled() {
+		if db == nil {
+			logger.Println("Failed to initialize Stripe Connect:", ErrConnectWithoutDatabase)
+			return ErrConnectWithoutDatabase
+		}
+		logger.Println("Initializing Stripe Connect")
+		connector = breaker.NewConnector(stripeBreaker, adapter.NewStripeConnector(config.Stripe, config.Connect))
+		if accounts, err = connect.NewGormStore(db); err != nil {
+			logger.Println("Failed to migrate connected accounts table:", err)
+			return err
+		}
+	}
 
 	logger.Println("Initializing Payments service")
 	ps := application.NewPaymentsService(application.Options{
-		Credits:   creditsClient,
-		Customers: customersClient,
-		Adapter:   stripeAdapter,
-		Logger:    logger,
-		Timeout:   config.Timeout,
+		Credits:         creditsClient,
+		Customers:       customersClient,
+		Adapter:         stripeAdapter,
+		Logger:          logger,
+		Timeout:         config.Timeout,
+		Ledger:          store,
+		Connector:       connector,
+		Accounts:        accounts,
+		ApplicationFees: fees,
+		Catalog:         catalog,
+		Currency:        config.Currency,
 	})
 
+	authenticator := newAuthenticator(config.Auth)
+	if authenticator == nil {
+		logger.Println("No authentication method configured, requests to the payments API won't be authenticated")
+	}
+
+	logger.Println("Initializing rate limiter")
+	limiter := ratelimit.NewLimiter(
+		rateLimits,
+		ratelimit.PerMinute(config.RateLimit.ApplicationPerMinute, config.RateLimit.ApplicationBurst),
+		ratelimit.PerMinute(config.RateLimit.HandlePerMinute, config.RateLimit.HandleBurst),
+	)
+
+	logger.Println("Initializing Stripe inspector")
+	inspector := adapter.NewStripeInspector(config.Stripe)
+
+	events := newEventTracker(replays, config)
+
+	var backfiller *backfill.Backfiller
+	if config.Backfill.Interval > 0 {
+		if db == nil || config.Database.LedgerSince.IsZero() {
+			logger.Println("Failed to initialize scheduled backfill:", ErrBackfillWithoutLedger)
+			return ErrBackfillWithoutLedger
+		}
+		logger.Println("Initializing scheduled backfill every", config.Backfill.Interval)
+		backfiller = backfill.NewBackfiller(backfill.Options{
+			Inspector: inspector,
+			Charger:   ps,
+			Events:    events,
+			Since:     config.Database.LedgerSince,
+			Logger:    logger,
+		})
+	}
+
 	logger.Println("Initializing HTTP server")
 	s := NewServer(Options{
-		config:   config,
-		payments: ps,
-		logger:   logger,
-		adapter:  stripeAdapter,
+		config:        config,
+		payments:      ps,
+		logger:        logger,
+		adapter:       stripeAdapter,
+		authenticator: authenticator,
+		limiter:       limiter,
+		backfill:      backfiller,
+		inspector:     inspector,
+		replay:        replays,
+		events:        events,
+		connector:     connector,
+		idempotency:   idempotencyStore,
+		breakers:      []*breaker.Breaker{creditsBreaker, customersBreaker, stripeBreaker},
+		caches:        []*cache.Cache{unitPrices, customerCache, prices},
 	})
 
 	if err := s.ListenAndServe(); err != nil {
@@ -62,12 +241,33 @@ func Run(config conf.Config, logger *log.Logger) error {
 	return nil
 }
 
+// newBreaker initializes a circuit breaker for the given dependency using the given config.
+func newBreaker(name string, config conf.Breaker, isFailure func(error) bool, logger *log.Logger) *breaker.Breaker {
+	return breaker.NewBreaker(breaker.Options{
+		Name:      name,
+		Failures:  config.Failures,
+		Cooldown:  config.Cooldown,
+		IsFailure: isFailure,
+		Logger:    logger,
+	})
+}
+
 // Options contains a set of components to be used when initializing a web server.
 type Options struct {
-	config   conf.Config
-	payments application.Service
-	logger   *log.Logger
-	adapter  adapter.Client
+	config        conf.Config
+	payments      application.Service
+	logger        *log.Logger
+	adapter       adapter.Client
+	authenticator auth.Authenticator
+	limiter       *ratelimit.Limiter
+	backfill      *backfill.Backfiller
+	inspector     adapter.Inspector
+	replay        replay.Cache
+	events        *replay.Tracker
+	connector     adapter.Connector
+	idempotency   idempotency.Store
+	breakers      []*breaker.Breaker
+	caches        []*cache.Cache
 }
 
 // Server is an HTTP web server used to expose api.PaymentsV1 endpoints. It prepares the input for each
@@ -85,16 +285,95 @@ type Server struct {
 	// port is the HTTP port used to listen for incoming requests.
 	port uint
 
+	// grpcPort is the port used to listen for incoming gRPC requests. The gRPC server is disabled if set to 0.
+	grpcPort uint
+
+	// grpcServer serves the api.PaymentsV1 methods using gRPC as transport.
+	grpcServer *grpc.Server
+
 	// httpServer is used to serve the router with fine-grained control of ListenAndServe and Shutdown operations.
 	httpServer http.Server
 
 	// adapter is used to generate charge requests from incoming webhook events. It contains an adapter implementation
 	// such as Stripe.
 	adapter adapter.Client
+
+	// authenticator is used to verify the credentials of api.PaymentsV1 callers. Authentication is disabled if nil.
+	authenticator auth.Authenticator
+
+	// limiter is used to limit the amount of sessions created per application and handle. Rate limiting is disabled
+	// if nil.
+	limiter *ratelimit.Limiter
+
+	// backfill is used to recover payment service events missed by the webhook handler. Scheduled backfills are
+	// disabled if nil.
+	backfill *backfill.Backfiller
+
+	// inspector is used to read payments from the payment service when exporting them. Exports are disabled if nil.
+	inspector adapter.Inspector
+
+	// connector is used to generate connected account events from incoming webhook events. Connected account events
+	// are not handled if nil.
+	connector adapter.Connector
+
+	// replay is used to detect webhook events that have already been received. A memory cache is used if not set.
+	replay replay.Cache
+
+	// replayWindow is the amount of time the signatures of received webhook events and the processed events are
+	// remembered. Replay protection is disabled if set to 0.
+	replayWindow time.Duration
+
+	// events is used to record the webhook events that are being processed and the ones that have been processed. It's
+	// shared with the scheduled backfills. A tracker using replay is created if not set.
+	events *replay.Tracker
+
+	// idempotency is used to record the responses of requests made with an idempotency key. A memory store is used
+	// if not set.
+	idempotency idempotency.Store
+
+	// idempotencyTTL is the amount of time the responses of requests made with an idempotency key are remembered.
+	// Idempotency keys are ignored if set to 0.
+	idempotencyTTL time.Duration
+
+	// breakers contains the circuit breakers of the dependencies of the payments service. Their state is reported by
+	// the readiness endpoint.
+	breakers []*breaker.Breaker
+
+	// caches contains the caches of the lookups made to other services. Their stats are reported by the caches
+	// endpoint, which is also used to purge them.
+	caches []*cache.Cache
+
+	// backfillConfig contains the schedule and time window of the scheduled backfills.
+	backfillConfig conf.Backfill
+
+	// done is closed when the server is shut down, it's used to stop background jobs.
+	done chan struct{}
+
+	// shutdown is used to close done only once.
+	shutdown sync.Once
 }
 
-// ListenAndServe starts listening in the port defined on conf.Config. It's in charge of serving the different endpoints.
+// ListenAndServe starts listening in the ports defined on conf.Config. It's in charge of serving the different endpoints.
+// The gRPC server is only started if a gRPC port has been defined.
 func (s *Server) ListenAndServe() error {
+	if s.grpcPort != 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.grpcPort))
+		if err != nil {
+			return err
+		}
+		s.logger.Println("Listening for gRPC requests on", lis.Addr())
+		go func() {
+			if err := s.grpcServer.Serve(lis); err != nil {
+				s.logger.Println("Error while running gRPC server:", err)
+			}
+		}()
+		defer s.grpcServer.Stop()
+	}
+
+	if s.backfill != nil && s.backfillConfig.Interval > 0 {
+		go s.scheduleBackfill()
+	}
+
 	s.logger.Println("Listening on", s.httpServer.Addr)
 	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return err
@@ -104,6 +383,10 @@ func (s *Server) ListenAndServe() error {
 
 // Shutdown shuts the web server down.
 func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdown.Do(func() {
+		close(s.done)
+	})
+	s.grpcServer.GracefulStop()
 	if err := s.httpServer.Shutdown(ctx); err != nil {
 		return err
 	}
@@ -118,10 +401,35 @@ func (s *Server) getAddress() string {
 // NewServer initializes a new web server that will serve api.PaymentsV1 and api.ChargerV1 methods.
 func NewServer(opts Options) *Server {
 	s := Server{
-		payments: opts.payments,
-		logger:   opts.logger,
-		port:     opts.config.Port,
-		adapter:  opts.adapter,
+		payments:       opts.payments,
+		logger:         opts.logger,
+		port:           opts.config.Port,
+		grpcPort:       opts.config.GRPCPort,
+		adapter:        opts.adapter,
+		authenticator:  opts.authenticator,
+		limiter:        opts.limiter,
+		backfill:       opts.backfill,
+		inspector:      opts.inspector,
+		replay:         opts.replay,
+		events:         opts.events,
+		connector:      opts.connector,
+		replayWindow:   opts.config.Stripe.WebhookReplayWindow,
+		idempotency:    opts.idempotency,
+		idempotencyTTL: opts.config.IdempotencyTTL,
+		breakers:       opts.breakers,
+		caches:         opts.caches,
+		backfillConfig: opts.config.Backfill,
+		done:           make(chan struct{}),
+	}
+
+	if s.replay == nil {
+		s.replay = replay.NewMemoryCache()
+	}
+	if s.events == nil {
+		s.events = newEventTracker(s.replay, opts.config)
+	}
+	if s.idempotency == nil {
+		s.idempotency = idempotency.NewMemoryStore()
 	}
 
 	s.router = chi.NewRouter()
@@ -132,11 +440,26 @@ func NewServer(opts Options) *Server {
 	s.router.Use(middleware.Recoverer)
 	s.router.Use(render.SetContentType(render.ContentTypeJSON))
 
+	s.router.NotFound(s.notFound)
+
 	s.router.Route("/payments", func(r chi.Router) {
 		r.Post("/webhooks/stripe", s.StripeWebhook)
-		r.Post("/session", s.CreateSession)
+		r.Post("/webhooks/stripe/connect", s.StripeWebhook)
+		r.With(s.authenticate).Post("/session", s.CreateSession)
+		r.With(s.authenticate).Post("/connect/accounts", s.CreateAccountLink)
+		r.With(s.authenticate).Get("/connect/accounts/{application}", s.GetAccount)
+		r.Get("/openapi.json", s.OpenAPI)
+		r.Get("/ready", s.Ready)
+		// Operator routes expose the data of every application, they're only served to authenticated callers.
+		if s.authenticator != nil {
+			r.With(s.authenticate).Get("/export", s.ExportPayments)
+			r.With(s.authenticate).Get("/caches", s.CacheStats)
+			r.With(s.authenticate).Delete("/caches/{name}", s.PurgeCache)
+		}
 	})
 
+	s.grpcServer = newGRPCServer(&s)
+
 	s.httpServer = http.Server{
 		Addr:    s.getAddress(),
 		Handler: s.router,
@@ -63,6 +63,25 @@ func (s *setupTestSuite) TestDefaultValues() {
 	s.Assert().Equal(uint(80), cfg.Port)
 	s.Assert().Equal(30*time.Second, cfg.Timeout)
 	s.Assert().Equal("", cfg.Stripe.URL)
+	s.Assert().Equal(uint(0), cfg.RateLimit.HandlePerMinute)
+	s.Assert().Equal(uint(0), cfg.RateLimit.HandleBurst)
+	s.Assert().Equal(uint(0), cfg.RateLimit.ApplicationPerMinute)
+}
+
+func (s *setupTestSuite) TestLedgerSince() {
+	s.Require().NoError(os.Setenv("PAYMENTS_STRIPE_SIGNING_KEY", "test1234"))
+	s.Require().NoError(os.Setenv("PAYMENTS_STRIPE_SECRET_KEY", "secret1234"))
+	s.Require().NoError(os.Setenv("PAYMENTS_CREDITS_SERVICE_URL", "http://localhost:8082"))
+	s.Require().NoError(os.Setenv("PAYMENTS_CUSTOMERS_SERVICE_URL", "http://localhost:8083"))
+	s.Require().NoError(os.Setenv("PAYMENTS_LEDGER_SINCE", "2021-11-01T00:00:00Z"))
+
+	cfg, err := Setup(s.Logger)
+	s.Require().NoError(err)
+	s.Assert().True(time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC).Equal(cfg.Database.LedgerSince))
+
+	s.Require().NoError(os.Setenv("PAYMENTS_LEDGER_SINCE", "2021-11-01"))
+	_, err = Setup(s.Logger)
+	s.Assert().Error(err)
 }
 
 func (s *setupTestSuite) TestMissingEnvVars() {
@@ -273,4 +292,5 @@ func unsetEnvVars(s suite.Suite) {
 	s.Require().NoError(os.Unsetenv("PAYMENTS_CIRCUIT_BREAKER_TIMEOUT"))
 	s.Require().NoError(os.Unsetenv("PAYMENTS_CREDITS_SERVICE_URL"))
 	s.Require().NoError(os.Unsetenv("PAYMENTS_CUSTOMERS_SERVICE_URL"))
+	s.Require().NoError(os.Unsetenv("PAYMENTS_LEDGER_SINCE"))
 }
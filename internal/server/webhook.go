@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrUnknownSigningKey is returned when a webhook endpoint already exists in Stripe but its signing secret hasn't been
+// configured. Stripe only returns the secret when an endpoint is created.
+var ErrUnknownSigningKey = errors.New("webhook endpoint exists but its signing secret is unknown, set PAYMENTS_STRIPE_SIGNING_KEY or PAYMENTS_STRIPE_WEBHOOK_SECRET_FILE")
+
+// secretFilePoll is the time between reads of a webhook secret file while waiting for another replica to store the
+// secret of the endpoint it has just created.
+const secretFilePoll = 100 * time.Millisecond
+
+// secretFileWait is the maximum amount of time to wait for another replica to store the secret of an existing webhook
+// endpoint in the secret file.
+var secretFileWait = 5 * time.Second
+
+// registerWebhook makes sure a Stripe webhook endpoint subscribed to the given events exists for the configured
+// WebhookURL. The signing key of the given config is replaced with the secret of the endpoint if it has to be created,
+// and the secret is stored in the WebhookSecretFile if set.
+func registerWebhook(ctx context.Context, cfg *conf.Stripe, events []string, logger *log.Logger) error {
+	secret, err := ensureWebhookEndpoint(ctx, *cfg, adapter.WebhookEndpointOptions{
+		URL:    cfg.WebhookURL,
+		Events: events,
+		Update: cfg.WebhookUpdateEvents,
+	}, cfg.SigningKey, cfg.WebhookSecretFile, logger)
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.SigningKey) > 0 && secret != cfg.SigningKey {
+		logger.Println("Warning: PAYMENTS_STRIPE_SIGNING_KEY has been replaced with the secret of the new webhook endpoint")
+	}
+	cfg.SigningKey = secret
+	if len(cfg.AllSigningKeys()) == 0 {
+		return ErrUnknownSigningKey
+	}
+	return nil
+}
+
+// registerConnectWebhook makes sure a Stripe Connect webhook endpoint subscribed to the events of connected accounts
+// exists for the configured ConnectWebhookURL. The secret of the endpoint is added to the signing keys of the given
+// config if it's known, and it's stored in the ConnectWebhookSecretFile if set.
+func registerConnectWebhook(ctx context.Context, cfg *conf.Stripe, logger *log.Logger) error {
+	secret, err := ensureWebhookEndpoint(ctx, *cfg, adapter.WebhookEndpointOptions{
+		URL:     cfg.ConnectWebhookURL(),
+		Events:  adapter.ConnectAccountWebhookEvents,
+		Connect: true,
+		Update:  cfg.WebhookUpdateEvents,
+	}, "", cfg.ConnectWebhookSecretFile, logger)
+	if err != nil {
+		return err
+	}
+
+	// The secret may have been added to the signing keys manually.
+	if len(secret) == 0 {
+		logger.Println("Warning: the signing secret of the Stripe Connect webhook endpoint is unknown, its events are only accepted if it's in PAYMENTS_STRIPE_SIGNING_KEYS")
+		return nil
+	}
+	for _, key := range cfg.SigningKeys {
+		if key == secret {
+			return nil
+		}
+	}
+	cfg.SigningKeys = append(cfg.SigningKeys, secret)
+	return nil
+}
+
+// ensureWebhookEndpoint makes sure the given Stripe webhook endpoint exists, and returns its signing secret. The
+// secret is read from secretFile if the given one is empty, and the secret of endpoints that are created is stored in
+// it. Stripe only returns the secret when an endpoint is created, an empty secret is returned if it's unknown.
+func ensureWebhookEndpoint(ctx context.Context, cfg conf.Stripe, opts adapter.WebhookEndpointOptions, secret, secretFile string, logger *log.Logger) (string, error) {
+	if len(secret) == 0 && len(secretFile) > 0 {
+		var err error
+		if secret, err = readSecretFile(secretFile); err != nil {
+			return "", err
+		}
+	}
+
+	logger.Println("Checking Stripe webhook endpoint:", opts.URL)
+	endpoint, err := adapter.EnsureStripeWebhookEndpoint(ctx, cfg, opts)
+	if err != nil {
+		logger.Println("Failed to check Stripe webhook endpoint:", err)
+		return "", err
+	}
+
+	if len(endpoint.Missing) > 0 {
+		logger.Println("Warning: Stripe webhook endpoint isn't subscribed to handled events:", strings.Join(endpoint.Missing, ", "))
+	}
+	if len(endpoint.Extra) > 0 {
+		logger.Println("Warning: Stripe webhook endpoint is subscribed to events that are not handled:", strings.Join(endpoint.Extra, ", "))
+	}
+	if endpoint.Disabled {
+		logger.Println("Warning: Stripe webhook endpoint is disabled:", endpoint.ID)
+	}
+	if endpoint.Updated {
+		logger.Println("Updated Stripe webhook endpoint:", endpoint.ID)
+	} else if len(endpoint.Missing) > 0 || len(endpoint.Extra) > 0 || endpoint.Disabled {
+		logger.Println("Set PAYMENTS_STRIPE_WEBHOOK_UPDATE_EVENTS to update the Stripe webhook endpoint on startup")
+	}
+
+	if len(endpoint.Secret) == 0 {
+		// The endpoint may have just been created by another replica sharing the secret file.
+		if len(secret) == 0 && len(secretFile) > 0 {
+			logger.Println("Waiting for the signing secret of Stripe webhook endpoint to be stored:", secretFile)
+			return waitSecretFile(ctx, secretFile)
+		}
+		return secret, nil
+	}
+	logger.Println("Created Stripe webhook endpoint:", endpoint.ID)
+
+	if len(secretFile) == 0 {
+		logger.Println("Warning: no webhook secret file is set, the signing secret of", opts.URL, "will be lost on restart")
+		return endpoint.Secret, nil
+	}
+	if err = writeSecretFile(secretFile, endpoint.Secret); err != nil {
+		logger.Println("Failed to store webhook signing secret:", err)
+		return "", err
+	}
+	return endpoint.Secret, nil
+}
+
+// readSecretFile returns the webhook signing secret stored in the given file. It returns an empty secret if the file
+// doesn't exist.
+func readSecretFile(path string) (string, error) {
+	stored, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return strings.TrimSpace(string(stored)), nil
+}
+
+// waitSecretFile reads the given webhook secret file until it contains a secret or secretFileWait passes. An empty
+// secret is returned if it's still unknown.
+func waitSecretFile(ctx context.Context, path string) (string, error) {
+	deadline := time.Now().Add(secretFileWait)
+	for {
+		secret, err := readSecretFile(path)
+		if err != nil || len(secret) > 0 || !time.Now().Before(deadline) {
+			return secret, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(secretFilePoll):
+		}
+	}
+}
+
+// writeSecretFile stores the given webhook signing secret in the given file. The secret is written to a temporary file
+// that replaces it, so replicas reading the file at the same time never get a partial secret.
+func writeSecretFile(path, secret string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.WriteString(secret + "\n"); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
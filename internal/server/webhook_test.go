@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v72"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/stripetest"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookStandIn is a local stand-in of the Stripe webhook endpoints API.
+type webhookStandIn struct {
+	// endpoints contains the JSON representation of the existing endpoints.
+	endpoints []string
+
+	// created contains the form of the last create request.
+	created url.Values
+
+	// updated contains the form of the last update request.
+	updated url.Values
+}
+
+func (w *webhookStandIn) serve(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		rw.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/webhook_endpoints":
+			fmt.Fprintf(rw, `{"object": "list", "url": "/v1/webhook_endpoints", "has_more": false, "data": [%s]}`,
+				strings.Join(w.endpoints, ","))
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/webhook_endpoints":
+			w.created = r.PostForm
+			fmt.Fprintf(rw, `{"id": "we_new", "object": "webhook_endpoint", "url": %q, "secret": "whsec_new", "status": "enabled"}`,
+				r.PostForm.Get("url"))
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v1/webhook_endpoints/"):
+			w.updated = r.PostForm
+			fmt.Fprintf(rw, `{"id": %q, "object": "webhook_endpoint"}`, strings.TrimPrefix(r.URL.Path, "/v1/webhook_endpoints/"))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(rw, `{"error": {"type": "invalid_request_error", "message": "not found"}}`)
+		}
+	}))
+}
+
+func webhookConfig(standIn *httptest.Server, secretFile string) conf.Stripe {
+	return conf.Stripe{
+		SecretKey:         "sk_test",
+		URL:               standIn.URL,
+		WebhookURL:        "https://payments.example.com/payments/webhooks/stripe",
+		WebhookSecretFile: secretFile,
+	}
+}
+
+func TestRegisterWebhookCreatesEndpoint(t *testing.T) {
+	var standIn webhookStandIn
+	srv := standIn.serve(t)
+	defer srv.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "signing_key")
+	cfg := webhookConfig(srv, secretFile)
+
+	require.NoError(t, registerWebhook(context.Background(), &cfg, adapter.WebhookEvents, log.New(io.Discard, "", 0)))
+
+	require.NotNil(t, standIn.created)
+	assert.Equal(t, cfg.WebhookURL, standIn.created.Get("url"))
+	assert.Equal(t, []string{"payment_intent.succeeded"}, standIn.created["enabled_events[0]"])
+	assert.Equal(t, "whsec_new", cfg.SigningKey)
+
+	stored, err := os.ReadFile(secretFile)
+	require.NoError(t, err)
+	assert.Equal(t, "whsec_new\n", string(stored))
+}
+
+func TestRegisterWebhookEventsDrift(t *testing.T) {
+	standIn := webhookStandIn{
+		endpoints: []string{
+			`{"id": "we_123", "object": "webhook_endpoint", "url": "https://payments.example.com/payments/webhooks/stripe", "enabled_events": ["charge.refunded"], "status": "disabled"}`,
+		},
+	}
+	srv := standIn.serve(t)
+	defer srv.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "signing_key")
+	require.NoError(t, os.WriteFile(secretFile, []byte("whsec_stored\n"), 0600))
+	cfg := webhookConfig(srv, secretFile)
+
+	var logs strings.Builder
+	require.NoError(t, registerWebhook(context.Background(), &cfg, adapter.WebhookEvents, log.New(&logs, "", 0)))
+
+	// Endpoints are only updated if enabled.
+	assert.Nil(t, standIn.created)
+	assert.Nil(t, standIn.updated)
+	assert.Contains(t, logs.String(), "isn't subscribed to handled events: payment_intent.succeeded")
+	assert.Contains(t, logs.String(), "is subscribed to events that are not handled: charge.refunded")
+	assert.Contains(t, logs.String(), "is disabled: we_123")
+	assert.Equal(t, "whsec_stored", cfg.SigningKey)
+}
+
+func TestRegisterWebhookUpdatesEvents(t *testing.T) {
+	standIn := webhookStandIn{
+		endpoints: []string{
+			`{"id": "we_other", "object": "webhook_endpoint", "url": "https://other.example.com", "enabled_events": ["*"], "status": "enabled"}`,
+			`{"id": "we_123", "object": "webhook_endpoint", "url": "https://payments.example.com/payments/webhooks/stripe", "enabled_events": ["charge.refunded"], "status": "enabled"}`,
+		},
+	}
+	srv := standIn.serve(t)
+	defer srv.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "signing_key")
+	require.NoError(t, os.WriteFile(secretFile, []byte("whsec_stored\n"), 0600))
+	cfg := webhookConfig(srv, secretFile)
+	cfg.WebhookUpdateEvents = true
+
+	require.NoError(t, registerWebhook(context.Background(), &cfg, adapter.WebhookEvents, log.New(io.Discard, "", 0)))
+
+	assert.Nil(t, standIn.created)
+	require.NotNil(t, standIn.updated)
+	assert.Equal(t, "payment_intent.succeeded", standIn.updated.Get("enabled_events[0]"))
+	assert.Equal(t, "false", standIn.updated.Get("disabled"))
+	assert.Equal(t, "whsec_stored", cfg.SigningKey)
+}
+
+func TestRegisterWebhookUpToDate(t *testing.T) {
+	standIn := webhookStandIn{
+		endpoints: []string{
+			`{"id": "we_123", "object": "webhook_endpoint", "url": "https://payments.example.com/payments/webhooks/stripe", "enabled_events": ["payment_intent.succeeded"], "status": "enabled"}`,
+		},
+	}
+	srv := standIn.serve(t)
+	defer srv.Close()
+
+	cfg := webhookConfig(srv, "")
+	cfg.SigningKey = "whsec_env"
+
+	require.NoError(t, registerWebhook(context.Background(), &cfg, adapter.WebhookEvents, log.New(io.Discard, "", 0)))
+
+	assert.Nil(t, standIn.created)
+	assert.Nil(t, standIn.updated)
+	assert.Equal(t, "whsec_env", cfg.SigningKey)
+}
+
+func TestRegisterWebhookUnknownSigningKey(t *testing.T) {
+	standIn := webhookStandIn{
+		endpoints: []string{
+			`{"id": "we_123", "object": "webhook_endpoint", "url": "https://payments.example.com/payments/webhooks/stripe", "enabled_events": ["payment_intent.succeeded"], "status": "enabled"}`,
+		},
+	}
+	srv := standIn.serve(t)
+	defer srv.Close()
+
+	cfg := webhookConfig(srv, filepath.Join(t.TempDir(), "signing_key"))
+
+	defer func(wait time.Duration) { secretFileWait = wait }(secretFileWait)
+	secretFileWait = 0
+
+	assert.ErrorIs(t, registerWebhook(context.Background(), &cfg, adapter.WebhookEvents, log.New(io.Discard, "", 0)), ErrUnknownSigningKey)
+
+	// The secret is never recovered by creating another endpoint.
+	assert.Nil(t, standIn.created)
+}
+
+func TestRegisterWebhookConcurrently(t *testing.T) {
+	srv := stripetest.NewServer(stripetest.Options{})
+	defer srv.Close()
+
+	// Replicas starting at the same time, sharing the same secret file.
+	const replicas = 4
+	secretFile := filepath.Join(t.TempDir(), "signing_key")
+	configs := make([]conf.Stripe, replicas)
+	var wg sync.WaitGroup
+	for i := range configs {
+		configs[i] = emulatorWebhookConfig(srv)
+		configs[i].WebhookSecretFile = secretFile
+
+		wg.Add(1)
+		go func(cfg *conf.Stripe) {
+			defer wg.Done()
+			assert.NoError(t, registerWebhook(context.Background(), cfg, adapter.WebhookEvents, log.New(io.Discard, "", 0)))
+		}(&configs[i])
+	}
+	wg.Wait()
+
+	assert.Len(t, listWebhookEndpoints(t, srv), 1)
+	require.NotEmpty(t, configs[0].SigningKey)
+	for _, cfg := range configs[1:] {
+		assert.Equal(t, configs[0].SigningKey, cfg.SigningKey)
+	}
+}
+
+func TestRegisterWebhookWaitsForSecretFile(t *testing.T) {
+	standIn := webhookStandIn{
+		endpoints: []string{
+			`{"id": "we_123", "object": "webhook_endpoint", "url": "https://payments.example.com/payments/webhooks/stripe", "enabled_events": ["payment_intent.succeeded"], "status": "enabled"}`,
+		},
+	}
+	srv := standIn.serve(t)
+	defer srv.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "signing_key")
+	cfg := webhookConfig(srv, secretFile)
+
+	// The replica that created the endpoint stores its secret a bit later.
+	go func() {
+		time.Sleep(3 * secretFilePoll)
+		assert.NoError(t, writeSecretFile(secretFile, "whsec_stored"))
+	}()
+
+	require.NoError(t, registerWebhook(context.Background(), &cfg, adapter.WebhookEvents, log.New(io.Discard, "", 0)))
+	assert.Equal(t, "whsec_stored", cfg.SigningKey)
+	assert.Nil(t, standIn.created)
+}
+
+func TestRegisterWebhookRecreatesDeletedEndpoint(t *testing.T) {
+	srv := stripetest.NewServer(stripetest.Options{})
+	defer srv.Close()
+
+	cfg := emulatorWebhookConfig(srv)
+	require.NoError(t, registerWebhook(context.Background(), &cfg, adapter.WebhookEvents, log.New(io.Discard, "", 0)))
+	endpoints := listWebhookEndpoints(t, srv)
+	require.Len(t, endpoints, 1)
+	deleteWebhookEndpoint(t, srv, endpoints[0].ID)
+
+	// The creation request is replayed by Stripe, but the endpoint doesn't exist anymore.
+	other := emulatorWebhookConfig(srv)
+	require.NoError(t, registerWebhook(context.Background(), &other, adapter.WebhookEvents, log.New(io.Discard, "", 0)))
+
+	recreated := listWebhookEndpoints(t, srv)
+	require.Len(t, recreated, 1)
+	assert.NotEqual(t, endpoints[0].ID, recreated[0].ID)
+	assert.NotEqual(t, cfg.SigningKey, other.SigningKey)
+}
+
+func TestRegisterConnectWebhook(t *testing.T) {
+	srv := stripetest.NewServer(stripetest.Options{})
+	defer srv.Close()
+
+	cfg := emulatorWebhookConfig(srv)
+	cfg.WebhookURL += "/"
+	cfg.ConnectWebhookSecretFile = filepath.Join(t.TempDir(), "connect_signing_key")
+	cfg.SigningKeys = []string{"whsec_old"}
+	require.NoError(t, registerConnectWebhook(context.Background(), &cfg, log.New(io.Discard, "", 0)))
+
+	endpoints := listWebhookEndpoints(t, srv)
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, "https://payments.example.com/payments/webhooks/stripe/connect", endpoints[0].URL)
+	assert.Equal(t, []string{"account.updated"}, endpoints[0].EnabledEvents)
+
+	stored, err := os.ReadFile(cfg.ConnectWebhookSecretFile)
+	require.NoError(t, err)
+	require.Len(t, cfg.SigningKeys, 2)
+	assert.Equal(t, "whsec_old", cfg.SigningKeys[0])
+	assert.Equal(t, strings.TrimSpace(string(stored)), cfg.SigningKeys[1])
+
+	// The stored secret is used on the next start.
+	next := emulatorWebhookConfig(srv)
+	next.ConnectWebhookSecretFile = cfg.ConnectWebhookSecretFile
+	require.NoError(t, registerConnectWebhook(context.Background(), &next, log.New(io.Discard, "", 0)))
+	assert.Equal(t, cfg.SigningKeys[1:], next.SigningKeys)
+	assert.Len(t, listWebhookEndpoints(t, srv), 1)
+}
+
+// emulatorWebhookConfig returns the config of a replica using the given Stripe emulator, without any signing key.
+func emulatorWebhookConfig(srv *stripetest.Server) conf.Stripe {
+	cfg := srv.Config()
+	cfg.SigningKey = ""
+	cfg.WebhookURL = "https://payments.example.com/payments/webhooks/stripe"
+	return cfg
+}
+
+// listWebhookEndpoints returns the webhook endpoints of the given Stripe emulator.
+func listWebhookEndpoints(t *testing.T, srv *stripetest.Server) []stripe.WebhookEndpoint {
+	res := stripeRequest(t, srv, http.MethodGet, "/v1/webhook_endpoints")
+	var list struct {
+		Data []stripe.WebhookEndpoint `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(res, &list))
+	return list.Data
+}
+
+// deleteWebhookEndpoint deletes a webhook endpoint of the given Stripe emulator.
+func deleteWebhookEndpoint(t *testing.T, srv *stripetest.Server, id string) {
+	stripeRequest(t, srv, http.MethodDelete, "/v1/webhook_endpoints/"+id)
+}
+
+// stripeRequest sends a request to the given Stripe emulator, and returns the body of the response.
+func stripeRequest(t *testing.T, srv *stripetest.Server, method, path string) []byte {
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+srv.Config().SecretKey)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return body
+}
@@ -0,0 +1,307 @@
+// Package adaptertest provides a conformance test suite for adapter.Client implementations. Every payment service
+// adapter must pass it, so the payments service behaves the same way regardless of the payment service being used.
+//
+// Adapters are tested against a provider-specific Harness, usually backed by an emulator of the payment service:
+//
+//	func TestConformance(t *testing.T) {
+//		adaptertest.Run(t, func(t *testing.T) (adapter.Client, adaptertest.Harness) {
+//			...
+//		})
+//	}
+package adaptertest
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"testing"
+	"time"
+)
+
+// UnitPrice is the amount of cents per unit used in the checkout sessions created by the suite.
+const UnitPrice = 150
+
+// Request is a webhook request sent by a payment service.
+type Request struct {
+	// Body is the body of the request.
+	Body []byte
+
+	// Params contains the parameters of the request, such as HTTP headers.
+	Params map[string][]string
+}
+
+// Harness contains the provider-specific operations needed to run the conformance suite against an adapter.
+type Harness interface {
+	// Service returns the payment service the adapter is used for.
+	Service() api.PaymentService
+
+	// Pay pays the given checkout session as a customer would do, buying the given quantity of units. It returns the
+	// webhook request sent by the payment service once the payment succeeds.
+	Pay(t *testing.T, session string, quantity int64) Request
+
+	// ChargeEvent returns a correctly signed webhook request for a successful payment matching the given charge
+	// request. The payment doesn't need to exist in the payment service. Empty Customer and Application fields must
+	// be left out of the event.
+	ChargeEvent(t *testing.T, req api.ChargeRequest) Request
+
+	// UnhandledEvent returns a correctly signed webhook request for an event that doesn't produce charges.
+	UnhandledEvent(t *testing.T) Request
+
+	// SignUnknown returns the parameters of a webhook request for the given body, signed with a key the adapter
+	// doesn't know.
+	SignUnknown(t *testing.T, body []byte) map[string][]string
+
+	// Charged returns the event recorded by adapter.Client.MarkCharged for the given payment, or an empty string if
+	// the payment hasn't been marked as charged.
+	Charged(t *testing.T, payment string) string
+}
+
+// Constructor returns a new adapter and the harness of the payment service it's connected to. It's called once for
+// every scenario, so scenarios don't share state.
+type Constructor func(t *testing.T) (adapter.Client, Harness)
+
+// Run runs the conformance suite against the adapters returned by the given constructor.
+func Run(t *testing.T, newClient Constructor) {
+	scenarios := []struct {
+		name string
+		fn   func(t *testing.T, client adapter.Client, h Harness)
+	}{
+		{"CreateCustomer", testCreateCustomer},
+		{"DeleteCustomer", testDeleteCustomer},
+		{"CreateSession", testCreateSession},
+		{"CreateSessionUnknownCustomer", testCreateSessionUnknownCustomer},
+		{"CanceledContext", testCanceledContext},
+		{"ChargeRequest", testChargeRequest},
+		{"MarkCharged", testMarkCharged},
+		{"MissingSignature", testMissingSignature},
+		{"UnknownSigningKey", testUnknownSigningKey},
+		{"TamperedBody", testTamperedBody},
+		{"MissingCustomer", testMissingCustomer},
+		{"MissingApplication", testMissingApplication},
+		{"UnhandledEvent", testUnhandledEvent},
+	}
+	for _, sc := range scenarios {
+		sc := sc
+		t.Run(sc.name, func(t *testing.T) {
+			client, h := newClient(t)
+			sc.fn(t, client, h)
+		})
+	}
+}
+
+// sessionRequest returns the request used to create checkout sessions for the given handle.
+func sessionRequest(h Harness, handle string) api.CreateSessionRequest {
+	return api.CreateSessionRequest{
+		Service:     h.Service(),
+		SuccessURL:  "https://app.example.com/success",
+		CancelURL:   "https://app.example.com/cancel",
+		Handle:      handle,
+		Application: "conformance",
+		UnitPrice:   UnitPrice,
+		Currency:    "usd",
+	}
+}
+
+// createSession creates a customer for the given handle and a checkout session for it. It returns the customer and
+// the session.
+func createSession(t *testing.T, client adapter.Client, h Harness, handle string) (string, api.CreateSessionResponse) {
+	req := sessionRequest(h, handle)
+	customer, err := client.CreateCustomer(context.Background(), req.Application, req.Handle)
+	require.NoError(t, err)
+	require.NotEmpty(t, customer)
+
+	res, err := client.CreateSession(context.Background(), req, customers.CustomerResponse{
+		Handle:      req.Handle,
+		ID:          customer,
+		Service:     string(req.Service),
+		Application: req.Application,
+	})
+	require.NoError(t, err)
+	return customer, res
+}
+
+// chargeRequest returns a charge request that can be turned into a webhook request with Harness.ChargeEvent.
+func chargeRequest(h Harness) api.ChargeRequest {
+	return api.ChargeRequest{
+		Amount:      300,
+		Currency:    "usd",
+		Customer:    "cus_conformance",
+		Service:     h.Service(),
+		Application: "conformance",
+		Event:       "evt_conformance",
+		Payment:     "pay_conformance",
+	}
+}
+
+// testCreateCustomer checks that customers get an identifier, and that different customers get different ones.
+func testCreateCustomer(t *testing.T, client adapter.Client, _ Harness) {
+	alice, err := client.CreateCustomer(context.Background(), "conformance", "alice")
+	require.NoError(t, err)
+	assert.NotEmpty(t, alice)
+
+	bob, err := client.CreateCustomer(context.Background(), "conformance", "bob")
+	require.NoError(t, err)
+	assert.NotEmpty(t, bob)
+	assert.NotEqual(t, alice, bob)
+}
+
+// testDeleteCustomer checks that sessions can't be created for deleted customers, and that deleting a customer twice
+// is not an error.
+func testDeleteCustomer(t *testing.T, client adapter.Client, h Harness) {
+	req := sessionRequest(h, "alice")
+	customer, err := client.CreateCustomer(context.Background(), req.Application, req.Handle)
+	require.NoError(t, err)
+
+	require.NoError(t, client.DeleteCustomer(context.Background(), customer))
+	assert.NoError(t, client.DeleteCustomer(context.Background(), customer))
+
+	_, err = client.CreateSession(context.Background(), req, customers.CustomerResponse{
+		Handle:      req.Handle,
+		ID:          customer,
+		Service:     string(req.Service),
+		Application: req.Application,
+	})
+	assert.Error(t, err)
+}
+
+// testCreateSession checks the fields of the session response.
+func testCreateSession(t *testing.T, client adapter.Client, h Harness) {
+	_, first := createSession(t, client, h, "alice")
+	assert.Equal(t, h.Service(), first.Service)
+	assert.NotEmpty(t, first.Session)
+
+	_, second := createSession(t, client, h, "bob")
+	assert.Equal(t, h.Service(), second.Service)
+	assert.NotEmpty(t, second.Session)
+	assert.NotEqual(t, first.Session, second.Session)
+}
+
+// testCreateSessionUnknownCustomer checks that sessions can't be created for customers that don't exist in the
+// payment service.
+func testCreateSessionUnknownCustomer(t *testing.T, client adapter.Client, h Harness) {
+	req := sessionRequest(h, "alice")
+	_, err := client.CreateSession(context.Background(), req, customers.CustomerResponse{
+		Handle:      req.Handle,
+		ID:          "cus_unknown",
+		Service:     string(req.Service),
+		Application: req.Application,
+	})
+	assert.Error(t, err)
+}
+
+// testCanceledContext checks that requests are bound to their context: they fail with the context error once the
+// context has been canceled or its deadline has passed.
+func testCanceledContext(t *testing.T, client adapter.Client, h Harness) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.CreateCustomer(ctx, "conformance", "alice")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	customer, err := client.CreateCustomer(context.Background(), "conformance", "alice")
+	require.NoError(t, err)
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	req := sessionRequest(h, "alice")
+	_, err = client.CreateSession(ctx, req, customers.CustomerResponse{
+		Handle:      req.Handle,
+		ID:          customer,
+		Service:     string(req.Service),
+		Application: req.Application,
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, adapter.Retryable(err))
+}
+
+// testChargeRequest checks the charge request generated from the webhook request sent when a session is paid.
+func testChargeRequest(t *testing.T, client adapter.Client, h Harness) {
+	customer, session := createSession(t, client, h, "alice")
+	webhook := h.Pay(t, session.Session, 3)
+
+	req, err := client.GenerateChargeRequest(context.Background(), webhook.Body, webhook.Params)
+	require.NoError(t, err)
+	assert.Equal(t, uint(3*UnitPrice), req.Amount)
+	assert.Equal(t, "usd", req.Currency)
+	assert.Equal(t, customer, req.Customer)
+	assert.Equal(t, h.Service(), req.Service)
+	assert.Equal(t, "conformance", req.Application)
+	assert.NotEmpty(t, req.Event)
+	assert.NotEmpty(t, req.Payment)
+}
+
+// testMarkCharged checks that charged payments are recorded in the payment service.
+func testMarkCharged(t *testing.T, client adapter.Client, h Harness) {
+	_, session := createSession(t, client, h, "alice")
+	webhook := h.Pay(t, session.Session, 1)
+
+	req, err := client.GenerateChargeRequest(context.Background(), webhook.Body, webhook.Params)
+	require.NoError(t, err)
+	assert.Empty(t, h.Charged(t, req.Payment))
+
+	require.NoError(t, client.MarkCharged(context.Background(), req))
+	assert.Equal(t, req.Event, h.Charged(t, req.Payment))
+}
+
+// testMissingSignature checks that webhook requests without a signature are rejected with adapter.ErrInvalidSignature.
+func testMissingSignature(t *testing.T, client adapter.Client, h Harness) {
+	webhook := h.ChargeEvent(t, chargeRequest(h))
+
+	_, err := client.GenerateChargeRequest(context.Background(), webhook.Body, nil)
+	assert.ErrorIs(t, err, adapter.ErrInvalidSignature)
+
+	_, err = client.GenerateChargeRequest(context.Background(), webhook.Body, map[string][]string{})
+	assert.ErrorIs(t, err, adapter.ErrInvalidSignature)
+}
+
+// testUnknownSigningKey checks that webhook requests signed with an unknown key are rejected with
+// adapter.ErrInvalidSignature.
+func testUnknownSigningKey(t *testing.T, client adapter.Client, h Harness) {
+	webhook := h.ChargeEvent(t, chargeRequest(h))
+
+	_, err := client.GenerateChargeRequest(context.Background(), webhook.Body, h.SignUnknown(t, webhook.Body))
+	assert.ErrorIs(t, err, adapter.ErrInvalidSignature)
+}
+
+// testTamperedBody checks that webhook requests whose body has been modified after being signed are rejected with
+// adapter.ErrInvalidSignature.
+func testTamperedBody(t *testing.T, client adapter.Client, h Harness) {
+	webhook := h.ChargeEvent(t, chargeRequest(h))
+
+	body := bytes.Replace(webhook.Body, []byte("300"), []byte("900"), 1)
+	require.NotEqual(t, webhook.Body, body)
+	_, err := client.GenerateChargeRequest(context.Background(), body, webhook.Params)
+	assert.ErrorIs(t, err, adapter.ErrInvalidSignature)
+}
+
+// testMissingCustomer checks that payments without a customer are reported with adapter.ErrMissingMetadata.
+func testMissingCustomer(t *testing.T, client adapter.Client, h Harness) {
+	req := chargeRequest(h)
+	req.Customer = ""
+	webhook := h.ChargeEvent(t, req)
+
+	_, err := client.GenerateChargeRequest(context.Background(), webhook.Body, webhook.Params)
+	assert.ErrorIs(t, err, adapter.ErrMissingMetadata)
+}
+
+// testMissingApplication checks that payments without an application are reported with adapter.ErrMissingMetadata.
+func testMissingApplication(t *testing.T, client adapter.Client, h Harness) {
+	req := chargeRequest(h)
+	req.Application = ""
+	webhook := h.ChargeEvent(t, req)
+
+	_, err := client.GenerateChargeRequest(context.Background(), webhook.Body, webhook.Params)
+	assert.ErrorIs(t, err, adapter.ErrMissingMetadata)
+}
+
+// testUnhandledEvent checks that events that don't produce charges are reported with adapter.ErrUnhandledEvent, so
+// they can be told apart from invalid events.
+func testUnhandledEvent(t *testing.T, client adapter.Client, h Harness) {
+	webhook := h.UnhandledEvent(t)
+
+	_, err := client.GenerateChargeRequest(context.Background(), webhook.Body, webhook.Params)
+	assert.ErrorIs(t, err, adapter.ErrUnhandledEvent)
+}
@@ -0,0 +1,80 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrPriceNotFound is returned when no active price has the requested lookup key.
+	ErrPriceNotFound = errors.New("price not found")
+
+	// ErrPriceRejected is returned when the payment service rejects the catalog price of a session, e.g. because the
+	// price has been archived by a sync after it was looked up.
+	ErrPriceRejected = errors.New("price rejected")
+)
+
+// Product is a product sold through the payment service. The payments service sells credits as a product per
+// application.
+type Product struct {
+	// ID is the identifier of the product in the payment service.
+	ID string `json:"id"`
+
+	// Application is the application the product is sold in.
+	Application string `json:"application"`
+
+	// Name is the name of the product displayed in checkout pages and reports.
+	Name string `json:"name"`
+
+	// Active is false if the product has been archived.
+	Active bool `json:"active"`
+}
+
+// Price is the price of a unit of a product in a certain currency.
+type Price struct {
+	// ID is the identifier of the price in the payment service. It's set by the payment service.
+	ID string `json:"id"`
+
+	// Product is the ID of the product.
+	Product string `json:"product"`
+
+	// LookupKey identifies the current price of a product in a certain currency. Only one active price has a given
+	// lookup key.
+	LookupKey string `json:"lookup_key"`
+
+	// Currency holds the ISO 4217 currency value in lowercase format.
+	Currency string `json:"currency"`
+
+	// UnitAmount is the price of a unit in the minimum currency value (e.g. cents for USD).
+	UnitAmount uint `json:"unit_amount"`
+
+	// Active is false if the price has been archived and can't be used in new sessions.
+	Active bool `json:"active"`
+}
+
+// Catalog manages the products and prices defined in a payment service. Prices can't be changed once created: a price
+// is replaced by creating a new one with the same lookup key and archiving the previous one. Requests made to the
+// payment service are bound to the given context.
+type Catalog interface {
+	// ListProducts returns the products created with CreateProduct, including archived ones.
+	ListProducts(ctx context.Context) ([]Product, error)
+
+	// CreateProduct creates the given product.
+	CreateProduct(ctx context.Context, product Product) error
+
+	// UpdateProduct updates the name of the given product, and archives or restores it.
+	UpdateProduct(ctx context.Context, product Product) error
+
+	// ListPrices returns the active prices of the given product.
+	ListPrices(ctx context.Context, product string) ([]Price, error)
+
+	// CreatePrice creates the given price and returns it with its ID. The lookup key is taken from the price that had
+	// it, if any.
+	CreatePrice(ctx context.Context, price Price) (Price, error)
+
+	// ArchivePrice archives the given price, so it can't be used in new sessions.
+	ArchivePrice(ctx context.Context, id string) error
+
+	// GetPrice returns the active price with the given lookup key. It returns ErrPriceNotFound if there's none.
+	GetPrice(ctx context.Context, lookupKey string) (Price, error)
+}
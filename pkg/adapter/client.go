@@ -1,20 +1,30 @@
 package adapter
 
 import (
+	"context"
 	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
 )
 
-// Client wraps a payment service client such as Stripe to be used as an adapter.
+// Client wraps a payment service client such as Stripe to be used as an adapter. Requests made to the payment service
+// are bound to the given context: they're aborted when it's canceled or its deadline is exceeded.
 type Client interface {
 	// CreateCustomer creates a customer in the context of the payment service.
-	CreateCustomer(application, handle string) (string, error)
+	CreateCustomer(ctx context.Context, application, handle string) (string, error)
+
+	// DeleteCustomer deletes a customer created with CreateCustomer. It's used to remove customers that couldn't be
+	// recorded in the customers service. Deleting a customer that doesn't exist is not an error.
+	DeleteCustomer(ctx context.Context, id string) error
 
 	// CreateSession creates a session in the context of the payment service. It's usually
 	// used to create new checkout sessions.
-	CreateSession(req api.CreateSessionRequest, cus customers.CustomerResponse) (api.CreateSessionResponse, error)
+	CreateSession(ctx context.Context, req api.CreateSessionRequest, cus customers.CustomerResponse) (api.CreateSessionResponse, error)
 
 	// GenerateChargeRequest generates an api.ChargeRequest out from the given body and a set
 	// of parameters
-	GenerateChargeRequest(body []byte, params map[string][]string) (api.ChargeRequest, error)
+	GenerateChargeRequest(ctx context.Context, body []byte, params map[string][]string) (api.ChargeRequest, error)
+
+	// MarkCharged records in the payment service that the given charge has been processed, so the events that
+	// originated it can be skipped when they are received again.
+	MarkCharged(ctx context.Context, req api.ChargeRequest) error
 }
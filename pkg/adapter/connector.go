@@ -0,0 +1,34 @@
+package adapter
+
+import (
+	"context"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+)
+
+// ConnectEvent is a webhook event related to connected accounts. Exactly one of its fields is set.
+type ConnectEvent struct {
+	// Account is set when the status of a connected account changes.
+	Account *api.AccountUpdate
+
+	// Capture is set when a payment made to a connected account has been authorized and must be captured.
+	Capture *api.CaptureRequest
+}
+
+// Connector wraps the connected accounts features of a payment service such as Stripe Connect. Connected accounts
+// receive a share of the payments made in the application they have been created for. Requests made to the payment
+// service are bound to the given context.
+type Connector interface {
+	// CreateAccount creates a connected account for the given application. It returns the ID of the new account.
+	CreateAccount(ctx context.Context, application string) (string, error)
+
+	// CreateAccountLink returns the URL of the onboarding flow of the given connected account.
+	CreateAccountLink(ctx context.Context, id, refreshURL, returnURL string) (string, error)
+
+	// Capture captures the given authorized payment, keeping the application fee.
+	Capture(ctx context.Context, req api.CaptureRequest) error
+
+	// GenerateConnectEvent generates a ConnectEvent out from the given body and a set of parameters. It returns
+	// ErrUnhandledEvent if the event is not related to connected accounts, and ErrMalformedEvent if its data can't be
+	// decoded.
+	GenerateConnectEvent(ctx context.Context, body []byte, params map[string][]string) (ConnectEvent, error)
+}
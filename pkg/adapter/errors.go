@@ -0,0 +1,163 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"net"
+	"net/http"
+)
+
+var (
+	// ErrInvalidSignature is returned when a webhook event signature is missing, malformed, expired or not valid for
+	// any of the signing keys.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+
+	// ErrMissingMetadata is returned when a webhook event lacks a value needed to process it, such as the customer or
+	// the application. Events of unsupported types are reported with ErrUnhandledEvent instead.
+	ErrMissingMetadata = errors.New("missing event metadata")
+
+	// ErrMalformedEvent is returned when the data of a webhook event can't be decoded.
+	ErrMalformedEvent = errors.New("malformed event data")
+
+	// ErrCardDeclined is returned when the payment service declines a card.
+	ErrCardDeclined = errors.New("card declined")
+
+	// ErrRateLimited is returned when the payment service rejects a request because too many requests have been made.
+	ErrRateLimited = errors.New("payment service rate limit exceeded")
+
+	// ErrAuthentication is returned when the payment service rejects the configured credentials, or they're not
+	// allowed to perform a request.
+	ErrAuthentication = errors.New("payment service authentication failed")
+
+	// ErrProviderUnavailable is returned when the payment service can't be reached, or fails to process a request
+	// because of an error on its side.
+	ErrProviderUnavailable = errors.New("payment service unavailable")
+)
+
+// ProviderError is an error returned by a payment service. It matches the sentinel error of its kind, such as
+// ErrCardDeclined, when used with errors.Is, and unwraps to the error returned by the payment service client.
+type ProviderError struct {
+	// Kind is the sentinel error matched by the error.
+	Kind error
+
+	// Code is the error code set by the payment service, such as card_declined. It may be empty.
+	Code string
+
+	// RequestID identifies the failed request in the payment service. It may be empty.
+	RequestID string
+
+	// Err is the error returned by the payment service client.
+	Err error
+}
+
+// Error returns the error message.
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+// Unwrap returns the error returned by the payment service client.
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is the kind of the error.
+func (e *ProviderError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// kindError is an error of a certain kind that keeps the error that caused it. It matches both the sentinel error of
+// its kind and the cause when used with errors.Is.
+type kindError struct {
+	kind error
+	err  error
+}
+
+// Error returns the error message.
+func (e *kindError) Error() string {
+	return fmt.Sprintf("%s: %v", e.kind, e.err)
+}
+
+// Unwrap returns the cause of the error.
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// Is returns true if target is the kind of the error.
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Retryable returns true if the given error is temporary, and the operation that returned it can be tried again
+// later.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrProviderUnavailable) ||
+		errors.Is(err, ErrIdempotencyConflict)
+}
+
+// missingMetadata returns an error reporting that the given event value is missing.
+func missingMetadata(field string) error {
+	return fmt.Errorf("%w: %s", ErrMissingMetadata, field)
+}
+
+// malformedEvent returns an ErrMalformedEvent error for the given event that couldn't be decoded.
+func malformedEvent(event stripe.Event, err error) error {
+	return fmt.Errorf("%w: event %s: %v", ErrMalformedEvent, event.ID, err)
+}
+
+// stripeError translates the given error returned by a Stripe request made with the given idempotency key into the
+// errors of this package. Errors that don't match any of them, such as invalid requests, are returned as they are.
+// Requests aborted because their context has been canceled or its deadline has passed return the context error, it's
+// up to the caller to decide whether the payment service is failing.
+func stripeError(key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return &ProviderError{Kind: ErrProviderUnavailable, Err: err}
+		}
+		return err
+	}
+
+	kind := stripeErrorKind(stripeErr)
+	switch kind {
+	case nil:
+		return err
+	case ErrIdempotencyConflict:
+		return &IdempotencyError{Key: key, Err: err}
+	}
+	return &ProviderError{
+		Kind:      kind,
+		Code:      string(stripeErr.Code),
+		RequestID: stripeErr.RequestID,
+		Err:       err,
+	}
+}
+
+// stripeErrorKind returns the sentinel error matching the given Stripe error, or nil if there's none.
+// Stripe docs: https://stripe.com/docs/error-handling
+func stripeErrorKind(err *stripe.Error) error {
+	switch {
+	case err.Type == stripe.ErrorTypeIdempotency || err.Code == stripe.ErrorCodeIdempotencyKeyInUse:
+		return ErrIdempotencyConflict
+	case err.Type == stripe.ErrorTypeCard:
+		return ErrCardDeclined
+	case err.Type == stripe.ErrorTypeRateLimit || err.HTTPStatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case err.Type == stripe.ErrorTypeAuthentication || err.Type == stripe.ErrorTypePermission,
+		err.HTTPStatusCode == http.StatusUnauthorized || err.HTTPStatusCode == http.StatusForbidden:
+		return ErrAuthentication
+	case err.Type == stripe.ErrorTypeAPI || err.Type == stripe.ErrorTypeAPIConnection,
+		err.HTTPStatusCode >= http.StatusInternalServerError:
+		return ErrProviderUnavailable
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v72"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/stripetest"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestStripeError(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		expected  error
+		retryable bool
+	}{
+		{
+			name:     "card declined",
+			err:      &stripe.Error{Type: stripe.ErrorTypeCard, Code: stripe.ErrorCodeCardDeclined, HTTPStatusCode: http.StatusPaymentRequired},
+			expected: ErrCardDeclined,
+		},
+		{
+			name:      "rate limited",
+			err:       &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, Code: stripe.ErrorCodeRateLimit, HTTPStatusCode: http.StatusTooManyRequests},
+			expected:  ErrRateLimited,
+			retryable: true,
+		},
+		{
+			name:     "authentication",
+			err:      &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, HTTPStatusCode: http.StatusUnauthorized},
+			expected: ErrAuthentication,
+		},
+		{
+			name:     "permission",
+			err:      &stripe.Error{Type: stripe.ErrorTypePermission, HTTPStatusCode: http.StatusForbidden},
+			expected: ErrAuthentication,
+		},
+		{
+			name:      "api error",
+			err:       &stripe.Error{Type: stripe.ErrorTypeAPI, HTTPStatusCode: http.StatusInternalServerError},
+			expected:  ErrProviderUnavailable,
+			retryable: true,
+		},
+		{
+			name:      "unavailable",
+			err:       &stripe.Error{HTTPStatusCode: http.StatusServiceUnavailable},
+			expected:  ErrProviderUnavailable,
+			retryable: true,
+		},
+		{
+			name:      "network",
+			err:       &url.Error{Op: "Post", URL: "https://api.stripe.com/v1/customers", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+			expected:  ErrProviderUnavailable,
+			retryable: true,
+		},
+		{
+			name:      "idempotency",
+			err:       &stripe.Error{Type: stripe.ErrorTypeIdempotency, HTTPStatusCode: http.StatusBadRequest},
+			expected:  ErrIdempotencyConflict,
+			retryable: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := stripeError("key", c.err)
+			assert.ErrorIs(t, err, c.expected)
+			assert.ErrorIs(t, err, c.err)
+			assert.Equal(t, c.retryable, Retryable(err))
+		})
+	}
+
+	// Context errors are returned as they are.
+	canceled := &url.Error{Op: "Post", URL: "https://api.stripe.com/v1/customers", Err: context.Canceled}
+	assert.Equal(t, canceled, stripeError("", canceled))
+	assert.False(t, Retryable(canceled))
+
+	// Invalid requests are returned as they are.
+	invalid := &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, Code: stripe.ErrorCodeResourceMissing, HTTPStatusCode: http.StatusNotFound}
+	assert.Equal(t, invalid, stripeError("", invalid))
+	assert.False(t, Retryable(invalid))
+	assert.NoError(t, stripeError("", nil))
+}
+
+func TestProviderError(t *testing.T) {
+	err := stripeError("", &stripe.Error{
+		Type:           stripe.ErrorTypeCard,
+		Code:           stripe.ErrorCodeCardDeclined,
+		RequestID:      "req_123",
+		HTTPStatusCode: http.StatusPaymentRequired,
+	})
+
+	var providerErr *ProviderError
+	require.True(t, errors.As(err, &providerErr))
+	assert.Equal(t, ErrCardDeclined, providerErr.Kind)
+	assert.Equal(t, string(stripe.ErrorCodeCardDeclined), providerErr.Code)
+	assert.Equal(t, "req_123", providerErr.RequestID)
+	assert.False(t, errors.Is(err, ErrRateLimited))
+}
+
+func TestStripeErrorAuthentication(t *testing.T) {
+	server := stripetest.NewServer(stripetest.Options{})
+	defer server.Close()
+
+	cfg := server.Config()
+	cfg.SecretKey = "sk_test_other"
+	_, err := NewStripeAdapter(cfg).CreateCustomer(context.Background(), "app", "alice")
+	assert.ErrorIs(t, err, ErrAuthentication)
+	assert.False(t, Retryable(err))
+}
+
+func TestInspectorErrors(t *testing.T) {
+	server := stripetest.NewServer(stripetest.Options{})
+	defer server.Close()
+
+	cfg := server.Config()
+	cfg.SecretKey = "sk_test_other"
+	inspector := NewStripeInspector(cfg)
+	ctx := context.Background()
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	_, err := inspector.GetSession(ctx, "cs_1")
+	assert.ErrorIs(t, err, ErrAuthentication)
+	_, err = inspector.GetPaymentIntent(ctx, "pi_1")
+	assert.ErrorIs(t, err, ErrAuthentication)
+	_, err = inspector.ListCharges(ctx, from, to, 0)
+	assert.ErrorIs(t, err, ErrAuthentication)
+	_, err = inspector.GetChargeRequest(ctx, "evt_1")
+	assert.ErrorIs(t, err, ErrAuthentication)
+	_, err = inspector.ListChargeEvents(ctx, from, to)
+	assert.ErrorIs(t, err, ErrAuthentication)
+	err = inspector.ListPayments(ctx, from, to, "", func(Payment) error { return nil })
+	assert.ErrorIs(t, err, ErrAuthentication)
+}
+
+func TestInspectorCanceled(t *testing.T) {
+	server := stripetest.NewServer(stripetest.Options{})
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := NewStripeInspector(server.Config()).GetSession(ctx, "cs_1")
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,78 @@
+package adapter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"strconv"
+	"time"
+)
+
+// idempotencyWindow is the period in which requests creating single-use objects, such as checkout sessions and account
+// links, get the same object when the caller doesn't tell them apart. It covers retries of requests that timed out,
+// while later requests get a new object instead of one that may have been used already.
+const idempotencyWindow = time.Minute
+
+// ErrIdempotencyConflict is returned when a payment service rejects a request because its idempotency key has been
+// used by a different request, or by a request that is still being processed.
+var ErrIdempotencyConflict = errors.New("idempotency key conflict")
+
+// IdempotencyError is returned when Stripe rejects a request because of its idempotency key. It matches
+// ErrIdempotencyConflict when used with errors.Is, and unwraps to the original Stripe error.
+type IdempotencyError struct {
+	// Key is the idempotency key sent with the request.
+	Key string
+
+	// Err is the error returned by Stripe.
+	Err error
+}
+
+// Error returns the error message.
+func (e *IdempotencyError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", ErrIdempotencyConflict, e.Key, e.Err)
+}
+
+// Unwrap returns the error returned by Stripe.
+func (e *IdempotencyError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is ErrIdempotencyConflict.
+func (e *IdempotencyError) Is(target error) bool {
+	return target == ErrIdempotencyConflict
+}
+
+// idempotencyKey returns a deterministic Stripe idempotency key for the given operation and values. Stripe returns the
+// result of the first request made with a key for 24 hours, so repeating a request that timed out doesn't create
+// another object. Values are hashed, so keys don't disclose them and are always shorter than the 255 characters
+// allowed by Stripe.
+// Stripe docs: https://stripe.com/docs/api/idempotent_requests
+func idempotencyKey(operation string, values ...string) string {
+	h := sha256.New()
+	for _, v := range values {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return operation + "-" + hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotent sets the idempotency key of the given request parameters, and returns the key.
+func idempotent(params *stripe.Params, operation string, values ...string) string {
+	key := idempotencyKey(operation, values...)
+	params.SetIdempotencyKey(key)
+	return key
+}
+
+// idempotencyPeriod returns the idempotencyWindow the given time belongs to, used in the idempotency keys of requests
+// that are only deduplicated within that window.
+func idempotencyPeriod(t time.Time) string {
+	return strconv.FormatInt(t.Truncate(idempotencyWindow).Unix(), 10)
+}
+
+// replayed returns true if the given response has been replayed by Stripe, because its request used an idempotency
+// key that had already been used.
+func replayed(res *stripe.APIResponse) bool {
+	return res != nil && res.Header.Get("Idempotent-Replayed") == "true"
+}
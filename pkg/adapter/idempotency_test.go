@@ -0,0 +1,165 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v72"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/stripetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestAdapter(t *testing.T) *stripeAdapter {
+	server := stripetest.NewServer(stripetest.Options{})
+	t.Cleanup(server.Close)
+	return NewStripeAdapter(server.Config()).(*stripeAdapter)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	key := idempotencyKey("customer", "app", "alice")
+	assert.Equal(t, key, idempotencyKey("customer", "app", "alice"))
+	assert.NotEqual(t, key, idempotencyKey("customer", "app", "bob"))
+	assert.NotEqual(t, key, idempotencyKey("account", "app", "alice"))
+	// Values are separated, so they can't be shifted between parts.
+	assert.NotEqual(t, idempotencyKey("customer", "ap", "palice"), key)
+	assert.LessOrEqual(t, len(key), 255)
+}
+
+func TestCreateCustomerIdempotent(t *testing.T) {
+	s := newTestAdapter(t)
+
+	first, err := s.CreateCustomer(context.Background(), "app", "alice")
+	require.NoError(t, err)
+
+	// A retry after a timeout gets the customer created by the first attempt.
+	second, err := s.CreateCustomer(context.Background(), "app", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	other, err := s.CreateCustomer(context.Background(), "app", "bob")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, other)
+}
+
+func TestCreateCustomerAfterDeletion(t *testing.T) {
+	s := newTestAdapter(t)
+
+	first, err := s.CreateCustomer(context.Background(), "app", "alice")
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteCustomer(context.Background(), first))
+
+	// The replayed response contains the deleted customer, a new one is created instead.
+	second, err := s.CreateCustomer(context.Background(), "app", "alice")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	third, err := s.CreateCustomer(context.Background(), "app", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, second, third)
+}
+
+func TestCreateSessionIdempotent(t *testing.T) {
+	s := newTestAdapter(t)
+
+	cus, err := s.CreateCustomer(context.Background(), "app", "alice")
+	require.NoError(t, err)
+
+	req := api.CreateSessionRequest{
+		Service:        api.PaymentServiceStripe,
+		SuccessURL:     "https://localhost/success",
+		CancelURL:      "https://localhost/cancel",
+		Handle:         "alice",
+		Application:    "app",
+		UnitPrice:      100,
+		Currency:       "usd",
+		IdempotencyKey: "purchase-1",
+	}
+	first, err := s.CreateSession(context.Background(), req, customers.CustomerResponse{ID: cus})
+	require.NoError(t, err)
+
+	second, err := s.CreateSession(context.Background(), req, customers.CustomerResponse{ID: cus})
+	require.NoError(t, err)
+	assert.Equal(t, first.Session, second.Session)
+
+	req.IdempotencyKey = "purchase-2"
+	other, err := s.CreateSession(context.Background(), req, customers.CustomerResponse{ID: cus})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Session, other.Session)
+
+	// Sessions created without a key are only deduplicated within the same window.
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	req.IdempotencyKey = ""
+	a, err := s.CreateSession(context.Background(), req, customers.CustomerResponse{ID: cus})
+	require.NoError(t, err)
+	b, err := s.CreateSession(context.Background(), req, customers.CustomerResponse{ID: cus})
+	require.NoError(t, err)
+	assert.Equal(t, a.Session, b.Session)
+	assert.NotEqual(t, first.Session, a.Session)
+
+	now = now.Add(idempotencyWindow)
+	c, err := s.CreateSession(context.Background(), req, customers.CustomerResponse{ID: cus})
+	require.NoError(t, err)
+	assert.NotEqual(t, a.Session, c.Session)
+}
+
+func TestCreateAccountLinkIdempotent(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		_, _ = w.Write([]byte(`{"object": "account_link", "url": "https://connect.stripe.com/setup/s/1"}`))
+	}))
+	defer server.Close()
+
+	s := NewStripeAdapter(conf.Stripe{URL: server.URL, SecretKey: "sk_test_123"}).(*stripeAdapter)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	// Retries use the key of the first attempt, later calls get a new link.
+	for i := 0; i < 2; i++ {
+		_, err := s.CreateAccountLink(context.Background(), "acct_1", "https://localhost/refresh", "https://localhost/return")
+		require.NoError(t, err)
+	}
+	now = now.Add(idempotencyWindow)
+	_, err := s.CreateAccountLink(context.Background(), "acct_1", "https://localhost/refresh", "https://localhost/return")
+	require.NoError(t, err)
+
+	require.Len(t, keys, 3)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+	assert.NotEqual(t, keys[0], keys[2])
+}
+
+func TestIdempotencyConflict(t *testing.T) {
+	s := newTestAdapter(t)
+
+	// Use the key of the customer with different parameters.
+	params := &stripe.CustomerParams{Description: stripe.String("Another customer")}
+	params.SetIdempotencyKey(idempotencyKey("customer", "app", "alice"))
+	_, err := s.API.Customers.New(params)
+	require.NoError(t, err)
+
+	_, err = s.CreateCustomer(context.Background(), "app", "alice")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrIdempotencyConflict))
+
+	var idempotencyErr *IdempotencyError
+	require.True(t, errors.As(err, &idempotencyErr))
+	assert.Equal(t, idempotencyKey("customer", "app", "alice"), idempotencyErr.Key)
+
+	var stripeErr *stripe.Error
+	require.True(t, errors.As(err, &stripeErr))
+	assert.Equal(t, stripe.ErrorTypeIdempotency, stripeErr.Type)
+
+	// Other errors are returned as they are.
+	err = s.Capture(context.Background(), api.CaptureRequest{Payment: "pi_missing", Amount: 100})
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrIdempotencyConflict))
+}
@@ -0,0 +1,202 @@
+package adapter
+
+import (
+	"context"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"time"
+)
+
+// Session contains the information of a checkout session created in a payment service.
+type Session struct {
+	// ID is the session identifier in the payment service.
+	ID string `json:"id"`
+
+	// Customer is the identifier of the customer that owns the session.
+	Customer string `json:"customer"`
+
+	// PaymentIntent is the identifier of the payment intent created for this session, if any.
+	PaymentIntent string `json:"payment_intent"`
+
+	// PaymentStatus is the payment status of the session. E.g. paid, unpaid.
+	PaymentStatus string `json:"payment_status"`
+
+	// AmountTotal is the total amount of the session in cents.
+	AmountTotal int64 `json:"amount_total"`
+
+	// Currency holds the ISO 4217 currency value in lowercase format.
+	Currency string `json:"currency"`
+
+	// Metadata contains the metadata attached to the session, such as the application and handle.
+	Metadata map[string]string `json:"metadata"`
+}
+
+// PaymentIntent contains the information of a payment intent created in a payment service.
+type PaymentIntent struct {
+	// ID is the payment intent identifier in the payment service.
+	ID string `json:"id"`
+
+	// Customer is the identifier of the customer the payment intent belongs to.
+	Customer string `json:"customer"`
+
+	// Status is the status of the payment intent. E.g. succeeded, canceled.
+	Status string `json:"status"`
+
+	// Amount is the amount of the payment intent in cents.
+	Amount int64 `json:"amount"`
+
+	// Currency holds the ISO 4217 currency value in lowercase format.
+	Currency string `json:"currency"`
+
+	// Metadata contains the metadata attached to the payment intent, such as the application and handle.
+	Metadata map[string]string `json:"metadata"`
+
+	// Created is the time the payment intent was created.
+	Created time.Time `json:"created"`
+}
+
+// Charge contains the information of a charge performed in a payment service.
+type Charge struct {
+	// ID is the charge identifier in the payment service.
+	ID string `json:"id"`
+
+	// Customer is the identifier of the customer that has been charged.
+	Customer string `json:"customer"`
+
+	// PaymentIntent is the identifier of the payment intent that originated this charge.
+	PaymentIntent string `json:"payment_intent"`
+
+	// Status is the status of the charge. E.g. succeeded, pending, failed.
+	Status string `json:"status"`
+
+	// Amount is the amount charged in cents.
+	Amount int64 `json:"amount"`
+
+	// AmountRefunded is the amount refunded in cents.
+	AmountRefunded int64 `json:"amount_refunded"`
+
+	// Currency holds the ISO 4217 currency value in lowercase format.
+	Currency string `json:"currency"`
+
+	// Refunded is true if the charge has been fully refunded.
+	Refunded bool `json:"refunded"`
+
+	// Metadata contains the metadata attached to the charge, such as the application and handle.
+	Metadata map[string]string `json:"metadata"`
+
+	// Created is the time the charge was performed.
+	Created time.Time `json:"created"`
+}
+
+// ChargeEvent contains a payment service event that should have produced a charge.
+type ChargeEvent struct {
+	// ID is the event identifier in the payment service.
+	ID string `json:"id"`
+
+	// Created is the time the event was created.
+	Created time.Time `json:"created"`
+
+	// Charged is true if the charge originated by this event, or by any other event of the same payment, has already
+	// been processed.
+	Charged bool `json:"charged"`
+
+	// Request contains the charge request generated from the event. It's empty if Err is set.
+	Request api.ChargeRequest `json:"request"`
+
+	// Err contains the reason why a charge request couldn't be generated from the event.
+	Err error `json:"-"`
+}
+
+// Refund statuses of a Payment.
+const (
+	// RefundStatusNone is used for payments that haven't been refunded.
+	RefundStatusNone = "none"
+	// RefundStatusPartial is used for payments that have been partially refunded.
+	RefundStatusPartial = "partial"
+	// RefundStatusFull is used for payments that have been fully refunded.
+	RefundStatusFull = "full"
+)
+
+// Payment contains the accounting information of a successful payment. It's used to export payments for finance
+// purposes.
+type Payment struct {
+	// ID is the payment identifier in the payment service. E.g. a Stripe payment intent.
+	ID string `json:"id"`
+
+	// Charge is the identifier of the charge that completed the payment.
+	Charge string `json:"charge"`
+
+	// BalanceTransaction is the identifier of the balance transaction created for the charge.
+	BalanceTransaction string `json:"balance_transaction"`
+
+	// Session is the identifier of the checkout session that originated the payment, if any.
+	Session string `json:"session"`
+
+	// Customer is the identifier of the customer in the payment service.
+	Customer string `json:"customer"`
+
+	// Handle is the customer identity in the context of the application.
+	Handle string `json:"handle"`
+
+	// Application is the application that originated the payment.
+	Application string `json:"application"`
+
+	// Gross is the amount charged in cents, including taxes.
+	Gross int64 `json:"gross"`
+
+	// Fee is the amount in cents charged by the payment service. It's expressed in the settlement currency.
+	Fee int64 `json:"fee"`
+
+	// Net is the amount in cents received after fees. It's expressed in the settlement currency.
+	Net int64 `json:"net"`
+
+	// Tax is the amount of taxes in cents included in Gross.
+	Tax int64 `json:"tax"`
+
+	// Currency holds the ISO 4217 currency value of Gross and Tax in lowercase format.
+	Currency string `json:"currency"`
+
+	// SettlementCurrency holds the ISO 4217 currency value of Fee and Net in lowercase format.
+	SettlementCurrency string `json:"settlement_currency"`
+
+	// Refunded is the amount refunded in cents.
+	Refunded int64 `json:"refunded"`
+
+	// RefundStatus is either none, partial or full.
+	RefundStatus string `json:"refund_status"`
+
+	// Created is the time the payment was created.
+	Created time.Time `json:"created"`
+
+	// Paid is the time the payment was charged.
+	Paid time.Time `json:"paid"`
+
+	// Available is the time the funds of the payment become available in the payment service balance.
+	Available time.Time `json:"available"`
+}
+
+// Inspector holds read-only methods to inspect the state of a payment service. It's used by operational tools
+// that need to look into the payment service without going through the payments API.
+type Inspector interface {
+	// GetSession returns the checkout session identified by the given ID.
+	GetSession(ctx context.Context, id string) (Session, error)
+
+	// GetPaymentIntent returns the payment intent identified by the given ID.
+	GetPaymentIntent(ctx context.Context, id string) (PaymentIntent, error)
+
+	// ListCharges returns the charges performed within the given time window, newest first. If limit is greater
+	// than 0, no more than limit charges are returned.
+	ListCharges(ctx context.Context, from, to time.Time, limit int) ([]Charge, error)
+
+	// GetChargeRequest generates an api.ChargeRequest out from the event identified by the given ID. The event is
+	// read from the payment service, so no signature is needed.
+	GetChargeRequest(ctx context.Context, eventID string) (api.ChargeRequest, error)
+
+	// ListChargeEvents returns the events that should have produced a charge created within the given time window,
+	// oldest first.
+	ListChargeEvents(ctx context.Context, from, to time.Time) ([]ChargeEvent, error)
+
+	// ListPayments calls fn for every successful payment created within the given time window, newest first. If
+	// application is not empty, only the payments originated by that application are listed. Payments are read from
+	// the payment service as they're passed to fn, listing stops at the first error returned by fn.
+	ListPayments(ctx context.Context, from, to time.Time, application string, fn func(Payment) error) error
+}
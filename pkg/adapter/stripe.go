@@ -1,6 +1,7 @@
 package adapter
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,58 +11,144 @@ import (
 	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
 	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"strconv"
+	"time"
 )
 
 const (
 	// EventPaymentIntentSucceeded is the event triggered by Stripe when a payment intent succeeds.
 	EventPaymentIntentSucceeded = "payment_intent.succeeded"
+
+	// MetadataChargedEvent is the payment intent metadata key used to record the event that has been used to charge
+	// a payment intent.
+	MetadataChargedEvent = "charged_event"
 )
 
+// ErrNoSigningKeys is returned when a webhook event is received but no signing key has been configured.
+var ErrNoSigningKeys = errors.New("no webhook signing keys configured")
+
+// ErrLivemodeMismatch is returned when a webhook event has been generated in a different mode than the configured
+// secret key. E.g. a test mode event sent to a production deployment.
+var ErrLivemodeMismatch = errors.New("webhook event livemode doesn't match the secret key")
+
+// ErrUnhandledEvent is returned when a webhook event of a type that isn't handled is received.
+var ErrUnhandledEvent = errors.New("couldn't process event type")
+
 // stripeAdapter implements Client using the Stripe API and tools.
 type stripeAdapter struct {
-	// SigningKey is used to validate a webhook event.
-	SigningKey string
+	// SigningKeys contains the keys used to validate a webhook event. They're tried in order.
+	SigningKeys []string
+	// Tolerance is the maximum age of the timestamp of a webhook event signature.
+	Tolerance time.Duration
+	// Livemode is true if the configured secret key belongs to live mode. Webhook events of the other mode are rejected.
+	Livemode bool
+	// Country is the country used when creating connected accounts.
+	Country string
 	// API contains a stripe client implementation.
 	API *client.API
+	// now returns the current time.
+	now func() time.Time
 }
 
 // GenerateChargeRequest generates an api.ChargeRequest out from the given body a set of parameters.
-func (s *stripeAdapter) GenerateChargeRequest(body []byte, params map[string][]string) (api.ChargeRequest, error) {
+func (s *stripeAdapter) GenerateChargeRequest(_ context.Context, body []byte, params map[string][]string) (api.ChargeRequest, error) {
+	event, key, err := s.verifyEvent(body, params)
+	if err != nil {
+		return api.ChargeRequest{}, err
+	}
+
+	req, err := chargeRequestFromEvent(event)
+	if err != nil {
+		return api.ChargeRequest{}, err
+	}
+	req.SigningKey = keyHint(key)
+	return req, nil
+}
+
+// verifyEvent verifies the signature of the given webhook event body using the Stripe-Signature parameter, and checks
+// that the event has been generated in the mode of the configured secret key. It returns the event and the signing key
+// that verified it.
+func (s *stripeAdapter) verifyEvent(body []byte, params map[string][]string) (stripe.Event, string, error) {
 	// Get stripe signature
 	var sig string
 	if p, ok := params["Stripe-Signature"]; !ok || len(p) == 0 {
-		return api.ChargeRequest{}, errors.New("invalid signature")
+		return stripe.Event{}, "", fmt.Errorf("%w: missing Stripe-Signature", ErrInvalidSignature)
 	} else {
 		sig = p[0]
 	}
 
 	// Validate event
-	event, err := webhook.ConstructEvent(body, sig, s.SigningKey)
+	event, key, err := s.constructEvent(body, sig)
 	if err != nil {
-		return api.ChargeRequest{}, err
+		return stripe.Event{}, "", err
+	}
+	if event.Livemode != s.Livemode {
+		return stripe.Event{}, "", fmt.Errorf("%w: event %s has livemode %t", ErrLivemodeMismatch, event.ID, event.Livemode)
+	}
+	return event, key, nil
+}
+
+// constructEvent verifies the signature of the given webhook event body trying every signing key, and returns the
+// event and the key that verified it.
+func (s *stripeAdapter) constructEvent(body []byte, sig string) (stripe.Event, string, error) {
+	if len(s.SigningKeys) == 0 {
+		return stripe.Event{}, "", ErrNoSigningKeys
+	}
+	var err error
+	for _, key := range s.SigningKeys {
+		var event stripe.Event
+		event, err = webhook.ConstructEventWithTolerance(body, sig, key, s.Tolerance)
+		if err == nil {
+			return event, key, nil
+		}
+		// Other errors, such as an expired timestamp, don't depend on the key.
+		if !errors.Is(err, webhook.ErrNoValidSignature) {
+			break
+		}
+	}
+	if isSignatureError(err) {
+		return stripe.Event{}, "", &kindError{kind: ErrInvalidSignature, err: err}
 	}
+	return stripe.Event{}, "", err
+}
+
+// isSignatureError returns true if the given error returned by the Stripe webhook package has been caused by the
+// event signature.
+func isSignatureError(err error) bool {
+	return errors.Is(err, webhook.ErrNoValidSignature) || errors.Is(err, webhook.ErrNotSigned) ||
+		errors.Is(err, webhook.ErrInvalidHeader) || errors.Is(err, webhook.ErrTooOld)
+}
+
+// keyHint returns a representation of the given signing key that can be logged without disclosing the key.
+func keyHint(key string) string {
+	if len(key) <= 12 {
+		return "..."
+	}
+	return "..." + key[len(key)-4:]
+}
 
+// chargeRequestFromEvent generates an api.ChargeRequest out from the given Stripe event.
+func chargeRequestFromEvent(event stripe.Event) (api.ChargeRequest, error) {
 	// Check event is a payment intent succeeded
 	if event.Type != EventPaymentIntentSucceeded {
-		return api.ChargeRequest{}, errors.New("couldn't process event type")
+		return api.ChargeRequest{}, fmt.Errorf("%w: %s", ErrUnhandledEvent, event.Type)
 	}
 
 	// Parse payment intent
 	var paymentIntent stripe.PaymentIntent
-	if err = json.Unmarshal(event.Data.Raw, &paymentIntent); err != nil {
-		return api.ChargeRequest{}, err
+	if err := json.Unmarshal(event.Data.Raw, &paymentIntent); err != nil {
+		return api.ChargeRequest{}, malformedEvent(event, err)
 	}
 
 	// A customer should be defined
 	if paymentIntent.Customer == nil {
-		return api.ChargeRequest{}, errors.New("missing customer")
+		return api.ChargeRequest{}, missingMetadata("customer")
 	}
 
 	// Get application metadata
-	var app string
-	var ok bool
-	if app, ok = paymentIntent.Metadata["application"]; !ok {
-		return api.ChargeRequest{}, errors.New("missing application")
+	app := paymentIntent.Metadata["application"]
+	if len(app) == 0 {
+		return api.ChargeRequest{}, missingMetadata("application")
 	}
 
 	// Parse charge
@@ -71,24 +158,107 @@ func (s *stripeAdapter) GenerateChargeRequest(body []byte, params map[string][]s
 		Customer:    paymentIntent.Customer.ID,
 		Service:     api.PaymentServiceStripe,
 		Application: app,
+		Event:       event.ID,
+		Payment:     paymentIntent.ID,
 	}, nil
 }
 
+// MarkCharged records the event used to process the given charge in the metadata of its Stripe payment intent.
+// Stripe docs: https://stripe.com/docs/api/payment_intents/update
+func (s *stripeAdapter) MarkCharged(ctx context.Context, req api.ChargeRequest) error {
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+	params.AddMetadata(MetadataChargedEvent, req.Event)
+	key := idempotent(&params.Params, "charged", req.Payment, req.Event)
+	_, err := s.API.PaymentIntents.Update(req.Payment, params)
+	return stripeError(key, err)
+}
+
 // CreateCustomer creates a customer in Stripe for the given application. It returns the ID of the new customer.
+// The request is idempotent: creating the same customer again within 24 hours returns the same ID.
 // Stripe docs: https://stripe.com/docs/api/customers/create
-func (s *stripeAdapter) CreateCustomer(application, handle string) (string, error) {
-	c, err := s.API.Customers.New(&stripe.CustomerParams{
+func (s *stripeAdapter) CreateCustomer(ctx context.Context, application, handle string) (string, error) {
+	params := &stripe.CustomerParams{
 		Description: stripe.String(fmt.Sprintf("Customer (%s) created for application: %s", handle, application)),
-	})
+	}
+	params.Context = ctx
+	key := idempotent(&params.Params, "customer", application, handle)
+	c, err := s.API.Customers.New(params)
 	if err != nil {
-		return "", nil
+		return "", stripeError(key, err)
+	}
+
+	// Stripe replays the first response for 24 hours, even if the customer has been deleted since then because it
+	// couldn't be recorded in the customers service. A new customer is created in that case.
+	if !replayed(c.LastResponse) || !s.customerDeleted(ctx, c.ID) {
+		return c.ID, nil
+	}
+	key = idempotent(&params.Params, "customer", application, handle, c.ID)
+	if c, err = s.API.Customers.New(params); err != nil {
+		return "", stripeError(key, err)
 	}
 	return c.ID, nil
 }
 
-// CreateSession initializes a new Stripe Checkout session.
+// customerDeleted returns true if the given Stripe customer has been deleted. Customers are assumed to exist if they
+// can't be retrieved.
+// Stripe docs: https://stripe.com/docs/api/customers/retrieve
+func (s *stripeAdapter) customerDeleted(ctx context.Context, id string) bool {
+	params := &stripe.CustomerParams{}
+	params.Context = ctx
+	c, err := s.API.Customers.Get(id, params)
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+		return true
+	}
+	return err == nil && c.Deleted
+}
+
+// DeleteCustomer deletes the given Stripe customer. The request is idempotent: a retry gets the response of the first
+// attempt.
+// Stripe docs: https://stripe.com/docs/api/customers/delete
+func (s *stripeAdapter) DeleteCustomer(ctx context.Context, id string) error {
+	params := &stripe.CustomerParams{}
+	params.Context = ctx
+	key := idempotent(&params.Params, "delete_customer", id)
+	_, err := s.API.Customers.Del(id, params)
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+		return nil
+	}
+	return stripeError(key, err)
+}
+
+// paymentIntentData returns the parameters of the payment intent created by a checkout session. Payments made to a
+// destination account use manual capture: the amount is only known once the customer has chosen the amount of
+// credits, and the application fee is computed from it when the payment intent is captured.
+func paymentIntentData(req api.CreateSessionRequest, params stripe.Params) *stripe.CheckoutSessionPaymentIntentDataParams {
+	if len(req.Destination) == 0 {
+		return &stripe.CheckoutSessionPaymentIntentDataParams{
+			Params: params,
+		}
+	}
+
+	metadata := make(map[string]string, len(params.Metadata)+1)
+	for k, v := range params.Metadata {
+		metadata[k] = v
+	}
+	metadata[MetadataApplicationFeePercent] = strconv.FormatFloat(req.ApplicationFeePercent, 'f', -1, 64)
+
+	return &stripe.CheckoutSessionPaymentIntentDataParams{
+		Params:        stripe.Params{Metadata: metadata},
+		CaptureMethod: stripe.String(string(stripe.PaymentIntentCaptureMethodManual)),
+		TransferData: &stripe.CheckoutSessionPaymentIntentDataTransferDataParams{
+			Destination: stripe.String(req.Destination),
+		},
+	}
+}
+
+// CreateSession initializes a new Stripe Checkout session. Repeating a request returns the same session instead of
+// creating another one: within 24 hours if the request has an idempotency key, and within idempotencyWindow otherwise.
+// It returns ErrPriceRejected if Stripe rejects the catalog price of the request.
 // Stripe docs: https://stripe.com/docs/api/checkout/sessions/create
-func (s *stripeAdapter) CreateSession(req api.CreateSessionRequest, cus customers.CustomerResponse) (api.CreateSessionResponse, error) {
+func (s *stripeAdapter) CreateSession(ctx context.Context, req api.CreateSessionRequest, cus customers.CustomerResponse) (api.CreateSessionResponse, error) {
 
 	params := stripe.Params{
 		Metadata: map[string]string{
@@ -97,38 +267,36 @@ func (s *stripeAdapter) CreateSession(req api.CreateSessionRequest, cus customer
 		},
 	}
 
-	session, err := s.API.CheckoutSessions.New(&stripe.CheckoutSessionParams{
+	// Sessions can't be told apart from their parameters, only the caller knows if a request is a repetition. Without
+	// its key, only retries made within the same window are deduplicated.
+	var period string
+	if len(req.IdempotencyKey) == 0 {
+		period = idempotencyPeriod(s.now())
+	}
+	key := idempotent(&params, "session", req.Application, req.Handle, cus.ID, req.IdempotencyKey, period,
+		strconv.FormatUint(uint64(req.UnitPrice), 10), req.SuccessURL, req.CancelURL, req.Destination,
+		strconv.FormatFloat(req.ApplicationFeePercent, 'f', -1, 64), req.Price, req.Currency)
+
+	sessionParams := &stripe.CheckoutSessionParams{
 		SuccessURL: &req.SuccessURL,
 		CancelURL:  &req.CancelURL,
 		PaymentMethodTypes: stripe.StringSlice([]string{
 			"card",
 		}),
-		Customer: stripe.String(cus.ID),
-		LineItems: []*stripe.CheckoutSessionLineItemParams{
-			{
-				Quantity: stripe.Int64(1),
-				AdjustableQuantity: &stripe.CheckoutSessionLineItemAdjustableQuantityParams{
-					Enabled: stripe.Bool(true),
-					Maximum: stripe.Int64(999), // Max amount of credits to buy
-					Minimum: stripe.Int64(1),   // Min amount of credits to buy
-				},
-				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-					Currency: stripe.String("usd"),
-					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-						Name: stripe.String("Credits"),
-					},
-					UnitAmount: stripe.Int64(int64(req.UnitPrice)), // Price per credit
-				},
-			},
-		},
-		Mode: stripe.String(string(stripe.CheckoutSessionModePayment)),
-		PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{
-			Params: params,
-		},
-		Params: params,
-	})
+		Customer:          stripe.String(cus.ID),
+		LineItems:         []*stripe.CheckoutSessionLineItemParams{lineItem(req)},
+		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+		PaymentIntentData: paymentIntentData(req, params),
+		Params:            params,
+	}
+	sessionParams.Context = ctx
+
+	session, err := s.API.CheckoutSessions.New(sessionParams)
+	if err != nil && len(req.Price) > 0 && priceRejected(err) {
+		return api.CreateSessionResponse{}, &kindError{kind: ErrPriceRejected, err: stripeError(key, err)}
+	}
 	if err != nil {
-		return api.CreateSessionResponse{}, err
+		return api.CreateSessionResponse{}, stripeError(key, err)
 	}
 	return api.CreateSessionResponse{
 		Service: req.Service,
@@ -136,6 +304,39 @@ func (s *stripeAdapter) CreateSession(req api.CreateSessionRequest, cus customer
 	}, nil
 }
 
+// lineItem returns the credits line item of the given session request. It uses the synced catalog price if the
+// request has one, and an inline price with the unit price in the request currency otherwise.
+func lineItem(req api.CreateSessionRequest) *stripe.CheckoutSessionLineItemParams {
+	item := &stripe.CheckoutSessionLineItemParams{
+		Quantity: stripe.Int64(1),
+		AdjustableQuantity: &stripe.CheckoutSessionLineItemAdjustableQuantityParams{
+			Enabled: stripe.Bool(true),
+			Maximum: stripe.Int64(999), // Max amount of credits to buy
+			Minimum: stripe.Int64(1),   // Min amount of credits to buy
+		},
+	}
+	if len(req.Price) > 0 {
+		item.Price = stripe.String(req.Price)
+		return item
+	}
+	item.PriceData = &stripe.CheckoutSessionLineItemPriceDataParams{
+		Currency: stripe.String(req.Currency),
+		ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+			Name: stripe.String("Credits"),
+		},
+		UnitAmount: stripe.Int64(int64(req.UnitPrice)), // Price per credit
+	}
+	return item
+}
+
+// priceRejected returns true if the given error returned by a Stripe session request reports that the price of its line
+// item can't be used, because it doesn't exist or it's not active.
+func priceRejected(err error) bool {
+	var stripeErr *stripe.Error
+	return errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeInvalidRequest &&
+		stripeErr.Param == "line_items[0][price]"
+}
+
 // NewStripeAdapter initializes a new adapter using the Stripe client.
 func NewStripeAdapter(cfg conf.Stripe) Client {
 	var backendURL *string
@@ -150,8 +351,15 @@ func NewStripeAdapter(cfg conf.Stripe) Client {
 		Connect: stripe.GetBackendWithConfig(stripe.ConnectBackend, &config),
 		Uploads: stripe.GetBackendWithConfig(stripe.UploadsBackend, &config),
 	})
+	tolerance := cfg.WebhookTolerance
+	if tolerance <= 0 {
+		tolerance = webhook.DefaultTolerance
+	}
 	return &stripeAdapter{
-		SigningKey: cfg.SigningKey,
-		API:        c,
+		SigningKeys: cfg.AllSigningKeys(),
+		Tolerance:   tolerance,
+		Livemode:    cfg.Livemode(),
+		API:         c,
+		now:         time.Now,
 	}
 }
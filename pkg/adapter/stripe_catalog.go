@@ -0,0 +1,168 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"github.com/stripe/stripe-go/v72"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"strconv"
+)
+
+// ListProducts returns the Stripe products that have an application in their metadata, including archived ones.
+// Stripe docs: https://stripe.com/docs/api/products/list
+func (s *stripeAdapter) ListProducts(ctx context.Context) ([]Product, error) {
+	params := &stripe.ProductListParams{}
+	params.Context = ctx
+
+	var out []Product
+	it := s.API.Products.List(params)
+	for it.Next() {
+		p := it.Product()
+		app, ok := p.Metadata["application"]
+		if !ok {
+			continue
+		}
+		out = append(out, Product{
+			ID:          p.ID,
+			Application: app,
+			Name:        p.Name,
+			Active:      p.Active,
+		})
+	}
+	if err := it.Err(); err != nil {
+		return nil, stripeError("", err)
+	}
+	return out, nil
+}
+
+// CreateProduct creates a Stripe product with the given ID. The application is recorded in the product metadata.
+// Stripe docs: https://stripe.com/docs/api/products/create
+func (s *stripeAdapter) CreateProduct(ctx context.Context, product Product) error {
+	params := &stripe.ProductParams{
+		ID:     stripe.String(product.ID),
+		Name:   stripe.String(product.Name),
+		Active: stripe.Bool(product.Active),
+	}
+	params.Context = ctx
+	params.AddMetadata("application", product.Application)
+	key := idempotent(&params.Params, "product", product.ID)
+
+	_, err := s.API.Products.New(params)
+	return stripeError(key, err)
+}
+
+// UpdateProduct updates the name of the given Stripe product, and archives or restores it. Updates are idempotent, so
+// no idempotency key is needed.
+// Stripe docs: https://stripe.com/docs/api/products/update
+func (s *stripeAdapter) UpdateProduct(ctx context.Context, product Product) error {
+	params := &stripe.ProductParams{
+		Name:   stripe.String(product.Name),
+		Active: stripe.Bool(product.Active),
+	}
+	params.Context = ctx
+
+	_, err := s.API.Products.Update(product.ID, params)
+	return stripeError("", err)
+}
+
+// ListPrices returns the active prices of the given Stripe product.
+// Stripe docs: https://stripe.com/docs/api/prices/list
+func (s *stripeAdapter) ListPrices(ctx context.Context, product string) ([]Price, error) {
+	params := &stripe.PriceListParams{
+		Active:  stripe.Bool(true),
+		Product: stripe.String(product),
+	}
+	params.Context = ctx
+	return s.listPrices(params)
+}
+
+// CreatePrice creates a Stripe price, transferring its lookup key from the price that had it. Requests are made with
+// an idempotency key derived from the price and the price it replaces, so a price can be set back to a previous amount.
+// Stripe docs: https://stripe.com/docs/api/prices/create
+func (s *stripeAdapter) CreatePrice(ctx context.Context, price Price) (Price, error) {
+	previous, err := s.GetPrice(ctx, price.LookupKey)
+	if err != nil && !errors.Is(err, ErrPriceNotFound) {
+		return Price{}, err
+	}
+
+	params := &stripe.PriceParams{
+		Product:           stripe.String(price.Product),
+		Currency:          stripe.String(price.Currency),
+		UnitAmount:        stripe.Int64(int64(price.UnitAmount)),
+		LookupKey:         stripe.String(price.LookupKey),
+		TransferLookupKey: stripe.Bool(true),
+	}
+	params.Context = ctx
+	key := idempotent(&params.Params, "price", price.LookupKey, price.Product, price.Currency,
+		strconv.FormatUint(uint64(price.UnitAmount), 10), previous.ID)
+
+	p, err := s.API.Prices.New(params)
+	if err != nil {
+		return Price{}, stripeError(key, err)
+	}
+	return stripePrice(p), nil
+}
+
+// ArchivePrice archives the given Stripe price. Updates are idempotent, so no idempotency key is needed.
+// Stripe docs: https://stripe.com/docs/api/prices/update
+func (s *stripeAdapter) ArchivePrice(ctx context.Context, id string) error {
+	params := &stripe.PriceParams{
+		Active: stripe.Bool(false),
+	}
+	params.Context = ctx
+
+	_, err := s.API.Prices.Update(id, params)
+	return stripeError("", err)
+}
+
+// GetPrice returns the active Stripe price with the given lookup key.
+// Stripe docs: https://stripe.com/docs/api/prices/list
+func (s *stripeAdapter) GetPrice(ctx context.Context, lookupKey string) (Price, error) {
+	params := &stripe.PriceListParams{
+		Active:     stripe.Bool(true),
+		LookupKeys: stripe.StringSlice([]string{lookupKey}),
+	}
+	params.Context = ctx
+
+	prices, err := s.listPrices(params)
+	if err != nil {
+		return Price{}, err
+	}
+	if len(prices) == 0 {
+		return Price{}, ErrPriceNotFound
+	}
+	return prices[0], nil
+}
+
+// listPrices returns the Stripe prices matching the given parameters.
+func (s *stripeAdapter) listPrices(params *stripe.PriceListParams) ([]Price, error) {
+	var out []Price
+	it := s.API.Prices.List(params)
+	for it.Next() {
+		out = append(out, stripePrice(it.Price()))
+	}
+	if err := it.Err(); err != nil {
+		return nil, stripeError("", err)
+	}
+	return out, nil
+}
+
+// stripePrice converts the given Stripe price into a Price.
+func stripePrice(p *stripe.Price) Price {
+	out := Price{
+		ID:         p.ID,
+		LookupKey:  p.LookupKey,
+		Currency:   string(p.Currency),
+		UnitAmount: uint(p.UnitAmount),
+		Active:     p.Active,
+	}
+	if p.Product != nil {
+		out.Product = p.Product.ID
+	}
+	return out
+}
+
+// NewStripeCatalog initializes a new Catalog using the Stripe products and prices API.
+func NewStripeCatalog(cfg conf.Stripe) Catalog {
+	return NewStripeAdapter(cfg).(*stripeAdapter)
+}
@@ -0,0 +1,125 @@
+package adapter_test
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter/adaptertest"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/stripetest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stripeHarness implements adaptertest.Harness using the stripetest emulator.
+type stripeHarness struct {
+	server *stripetest.Server
+
+	lock     sync.Mutex
+	requests []adaptertest.Request
+}
+
+func newStripeHarness(t *testing.T) *stripeHarness {
+	h := &stripeHarness{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		h.requests = append(h.requests, adaptertest.Request{Body: body, Params: r.Header})
+	}))
+	t.Cleanup(webhook.Close)
+
+	h.server = stripetest.NewServer(stripetest.Options{
+		WebhookURL:    webhook.URL,
+		WebhookEvents: []string{adapter.EventPaymentIntentSucceeded},
+	})
+	t.Cleanup(h.server.Close)
+	return h
+}
+
+func (h *stripeHarness) Service() api.PaymentService {
+	return api.PaymentServiceStripe
+}
+
+func (h *stripeHarness) Pay(t *testing.T, session string, quantity int64) adaptertest.Request {
+	_, err := h.server.Pay(session, quantity)
+	require.NoError(t, err)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	require.NotEmpty(t, h.requests)
+	return h.requests[len(h.requests)-1]
+}
+
+// event returns a signed webhook request for a Stripe event of the given type containing the given object.
+func (h *stripeHarness) event(t *testing.T, typ string, object map[string]interface{}) adaptertest.Request {
+	now := time.Now()
+	body, err := json.Marshal(map[string]interface{}{
+		"id":       "evt_conformance",
+		"object":   "event",
+		"type":     typ,
+		"created":  now.Unix(),
+		"livemode": false,
+		"data": map[string]interface{}{
+			"object": object,
+		},
+	})
+	require.NoError(t, err)
+
+	return adaptertest.Request{
+		Body: body,
+		Params: map[string][]string{
+			"Stripe-Signature": {stripetest.SignatureHeader(body, stripetest.DefaultSigningSecret, now)},
+		},
+	}
+}
+
+func (h *stripeHarness) ChargeEvent(t *testing.T, req api.ChargeRequest) adaptertest.Request {
+	pi := map[string]interface{}{
+		"id":              req.Payment,
+		"object":          "payment_intent",
+		"amount":          req.Amount,
+		"amount_received": req.Amount,
+		"currency":        req.Currency,
+		"status":          "succeeded",
+		"metadata":        map[string]string{},
+	}
+	if len(req.Customer) > 0 {
+		pi["customer"] = req.Customer
+	}
+	if len(req.Application) > 0 {
+		pi["metadata"] = map[string]string{"application": req.Application}
+	}
+	return h.event(t, adapter.EventPaymentIntentSucceeded, pi)
+}
+
+func (h *stripeHarness) UnhandledEvent(t *testing.T) adaptertest.Request {
+	return h.event(t, "customer.created", map[string]interface{}{
+		"id":     "cus_conformance",
+		"object": "customer",
+	})
+}
+
+func (h *stripeHarness) SignUnknown(t *testing.T, body []byte) map[string][]string {
+	return map[string][]string{
+		"Stripe-Signature": {stripetest.SignatureHeader(body, "whsec_unknown", time.Now())},
+	}
+}
+
+func (h *stripeHarness) Charged(t *testing.T, payment string) string {
+	pi, ok := h.server.PaymentIntent(payment)
+	require.True(t, ok)
+	return pi.Metadata[adapter.MetadataChargedEvent]
+}
+
+func TestStripeConformance(t *testing.T) {
+	adaptertest.Run(t, func(t *testing.T) (adapter.Client, adaptertest.Harness) {
+		h := newStripeHarness(t)
+		return adapter.NewStripeAdapter(h.server.Config()), h
+	})
+}
@@ -0,0 +1,162 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"strconv"
+	"time"
+)
+
+const (
+	// EventAccountUpdated is the event triggered by Stripe when a connected account changes.
+	EventAccountUpdated = "account.updated"
+
+	// EventPaymentIntentAmountCapturableUpdated is the event triggered by Stripe when a payment intent that uses
+	// manual capture has been authorized.
+	EventPaymentIntentAmountCapturableUpdated = "payment_intent.amount_capturable_updated"
+
+	// MetadataApplicationFeePercent is the payment intent metadata key used to record the application fee percentage
+	// agreed when the checkout session was created.
+	MetadataApplicationFeePercent = "application_fee_percent"
+)
+
+// CreateAccount creates an Express connected account in Stripe. The application is recorded in the account metadata.
+// Stripe docs: https://stripe.com/docs/api/accounts/create
+func (s *stripeAdapter) CreateAccount(ctx context.Context, application string) (string, error) {
+	params := &stripe.AccountParams{
+		Type:    stripe.String(string(stripe.AccountTypeExpress)),
+		Country: stripe.String(s.Country),
+		Capabilities: &stripe.AccountCapabilitiesParams{
+			CardPayments: &stripe.AccountCapabilitiesCardPaymentsParams{Requested: stripe.Bool(true)},
+			Transfers:    &stripe.AccountCapabilitiesTransfersParams{Requested: stripe.Bool(true)},
+		},
+	}
+	params.Context = ctx
+	params.AddMetadata("application", application)
+	key := idempotent(&params.Params, "account", application)
+
+	account, err := s.API.Account.New(params)
+	if err != nil {
+		return "", stripeError(key, err)
+	}
+	return account.ID, nil
+}
+
+// CreateAccountLink creates an onboarding link for the given Stripe connected account. Links can only be visited once,
+// so requests are only deduplicated within idempotencyWindow: retries get the same link, later calls a new one.
+// Stripe docs: https://stripe.com/docs/api/account_links/create
+func (s *stripeAdapter) CreateAccountLink(ctx context.Context, id, refreshURL, returnURL string) (string, error) {
+	params := &stripe.AccountLinkParams{
+		Account:    stripe.String(id),
+		RefreshURL: stripe.String(refreshURL),
+		ReturnURL:  stripe.String(returnURL),
+		Type:       stripe.String(string(stripe.AccountLinkTypeAccountOnboarding)),
+	}
+	params.Context = ctx
+	key := idempotent(&params.Params, "account_link", id, refreshURL, returnURL, idempotencyPeriod(s.now()))
+
+	link, err := s.API.AccountLinks.New(params)
+	if err != nil {
+		return "", stripeError(key, err)
+	}
+	return link.URL, nil
+}
+
+// Capture captures the given Stripe payment intent. The application fee is kept by the platform, and the rest is
+// transferred to the destination account set when the checkout session was created.
+// Stripe docs: https://stripe.com/docs/api/payment_intents/capture
+func (s *stripeAdapter) Capture(ctx context.Context, req api.CaptureRequest) error {
+	params := &stripe.PaymentIntentCaptureParams{
+		AmountToCapture:      stripe.Int64(int64(req.Amount)),
+		ApplicationFeeAmount: stripe.Int64(int64(req.ApplicationFee())),
+	}
+	params.Context = ctx
+	key := idempotent(&params.Params, "capture", req.Payment, strconv.FormatInt(*params.AmountToCapture, 10),
+		strconv.FormatInt(*params.ApplicationFeeAmount, 10))
+	_, err := s.API.PaymentIntents.Capture(req.Payment, params)
+	return stripeError(key, err)
+}
+
+// GenerateConnectEvent generates a ConnectEvent out from the given body and a set of parameters.
+func (s *stripeAdapter) GenerateConnectEvent(_ context.Context, body []byte, params map[string][]string) (ConnectEvent, error) {
+	event, _, err := s.verifyEvent(body, params)
+	if err != nil {
+		return ConnectEvent{}, err
+	}
+
+	switch event.Type {
+	case EventAccountUpdated:
+		var account stripe.Account
+		if err := json.Unmarshal(event.Data.Raw, &account); err != nil {
+			return ConnectEvent{}, malformedEvent(event, err)
+		}
+		update := accountUpdate(&account)
+		update.Event = event.ID
+		update.Created = time.Unix(event.Created, 0)
+		return ConnectEvent{Account: &update}, nil
+	case EventPaymentIntentAmountCapturableUpdated:
+		req, err := captureRequestFromEvent(event)
+		if err != nil {
+			return ConnectEvent{}, err
+		}
+		return ConnectEvent{Capture: &req}, nil
+	default:
+		return ConnectEvent{}, fmt.Errorf("%w: %s", ErrUnhandledEvent, event.Type)
+	}
+}
+
+// accountUpdate converts the given Stripe account into an api.AccountUpdate.
+func accountUpdate(account *stripe.Account) api.AccountUpdate {
+	return api.AccountUpdate{
+		ID:               account.ID,
+		DetailsSubmitted: account.DetailsSubmitted,
+		ChargesEnabled:   account.ChargesEnabled,
+		PayoutsEnabled:   account.PayoutsEnabled,
+	}
+}
+
+// captureRequestFromEvent generates an api.CaptureRequest out from the given Stripe event.
+func captureRequestFromEvent(event stripe.Event) (api.CaptureRequest, error) {
+	var paymentIntent stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &paymentIntent); err != nil {
+		return api.CaptureRequest{}, malformedEvent(event, err)
+	}
+
+	if paymentIntent.Status != stripe.PaymentIntentStatusRequiresCapture {
+		return api.CaptureRequest{}, fmt.Errorf("payment intent %s doesn't require capture: %s", paymentIntent.ID, paymentIntent.Status)
+	}
+	if paymentIntent.TransferData == nil || paymentIntent.TransferData.Destination == nil {
+		return api.CaptureRequest{}, missingMetadata("destination account")
+	}
+
+	app, ok := paymentIntent.Metadata["application"]
+	if !ok {
+		return api.CaptureRequest{}, missingMetadata("application")
+	}
+
+	fee, err := strconv.ParseFloat(paymentIntent.Metadata[MetadataApplicationFeePercent], 64)
+	if err != nil {
+		return api.CaptureRequest{}, fmt.Errorf("invalid application fee: %w", err)
+	}
+
+	return api.CaptureRequest{
+		Event:                 event.ID,
+		Payment:               paymentIntent.ID,
+		Amount:                uint(paymentIntent.AmountCapturable),
+		Currency:              paymentIntent.Currency,
+		Application:           app,
+		Destination:           paymentIntent.TransferData.Destination.ID,
+		ApplicationFeePercent: fee,
+	}, nil
+}
+
+// NewStripeConnector initializes a new Connector using the Stripe Connect API.
+func NewStripeConnector(cfg conf.Stripe, connect conf.Connect) Connector {
+	s := NewStripeAdapter(cfg).(*stripeAdapter)
+	s.Country = connect.Country
+	return s
+}
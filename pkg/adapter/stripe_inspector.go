@@ -0,0 +1,260 @@
+package adapter
+
+import (
+	"context"
+	"github.com/stripe/stripe-go/v72"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"time"
+)
+
+// GetSession returns the Stripe Checkout session identified by the given ID.
+// Stripe docs: https://stripe.com/docs/api/checkout/sessions/retrieve
+func (s *stripeAdapter) GetSession(ctx context.Context, id string) (Session, error) {
+	params := &stripe.CheckoutSessionParams{}
+	params.Context = ctx
+	session, err := s.API.CheckoutSessions.Get(id, params)
+	if err != nil {
+		return Session{}, stripeError("", err)
+	}
+	out := Session{
+		ID:            session.ID,
+		PaymentStatus: string(session.PaymentStatus),
+		AmountTotal:   session.AmountTotal,
+		Currency:      string(session.Currency),
+		Metadata:      session.Metadata,
+	}
+	if session.Customer != nil {
+		out.Customer = session.Customer.ID
+	}
+	if session.PaymentIntent != nil {
+		out.PaymentIntent = session.PaymentIntent.ID
+	}
+	return out, nil
+}
+
+// GetPaymentIntent returns the Stripe payment intent identified by the given ID.
+// Stripe docs: https://stripe.com/docs/api/payment_intents/retrieve
+func (s *stripeAdapter) GetPaymentIntent(ctx context.Context, id string) (PaymentIntent, error) {
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+	pi, err := s.API.PaymentIntents.Get(id, params)
+	if err != nil {
+		return PaymentIntent{}, stripeError("", err)
+	}
+	out := PaymentIntent{
+		ID:       pi.ID,
+		Status:   string(pi.Status),
+		Amount:   pi.Amount,
+		Currency: pi.Currency,
+		Metadata: pi.Metadata,
+		Created:  time.Unix(pi.Created, 0).UTC(),
+	}
+	if pi.Customer != nil {
+		out.Customer = pi.Customer.ID
+	}
+	return out, nil
+}
+
+// ListCharges returns the Stripe charges created within the given time window. Stripe doesn't copy the metadata of
+// payment intents to their charges, so payment intents are expanded to read the application and handle.
+// Stripe docs: https://stripe.com/docs/api/charges/list
+func (s *stripeAdapter) ListCharges(ctx context.Context, from, to time.Time, limit int) ([]Charge, error) {
+	params := &stripe.ChargeListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThanOrEqual:  to.Unix(),
+		},
+	}
+	params.Context = ctx
+	params.AddExpand("data.payment_intent")
+	if limit > 0 && limit < 100 {
+		params.Limit = stripe.Int64(int64(limit))
+	}
+
+	var out []Charge
+	it := s.API.Charges.List(params)
+	for (limit <= 0 || len(out) < limit) && it.Next() {
+		ch := it.Charge()
+		c := Charge{
+			ID:             ch.ID,
+			Status:         ch.Status,
+			Amount:         ch.Amount,
+			AmountRefunded: ch.AmountRefunded,
+			Currency:       string(ch.Currency),
+			Refunded:       ch.Refunded,
+			Metadata:       ch.Metadata,
+			Created:        time.Unix(ch.Created, 0).UTC(),
+		}
+		if ch.Customer != nil {
+			c.Customer = ch.Customer.ID
+		}
+		if ch.PaymentIntent != nil {
+			c.PaymentIntent = ch.PaymentIntent.ID
+			if len(c.Metadata) == 0 {
+				c.Metadata = ch.PaymentIntent.Metadata
+			}
+		}
+		out = append(out, c)
+	}
+	if err := it.Err(); err != nil {
+		return nil, stripeError("", err)
+	}
+	return out, nil
+}
+
+// GetChargeRequest generates an api.ChargeRequest out from the Stripe event identified by the given ID.
+// Stripe docs: https://stripe.com/docs/api/events/retrieve
+func (s *stripeAdapter) GetChargeRequest(ctx context.Context, eventID string) (api.ChargeRequest, error) {
+	params := &stripe.EventParams{}
+	params.Context = ctx
+	event, err := s.API.Events.Get(eventID, params)
+	if err != nil {
+		return api.ChargeRequest{}, stripeError("", err)
+	}
+	return chargeRequestFromEvent(*event)
+}
+
+// ListChargeEvents returns the payment_intent.succeeded Stripe events created within the given time window. Stripe
+// only keeps events for 30 days. Each payment intent is read from Stripe to check if it has already been charged.
+// Stripe docs: https://stripe.com/docs/api/events/list
+func (s *stripeAdapter) ListChargeEvents(ctx context.Context, from, to time.Time) ([]ChargeEvent, error) {
+	params := &stripe.EventListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThanOrEqual:  to.Unix(),
+		},
+		Type: stripe.String(EventPaymentIntentSucceeded),
+	}
+	params.Context = ctx
+
+	var out []ChargeEvent
+	it := s.API.Events.List(params)
+	for it.Next() {
+		event := it.Event()
+		e := ChargeEvent{
+			ID:      event.ID,
+			Created: time.Unix(event.Created, 0).UTC(),
+		}
+		e.Request, e.Err = chargeRequestFromEvent(*event)
+		if e.Err == nil {
+			piParams := &stripe.PaymentIntentParams{}
+			piParams.Context = ctx
+			pi, err := s.API.PaymentIntents.Get(e.Request.Payment, piParams)
+			if err != nil {
+				return nil, stripeError("", err)
+			}
+			_, e.Charged = pi.Metadata[MetadataChargedEvent]
+		}
+		out = append(out, e)
+	}
+	if err := it.Err(); err != nil {
+		return nil, stripeError("", err)
+	}
+
+	// Stripe lists events newest first
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+// ListPayments calls fn for every succeeded Stripe payment intent created within the given time window. Charges and
+// their balance transactions are expanded to read fees, and the checkout session of each payment is read to get the
+// amount of taxes.
+// Stripe docs: https://stripe.com/docs/api/payment_intents/list
+func (s *stripeAdapter) ListPayments(ctx context.Context, from, to time.Time, application string, fn func(Payment) error) error {
+	params := &stripe.PaymentIntentListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThanOrEqual:  to.Unix(),
+		},
+	}
+	params.Context = ctx
+	params.AddExpand("data.charges.data.balance_transaction")
+
+	it := s.API.PaymentIntents.List(params)
+	for it.Next() {
+		pi := it.PaymentIntent()
+		if pi.Status != stripe.PaymentIntentStatusSucceeded {
+			continue
+		}
+		if len(application) > 0 && pi.Metadata["application"] != application {
+			continue
+		}
+
+		p := paymentFromIntent(pi)
+
+		sessionParams := &stripe.CheckoutSessionListParams{
+			PaymentIntent: stripe.String(pi.ID),
+		}
+		sessionParams.Context = ctx
+		sessions := s.API.CheckoutSessions.List(sessionParams)
+		if sessions.Next() {
+			session := sessions.CheckoutSession()
+			p.Session = session.ID
+			if session.TotalDetails != nil {
+				p.Tax = session.TotalDetails.AmountTax
+			}
+		}
+		if err := sessions.Err(); err != nil {
+			return stripeError("", err)
+		}
+
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return stripeError("", it.Err())
+}
+
+// paymentFromIntent converts the given Stripe payment intent into a Payment. The balance transaction of the succeeded
+// charge should be expanded to fill in fees.
+func paymentFromIntent(pi *stripe.PaymentIntent) Payment {
+	p := Payment{
+		ID:           pi.ID,
+		Handle:       pi.Metadata["handle"],
+		Application:  pi.Metadata["application"],
+		Gross:        pi.AmountReceived,
+		Currency:     pi.Currency,
+		RefundStatus: RefundStatusNone,
+		Created:      time.Unix(pi.Created, 0).UTC(),
+	}
+	if pi.Customer != nil {
+		p.Customer = pi.Customer.ID
+	}
+	if pi.Charges == nil {
+		return p
+	}
+
+	for _, ch := range pi.Charges.Data {
+		if ch.Status != string(stripe.PaymentIntentStatusSucceeded) {
+			continue
+		}
+		p.Charge = ch.ID
+		p.Gross = ch.Amount
+		p.Refunded = ch.AmountRefunded
+		p.Paid = time.Unix(ch.Created, 0).UTC()
+		switch {
+		case ch.Refunded:
+			p.RefundStatus = RefundStatusFull
+		case ch.AmountRefunded > 0:
+			p.RefundStatus = RefundStatusPartial
+		}
+		if bt := ch.BalanceTransaction; bt != nil {
+			p.BalanceTransaction = bt.ID
+			p.Fee = bt.Fee
+			p.Net = bt.Net
+			p.SettlementCurrency = string(bt.Currency)
+			if bt.AvailableOn > 0 {
+				p.Available = time.Unix(bt.AvailableOn, 0).UTC()
+			}
+		}
+	}
+	return p
+}
+
+// NewStripeInspector initializes a new Inspector using the Stripe client.
+func NewStripeInspector(cfg conf.Stripe) Inspector {
+	return NewStripeAdapter(cfg).(*stripeAdapter)
+}
@@ -0,0 +1,226 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"github.com/stripe/stripe-go/v72"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WebhookEvents contains the list of Stripe events handled by the webhook handler.
+var WebhookEvents = []string{
+	EventPaymentIntentSucceeded,
+}
+
+// ConnectWebhookEvents contains the list of additional Stripe events handled by the webhook handler when Stripe
+// Connect is enabled. Events of connected accounts, such as account.updated, are sent to a separate Connect endpoint.
+var ConnectWebhookEvents = []string{
+	EventPaymentIntentAmountCapturableUpdated,
+}
+
+// ConnectAccountWebhookEvents contains the list of Stripe events of connected accounts handled by the webhook handler
+// when Stripe Connect is enabled. They're sent to the Connect webhook endpoint.
+var ConnectAccountWebhookEvents = []string{
+	EventAccountUpdated,
+}
+
+const (
+	// webhookStatusDisabled is the status of Stripe webhook endpoints that don't receive events.
+	webhookStatusDisabled = "disabled"
+
+	// webhookCreateAttempts is the amount of times the creation of a webhook endpoint is tried while another request
+	// with the same idempotency key is being processed, e.g. by another replica.
+	webhookCreateAttempts = 5
+)
+
+// webhookRetryDelay is the time between attempts to create a webhook endpoint.
+var webhookRetryDelay = time.Second
+
+// WebhookEndpointOptions contains the configuration of a webhook endpoint registered in the payment service.
+type WebhookEndpointOptions struct {
+	// URL is the URL the payment service sends events to.
+	URL string
+
+	// Events contains the events the endpoint is subscribed to.
+	Events []string
+
+	// Connect is true if the endpoint receives the events of connected accounts instead of the events of the
+	// platform account.
+	Connect bool
+
+	// Update is true if an existing endpoint subscribed to different events, or disabled, must be updated. The
+	// differences are only reported otherwise.
+	Update bool
+}
+
+// WebhookEndpoint contains the result of making sure a webhook endpoint exists in the payment service.
+type WebhookEndpoint struct {
+	// ID is the webhook endpoint identifier in the payment service.
+	ID string
+
+	// URL is the URL the payment service sends events to.
+	URL string
+
+	// Created is true if the endpoint didn't exist and has been created.
+	Created bool
+
+	// Updated is true if the endpoint existed but its events or status have been updated.
+	Updated bool
+
+	// Disabled is true if the endpoint existed but it was disabled.
+	Disabled bool
+
+	// Missing contains the handled events the endpoint wasn't subscribed to.
+	Missing []string
+
+	// Extra contains the events the endpoint was subscribed to that are not handled.
+	Extra []string
+
+	// Secret is the signing secret of the endpoint. The payment service only returns it when an endpoint is created,
+	// it's empty for existing endpoints.
+	Secret string
+}
+
+// EnsureWebhookEndpoint makes sure a Stripe webhook endpoint exists for the given URL, subscribed to the given
+// events. Existing endpoints subscribed to different events, or disabled, are only updated if opts.Update is true.
+// Endpoints are created with a deterministic idempotency key, so replicas starting at the same time end up with the
+// same endpoint and signing secret: Stripe replays the response of the first request to the rest for 24 hours.
+// Stripe docs: https://stripe.com/docs/api/webhook_endpoints
+func (s *stripeAdapter) EnsureWebhookEndpoint(ctx context.Context, opts WebhookEndpointOptions) (WebhookEndpoint, error) {
+	existing, err := s.findWebhookEndpoint(ctx, opts.URL)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	if existing == nil {
+		created, replayed, err := s.createWebhookEndpoint(ctx, opts, "")
+		if err != nil {
+			return WebhookEndpoint{}, err
+		}
+		// A replayed endpoint that can't be found has been deleted since, another one is created in that case.
+		if replayed && s.webhookEndpointDeleted(ctx, created.ID) {
+			if created, _, err = s.createWebhookEndpoint(ctx, opts, created.ID); err != nil {
+				return WebhookEndpoint{}, err
+			}
+		}
+		return WebhookEndpoint{
+			ID:      created.ID,
+			URL:     created.URL,
+			Created: true,
+			Secret:  created.Secret,
+		}, nil
+	}
+
+	out := WebhookEndpoint{
+		ID:       existing.ID,
+		URL:      existing.URL,
+		Disabled: existing.Status == webhookStatusDisabled,
+	}
+	out.Missing, out.Extra = diffEvents(opts.Events, existing.EnabledEvents)
+
+	if !opts.Update || (len(out.Missing) == 0 && len(out.Extra) == 0 && !out.Disabled) {
+		return out, nil
+	}
+
+	params := &stripe.WebhookEndpointParams{
+		EnabledEvents: stripe.StringSlice(opts.Events),
+		Disabled:      stripe.Bool(false),
+	}
+	params.Context = ctx
+	// The current state is part of the key, so the endpoint is updated again if it changes after a replayed update.
+	key := idempotent(&params.Params, "webhook_endpoint_update", existing.ID, existing.Status,
+		strings.Join(existing.EnabledEvents, ","), strings.Join(opts.Events, ","))
+	_, err = s.API.WebhookEndpoints.Update(existing.ID, params)
+	if err != nil {
+		return WebhookEndpoint{}, stripeError(key, err)
+	}
+	out.Updated = true
+	return out, nil
+}
+
+// findWebhookEndpoint returns the Stripe webhook endpoint of the given URL, or nil if there's none.
+// Stripe docs: https://stripe.com/docs/api/webhook_endpoints/list
+func (s *stripeAdapter) findWebhookEndpoint(ctx context.Context, url string) (*stripe.WebhookEndpoint, error) {
+	params := &stripe.WebhookEndpointListParams{}
+	params.Context = ctx
+	it := s.API.WebhookEndpoints.List(params)
+	for it.Next() {
+		if e := it.WebhookEndpoint(); e.URL == url {
+			return e, nil
+		}
+	}
+	return nil, stripeError("", it.Err())
+}
+
+// createWebhookEndpoint creates a Stripe webhook endpoint. The idempotency key depends on the URL and the given
+// endpoint that has been deleted, if any. Requests are retried while another request with the same key is being
+// processed. It returns true if the response has been replayed by Stripe.
+// Stripe docs: https://stripe.com/docs/api/webhook_endpoints/create
+func (s *stripeAdapter) createWebhookEndpoint(ctx context.Context, opts WebhookEndpointOptions, deleted string) (*stripe.WebhookEndpoint, bool, error) {
+	params := &stripe.WebhookEndpointParams{
+		URL:           stripe.String(opts.URL),
+		EnabledEvents: stripe.StringSlice(opts.Events),
+		APIVersion:    stripe.String(stripe.APIVersion),
+		Description:   stripe.String("Payments service"),
+	}
+	if opts.Connect {
+		params.Connect = stripe.Bool(true)
+	}
+	params.Context = ctx
+	key := idempotent(&params.Params, "webhook_endpoint", opts.URL, deleted)
+
+	for attempt := 1; ; attempt++ {
+		e, err := s.API.WebhookEndpoints.New(params)
+		if err == nil {
+			return e, replayed(e.LastResponse), nil
+		}
+		var stripeErr *stripe.Error
+		if !errors.As(err, &stripeErr) || stripeErr.Code != stripe.ErrorCodeIdempotencyKeyInUse || attempt >= webhookCreateAttempts {
+			return nil, false, stripeError(key, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(webhookRetryDelay):
+		}
+	}
+}
+
+// webhookEndpointDeleted returns true if the given Stripe webhook endpoint has been deleted. Endpoints are assumed to
+// exist if they can't be retrieved.
+// Stripe docs: https://stripe.com/docs/api/webhook_endpoints/retrieve
+func (s *stripeAdapter) webhookEndpointDeleted(ctx context.Context, id string) bool {
+	params := &stripe.WebhookEndpointParams{}
+	params.Context = ctx
+	_, err := s.API.WebhookEndpoints.Get(id, params)
+	var stripeErr *stripe.Error
+	return errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing
+}
+
+// diffEvents returns the events in expected that are not in actual, and the events in actual that are not in
+// expected. The "*" wildcard subscribes to every event, so it's always reported as extra.
+func diffEvents(expected, actual []string) (missing, extra []string) {
+	set := make(map[string]bool, len(actual))
+	for _, e := range actual {
+		set[e] = true
+	}
+	for _, e := range expected {
+		if !set[e] && !set["*"] {
+			missing = append(missing, e)
+		}
+		delete(set, e)
+	}
+	for e := range set {
+		extra = append(extra, e)
+	}
+	sort.Strings(extra)
+	return missing, extra
+}
+
+// EnsureStripeWebhookEndpoint makes sure a Stripe webhook endpoint exists using the given conf.Stripe credentials.
+func EnsureStripeWebhookEndpoint(ctx context.Context, cfg conf.Stripe, opts WebhookEndpointOptions) (WebhookEndpoint, error) {
+	return NewStripeAdapter(cfg).(*stripeAdapter).EnsureWebhookEndpoint(ctx, opts)
+}
@@ -24,10 +24,19 @@ var (
 	// ErrEmptyApplication is returned when an empty application value is passed on a request.
 	ErrEmptyApplication = errors.New("empty application")
 
-	// ErrInvalidUnitPrice is returned when an invalid unit price is passed on a request.
+	// ErrInvalidUnitPrice is returned when the credits service returns an invalid unit price. It's an upstream failure,
+	// not a problem with the request.
 	ErrInvalidUnitPrice = errors.New("invalid unit price")
+
+	// ErrAlreadyCharged is returned when a charge is not processed because the ledger already holds a grant of the
+	// same payment.
+	ErrAlreadyCharged = errors.New("payment already charged")
 )
 
+// HeaderIdempotencyKey is the HTTP header, and the gRPC metadata key, containing the idempotency key of a request.
+// Requests repeated with the same key get the response of the first request instead of performing the operation again.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
 // PaymentService identifies different payment services such as Stripe, PayPal, and more.
 type PaymentService string
 
@@ -72,6 +81,20 @@ type ChargeRequest struct {
 
 	// Application contains an identifier of an application that originated this charge.
 	Application string
+
+	// Event contains the identifier of the payment service event that originated this charge.
+	Event string
+
+	// Payment contains the identifier of the payment in the payment service. E.g. a Stripe payment intent.
+	Payment string
+
+	// SigningKey identifies the webhook signing key that verified the event that originated this charge, without
+	// disclosing it. It's empty if the event hasn't been received through a webhook.
+	SigningKey string
+
+	// Force processes the charge even if the ledger already holds a grant of the same payment. It's only meant to be
+	// used by operators re-running a charge on purpose.
+	Force bool
 }
 
 // ChargeResponse is the output of the ChargerV1.Charge method.
@@ -110,10 +133,47 @@ type CreateSessionRequest struct {
 	// This field is ignored.
 	// TODO: Remove this field from the public-facing API data structure.
 	UnitPrice uint `json:"-"`
+
+	// Price is the ID of the payment service price of a credit, synced from the catalog. Sessions use an inline price
+	// with UnitPrice if empty.
+	// This field is ignored, it's filled by the payments service.
+	Price string `json:"-"`
+
+	// Currency is the ISO 4217 currency, in lowercase format, of the UnitPrice and the Price.
+	// This field is ignored, it's filled by the payments service.
+	Currency string `json:"-"`
+
+	// Destination is the connected account that receives the payment, minus the ApplicationFeePercent. Payments are
+	// kept by the platform if empty.
+	// This field is ignored, it's filled by the payments service.
+	Destination string `json:"-"`
+
+	// ApplicationFeePercent is the percentage of the payment kept by the platform when paying a Destination.
+	// This field is ignored, it's filled by the payments service.
+	ApplicationFeePercent float64 `json:"-"`
+
+	// IdempotencyKey identifies the request when it's repeated, so the payment service returns the session created by
+	// the first attempt. If empty, only retries made within a short window are deduplicated.
+	// This field is ignored, it's filled by the payments service from the Idempotency-Key header.
+	IdempotencyKey string `json:"-"`
 }
 
 // Validate validates the current request.
 func (r CreateSessionRequest) Validate() error {
+	if err := r.ValidateInput(); err != nil {
+		return err
+	}
+
+	if r.UnitPrice == 0 {
+		return ErrInvalidUnitPrice
+	}
+
+	return nil
+}
+
+// ValidateInput validates the fields of the current request set by the caller. Fields filled by the payments service,
+// such as UnitPrice, are not checked.
+func (r CreateSessionRequest) ValidateInput() error {
 	if err := r.Service.Validate(); err != nil {
 		return err
 	}
@@ -137,10 +197,6 @@ func (r CreateSessionRequest) Validate() error {
 		return ErrEmptyApplication
 	}
 
-	if r.UnitPrice == 0 {
-		return ErrInvalidUnitPrice
-	}
-
 	return nil
 }
 
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrConnectUnavailable is returned when an application can't receive a share of its payments, because no application
+// fee has been configured for it.
+var ErrConnectUnavailable = errors.New("connected accounts are not available for this application")
+
+// AccountsV1 holds the methods used to onboard application owners to a payment platform such as Stripe Connect, so
+// they receive a share of the payments of their applications. Like PaymentsV1, it shouldn't be called from the
+// internet.
+type AccountsV1 interface {
+	// CreateAccountLink creates the connected account of an application if it doesn't exist yet, and returns the URL of
+	// the onboarding flow the application owner must complete before receiving payouts.
+	CreateAccountLink(ctx context.Context, req CreateAccountLinkRequest) (CreateAccountLinkResponse, error)
+
+	// GetAccount returns the connected account of an application.
+	GetAccount(ctx context.Context, req GetAccountRequest) (Account, error)
+}
+
+// ConnectV1 contains methods that should be called after a connected account or a payment made to a connected
+// account changes in the payment service. Like ChargerV1, it's private to the payment service and should only be
+// called from a webhook.
+type ConnectV1 interface {
+	// UpdateAccount updates the status of a connected account.
+	UpdateAccount(ctx context.Context, req AccountUpdate) error
+
+	// Capture captures an authorized payment made to a connected account, keeping the application fee.
+	Capture(ctx context.Context, req CaptureRequest) error
+}
+
+// Account contains the status of the connected account of an application.
+type Account struct {
+	// Application is the application the account receives payments for.
+	Application string `json:"application"`
+
+	// ID is the identifier of the account in the payment service.
+	ID string `json:"id"`
+
+	// DetailsSubmitted is true if the application owner has completed the onboarding flow.
+	DetailsSubmitted bool `json:"details_submitted"`
+
+	// ChargesEnabled is true if the account can receive payments.
+	ChargesEnabled bool `json:"charges_enabled"`
+
+	// PayoutsEnabled is true if the payment service can pay the account out. Payments are only shared with the
+	// account once payouts are enabled.
+	PayoutsEnabled bool `json:"payouts_enabled"`
+}
+
+// CreateAccountLinkRequest is the input for the AccountsV1.CreateAccountLink method.
+type CreateAccountLinkRequest struct {
+	// Service contains the name of the payment service where the account is created.
+	Service PaymentService `json:"service"`
+
+	// Application is the application the account receives payments for.
+	Application string `json:"application"`
+
+	// RefreshURL is the URL where to redirect the application owner if the link expires or has already been visited.
+	// It should create a new link.
+	RefreshURL string `json:"refresh_url"`
+
+	// ReturnURL is the URL where to redirect the application owner when leaving the onboarding flow. Leaving the flow
+	// doesn't mean it has been completed, use GetAccount to check the status of the account.
+	ReturnURL string `json:"return_url"`
+}
+
+// Validate validates the current request.
+func (r CreateAccountLinkRequest) Validate() error {
+	if err := r.Service.Validate(); err != nil {
+		return err
+	}
+	if len(r.Application) == 0 {
+		return ErrEmptyApplication
+	}
+	if len(r.RefreshURL) == 0 || len(r.ReturnURL) == 0 {
+		return ErrEmptyCallbacks
+	}
+	if err := validateURL(r.RefreshURL); err != nil {
+		return err
+	}
+	return validateURL(r.ReturnURL)
+}
+
+// CreateAccountLinkResponse is the output of the AccountsV1.CreateAccountLink method.
+type CreateAccountLinkResponse struct {
+	// Account contains the status of the connected account.
+	Account Account `json:"account"`
+
+	// URL is the onboarding URL. It can only be visited once and it expires after a few minutes.
+	URL string `json:"url"`
+}
+
+// GetAccountRequest is the input for the AccountsV1.GetAccount method.
+type GetAccountRequest struct {
+	// Application is the application the account receives payments for.
+	Application string `json:"application"`
+}
+
+// AccountUpdate is the input for the ConnectV1.UpdateAccount method.
+type AccountUpdate struct {
+	// Event contains the identifier of the payment service event that originated this update.
+	Event string
+
+	// Created is the time the event was created. Updates older than the last applied one are ignored.
+	Created time.Time
+
+	// ID is the identifier of the account in the payment service.
+	ID string
+
+	// DetailsSubmitted is true if the application owner has completed the onboarding flow.
+	DetailsSubmitted bool
+
+	// ChargesEnabled is true if the account can receive payments.
+	ChargesEnabled bool
+
+	// PayoutsEnabled is true if the payment service can pay the account out.
+	PayoutsEnabled bool
+}
+
+// CaptureRequest is the input for the ConnectV1.Capture method.
+type CaptureRequest struct {
+	// Event contains the identifier of the payment service event that originated this request.
+	Event string
+
+	// Payment contains the identifier of the payment in the payment service. E.g. a Stripe payment intent.
+	Payment string
+
+	// Amount contains the authorized value in cents.
+	Amount uint
+
+	// Currency holds the ISO 4217 currency value in lowercase format.
+	Currency string
+
+	// Application contains an identifier of the application the payment has been made for.
+	Application string
+
+	// Destination is the identifier of the connected account that receives the payment.
+	Destination string
+
+	// ApplicationFeePercent is the percentage of the amount kept by the platform. It's the percentage configured when
+	// the payment session was created.
+	ApplicationFeePercent float64
+}
+
+// ApplicationFee returns the amount in cents kept by the platform, rounded to the nearest cent.
+func (r CaptureRequest) ApplicationFee() uint {
+	return uint(float64(r.Amount)*r.ApplicationFeePercent/100 + 0.5)
+}
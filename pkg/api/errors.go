@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrMalformedRequest is returned when a request body cannot be decoded.
+	ErrMalformedRequest = errors.New("malformed request")
+
+	// ErrUnauthenticated is returned when a request doesn't include valid credentials.
+	ErrUnauthenticated = errors.New("unauthenticated")
+
+	// ErrForbidden is returned when the caller is not allowed to perform a certain operation.
+	ErrForbidden = errors.New("forbidden")
+
+	// ErrNotFound is returned when a resource has not been found.
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is returned when a request conflicts with the current state of a resource.
+	ErrConflict = errors.New("conflict")
+
+	// ErrRateLimited is returned when a caller exceeds the allowed amount of requests.
+	ErrRateLimited = errors.New("rate limit exceeded")
+
+	// ErrUpstream is returned when a service the payments service depends on fails, such as the customers or
+	// the credits service.
+	ErrUpstream = errors.New("upstream service failed")
+
+	// ErrProvider is returned when the payment provider (e.g. Stripe) fails to process a request.
+	ErrProvider = errors.New("payment provider failed")
+
+	// ErrTimeout is returned when an operation doesn't finish in time.
+	ErrTimeout = errors.New("timeout")
+
+	// ErrInternal is returned when an unexpected error happens.
+	ErrInternal = errors.New("internal error")
+)
+
+// ErrorCode is a stable machine-readable identifier of an error returned by the payments API.
+type ErrorCode string
+
+const (
+	// ErrorCodeEmptyService is the code of ErrEmptyService.
+	ErrorCodeEmptyService ErrorCode = "empty_service"
+	// ErrorCodeInvalidService is the code of ErrInvalidService.
+	ErrorCodeInvalidService ErrorCode = "invalid_service"
+	// ErrorCodeEmptyCallbacks is the code of ErrEmptyCallbacks.
+	ErrorCodeEmptyCallbacks ErrorCode = "empty_callbacks"
+	// ErrorCodeInvalidURL is the code of ErrInvalidURL.
+	ErrorCodeInvalidURL ErrorCode = "invalid_url"
+	// ErrorCodeEmptyHandle is the code of ErrEmptyHandle.
+	ErrorCodeEmptyHandle ErrorCode = "empty_handle"
+	// ErrorCodeEmptyApplication is the code of ErrEmptyApplication.
+	ErrorCodeEmptyApplication ErrorCode = "empty_application"
+	// ErrorCodeInvalidUnitPrice is the code of ErrInvalidUnitPrice.
+	ErrorCodeInvalidUnitPrice ErrorCode = "invalid_unit_price"
+	// ErrorCodeConnectUnavailable is the code of ErrConnectUnavailable.
+	ErrorCodeConnectUnavailable ErrorCode = "connect_unavailable"
+	// ErrorCodeMalformedRequest is the code of ErrMalformedRequest.
+	ErrorCodeMalformedRequest ErrorCode = "malformed_request"
+	// ErrorCodeUnauthenticated is the code of ErrUnauthenticated.
+	ErrorCodeUnauthenticated ErrorCode = "unauthenticated"
+	// ErrorCodeForbidden is the code of ErrForbidden.
+	ErrorCodeForbidden ErrorCode = "forbidden"
+	// ErrorCodeNotFound is the code of ErrNotFound.
+	ErrorCodeNotFound ErrorCode = "not_found"
+	// ErrorCodeConflict is the code of ErrConflict.
+	ErrorCodeConflict ErrorCode = "conflict"
+	// ErrorCodeRateLimited is the code of ErrRateLimited.
+	ErrorCodeRateLimited ErrorCode = "rate_limited"
+	// ErrorCodeUpstream is the code of ErrUpstream.
+	ErrorCodeUpstream ErrorCode = "upstream_error"
+	// ErrorCodeProvider is the code of ErrProvider.
+	ErrorCodeProvider ErrorCode = "provider_error"
+	// ErrorCodeTimeout is the code of ErrTimeout.
+	ErrorCodeTimeout ErrorCode = "timeout"
+	// ErrorCodeInternal is the code of ErrInternal.
+	ErrorCodeInternal ErrorCode = "internal"
+)
+
+// errorCodes contains the list of known errors and their codes. The order is relevant: ErrorCodeOf returns the
+// code of the first error that matches.
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrEmptyService, ErrorCodeEmptyService},
+	{ErrInvalidService, ErrorCodeInvalidService},
+	{ErrEmptyCallbacks, ErrorCodeEmptyCallbacks},
+	{ErrInvalidURL, ErrorCodeInvalidURL},
+	{ErrEmptyHandle, ErrorCodeEmptyHandle},
+	{ErrEmptyApplication, ErrorCodeEmptyApplication},
+	{ErrInvalidUnitPrice, ErrorCodeInvalidUnitPrice},
+	{ErrConnectUnavailable, ErrorCodeConnectUnavailable},
+	{ErrMalformedRequest, ErrorCodeMalformedRequest},
+	{ErrUnauthenticated, ErrorCodeUnauthenticated},
+	{ErrForbidden, ErrorCodeForbidden},
+	{ErrNotFound, ErrorCodeNotFound},
+	{ErrConflict, ErrorCodeConflict},
+	{ErrRateLimited, ErrorCodeRateLimited},
+	{ErrUpstream, ErrorCodeUpstream},
+	{ErrProvider, ErrorCodeProvider},
+	{ErrTimeout, ErrorCodeTimeout},
+	{context.DeadlineExceeded, ErrorCodeTimeout},
+	{ErrInternal, ErrorCodeInternal},
+}
+
+// genericCodes contains the codes of the errors whose message may include details of the payments service internals
+// or of the services it depends on, such as credits, customers and Stripe error messages.
+var genericCodes = map[ErrorCode]bool{
+	ErrorCodeUpstream: true,
+	ErrorCodeProvider: true,
+	ErrorCodeTimeout:  true,
+	ErrorCodeInternal: true,
+}
+
+// Message returns the message of the given error that can be returned to API clients. Internal, upstream, provider and
+// timeout errors, and errors created with WrapProviderError, return the message of their sentinel error, their details
+// should be logged instead.
+func Message(err error) string {
+	code := ErrorCodeOf(err)
+	var apiErr *Error
+	if genericCodes[code] || (errors.As(err, &apiErr) && apiErr.provider) {
+		return code.Err().Error()
+	}
+	return err.Error()
+}
+
+// ErrorCodes returns the list of codes of the errors returned by the payments API.
+func ErrorCodes() []ErrorCode {
+	seen := make(map[ErrorCode]bool, len(errorCodes))
+	codes := make([]ErrorCode, 0, len(errorCodes))
+	for _, e := range errorCodes {
+		if seen[e.code] {
+			continue
+		}
+		seen[e.code] = true
+		codes = append(codes, e.code)
+	}
+	return codes
+}
+
+// Err returns the sentinel error identified by the current code. It returns ErrInternal for unknown codes.
+func (c ErrorCode) Err() error {
+	for _, e := range errorCodes {
+		if e.code == c {
+			return e.err
+		}
+	}
+	return ErrInternal
+}
+
+// ErrorCodeOf returns the code of the given error. It returns ErrorCodeInternal for unknown errors.
+func ErrorCodeOf(err error) ErrorCode {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return ErrorCodeInternal
+}
+
+// Error is an error returned by the payments API. It's used as the JSON body of failed HTTP responses.
+type Error struct {
+	// Code is the machine-readable identifier of the error.
+	Code ErrorCode `json:"code"`
+
+	// Message is a human-readable description of the error.
+	Message string `json:"message"`
+
+	// RequestID is the identifier of the request that originated the error.
+	RequestID string `json:"request_id,omitempty"`
+
+	// cause contains the underlying error, if any.
+	cause error
+
+	// provider is true if the underlying error has been returned by the payment provider. Its message is not returned
+	// to API clients.
+	provider bool
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is returns true if the given target is the sentinel error identified by the current error code, or if it's an
+// Error with the same code.
+func (e *Error) Is(target error) bool {
+	if t, ok := target.(*Error); ok {
+		return e.Code == t.Code
+	}
+	return e.Code.Err() == target
+}
+
+// NewError initializes a new Error with the given code and message.
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// WrapError initializes a new Error with the given code wrapping the given error.
+func WrapError(code ErrorCode, err error) *Error {
+	return &Error{
+		Code:    code,
+		Message: err.Error(),
+		cause:   err,
+	}
+}
+
+// WrapProviderError initializes a new Error with the given code wrapping the given error returned by the payment
+// provider. Its message is kept for logs, but Message returns the message of the sentinel error of the code, as
+// provider errors may include details such as Stripe request parameters.
+func WrapProviderError(code ErrorCode, err error) *Error {
+	e := WrapError(code, err)
+	e.provider = true
+	return e
+}
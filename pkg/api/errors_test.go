@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestErrorCodeOf(t *testing.T) {
+	assert.Equal(t, ErrorCodeEmptyHandle, ErrorCodeOf(ErrEmptyHandle))
+	assert.Equal(t, ErrorCodeEmptyHandle, ErrorCodeOf(fmt.Errorf("wrapped: %w", ErrEmptyHandle)))
+	assert.Equal(t, ErrorCodeTimeout, ErrorCodeOf(context.DeadlineExceeded))
+	assert.Equal(t, ErrorCodeUpstream, ErrorCodeOf(WrapError(ErrorCodeUpstream, errors.New("customers failed"))))
+	assert.Equal(t, ErrorCodeInternal, ErrorCodeOf(errors.New("unknown")))
+}
+
+func TestErrorIs(t *testing.T) {
+	cause := errors.New("stripe failed")
+	err := WrapError(ErrorCodeProvider, cause)
+
+	assert.ErrorIs(t, err, ErrProvider)
+	assert.ErrorIs(t, err, cause)
+	assert.ErrorIs(t, err, NewError(ErrorCodeProvider, ""))
+	assert.NotErrorIs(t, err, ErrUpstream)
+	assert.Equal(t, ErrEmptyHandle, ErrorCodeEmptyHandle.Err())
+	assert.Equal(t, ErrInternal, ErrorCode("unknown").Err())
+}
+
+func TestMessage(t *testing.T) {
+	assert.Equal(t, ErrEmptyHandle.Error(), Message(ErrEmptyHandle))
+	assert.Equal(t, "rate limit exceeded: retry in 1s", Message(fmt.Errorf("%w: retry in 1s", ErrRateLimited)))
+
+	// Details of internal and upstream errors are not returned to clients
+	assert.Equal(t, ErrUpstream.Error(), Message(WrapError(ErrorCodeUpstream, errors.New("customers: connection refused"))))
+	assert.Equal(t, ErrProvider.Error(), Message(WrapError(ErrorCodeProvider, errors.New("stripe: invalid api key"))))
+	assert.Equal(t, ErrTimeout.Error(), Message(context.DeadlineExceeded))
+	assert.Equal(t, ErrInternal.Error(), Message(errors.New("sql: database is closed")))
+
+	// Neither are the details of errors returned by the payment provider
+	assert.Equal(t, ErrConflict.Error(), Message(WrapProviderError(ErrorCodeConflict, errors.New("stripe: keys for idempotent requests can only be used with the same parameters"))))
+	assert.Equal(t, ErrRateLimited.Error(), Message(WrapProviderError(ErrorCodeRateLimited, errors.New("stripe: too many requests"))))
+	assert.Equal(t, "stripe: too many requests", WrapProviderError(ErrorCodeRateLimited, errors.New("stripe: too many requests")).Error())
+}
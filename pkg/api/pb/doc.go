@@ -0,0 +1,5 @@
+// Package pb contains the protobuf definitions of the api.PaymentsV1 messages and the gRPC service used to expose them.
+// Code in this package is generated from payments.proto, do not edit the generated files.
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative payments.proto
@@ -0,0 +1,103 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// PaymentsV1Client is the client API for PaymentsV1 service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PaymentsV1Client interface {
+	// CreateSession creates a session for a user to pay for a certain product or service.
+	CreateSession(ctx context.Context, in *CreateSessionRequest, opts ...grpc.CallOption) (*CreateSessionResponse, error)
+}
+
+type paymentsV1Client struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPaymentsV1Client(cc grpc.ClientConnInterface) PaymentsV1Client {
+	return &paymentsV1Client{cc}
+}
+
+func (c *paymentsV1Client) CreateSession(ctx context.Context, in *CreateSessionRequest, opts ...grpc.CallOption) (*CreateSessionResponse, error) {
+	out := new(CreateSessionResponse)
+	err := c.cc.Invoke(ctx, "/payments.v1.PaymentsV1/CreateSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PaymentsV1Server is the server API for PaymentsV1 service.
+// All implementations must embed UnimplementedPaymentsV1Server
+// for forward compatibility
+type PaymentsV1Server interface {
+	// CreateSession creates a session for a user to pay for a certain product or service.
+	CreateSession(context.Context, *CreateSessionRequest) (*CreateSessionResponse, error)
+	mustEmbedUnimplementedPaymentsV1Server()
+}
+
+// UnimplementedPaymentsV1Server must be embedded to have forward compatible implementations.
+type UnimplementedPaymentsV1Server struct {
+}
+
+func (UnimplementedPaymentsV1Server) CreateSession(context.Context, *CreateSessionRequest) (*CreateSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSession not implemented")
+}
+func (UnimplementedPaymentsV1Server) mustEmbedUnimplementedPaymentsV1Server() {}
+
+// UnsafePaymentsV1Server may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PaymentsV1Server will
+// result in compilation errors.
+type UnsafePaymentsV1Server interface {
+	mustEmbedUnimplementedPaymentsV1Server()
+}
+
+func RegisterPaymentsV1Server(s grpc.ServiceRegistrar, srv PaymentsV1Server) {
+	s.RegisterService(&PaymentsV1_ServiceDesc, srv)
+}
+
+func _PaymentsV1_CreateSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentsV1Server).CreateSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/payments.v1.PaymentsV1/CreateSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentsV1Server).CreateSession(ctx, req.(*CreateSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PaymentsV1_ServiceDesc is the grpc.ServiceDesc for PaymentsV1 service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PaymentsV1_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "payments.v1.PaymentsV1",
+	HandlerType: (*PaymentsV1Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateSession",
+			Handler:    _PaymentsV1_CreateSession_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "payments.proto",
+}
@@ -0,0 +1,49 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/catalog"
+)
+
+// setPrice sets the synced catalog price of a credit in the session's application. The session uses an inline price
+// if the application has no synced price, or if the amount of the synced price doesn't match the unit price, e.g.
+// because the unit price changed after the last sync. Errors are only logged, as the inline price can be used instead.
+func (s *service) setPrice(ctx context.Context, req *api.CreateSessionRequest) {
+	if s.catalog == nil {
+		return
+	}
+
+	price, err := s.catalog.GetPrice(ctx, catalog.LookupKey(req.Application, req.Currency))
+	if errors.Is(err, adapter.ErrPriceNotFound) {
+		return
+	}
+	if err != nil {
+		s.logger.Println("Failed to get catalog price, using inline price:", err)
+		return
+	}
+
+	if price.UnitAmount != req.UnitPrice {
+		s.logger.Printf("Catalog price %s doesn't match the unit price (%d != %d), using inline price\n", price.ID,
+			price.UnitAmount, req.UnitPrice)
+		return
+	}
+	req.Price = price.ID
+}
+
+// priceInvalidator is implemented by catalogs that keep the prices they return, such as the ones returned by
+// cache.NewCatalog.
+type priceInvalidator interface {
+	// InvalidatePrice forgets the price with the given lookup key, so it's looked up again the next time.
+	InvalidatePrice(lookupKey string)
+}
+
+// invalidatePrice forgets the catalog price of the given session request after the payment service rejected it, e.g.
+// because a sync archived it, so the next sessions don't keep using it until it expires.
+func (s *service) invalidatePrice(req api.CreateSessionRequest) {
+	if invalidator, ok := s.catalog.(priceInvalidator); ok {
+		invalidator.InvalidatePrice(catalog.LookupKey(req.Application, req.Currency))
+	}
+}
@@ -0,0 +1,139 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	fakecredits "gitlab.com/ignitionrobotics/billing/credits/pkg/fake"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	fakecustomers "gitlab.com/ignitionrobotics/billing/customers/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/cache"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"testing"
+	"time"
+)
+
+func TestCreateSessionPrice(t *testing.T) {
+	var f fake.Adapter
+	creditsClient := fakecredits.NewClient()
+	customersClient := fakecustomers.NewClient()
+	catalog := &fake.Catalog{}
+
+	s := NewPaymentsService(Options{
+		Credits:   creditsClient,
+		Customers: customersClient,
+		Adapter:   &f,
+		Timeout:   200 * time.Millisecond,
+		Catalog:   catalog,
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	cus := customers.CustomerResponse{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "fuel",
+		ID:          "cus_HdRJTeoStCxpP4E",
+	}
+	creditsClient.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+	customersClient.On("GetCustomerByHandle", ctx, mock.Anything).Return(cus, error(nil))
+
+	in := api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  "https://localhost",
+		CancelURL:   "https://localhost",
+		Handle:      "test",
+		Application: "fuel",
+	}
+	inline := in
+	inline.UnitPrice = 2
+	inline.Currency = "usd"
+	synced := inline
+	synced.Price = "price_2"
+
+	// The synced price is used when it matches the unit price
+	catalog.On("GetPrice", ctx, "credits_fuel_usd").Return(adapter.Price{ID: "price_2", UnitAmount: 2}, nil).Once()
+	f.On("CreateSession", mock.Anything, synced, cus).Return(api.CreateSessionResponse{Session: "cs_1"}, nil).Once()
+	_, err := s.CreateSession(context.Background(), in)
+	require.NoError(t, err)
+
+	// Otherwise, sessions fall back to an inline price
+	catalog.On("GetPrice", ctx, "credits_fuel_usd").Return(adapter.Price{ID: "price_1", UnitAmount: 1}, nil).Once()
+	catalog.On("GetPrice", ctx, "credits_fuel_usd").Return(adapter.Price{}, adapter.ErrPriceNotFound).Once()
+	catalog.On("GetPrice", ctx, "credits_fuel_usd").Return(adapter.Price{}, errors.New("stripe is down")).Once()
+	f.On("CreateSession", mock.Anything, inline, cus).Return(api.CreateSessionResponse{Session: "cs_2"}, nil).Times(3)
+	for i := 0; i < 3; i++ {
+		_, err = s.CreateSession(context.Background(), in)
+		require.NoError(t, err)
+	}
+	catalog.AssertExpectations(t)
+	f.AssertExpectations(t)
+}
+
+func TestCreateSessionPriceRejected(t *testing.T) {
+	var f fake.Adapter
+	creditsClient := fakecredits.NewClient()
+	customersClient := fakecustomers.NewClient()
+	catalog := &fake.Catalog{}
+
+	s := NewPaymentsService(Options{
+		Credits:   creditsClient,
+		Customers: customersClient,
+		Adapter:   &f,
+		Timeout:   200 * time.Millisecond,
+		Catalog:   cache.NewCatalog(cache.NewCache(cache.Options{TTL: time.Minute}), catalog),
+		Currency:  "eur",
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	cus := customers.CustomerResponse{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "fuel",
+		ID:          "cus_HdRJTeoStCxpP4E",
+	}
+	creditsClient.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "eur"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "eur",
+	}, error(nil))
+	customersClient.On("GetCustomerByHandle", ctx, mock.Anything).Return(cus, error(nil))
+
+	in := api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  "https://localhost",
+		CancelURL:   "https://localhost",
+		Handle:      "test",
+		Application: "fuel",
+	}
+	inline := in
+	inline.UnitPrice = 2
+	inline.Currency = "eur"
+	archived := inline
+	archived.Price = "price_1"
+	synced := inline
+	synced.Price = "price_2"
+
+	// A cached price archived by a sync is rejected, the session falls back to an inline price
+	catalog.On("GetPrice", mock.Anything, "credits_fuel_eur").Return(adapter.Price{ID: "price_1", UnitAmount: 2}, nil).Once()
+	f.On("CreateSession", mock.Anything, archived, cus).Return(api.CreateSessionResponse{}, adapter.ErrPriceRejected).Once()
+	f.On("CreateSession", mock.Anything, inline, cus).Return(api.CreateSessionResponse{Session: "cs_1"}, nil).Once()
+	res, err := s.CreateSession(context.Background(), in)
+	require.NoError(t, err)
+	require.Equal(t, "cs_1", res.Session)
+
+	// The rejected price is no longer cached
+	catalog.On("GetPrice", mock.Anything, "credits_fuel_eur").Return(adapter.Price{ID: "price_2", UnitAmount: 2}, nil).Once()
+	f.On("CreateSession", mock.Anything, synced, cus).Return(api.CreateSessionResponse{Session: "cs_2"}, nil).Once()
+	res, err = s.CreateSession(context.Background(), in)
+	require.NoError(t, err)
+	require.Equal(t, "cs_2", res.Session)
+
+	catalog.AssertExpectations(t)
+	f.AssertExpectations(t)
+}
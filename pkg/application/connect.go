@@ -0,0 +1,195 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/connect"
+)
+
+// CreateAccountLink creates the connected account of an application if it doesn't exist yet, and returns the URL of
+// its onboarding flow.
+func (s *service) CreateAccountLink(ctx context.Context, req api.CreateAccountLinkRequest) (api.CreateAccountLinkResponse, error) {
+	s.logger.Printf("Creating account link: %+v\n", req)
+
+	if err := req.Validate(); err != nil {
+		return api.CreateAccountLinkResponse{}, err
+	}
+	if err := s.checkConnect(req.Application); err != nil {
+		return api.CreateAccountLinkResponse{}, err
+	}
+
+	var out api.CreateAccountLinkResponse
+	err := s.withTimeout(ctx, func(ctx context.Context) error {
+		account, err := s.accounts.Find(ctx, req.Application)
+		if errors.Is(err, connect.ErrAccountNotFound) {
+			s.logger.Println("Connected account not found, creating new one:", req.Application)
+			account, err = s.createAccount(ctx, req)
+		}
+		if err != nil {
+			return err
+		}
+
+		url, err := s.connector.CreateAccountLink(ctx, account.ID, req.RefreshURL, req.ReturnURL)
+		if err != nil {
+			return providerError(err)
+		}
+
+		out = api.CreateAccountLinkResponse{
+			Account: accountResponse(account),
+			URL:     url,
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Println("Failed to create account link:", err)
+		return api.CreateAccountLinkResponse{}, err
+	}
+	return out, nil
+}
+
+// createAccount creates a connected account in the payment service and records it.
+func (s *service) createAccount(ctx context.Context, req api.CreateAccountLinkRequest) (connect.Account, error) {
+	id, err := s.connector.CreateAccount(ctx, req.Application)
+	if err != nil {
+		return connect.Account{}, providerError(err)
+	}
+
+	account := connect.Account{
+		Application: req.Application,
+		ID:          id,
+		Service:     string(req.Service),
+	}
+	if err = s.accounts.Save(ctx, account); err != nil {
+		return connect.Account{}, api.WrapError(api.ErrorCodeInternal, err)
+	}
+	return account, nil
+}
+
+// GetAccount returns the connected account of an application.
+func (s *service) GetAccount(ctx context.Context, req api.GetAccountRequest) (api.Account, error) {
+	if len(req.Application) == 0 {
+		return api.Account{}, api.ErrEmptyApplication
+	}
+	if err := s.checkConnect(req.Application); err != nil {
+		return api.Account{}, err
+	}
+
+	account, err := s.accounts.Find(ctx, req.Application)
+	if errors.Is(err, connect.ErrAccountNotFound) {
+		return api.Account{}, api.WrapError(api.ErrorCodeNotFound, err)
+	}
+	if err != nil {
+		return api.Account{}, api.WrapError(api.ErrorCodeInternal, err)
+	}
+	return accountResponse(account), nil
+}
+
+// UpdateAccount updates the status of a connected account. Updates older than the last applied one are ignored, as
+// the payment service doesn't guarantee the order of events.
+func (s *service) UpdateAccount(ctx context.Context, req api.AccountUpdate) error {
+	s.logger.Printf("Processing account update: %+v\n", req)
+
+	if s.accounts == nil {
+		return api.ErrConnectUnavailable
+	}
+
+	account, err := s.accounts.FindByID(ctx, req.ID)
+	if errors.Is(err, connect.ErrAccountNotFound) {
+		// Accounts that haven't been created by the payments service are not tracked.
+		s.logger.Println("Ignoring update of unknown connected account:", req.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if req.Created.Before(account.EventCreated) {
+		s.logger.Println("Ignoring outdated account update:", req.Event)
+		return nil
+	}
+
+	if account.PayoutsEnabled != req.PayoutsEnabled {
+		s.logger.Printf("Payouts of connected account %s (%s) enabled: %t\n", account.ID, account.Application, req.PayoutsEnabled)
+	}
+
+	account.DetailsSubmitted = req.DetailsSubmitted
+	account.ChargesEnabled = req.ChargesEnabled
+	account.PayoutsEnabled = req.PayoutsEnabled
+	account.EventCreated = req.Created
+	return s.accounts.Save(ctx, account)
+}
+
+// Capture captures an authorized payment made to a connected account, keeping the application fee.
+func (s *service) Capture(ctx context.Context, req api.CaptureRequest) error {
+	s.logger.Printf("Processing capture request: %+v\n", req)
+
+	if s.connector == nil {
+		return api.ErrConnectUnavailable
+	}
+
+	err := s.withTimeout(ctx, func(ctx context.Context) error {
+		if err := s.connector.Capture(ctx, req); err != nil {
+			return providerError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Println("Failed to capture payment:", err)
+		return err
+	}
+
+	s.logger.Printf("Captured payment %s, application fee: %d %s\n", req.Payment, req.ApplicationFee(), req.Currency)
+	return nil
+}
+
+// setDestination sets the connected account that receives the payments of the session's application, if the
+// application has one with payouts enabled. Payments are kept by the platform otherwise.
+func (s *service) setDestination(ctx context.Context, req *api.CreateSessionRequest) error {
+	if s.accounts == nil {
+		return nil
+	}
+	fee, ok := s.fees[req.Application]
+	if !ok {
+		return nil
+	}
+
+	account, err := s.accounts.Find(ctx, req.Application)
+	if errors.Is(err, connect.ErrAccountNotFound) {
+		return nil
+	}
+	if err != nil {
+		return api.WrapError(api.ErrorCodeInternal, err)
+	}
+
+	if !account.PayoutsEnabled {
+		s.logger.Println("Payouts of connected account are not enabled, the platform keeps the payment:", account.ID)
+		return nil
+	}
+
+	req.Destination = account.ID
+	req.ApplicationFeePercent = fee
+	return nil
+}
+
+// checkConnect returns api.ErrConnectUnavailable if the given application can't have a connected account.
+func (s *service) checkConnect(application string) error {
+	if s.accounts == nil || s.connector == nil {
+		return api.ErrConnectUnavailable
+	}
+	if _, ok := s.fees[application]; !ok {
+		return api.ErrConnectUnavailable
+	}
+	return nil
+}
+
+// accountResponse converts the given connect.Account into an api.Account.
+func accountResponse(account connect.Account) api.Account {
+	return api.Account{
+		Application:      account.Application,
+		ID:               account.ID,
+		DetailsSubmitted: account.DetailsSubmitted,
+		ChargesEnabled:   account.ChargesEnabled,
+		PayoutsEnabled:   account.PayoutsEnabled,
+	}
+}
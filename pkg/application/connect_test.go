@@ -0,0 +1,187 @@
+package application
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	fakecredits "gitlab.com/ignitionrobotics/billing/credits/pkg/fake"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	fakecustomers "gitlab.com/ignitionrobotics/billing/customers/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/connect"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"testing"
+	"time"
+)
+
+func newConnectService(connector *fake.Connector, accounts connect.Store) Service {
+	return NewPaymentsService(Options{
+		Timeout:         200 * time.Millisecond,
+		Connector:       connector,
+		Accounts:        accounts,
+		ApplicationFees: map[string]float64{"fuel": 10},
+	})
+}
+
+func TestCreateAccountLink(t *testing.T) {
+	var connector fake.Connector
+	accounts := connect.NewMemoryStore()
+	s := newConnectService(&connector, accounts)
+
+	req := api.CreateAccountLinkRequest{
+		Service:     api.PaymentServiceStripe,
+		Application: "fuel",
+		RefreshURL:  "https://fuel.example.com/connect/refresh",
+		ReturnURL:   "https://fuel.example.com/connect/return",
+	}
+
+	connector.On("CreateAccount", mock.Anything, "fuel").Return("acct_1", nil).Once()
+	connector.On("CreateAccountLink", mock.Anything, "acct_1", req.RefreshURL, req.ReturnURL).Return("https://connect.stripe.com/setup/e/acct_1", nil)
+
+	res, err := s.CreateAccountLink(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "https://connect.stripe.com/setup/e/acct_1", res.URL)
+	assert.Equal(t, api.Account{Application: "fuel", ID: "acct_1"}, res.Account)
+
+	// The existing account is reused
+	_, err = s.CreateAccountLink(context.Background(), req)
+	require.NoError(t, err)
+	connector.AssertNumberOfCalls(t, "CreateAccount", 1)
+
+	account, err := s.GetAccount(context.Background(), api.GetAccountRequest{Application: "fuel"})
+	require.NoError(t, err)
+	assert.Equal(t, "acct_1", account.ID)
+}
+
+func TestCreateAccountLinkUnavailable(t *testing.T) {
+	var connector fake.Connector
+	s := newConnectService(&connector, connect.NewMemoryStore())
+
+	_, err := s.CreateAccountLink(context.Background(), api.CreateAccountLinkRequest{
+		Service:     api.PaymentServiceStripe,
+		Application: "cloudsim",
+		RefreshURL:  "https://cloudsim.example.com/connect/refresh",
+		ReturnURL:   "https://cloudsim.example.com/connect/return",
+	})
+	assert.ErrorIs(t, err, api.ErrConnectUnavailable)
+
+	_, err = s.GetAccount(context.Background(), api.GetAccountRequest{Application: "fuel"})
+	assert.ErrorIs(t, err, api.ErrNotFound)
+
+	// Connect is disabled without a connector
+	_, err = NewPaymentsService(Options{}).GetAccount(context.Background(), api.GetAccountRequest{Application: "fuel"})
+	assert.ErrorIs(t, err, api.ErrConnectUnavailable)
+}
+
+func TestUpdateAccount(t *testing.T) {
+	var connector fake.Connector
+	accounts := connect.NewMemoryStore()
+	s := newConnectService(&connector, accounts)
+	ctx := context.Background()
+
+	require.NoError(t, accounts.Save(ctx, connect.Account{Application: "fuel", ID: "acct_1"}))
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.UpdateAccount(ctx, api.AccountUpdate{
+		Event:            "evt_2",
+		Created:          now,
+		ID:               "acct_1",
+		DetailsSubmitted: true,
+		ChargesEnabled:   true,
+		PayoutsEnabled:   true,
+	}))
+
+	// Events received out of order are ignored
+	require.NoError(t, s.UpdateAccount(ctx, api.AccountUpdate{
+		Event:   "evt_1",
+		Created: now.Add(-time.Minute),
+		ID:      "acct_1",
+	}))
+
+	// Unknown accounts are ignored
+	require.NoError(t, s.UpdateAccount(ctx, api.AccountUpdate{Event: "evt_3", ID: "acct_2"}))
+
+	account, err := accounts.Find(ctx, "fuel")
+	require.NoError(t, err)
+	assert.True(t, account.PayoutsEnabled)
+	assert.True(t, account.EventCreated.Equal(now))
+}
+
+func TestCapture(t *testing.T) {
+	var connector fake.Connector
+	s := newConnectService(&connector, connect.NewMemoryStore())
+
+	req := api.CaptureRequest{
+		Event:                 "evt_1",
+		Payment:               "pi_1",
+		Amount:                1005,
+		Currency:              "usd",
+		Application:           "fuel",
+		Destination:           "acct_1",
+		ApplicationFeePercent: 10,
+	}
+	connector.On("Capture", mock.Anything, req).Return(nil)
+
+	require.NoError(t, s.Capture(context.Background(), req))
+	connector.AssertCalled(t, "Capture", mock.Anything, req)
+	assert.Equal(t, uint(101), req.ApplicationFee())
+}
+
+func TestCreateSessionDestination(t *testing.T) {
+	var f fake.Adapter
+	creditsClient := fakecredits.NewClient()
+	customersClient := fakecustomers.NewClient()
+	accounts := connect.NewMemoryStore()
+
+	s := NewPaymentsService(Options{
+		Credits:         creditsClient,
+		Customers:       customersClient,
+		Adapter:         &f,
+		Timeout:         200 * time.Millisecond,
+		Accounts:        accounts,
+		Connector:       &fake.Connector{},
+		ApplicationFees: map[string]float64{"fuel": 10},
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	cus := customers.CustomerResponse{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "fuel",
+		ID:          "cus_HdRJTeoStCxpP4E",
+	}
+	creditsClient.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+	customersClient.On("GetCustomerByHandle", ctx, mock.Anything).Return(cus, error(nil))
+
+	in := api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  "https://localhost",
+		CancelURL:   "https://localhost",
+		Handle:      "test",
+		Application: "fuel",
+	}
+	expected := in
+	expected.UnitPrice = 2
+	expected.Currency = "usd"
+
+	// Payouts are not enabled, the platform keeps the payment
+	require.NoError(t, accounts.Save(context.Background(), connect.Account{Application: "fuel", ID: "acct_1"}))
+	f.On("CreateSession", mock.Anything, expected, cus).Return(api.CreateSessionResponse{Session: "cs_1"}, nil).Once()
+
+	_, err := s.CreateSession(context.Background(), in)
+	require.NoError(t, err)
+
+	require.NoError(t, accounts.Save(context.Background(), connect.Account{Application: "fuel", ID: "acct_1", PayoutsEnabled: true}))
+	expected.Destination = "acct_1"
+	expected.ApplicationFeePercent = 10
+	f.On("CreateSession", mock.Anything, expected, cus).Return(api.CreateSessionResponse{Session: "cs_2"}, nil).Once()
+
+	res, err := s.CreateSession(context.Background(), in)
+	require.NoError(t, err)
+	assert.Equal(t, "cs_2", res.Session)
+}
@@ -0,0 +1,69 @@
+package application
+
+import (
+	"context"
+	"sync"
+)
+
+// handleLocks serializes the operations performed on the customers of the same application handle. Operations are
+// only serialized within a single replica, concurrent requests sent to other replicas are handled by the customer
+// creation conflict recovery.
+type handleLocks struct {
+	// lock is used to synchronize access to locks.
+	lock sync.Mutex
+
+	// locks contains the locks of the handles that are being used, keyed by application and handle.
+	locks map[string]*handleLock
+}
+
+// handleLock is the lock of a single handle.
+type handleLock struct {
+	// ch holds a value while the lock is held.
+	ch chan struct{}
+
+	// refs is the number of callers holding or waiting for the lock. The lock is removed when it reaches 0.
+	refs int
+}
+
+// newHandleLocks initializes a new handleLocks.
+func newHandleLocks() *handleLocks {
+	return &handleLocks{
+		locks: make(map[string]*handleLock),
+	}
+}
+
+// Lock waits until the lock of the given application handle is acquired, or the given context is done. The returned
+// function must be called to release the lock.
+func (l *handleLocks) Lock(ctx context.Context, application, handle string) (func(), error) {
+	key := application + "\x00" + handle
+
+	l.lock.Lock()
+	hl, ok := l.locks[key]
+	if !ok {
+		hl = &handleLock{ch: make(chan struct{}, 1)}
+		l.locks[key] = hl
+	}
+	hl.refs++
+	l.lock.Unlock()
+
+	select {
+	case hl.ch <- struct{}{}:
+		return func() {
+			<-hl.ch
+			l.release(key, hl)
+		}, nil
+	case <-ctx.Done():
+		l.release(key, hl)
+		return nil, ctx.Err()
+	}
+}
+
+// release removes a reference to the given lock, and removes the lock once it's no longer used.
+func (l *handleLocks) release(key string, hl *handleLock) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	hl.refs--
+	if hl.refs == 0 {
+		delete(l.locks, key)
+	}
+}
@@ -2,16 +2,35 @@ package application
 
 import (
 	"context"
+	"errors"
 	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
 	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/connect"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ledger"
 	"gitlab.com/ignitionrobotics/web/ign-go"
 	"io"
 	"log"
 	"time"
 )
 
+const (
+	// customerAttempts is the maximum amount of times the creation of a customer in the payment system is attempted
+	// when it fails with a temporary error.
+	customerAttempts = 3
+
+	// customerBackoff is the wait before creating a customer again after a temporary error. It grows linearly.
+	customerBackoff = 100 * time.Millisecond
+
+	// markAttempts is the maximum amount of times a payment is marked as charged in the payment system when it fails
+	// with a temporary error.
+	markAttempts = 3
+
+	// markBackoff is the wait before marking a payment as charged again after a temporary error. It grows linearly.
+	markBackoff = 100 * time.Millisecond
+)
+
 // service contains the business logic to manage payments on different billing systems such as Adapter.
 type service struct {
 	// logger is used to log relevant information when running this service.
@@ -23,33 +42,53 @@ type service struct {
 	// customers contains a api.CustomersV1 implementation.
 	customers customers.CustomersV1
 
-	// timeout is used as the timeout duration for the circuit breaking mechanism when calling different methods.
+	// handles is used to serialize the creation of the customers of the same handle.
+	handles *handleLocks
+
+	// timeout bounds the duration of each operation, including the calls made to other services.
 	timeout time.Duration
 
 	// adapter contains an implementation of a payment service client.
 	// E.g. Stripe, Paypal, etc.
 	adapter adapter.Client
+
+	// ledger is used to record the credits granted to customers. Grants are not recorded if nil.
+	ledger ledger.Store
+
+	// connector is used to manage the connected accounts of application owners. Connected accounts are disabled if
+	// nil.
+	connector adapter.Connector
+
+	// accounts is used to record the connected accounts of applications. Connected accounts are disabled if nil.
+	accounts connect.Store
+
+	// fees maps applications to the percentage of their payments kept by the platform. Only the listed applications
+	// can have a connected account.
+	fees map[string]float64
+
+	// catalog is used to look up the synced price of a credit. Sessions use an inline price if nil.
+	catalog adapter.Catalog
+
+	// currency is the ISO 4217 currency, in lowercase format, sessions are paid in.
+	currency string
 }
 
 // Charge charges a certain amount of money to a given user.
 func (s *service) Charge(ctx context.Context, req api.ChargeRequest) (api.ChargeResponse, error) {
 	s.logger.Printf("Processing charge request: %+v\n", req)
 
-	ctx, cancel := context.WithTimeout(ctx, s.timeout)
-	defer cancel()
+	err := s.withTimeout(ctx, func(ctx context.Context) error {
+		if err := s.checkCharged(ctx, req); err != nil {
+			return err
+		}
 
-	// Main thread
-	ch := make(chan api.ChargeResponse, 1)
-	errs := make(chan error, 1)
-	go func() {
 		customerResponse, err := s.customers.GetCustomerByID(ctx, customers.GetCustomerByIDRequest{
 			ID:          req.Customer,
 			Service:     string(req.Service),
 			Application: req.Application,
 		})
 		if err != nil {
-			errs <- err
-			return
+			return api.WrapError(api.ErrorCodeUpstream, err)
 		}
 
 		_, err = s.credits.IncreaseCredits(ctx, credits.IncreaseCreditsRequest{
@@ -61,24 +100,26 @@ func (s *service) Charge(ctx context.Context, req api.ChargeRequest) (api.Charge
 			},
 		})
 		if err != nil {
-			errs <- err
-			return
+			return api.WrapError(api.ErrorCodeUpstream, err)
 		}
 
-		ch <- api.ChargeResponse{}
-	}()
+		s.recordGrant(ctx, req, customerResponse)
 
-	select {
-	case <-ctx.Done(): // Circuit breaker
-		s.logger.Println("Context error:", ctx.Err())
-		return api.ChargeResponse{}, ctx.Err()
-	case err := <-errs: // Error handler
+		// Credits have already been granted at this point, failing to mark the payment as charged should not make the
+		// payment service send the same event again.
+		if len(req.Payment) > 0 {
+			s.markCharged(ctx, req)
+		}
+		return nil
+	})
+	if err != nil {
 		s.logger.Println("Failed to process charge:", err)
 		return api.ChargeResponse{}, err
-	case res := <-ch: // Post-processing
-		s.logger.Printf("Processing charge finished: %+v\n", res)
-		return res, nil
 	}
+
+	res := api.ChargeResponse{}
+	s.logger.Printf("Processing charge finished: %+v\n", res)
+	return res, nil
 }
 
 // CreateSession creates a session for a user to pay for a certain product or service.
@@ -86,73 +127,182 @@ func (s *service) Charge(ctx context.Context, req api.ChargeRequest) (api.Charge
 func (s *service) CreateSession(ctx context.Context, req api.CreateSessionRequest) (api.CreateSessionResponse, error) {
 	s.logger.Printf("Creating payment session: %+v\n", req)
 
-	ctx, cancel := context.WithTimeout(ctx, s.timeout)
-	defer cancel()
-
-	// Main thread
-	ch := make(chan api.CreateSessionResponse, 1)
-	errs := make(chan error, 1)
-	go func() {
-		// TODO: Support multiple currencies
-		unitPrice, err := s.credits.GetUnitPrice(ctx, credits.GetUnitPriceRequest{Currency: "usd"})
+	var res api.CreateSessionResponse
+	err := s.withTimeout(ctx, func(ctx context.Context) error {
+		req.Currency = s.currency
+		unitPrice, err := s.credits.GetUnitPrice(ctx, credits.GetUnitPriceRequest{Currency: req.Currency})
 		if err != nil {
-			errs <- err
-			return
+			return api.WrapError(api.ErrorCodeUpstream, err)
 		}
 
 		req.UnitPrice = unitPrice.Amount
 
 		if err = req.Validate(); err != nil {
-			errs <- err
-			return
+			return err
 		}
 
-		customerResponse, err := s.customers.GetCustomerByHandle(ctx, customers.GetCustomerByHandleRequest{
-			Handle:      req.Handle,
-			Service:     string(api.PaymentServiceStripe),
-			Application: req.Application,
-		})
+		s.setPrice(ctx, &req)
 
-		if err != nil && !ign.IsError(err, customers.ErrCustomerNotFound) {
-			errs <- err
-			return
+		if err = s.setDestination(ctx, &req); err != nil {
+			return err
 		}
 
-		if err != nil && ign.IsError(err, customers.ErrCustomerNotFound) {
-			s.logger.Println("Customer not found, creating new one:", req.Handle)
-			if customerResponse, err = s.createCustomer(ctx, req); err != nil {
-				errs <- err
-				return
-			}
+		customerResponse, err := s.getOrCreateCustomer(ctx, req)
+		if err != nil {
+			return err
 		}
 
-		res, err := s.adapter.CreateSession(req, customerResponse)
+		res, err = s.adapter.CreateSession(ctx, req, customerResponse)
+		if errors.Is(err, adapter.ErrPriceRejected) {
+			s.logger.Println("Catalog price rejected, using inline price:", err)
+			s.invalidatePrice(req)
+			req.Price = ""
+			res, err = s.adapter.CreateSession(ctx, req, customerResponse)
+		}
 		if err != nil {
-			errs <- err
-			return
+			return providerError(err)
 		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Println("Failed to create session:", err)
+		return api.CreateSessionResponse{}, err
+	}
+
+	s.logger.Printf("Creating payment session finished: %+v\n", res)
+	return res, nil
+}
 
-		ch <- res
-	}()
+// withTimeout runs the given function with a context bound to the service timeout. Calls made by the function are
+// aborted once the timeout has passed, and the errors returned from then on are reported as timeouts.
+func (s *service) withTimeout(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
 
-	select {
-	case <-ctx.Done(): // Circuit breaker
+	err := fn(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
 		s.logger.Println("Context error:", ctx.Err())
-		return api.CreateSessionResponse{}, ctx.Err()
-	case err := <-errs: // Error handler
-		s.logger.Println("Failed to create session:", err)
-		return api.CreateSessionResponse{}, err
-	case res := <-ch: // Post-processing
-		s.logger.Printf("Creating payment session finished: %+v\n", res)
-		return res, nil
+		return api.WrapError(api.ErrorCodeTimeout, err)
 	}
+	return err
+}
+
+// checkCharged returns api.ErrAlreadyCharged if the ledger already holds a grant of the payment of the given charge,
+// unless the charge is forced. The payment is marked as charged again in that case, marking it may have failed when it
+// was charged. The metadata of the payment is not enough: it's set after granting the credits and it can fail.
+func (s *service) checkCharged(ctx context.Context, req api.ChargeRequest) error {
+	if s.ledger == nil || len(req.Payment) == 0 || req.Force {
+		return nil
+	}
+	grants, err := s.ledger.FindByPayment(ctx, req.Payment)
+	if err != nil {
+		return api.WrapError(api.ErrorCodeInternal, err)
+	}
+	if len(grants) == 0 {
+		return nil
+	}
+
+	s.logger.Println("Payment already charged by event:", req.Payment, grants[0].Event)
+	req.Event = grants[0].Event
+	s.markCharged(ctx, req)
+	return api.ErrAlreadyCharged
+}
+
+// markCharged marks the payment of the given charge as charged in the payment system, retrying temporary errors.
+// Errors are only logged: credits have already been granted, and the grant recorded in the ledger keeps the payment
+// from being charged again.
+func (s *service) markCharged(ctx context.Context, req api.ChargeRequest) {
+	for attempt := 1; ; attempt++ {
+		err := s.adapter.MarkCharged(ctx, req)
+		if err == nil {
+			return
+		}
+		if !adapter.Retryable(err) || attempt == markAttempts {
+			s.logger.Println("Failed to mark payment as charged:", req.Payment, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			s.logger.Println("Failed to mark payment as charged:", req.Payment, ctx.Err())
+			return
+		case <-time.After(time.Duration(attempt) * markBackoff):
+		}
+	}
+}
+
+// recordGrant records the credits granted for the given charge in the ledger. Credits have already been granted when
+// this method is called, errors are only logged so the charge is not processed again.
+func (s *service) recordGrant(ctx context.Context, req api.ChargeRequest, cus customers.CustomerResponse) {
+	if s.ledger == nil {
+		return
+	}
+	err := s.ledger.Record(ctx, ledger.Grant{
+		Payment:     req.Payment,
+		Event:       req.Event,
+		Service:     string(req.Service),
+		Customer:    req.Customer,
+		Handle:      cus.Handle,
+		Application: req.Application,
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+	})
+	if err != nil {
+		s.logger.Println("Failed to record grant:", req.Payment, err)
+	}
+}
+
+// providerError wraps the given error returned by the payment service adapter with the matching API error code.
+// Requests rejected because of their idempotency key are conflicts: they can be retried once the request using the key
+// has finished. Rejected credentials are a configuration error, callers can't fix them by retrying.
+func providerError(err error) error {
+	switch {
+	case errors.Is(err, adapter.ErrIdempotencyConflict):
+		return api.WrapProviderError(api.ErrorCodeConflict, err)
+	case errors.Is(err, adapter.ErrRateLimited):
+		return api.WrapProviderError(api.ErrorCodeRateLimited, err)
+	case errors.Is(err, adapter.ErrAuthentication):
+		return api.WrapProviderError(api.ErrorCodeInternal, err)
+	default:
+		return api.WrapProviderError(api.ErrorCodeProvider, err)
+	}
+}
+
+// getOrCreateCustomer returns the customer of the given request, and creates it if it doesn't exist. Requests for the
+// same handle are serialized, so concurrent requests don't create the same customer twice.
+func (s *service) getOrCreateCustomer(ctx context.Context, req api.CreateSessionRequest) (customers.CustomerResponse, error) {
+	unlock, err := s.handles.Lock(ctx, req.Application, req.Handle)
+	if err != nil {
+		return customers.CustomerResponse{}, err
+	}
+	defer unlock()
+
+	customerResponse, err := s.getCustomer(ctx, req)
+	if err != nil && !ign.IsError(err, customers.ErrCustomerNotFound) {
+		return customers.CustomerResponse{}, api.WrapError(api.ErrorCodeUpstream, err)
+	}
+	if err != nil {
+		s.logger.Println("Customer not found, creating new one:", req.Handle)
+		return s.createCustomer(ctx, req)
+	}
+	return customerResponse, nil
+}
+
+// getCustomer returns the customer of the given request from the customers service.
+func (s *service) getCustomer(ctx context.Context, req api.CreateSessionRequest) (customers.CustomerResponse, error) {
+	return s.customers.GetCustomerByHandle(ctx, customers.GetCustomerByHandleRequest{
+		Handle:      req.Handle,
+		Service:     string(api.PaymentServiceStripe),
+		Application: req.Application,
+	})
 }
 
 // createCustomer groups the operations needed to create a customer in a certain payment system and in the customer service.
+// Customers created at the same time by another replica are returned instead, and the payment system customer that
+// couldn't be recorded is deleted.
 func (s *service) createCustomer(ctx context.Context, req api.CreateSessionRequest) (customers.CustomerResponse, error) {
-	id, err := s.adapter.CreateCustomer(req.Application, req.Handle)
+	id, err := s.createPaymentCustomer(ctx, req)
 	if err != nil {
-		return customers.CustomerResponse{}, err
+		return customers.CustomerResponse{}, providerError(err)
 	}
 
 	customerResponse, err := s.customers.CreateCustomer(ctx, customers.CreateCustomerRequest{
@@ -162,11 +312,47 @@ func (s *service) createCustomer(ctx context.Context, req api.CreateSessionReque
 		Application: req.Application,
 	})
 	if err != nil {
-		return customers.CustomerResponse{}, err
+		// The customer may have been recorded by a concurrent request handled by another replica.
+		existing, getErr := s.getCustomer(ctx, req)
+		if getErr != nil {
+			s.deleteOrphanCustomer(ctx, id)
+			return customers.CustomerResponse{}, api.WrapError(api.ErrorCodeUpstream, err)
+		}
+		s.logger.Println("Customer created concurrently, using existing one:", req.Handle, existing.ID)
+		if existing.ID != id {
+			s.deleteOrphanCustomer(ctx, id)
+		}
+		return existing, nil
 	}
 	return customerResponse, nil
 }
 
+// createPaymentCustomer creates the customer of the given request in the payment system. Requests that fail with a
+// temporary error are retried: customers are created with idempotent requests, so retries never create duplicates.
+// Requests rejected because another replica is creating the same customer get the customer created by it.
+func (s *service) createPaymentCustomer(ctx context.Context, req api.CreateSessionRequest) (string, error) {
+	for attempt := 1; ; attempt++ {
+		id, err := s.adapter.CreateCustomer(ctx, req.Application, req.Handle)
+		if err == nil || !adapter.Retryable(err) || attempt == customerAttempts {
+			return id, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Duration(attempt) * customerBackoff):
+		}
+	}
+}
+
+// deleteOrphanCustomer deletes the given payment system customer, which is not recorded in the customers service.
+// Errors are only logged: orphan customers are never used, so they don't need to be retried.
+func (s *service) deleteOrphanCustomer(ctx context.Context, id string) {
+	s.logger.Println("Deleting orphan customer:", id)
+	if err := s.adapter.DeleteCustomer(ctx, id); err != nil {
+		s.logger.Println("Failed to delete orphan customer:", id, err)
+	}
+}
+
 // ListInvoices returns a list of invoices of the given user.
 func (s *service) ListInvoices(ctx context.Context, req api.ListInvoicesRequest) (api.ListInvoicesResponse, error) {
 	panic("implement me")
@@ -176,6 +362,8 @@ func (s *service) ListInvoices(ctx context.Context, req api.ListInvoicesRequest)
 type Service interface {
 	api.ChargerV1
 	api.PaymentsV1
+	api.AccountsV1
+	api.ConnectV1
 }
 
 // Options contains a set of components needed to configure the payment service.
@@ -189,11 +377,33 @@ type Options struct {
 	// Logger contains a logger mechanism. If set to nil, it defaults to a logger pointing to io.Discard.
 	Logger *log.Logger
 
-	// Timeout contains a circuit breaking timeout used to prevent long process runs.
+	// Timeout bounds the duration of each operation to prevent long process runs. Dependencies that keep failing should
+	// be wrapped with the clients of the breaker package.
 	Timeout time.Duration
 
 	// Adapter contains a payment adapter implementation such as Adapter.
 	Adapter adapter.Client
+
+	// Ledger is used to record the credits granted to customers. Grants are not recorded if set to nil.
+	Ledger ledger.Store
+
+	// Connector contains a connected accounts implementation such as Stripe Connect. Connected accounts are disabled
+	// if set to nil.
+	Connector adapter.Connector
+
+	// Accounts is used to record the connected accounts of applications. Connected accounts are disabled if set to nil.
+	Accounts connect.Store
+
+	// ApplicationFees maps applications to the percentage of their payments kept by the platform when paying their
+	// connected account. Only the listed applications can have a connected account.
+	ApplicationFees map[string]float64
+
+	// Catalog is used to look up the synced price of a credit when creating sessions. Sessions use an inline price if
+	// set to nil.
+	Catalog adapter.Catalog
+
+	// Currency is the ISO 4217 currency, in lowercase format, sessions are paid in. It defaults to usd if empty.
+	Currency string
 }
 
 // NewPaymentsService initializes a new Service implementation using Adapter.
@@ -201,11 +411,21 @@ func NewPaymentsService(opts Options) Service {
 	if opts.Logger == nil {
 		opts.Logger = log.New(io.Discard, "", log.LstdFlags)
 	}
+	if len(opts.Currency) == 0 {
+		opts.Currency = "usd"
+	}
 	return &service{
 		logger:    opts.Logger,
 		credits:   opts.Credits,
 		customers: opts.Customers,
+		handles:   newHandleLocks(),
 		timeout:   opts.Timeout,
 		adapter:   opts.Adapter,
+		ledger:    opts.Ledger,
+		connector: opts.Connector,
+		accounts:  opts.Accounts,
+		fees:      opts.ApplicationFees,
+		catalog:   opts.Catalog,
+		currency:  opts.Currency,
 	}
 }
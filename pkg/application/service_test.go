@@ -3,7 +3,9 @@ package application
 import (
 	"context"
 	"errors"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
 	fakecredits "gitlab.com/ignitionrobotics/billing/credits/pkg/fake"
@@ -13,6 +15,9 @@ import (
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ledger"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/stripetest"
+	"sync"
 	"testing"
 	"time"
 )
@@ -293,7 +298,7 @@ func (s *serviceTestSuite) TestCreateSessionFailsWhenCreatingCustomer() {
 	}, error(nil))
 
 	// If stripe returns an error, the create session call should fail.
-	f.On("CreateCustomer", "test", "test").Return("", errors.New("stripe fake service failed"))
+	f.On("CreateCustomer", mock.Anything, "test", "test").Return("", errors.New("stripe fake service failed"))
 
 	_, err := s.Service.CreateSession(context.Background(), api.CreateSessionRequest{
 		Service:     api.PaymentServiceStripe,
@@ -338,6 +343,7 @@ func (s *serviceTestSuite) TestCreateSessionFailsWhenCreatingSession() {
 		Handle:      "test",
 		Application: "test",
 		UnitPrice:   2,
+		Currency:    "usd",
 	}
 
 	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
@@ -346,7 +352,7 @@ func (s *serviceTestSuite) TestCreateSessionFailsWhenCreatingSession() {
 	}, error(nil))
 
 	// If stripe returns an error, the create session call should fail.
-	f.On("CreateSession", req, cus).Return(api.CreateSessionResponse{}, errors.New("stripe fake service failed"))
+	f.On("CreateSession", mock.Anything, req, cus).Return(api.CreateSessionResponse{}, errors.New("stripe fake service failed"))
 
 	_, err := s.Service.CreateSession(context.Background(), api.CreateSessionRequest{
 		Service:     api.PaymentServiceStripe,
@@ -357,3 +363,540 @@ func (s *serviceTestSuite) TestCreateSessionFailsWhenCreatingSession() {
 	})
 	s.Assert().Error(err)
 }
+
+func (s *serviceTestSuite) TestCreateSessionIdempotencyConflict() {
+	var f fake.Adapter
+
+	// Load new payment service with fake adapter
+	s.Service = NewPaymentsService(Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   &f,
+		Timeout:   200 * time.Millisecond,
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+
+	cus := customers.CustomerResponse{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+		ID:          "cus_HdRJTeoStCxpP4E",
+	}
+
+	s.Customers.On("GetCustomerByHandle", ctx, customers.GetCustomerByHandleRequest{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+	}).Return(cus, error(nil))
+
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+
+	// Stripe rejects the idempotency key while the first attempt is being processed.
+	f.On("CreateSession", mock.Anything, mock.AnythingOfType("api.CreateSessionRequest"), cus).Return(api.CreateSessionResponse{},
+		&adapter.IdempotencyError{Key: "session-test", Err: errors.New("idempotency_key_in_use")})
+
+	_, err := s.Service.CreateSession(context.Background(), api.CreateSessionRequest{
+		Service:        api.PaymentServiceStripe,
+		SuccessURL:     "https://localhost",
+		CancelURL:      "https://localhost",
+		Handle:         "test",
+		Application:    "test",
+		IdempotencyKey: "test",
+	})
+	s.Require().Error(err)
+	s.Assert().True(errors.Is(err, api.ErrConflict))
+	s.Assert().True(errors.Is(err, adapter.ErrIdempotencyConflict))
+}
+
+func (s *serviceTestSuite) TestCreateSessionTimeout() {
+	var f fake.Adapter
+
+	// Load new payment service with fake adapter
+	s.Service = NewPaymentsService(Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   &f,
+		Timeout:   200 * time.Millisecond,
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+
+	cus := customers.CustomerResponse{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+		ID:          "cus_HdRJTeoStCxpP4E",
+	}
+
+	s.Customers.On("GetCustomerByHandle", ctx, customers.GetCustomerByHandleRequest{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+	}).Return(cus, error(nil))
+
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+
+	// The request sent to Stripe is aborted once the service timeout has passed.
+	var aborted error
+	f.On("CreateSession", ctx, mock.AnythingOfType("api.CreateSessionRequest"), cus).Return(api.CreateSessionResponse{},
+		context.DeadlineExceeded).Run(func(args mock.Arguments) {
+		requestCtx := args.Get(0).(context.Context)
+		<-requestCtx.Done()
+		aborted = requestCtx.Err()
+	})
+
+	_, err := s.Service.CreateSession(context.Background(), api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  "https://localhost",
+		CancelURL:   "https://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().Error(err)
+	s.Assert().Equal(api.ErrorCodeTimeout, api.ErrorCodeOf(err))
+	s.Assert().ErrorIs(aborted, context.DeadlineExceeded)
+}
+
+func (s *serviceTestSuite) TestCreateSessionRecoversCustomerConflict() {
+	var f fake.Adapter
+
+	// Load new payment service with fake adapter
+	s.Service = NewPaymentsService(Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   &f,
+		Timeout:   200 * time.Millisecond,
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	byHandle := customers.GetCustomerByHandleRequest{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+	}
+	existing := customers.CustomerResponse{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+		ID:          "cus_existing",
+	}
+
+	// Another replica records its customer between the lookup and the creation.
+	s.Customers.On("GetCustomerByHandle", ctx, byHandle).Return(customers.CustomerResponse{}, customers.ErrCustomerNotFound).Once()
+	s.Customers.On("GetCustomerByHandle", ctx, byHandle).Return(existing, error(nil)).Once()
+	s.Customers.On("CreateCustomer", ctx, mock.AnythingOfType("api.CreateCustomerRequest")).Return(customers.CustomerResponse{},
+		errors.New("duplicate key value violates unique constraint"))
+
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+
+	f.On("CreateCustomer", mock.Anything, "test", "test").Return("cus_orphan", error(nil))
+	f.On("DeleteCustomer", mock.Anything, "cus_orphan").Return(error(nil))
+	f.On("CreateSession", mock.Anything, mock.AnythingOfType("api.CreateSessionRequest"), existing).Return(api.CreateSessionResponse{
+		Service: api.PaymentServiceStripe,
+		Session: "cs_test",
+	}, error(nil))
+
+	res, err := s.Service.CreateSession(context.Background(), api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  "https://localhost",
+		CancelURL:   "https://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().NoError(err)
+	s.Assert().Equal("cs_test", res.Session)
+	f.AssertCalled(s.T(), "DeleteCustomer", mock.Anything, "cus_orphan")
+}
+
+func (s *serviceTestSuite) TestCreateSessionKeepsSharedCustomer() {
+	var f fake.Adapter
+
+	// Load new payment service with fake adapter
+	s.Service = NewPaymentsService(Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   &f,
+		Timeout:   200 * time.Millisecond,
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	byHandle := customers.GetCustomerByHandleRequest{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+	}
+	existing := customers.CustomerResponse{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+		ID:          "cus_shared",
+	}
+
+	// Both replicas got the same payment service customer, it must not be deleted.
+	s.Customers.On("GetCustomerByHandle", ctx, byHandle).Return(customers.CustomerResponse{}, customers.ErrCustomerNotFound).Once()
+	s.Customers.On("GetCustomerByHandle", ctx, byHandle).Return(existing, error(nil)).Once()
+	s.Customers.On("CreateCustomer", ctx, mock.AnythingOfType("api.CreateCustomerRequest")).Return(customers.CustomerResponse{},
+		errors.New("duplicate key value violates unique constraint"))
+
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+
+	f.On("CreateCustomer", mock.Anything, "test", "test").Return("cus_shared", error(nil))
+	f.On("CreateSession", mock.Anything, mock.AnythingOfType("api.CreateSessionRequest"), existing).Return(api.CreateSessionResponse{
+		Service: api.PaymentServiceStripe,
+		Session: "cs_test",
+	}, error(nil))
+
+	_, err := s.Service.CreateSession(context.Background(), api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  "https://localhost",
+		CancelURL:   "https://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().NoError(err)
+	f.AssertNotCalled(s.T(), "DeleteCustomer", mock.Anything, mock.Anything)
+}
+
+func (s *serviceTestSuite) TestCreateSessionCustomerConflictNotRecovered() {
+	var f fake.Adapter
+
+	// Load new payment service with fake adapter
+	s.Service = NewPaymentsService(Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   &f,
+		Timeout:   200 * time.Millisecond,
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Customers.On("GetCustomerByHandle", ctx, mock.AnythingOfType("api.GetCustomerByHandleRequest")).Return(
+		customers.CustomerResponse{}, customers.ErrCustomerNotFound)
+	s.Customers.On("CreateCustomer", ctx, mock.AnythingOfType("api.CreateCustomerRequest")).Return(customers.CustomerResponse{},
+		errors.New("customers service failed"))
+
+	s.Credits.On("GetUnitPrice", ctx, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+
+	// The customer can't be recorded, so it's deleted. Retries create a new one.
+	f.On("CreateCustomer", mock.Anything, "test", "test").Return("cus_test", error(nil))
+	f.On("DeleteCustomer", mock.Anything, "cus_test").Return(error(nil))
+
+	_, err := s.Service.CreateSession(context.Background(), api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  "https://localhost",
+		CancelURL:   "https://localhost",
+		Handle:      "test",
+		Application: "test",
+	})
+	s.Require().Error(err)
+	s.Assert().True(errors.Is(err, api.ErrUpstream))
+	f.AssertCalled(s.T(), "DeleteCustomer", mock.Anything, "cus_test")
+}
+
+func (s *serviceTestSuite) TestChargeMarksPaymentAsCharged() {
+	var f fake.Adapter
+
+	// Load new payment service with fake adapter
+	s.Service = NewPaymentsService(Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   &f,
+		Timeout:   200 * time.Millisecond,
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+
+	s.Customers.On("GetCustomerByID", ctx, customers.GetCustomerByIDRequest{
+		ID:          "cus_HdRJTeoStCxpP4E",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+	}).Return(customers.CustomerResponse{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+		ID:          "cus_HdRJTeoStCxpP4E",
+	}, error(nil))
+
+	s.Credits.On("IncreaseCredits", ctx, credits.IncreaseCreditsRequest{
+		Transaction: credits.Transaction{
+			Handle:      "test",
+			Amount:      100,
+			Currency:    "usd",
+			Application: "test",
+		},
+	}).Return(credits.IncreaseCreditsResponse{}, error(nil))
+
+	req := api.ChargeRequest{
+		Amount:      100,
+		Currency:    "usd",
+		Customer:    "cus_HdRJTeoStCxpP4E",
+		Service:     api.PaymentServiceStripe,
+		Application: "test",
+		Event:       "evt_1CiPtv2eZvKYlo2CcUZsDcO6",
+		Payment:     "pi_5DpcTV1eZvKYlo3Cy7cIe9am",
+	}
+
+	// Credits have already been granted, failing to mark the payment should not fail the charge.
+	f.On("MarkCharged", mock.Anything, req).Return(errors.New("stripe fake service failed"))
+
+	_, err := s.Service.Charge(context.Background(), req)
+	s.Assert().NoError(err)
+	f.AssertCalled(s.T(), "MarkCharged", mock.Anything, req)
+}
+
+func (s *serviceTestSuite) TestChargeRecordsGrant() {
+	var f fake.Adapter
+	store := ledger.NewMemoryStore()
+
+	// Load new payment service with fake adapter and an in-memory ledger
+	s.Service = NewPaymentsService(Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   &f,
+		Timeout:   200 * time.Millisecond,
+		Ledger:    store,
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+
+	s.Customers.On("GetCustomerByID", ctx, mock.Anything).Return(customers.CustomerResponse{
+		Handle:      "test",
+		Service:     string(api.PaymentServiceStripe),
+		Application: "test",
+		ID:          "cus_HdRJTeoStCxpP4E",
+	}, error(nil))
+
+	s.Credits.On("IncreaseCredits", ctx, mock.Anything).Return(credits.IncreaseCreditsResponse{}, error(nil))
+
+	req := api.ChargeRequest{
+		Amount:      100,
+		Currency:    "usd",
+		Customer:    "cus_HdRJTeoStCxpP4E",
+		Service:     api.PaymentServiceStripe,
+		Application: "test",
+		Event:       "evt_1CiPtv2eZvKYlo2CcUZsDcO6",
+		Payment:     "pi_5DpcTV1eZvKYlo3Cy7cIe9am",
+	}
+	f.On("MarkCharged", mock.Anything, req).Return(error(nil))
+
+	_, err := s.Service.Charge(context.Background(), req)
+	s.Require().NoError(err)
+
+	grants, err := store.FindByPayment(context.Background(), req.Payment)
+	s.Require().NoError(err)
+	s.Require().Len(grants, 1)
+	s.Assert().Equal("test", grants[0].Handle)
+	s.Assert().Equal(req.Event, grants[0].Event)
+	s.Assert().Equal(uint(100), grants[0].Amount)
+}
+
+func (s *serviceTestSuite) TestChargeRetriesMarkCharged() {
+	var f fake.Adapter
+
+	s.Service = NewPaymentsService(Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   &f,
+		Timeout:   time.Second,
+	})
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Customers.On("GetCustomerByID", ctx, mock.Anything).Return(customers.CustomerResponse{Handle: "test"}, error(nil))
+	s.Credits.On("IncreaseCredits", ctx, mock.Anything).Return(credits.IncreaseCreditsResponse{}, error(nil))
+
+	req := api.ChargeRequest{
+		Amount:      100,
+		Currency:    "usd",
+		Customer:    "cus_HdRJTeoStCxpP4E",
+		Service:     api.PaymentServiceStripe,
+		Application: "test",
+		Event:       "evt_1CiPtv2eZvKYlo2CcUZsDcO6",
+		Payment:     "pi_5DpcTV1eZvKYlo3Cy7cIe9am",
+	}
+	f.On("MarkCharged", mock.Anything, req).Return(adapter.ErrRateLimited).Once()
+	f.On("MarkCharged", mock.Anything, req).Return(error(nil)).Once()
+
+	_, err := s.Service.Charge(context.Background(), req)
+	s.Require().NoError(err)
+	f.AssertNumberOfCalls(s.T(), "MarkCharged", 2)
+}
+
+func (s *serviceTestSuite) TestChargeAlreadyCharged() {
+	var f fake.Adapter
+	store := ledger.NewMemoryStore()
+
+	s.Service = NewPaymentsService(Options{
+		Credits:   s.Credits,
+		Customers: s.Customers,
+		Adapter:   &f,
+		Timeout:   200 * time.Millisecond,
+		Ledger:    store,
+	})
+
+	// The payment was charged by another event, but marking it failed.
+	s.Require().NoError(store.Record(context.Background(), ledger.Grant{
+		Payment: "pi_5DpcTV1eZvKYlo3Cy7cIe9am",
+		Event:   "evt_first",
+		Amount:  100,
+	}))
+
+	ctx := mock.AnythingOfType("*context.timerCtx")
+	s.Customers.On("GetCustomerByID", ctx, mock.Anything).Return(customers.CustomerResponse{Handle: "test"}, error(nil))
+	s.Credits.On("IncreaseCredits", ctx, mock.Anything).Return(credits.IncreaseCreditsResponse{}, error(nil))
+	f.On("MarkCharged", mock.Anything, mock.Anything).Return(error(nil))
+
+	req := api.ChargeRequest{
+		Amount:      100,
+		Currency:    "usd",
+		Customer:    "cus_HdRJTeoStCxpP4E",
+		Service:     api.PaymentServiceStripe,
+		Application: "test",
+		Event:       "evt_1CiPtv2eZvKYlo2CcUZsDcO6",
+		Payment:     "pi_5DpcTV1eZvKYlo3Cy7cIe9am",
+	}
+
+	_, err := s.Service.Charge(context.Background(), req)
+	s.Require().ErrorIs(err, api.ErrAlreadyCharged)
+	s.Credits.AssertNotCalled(s.T(), "IncreaseCredits", mock.Anything, mock.Anything)
+	marked := req
+	marked.Event = "evt_first"
+	f.AssertCalled(s.T(), "MarkCharged", mock.Anything, marked)
+
+	// Forced charges are processed anyway.
+	req.Force = true
+	_, err = s.Service.Charge(context.Background(), req)
+	s.Require().NoError(err)
+	s.Credits.AssertNumberOfCalls(s.T(), "IncreaseCredits", 1)
+
+	grants, err := store.FindByPayment(context.Background(), req.Payment)
+	s.Require().NoError(err)
+	s.Assert().Len(grants, 2)
+}
+
+func TestProviderError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code api.ErrorCode
+	}{
+		{&adapter.IdempotencyError{Key: "key", Err: errors.New("idempotency_error")}, api.ErrorCodeConflict},
+		{&adapter.ProviderError{Kind: adapter.ErrRateLimited, Err: errors.New("rate_limit")}, api.ErrorCodeRateLimited},
+		{&adapter.ProviderError{Kind: adapter.ErrAuthentication, Err: errors.New("invalid api key")}, api.ErrorCodeInternal},
+		{&adapter.ProviderError{Kind: adapter.ErrProviderUnavailable, Err: errors.New("api_error")}, api.ErrorCodeProvider},
+		{&adapter.ProviderError{Kind: adapter.ErrCardDeclined, Err: errors.New("card_declined")}, api.ErrorCodeProvider},
+		{errors.New("stripe failed"), api.ErrorCodeProvider},
+	}
+	for _, c := range cases {
+		err := providerError(c.err)
+		assert.Equal(t, c.code, api.ErrorCodeOf(err), c.err.Error())
+		assert.ErrorIs(t, err, c.err)
+		assert.Equal(t, c.code.Err().Error(), api.Message(err), c.err.Error())
+	}
+}
+
+// memoryCustomers is a customers.CustomersV1 implementation that keeps customers in memory. Like the customers
+// service, it fails to create a customer for a handle that already has one.
+type memoryCustomers struct {
+	lock      sync.Mutex
+	customers map[string]customers.CustomerResponse
+}
+
+func (m *memoryCustomers) GetCustomerByHandle(ctx context.Context, req customers.GetCustomerByHandleRequest) (customers.CustomerResponse, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	c, ok := m.customers[req.Application+"/"+req.Handle]
+	if !ok {
+		return customers.CustomerResponse{}, customers.ErrCustomerNotFound
+	}
+	return c, nil
+}
+
+func (m *memoryCustomers) GetCustomerByID(ctx context.Context, req customers.GetCustomerByIDRequest) (customers.CustomerResponse, error) {
+	return customers.CustomerResponse{}, customers.ErrCustomerNotFound
+}
+
+func (m *memoryCustomers) CreateCustomer(ctx context.Context, req customers.CreateCustomerRequest) (customers.CustomerResponse, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := req.Application + "/" + req.Handle
+	if _, ok := m.customers[key]; ok {
+		return customers.CustomerResponse{}, errors.New("duplicate key value violates unique constraint")
+	}
+	c := customers.CustomerResponse{
+		Handle:      req.Handle,
+		ID:          req.ID,
+		Service:     req.Service,
+		Application: req.Application,
+	}
+	m.customers[key] = c
+	return c, nil
+}
+
+func TestCreateSessionConcurrentCustomerCreation(t *testing.T) {
+	server := stripetest.NewServer(stripetest.Options{})
+	defer server.Close()
+
+	creditsClient := fakecredits.NewClient()
+	creditsClient.On("GetUnitPrice", mock.Anything, mock.Anything).Return(credits.GetUnitPriceResponse{
+		Amount:   2,
+		Currency: "usd",
+	}, error(nil))
+	store := &memoryCustomers{customers: make(map[string]customers.CustomerResponse)}
+
+	// Two replicas sharing the same customers service and Stripe account.
+	replicas := make([]Service, 2)
+	for i := range replicas {
+		replicas[i] = NewPaymentsService(Options{
+			Credits:   creditsClient,
+			Customers: store,
+			Adapter:   adapter.NewStripeAdapter(server.Config()),
+			Timeout:   5 * time.Second,
+		})
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(svc Service) {
+			defer wg.Done()
+			_, err := svc.CreateSession(context.Background(), api.CreateSessionRequest{
+				Service:     api.PaymentServiceStripe,
+				SuccessURL:  "https://localhost",
+				CancelURL:   "https://localhost",
+				Handle:      "test",
+				Application: "test",
+			})
+			errs <- err
+		}(replicas[i%len(replicas)])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// A single Stripe customer exists, and it's the recorded one.
+	cus, err := store.GetCustomerByHandle(context.Background(), customers.GetCustomerByHandleRequest{
+		Handle:      "test",
+		Application: "test",
+	})
+	require.NoError(t, err)
+	created := server.Customers()
+	require.Len(t, created, 1)
+	assert.Equal(t, cus.ID, created[0].ID)
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrMissingCredentials is returned when a request doesn't contain credentials supported by an Authenticator.
+	ErrMissingCredentials = errors.New("missing credentials")
+
+	// ErrInvalidCredentials is returned when the credentials included in a request are not valid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrForbiddenApplication is returned when an authenticated caller is not allowed to act for a certain application.
+	ErrForbiddenApplication = errors.New("caller not allowed to act for the given application")
+)
+
+// AnyApplication is used in Identity.Applications to allow a caller to act for every application.
+const AnyApplication = "*"
+
+// Identity represents an authenticated caller of the payments service.
+type Identity struct {
+	// Subject identifies the service that is calling the payments service.
+	Subject string
+
+	// Applications contains the list of applications the caller is allowed to act for.
+	Applications []string
+}
+
+// CanActFor returns true if the current identity is allowed to act for the given application.
+func (id Identity) CanActFor(application string) bool {
+	for _, app := range id.Applications {
+		if app == AnyApplication || app == application {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies the credentials included in incoming HTTP requests.
+type Authenticator interface {
+	// Authenticate verifies the credentials included in the given request and returns the identity of the caller.
+	// The request body is passed separately as it may have already been consumed.
+	// It returns ErrMissingCredentials if the request doesn't include the credentials handled by this Authenticator.
+	Authenticate(r *http.Request, body []byte) (Identity, error)
+}
+
+// Signer attaches credentials to outgoing HTTP requests.
+type Signer interface {
+	// Sign adds credentials to the given request. The request body is passed separately as it may be needed to
+	// compute a signature.
+	Sign(r *http.Request, body []byte) error
+}
+
+// chain is an Authenticator implementation that tries a list of authenticators in order.
+type chain []Authenticator
+
+// Authenticate tries every authenticator until one of them finds credentials in the given request.
+func (c chain) Authenticate(r *http.Request, body []byte) (Identity, error) {
+	for _, a := range c {
+		id, err := a.Authenticate(r, body)
+		if errors.Is(err, ErrMissingCredentials) {
+			continue
+		}
+		return id, err
+	}
+	return Identity{}, ErrMissingCredentials
+}
+
+// NewChain initializes a new Authenticator that accepts any of the credentials supported by the given authenticators.
+func NewChain(authenticators ...Authenticator) Authenticator {
+	return chain(authenticators)
+}
+
+// identityKey is the context key used to store an Identity.
+type identityKey struct{}
+
+// WithIdentity returns a copy of the given context containing the given identity.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the Identity stored in the given context, if any.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
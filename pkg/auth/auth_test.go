@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"bytes"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newRequest(t *testing.T, body []byte) *http.Request {
+	r, err := http.NewRequest(http.MethodPost, "http://localhost/payments/session", bytes.NewReader(body))
+	require.NoError(t, err)
+	return r
+}
+
+func TestIdentityCanActFor(t *testing.T) {
+	id := Identity{Subject: "fuel", Applications: []string{"fuel"}}
+	assert.True(t, id.CanActFor("fuel"))
+	assert.False(t, id.CanActFor("cloudsim"))
+
+	admin := Identity{Subject: "admin", Applications: []string{AnyApplication}}
+	assert.True(t, admin.CanActFor("cloudsim"))
+}
+
+func TestHMAC(t *testing.T) {
+	body := []byte(`{"handle":"test"}`)
+	a := NewHMACAuthenticator(map[string]string{"fuel": "secret"}, time.Minute)
+
+	r := newRequest(t, body)
+	_, err := a.Authenticate(r, body)
+	assert.ErrorIs(t, err, ErrMissingCredentials)
+
+	require.NoError(t, NewHMACSigner("fuel", "secret").Sign(r, body))
+	id, err := a.Authenticate(r, body)
+	require.NoError(t, err)
+	assert.Equal(t, "fuel", id.Subject)
+	assert.True(t, id.CanActFor("fuel"))
+
+	// Tampered body
+	_, err = a.Authenticate(r, []byte(`{"handle":"other"}`))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Wrong secret
+	r = newRequest(t, body)
+	require.NoError(t, NewHMACSigner("fuel", "wrong").Sign(r, body))
+	_, err = a.Authenticate(r, body)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Expired signature
+	r = newRequest(t, body)
+	require.NoError(t, NewHMACSigner("fuel", "secret").Sign(r, body))
+	a.(*hmacAuthenticator).now = func() time.Time { return time.Now().Add(time.Hour) }
+	_, err = a.Authenticate(r, body)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestAPIKey(t *testing.T) {
+	a := NewAPIKeyAuthenticator(map[string]string{"key1": "fuel"})
+
+	r := newRequest(t, nil)
+	_, err := a.Authenticate(r, nil)
+	assert.ErrorIs(t, err, ErrMissingCredentials)
+
+	require.NoError(t, NewAPIKeySigner("key1").Sign(r, nil))
+	id, err := a.Authenticate(r, nil)
+	require.NoError(t, err)
+	assert.True(t, id.CanActFor("fuel"))
+
+	require.NoError(t, NewAPIKeySigner("key2").Sign(r, nil))
+	_, err = a.Authenticate(r, nil)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("secret")
+	a := NewJWTAuthenticator(secret, "ignition", "payments", 24*time.Hour)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "cloudsim-backend",
+			Issuer:    "ignition",
+			Audience:  "payments",
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+		Applications: []string{"cloudsim"},
+	}).SignedString(secret)
+	require.NoError(t, err)
+
+	r := newRequest(t, nil)
+	require.NoError(t, NewBearerSigner(token).Sign(r, nil))
+	id, err := a.Authenticate(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "cloudsim-backend", id.Subject)
+	assert.True(t, id.CanActFor("cloudsim"))
+	assert.False(t, id.CanActFor("fuel"))
+
+	invalid := []jwt.StandardClaims{
+		// Invalid audience
+		{Issuer: "ignition", Audience: "credits", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		// Missing expiration time
+		{Issuer: "ignition", Audience: "payments"},
+		// Expired
+		{Issuer: "ignition", Audience: "payments", ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+		// Valid for longer than the maximum lifetime
+		{Issuer: "ignition", Audience: "payments", ExpiresAt: time.Now().Add(48 * time.Hour).Unix()},
+	}
+	for _, claims := range invalid {
+		token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{StandardClaims: claims}).SignedString(secret)
+		require.NoError(t, err)
+		require.NoError(t, NewBearerSigner(token).Sign(r, nil))
+		_, err = a.Authenticate(r, nil)
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+}
+
+func TestChain(t *testing.T) {
+	a := NewChain(
+		NewAPIKeyAuthenticator(map[string]string{"key1": "fuel"}),
+		NewHMACAuthenticator(map[string]string{"cloudsim": "secret"}, time.Minute),
+	)
+
+	r := newRequest(t, nil)
+	_, err := a.Authenticate(r, nil)
+	assert.ErrorIs(t, err, ErrMissingCredentials)
+
+	require.NoError(t, NewHMACSigner("cloudsim", "secret").Sign(r, nil))
+	id, err := a.Authenticate(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "cloudsim", id.Subject)
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// HeaderKeyID is the HTTP header containing the application that signed a request.
+	HeaderKeyID = "X-Payments-Key-Id"
+
+	// HeaderTimestamp is the HTTP header containing the unix timestamp of when a request was signed.
+	HeaderTimestamp = "X-Payments-Timestamp"
+
+	// HeaderSignature is the HTTP header containing the hex encoded HMAC-SHA256 signature of a request.
+	HeaderSignature = "X-Payments-Signature"
+)
+
+// ComputeSignature computes the HMAC-SHA256 signature of a request using the given secret.
+// The signed payload is made of the timestamp, the HTTP method, the request path and the body.
+func ComputeSignature(secret string, t time.Time, method, path string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.%s.%s.", t.Unix(), method, path)))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// hmacAuthenticator is an Authenticator implementation that verifies requests signed with a shared secret.
+type hmacAuthenticator struct {
+	// secrets contains the shared secret of each application.
+	secrets map[string]string
+
+	// tolerance is the maximum amount of time allowed between the signature timestamp and the current time.
+	tolerance time.Duration
+
+	// now returns the current time.
+	now func() time.Time
+}
+
+// Authenticate verifies that the given request has been signed with the secret of the application identified by
+// the HeaderKeyID header.
+func (a *hmacAuthenticator) Authenticate(r *http.Request, body []byte) (Identity, error) {
+	keyID := r.Header.Get(HeaderKeyID)
+	sig := r.Header.Get(HeaderSignature)
+	if len(keyID) == 0 || len(sig) == 0 {
+		return Identity{}, ErrMissingCredentials
+	}
+
+	secret, ok := a.secrets[keyID]
+	if !ok {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return Identity{}, ErrInvalidCredentials
+	}
+	t := time.Unix(ts, 0)
+
+	if a.tolerance > 0 {
+		if d := a.now().Sub(t); d > a.tolerance || d < -a.tolerance {
+			return Identity{}, ErrInvalidCredentials
+		}
+	}
+
+	actual, err := hex.DecodeString(sig)
+	if err != nil {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	expected := ComputeSignature(secret, t, r.Method, r.URL.Path, body)
+	if !hmac.Equal(expected, actual) {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	return Identity{
+		Subject:      keyID,
+		Applications: []string{keyID},
+	}, nil
+}
+
+// NewHMACAuthenticator initializes a new Authenticator that verifies HMAC-SHA256 signed requests.
+// Secrets maps each application to its shared secret. Signatures older or newer than the given tolerance are
+// rejected. A tolerance of zero disables the timestamp check.
+func NewHMACAuthenticator(secrets map[string]string, tolerance time.Duration) Authenticator {
+	return &hmacAuthenticator{
+		secrets:   secrets,
+		tolerance: tolerance,
+		now:       time.Now,
+	}
+}
+
+// hmacSigner is a Signer implementation that signs requests with a shared secret.
+type hmacSigner struct {
+	// application is the application the secret belongs to.
+	application string
+
+	// secret is the shared secret used to sign requests.
+	secret string
+}
+
+// Sign signs the given request using HMAC-SHA256.
+func (s *hmacSigner) Sign(r *http.Request, body []byte) error {
+	now := time.Now()
+	sig := ComputeSignature(s.secret, now, r.Method, r.URL.Path, body)
+	r.Header.Set(HeaderKeyID, s.application)
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	r.Header.Set(HeaderSignature, hex.EncodeToString(sig))
+	return nil
+}
+
+// NewHMACSigner initializes a new Signer that signs requests on behalf of the given application using its shared secret.
+func NewHMACSigner(application, secret string) Signer {
+	return &hmacSigner{
+		application: application,
+		secret:      secret,
+	}
+}
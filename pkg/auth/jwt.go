@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Claims contains the JWT claims accepted by the payments service.
+type Claims struct {
+	jwt.StandardClaims
+
+	// Applications contains the list of applications the token holder is allowed to act for.
+	Applications []string `json:"applications"`
+}
+
+// jwtAuthenticator is an Authenticator implementation that verifies HS256 signed JSON Web Tokens sent as bearer tokens.
+type jwtAuthenticator struct {
+	// secret is the key used to verify token signatures.
+	secret []byte
+
+	// issuer is the expected token issuer. It's not checked if empty.
+	issuer string
+
+	// audience is the expected token audience. It's not checked if empty.
+	audience string
+
+	// maxLifetime is the maximum time a token can be valid for from now on. It's not checked if zero.
+	maxLifetime time.Duration
+}
+
+// Authenticate verifies the bearer token included in the Authorization header.
+func (a *jwtAuthenticator) Authenticate(r *http.Request, _ []byte) (Identity, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return Identity{}, ErrMissingCredentials
+	}
+
+	var claims Claims
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(header, "Bearer "), &claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %s", t.Method.Alg())
+		}
+		return a.secret, nil
+	})
+	if err != nil {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	// Tokens must expire. The parser only checks the expiration time when it's set.
+	if claims.ExpiresAt == 0 {
+		return Identity{}, ErrInvalidCredentials
+	}
+	if a.maxLifetime > 0 && time.Unix(claims.ExpiresAt, 0).After(time.Now().Add(a.maxLifetime)) {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	if len(a.issuer) > 0 && !claims.VerifyIssuer(a.issuer, true) {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	if len(a.audience) > 0 && !claims.VerifyAudience(a.audience, true) {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	return Identity{
+		Subject:      claims.Subject,
+		Applications: claims.Applications,
+	}, nil
+}
+
+// NewJWTAuthenticator initializes a new Authenticator that verifies HS256 bearer tokens signed with the given secret.
+// Tokens must have an expiration time. Issuer and audience are only verified when they're not empty, and tokens
+// expiring later than maxLifetime from now on are rejected unless maxLifetime is zero.
+func NewJWTAuthenticator(secret []byte, issuer, audience string, maxLifetime time.Duration) Authenticator {
+	return &jwtAuthenticator{
+		secret:      secret,
+		issuer:      issuer,
+		audience:    audience,
+		maxLifetime: maxLifetime,
+	}
+}
+
+// bearerSigner is a Signer implementation that attaches a bearer token to requests.
+type bearerSigner string
+
+// Sign sets the Authorization header in the given request.
+func (s bearerSigner) Sign(r *http.Request, _ []byte) error {
+	r.Header.Set("Authorization", "Bearer "+string(s))
+	return nil
+}
+
+// NewBearerSigner initializes a new Signer that attaches the given token to requests.
+func NewBearerSigner(token string) Signer {
+	return bearerSigner(token)
+}
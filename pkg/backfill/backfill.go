@@ -0,0 +1,267 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/replay"
+	"io"
+	"log"
+	"time"
+)
+
+var (
+	// ErrIncomplete is returned when some of the events couldn't be recovered.
+	ErrIncomplete = errors.New("backfill incomplete")
+
+	// ErrUnverified is reported for events created before the ledger cut-off, whose charge can't be checked.
+	ErrUnverified = errors.New("event created before the ledger cut-off")
+)
+
+// Status is the result of processing a single event.
+type Status string
+
+const (
+	// StatusSkipped is used for events whose charge has already been processed.
+	StatusSkipped Status = "skipped"
+	// StatusRecovered is used for events whose charge has been processed by the backfill.
+	StatusRecovered Status = "recovered"
+	// StatusPending is used for events whose charge would have been processed if the backfill wasn't a dry run.
+	StatusPending Status = "pending"
+	// StatusInvalid is used for events that don't contain a valid charge, e.g. because of missing metadata.
+	StatusInvalid Status = "invalid"
+	// StatusUnverified is used for events created before the ledger cut-off. There's no record of whether their
+	// charge has been processed, so they're not processed and must be checked manually.
+	StatusUnverified Status = "unverified"
+	// StatusFailed is used for events whose charge failed to be processed.
+	StatusFailed Status = "failed"
+)
+
+// Entry contains the result of processing a single event.
+type Entry struct {
+	// Event is the identifier of the event in the payment service.
+	Event string `json:"event"`
+
+	// Created is the time the event was created.
+	Created time.Time `json:"created"`
+
+	// Status is the result of processing the event.
+	Status Status `json:"status"`
+
+	// Payment is the identifier of the payment in the payment service.
+	Payment string `json:"payment,omitempty"`
+
+	// Customer is the identifier of the customer in the payment service.
+	Customer string `json:"customer,omitempty"`
+
+	// Application is the application that originated the payment.
+	Application string `json:"application,omitempty"`
+
+	// Amount is the amount paid in cents.
+	Amount uint `json:"amount,omitempty"`
+
+	// Currency holds the ISO 4217 currency value in lowercase format.
+	Currency string `json:"currency,omitempty"`
+
+	// Error contains the reason why the event couldn't be processed.
+	Error string `json:"error,omitempty"`
+}
+
+// Report contains the result of a backfill run.
+type Report struct {
+	// From is the beginning of the time window checked.
+	From time.Time `json:"from"`
+
+	// To is the end of the time window checked.
+	To time.Time `json:"to"`
+
+	// DryRun is true if no charges were processed.
+	DryRun bool `json:"dry_run"`
+
+	// Entries contains the result of every event found in the time window, oldest first.
+	Entries []Entry `json:"entries"`
+}
+
+// Count returns the amount of entries with the given status.
+func (r Report) Count(status Status) int {
+	var n int
+	for _, e := range r.Entries {
+		if e.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Options contains a set of components needed to configure a Backfiller.
+type Options struct {
+	// Inspector is used to list the events sent by the payment service.
+	Inspector adapter.Inspector
+
+	// Charger is used to process the charges of the missed events. It's usually the same application.Service
+	// used by the webhook handler, which refuses to charge the payments already recorded in the ledger.
+	Charger api.ChargerV1
+
+	// Events is used to record the events being processed and the processed ones. It should be the tracker used by
+	// the webhook handler, so an event isn't processed by both the webhook handler and a backfill, nor by backfills
+	// running at the same time in multiple replicas. If set to nil, events are not tracked.
+	Events *replay.Tracker
+
+	// Since is the time since which every charge has been recorded in the ledger. Events created before it are
+	// reported as unverified instead of being processed. If zero, no event is processed.
+	Since time.Time
+
+	// Logger contains a logger mechanism. If set to nil, it defaults to a logger pointing to io.Discard.
+	Logger *log.Logger
+}
+
+// Backfiller recovers payment service events that should have been received through webhooks but were never
+// processed, e.g. because the payments service was down for longer than the payment service retries them.
+type Backfiller struct {
+	// inspector is used to list the events sent by the payment service.
+	inspector adapter.Inspector
+
+	// charger is used to process the charges of the missed events.
+	charger api.ChargerV1
+
+	// events is used to record the events being processed and the processed ones.
+	events *replay.Tracker
+
+	// since is the time since which every charge has been recorded in the ledger.
+	since time.Time
+
+	// logger is used to log relevant information when running a backfill.
+	logger *log.Logger
+}
+
+// Run lists the events created within the given time window and processes the charges that haven't been processed
+// yet. If dryRun is true, charges are reported but not processed. ErrIncomplete is returned alongside the report if
+// any charge failed.
+func (b *Backfiller) Run(ctx context.Context, from, to time.Time, dryRun bool) (Report, error) {
+	b.logger.Printf("Running backfill from %s to %s (dry run: %t)\n", from.Format(time.RFC3339), to.Format(time.RFC3339), dryRun)
+
+	events, err := b.inspector.ListChargeEvents(ctx, from, to)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{
+		From:    from,
+		To:      to,
+		DryRun:  dryRun,
+		Entries: make([]Entry, 0, len(events)),
+	}
+
+	// Payments processed during this run, a payment may be referenced by more than one event.
+	processed := make(map[string]bool)
+	for _, e := range events {
+		entry := Entry{
+			Event:       e.ID,
+			Created:     e.Created,
+			Payment:     e.Request.Payment,
+			Customer:    e.Request.Customer,
+			Application: e.Request.Application,
+			Amount:      e.Request.Amount,
+			Currency:    e.Request.Currency,
+		}
+
+		switch {
+		case e.Err != nil:
+			entry.Status = StatusInvalid
+			entry.Error = e.Err.Error()
+		case e.Charged || processed[e.Request.Payment]:
+			entry.Status = StatusSkipped
+		case b.since.IsZero() || e.Created.Before(b.since):
+			entry.Status = StatusUnverified
+			entry.Error = ErrUnverified.Error()
+		case dryRun:
+			entry.Status = StatusPending
+			processed[e.Request.Payment] = true
+		default:
+			var err error
+			if entry.Status, err = b.process(ctx, e); err != nil {
+				entry.Error = err.Error()
+			}
+			if entry.Status != StatusFailed {
+				processed[e.Request.Payment] = true
+			}
+		}
+
+		report.Entries = append(report.Entries, entry)
+	}
+
+	b.logger.Printf("Backfill finished. Recovered: %d, Pending: %d, Skipped: %d, Invalid: %d, Unverified: %d, Failed: %d\n",
+		report.Count(StatusRecovered), report.Count(StatusPending), report.Count(StatusSkipped),
+		report.Count(StatusInvalid), report.Count(StatusUnverified), report.Count(StatusFailed))
+
+	if report.Count(StatusFailed) > 0 {
+		return report, ErrIncomplete
+	}
+	return report, nil
+}
+
+// process processes the charge of the given event in the same way the webhook handler does. Events that are being
+// processed or have already been processed, and payments already recorded in the ledger, are skipped.
+func (b *Backfiller) process(ctx context.Context, e adapter.ChargeEvent) (Status, error) {
+	if b.events != nil {
+		err := b.events.Begin(ctx, e.ID)
+		if errors.Is(err, replay.ErrProcessed) || errors.Is(err, replay.ErrInProgress) {
+			b.logger.Println("Skipping event already handled:", e.ID, err)
+			return StatusSkipped, nil
+		}
+		if err != nil {
+			b.logger.Println("Failed to track event:", e.ID, err)
+			return StatusFailed, err
+		}
+	}
+
+	_, err := b.charger.Charge(ctx, e.Request)
+	if err != nil && !errors.Is(err, api.ErrAlreadyCharged) {
+		b.logger.Println("Failed to recover event:", e.ID, err)
+		b.abort(ctx, e.ID)
+		return StatusFailed, err
+	}
+	b.finish(ctx, e.ID)
+
+	if err != nil {
+		b.logger.Println("Skipping event of a payment already charged:", e.ID)
+		return StatusSkipped, nil
+	}
+	b.logger.Println("Recovered event:", e.ID)
+	return StatusRecovered, nil
+}
+
+// finish remembers the given event as processed. Errors are only logged, the event has already been processed.
+func (b *Backfiller) finish(ctx context.Context, event string) {
+	if b.events == nil {
+		return
+	}
+	if err := b.events.Finish(ctx, event); err != nil {
+		b.logger.Println("Failed to record processed event:", event, err)
+	}
+}
+
+// abort forgets the given event after its processing failed, so it's processed by the next delivery or backfill.
+func (b *Backfiller) abort(ctx context.Context, event string) {
+	if b.events == nil {
+		return
+	}
+	if err := b.events.Abort(ctx, event); err != nil {
+		b.logger.Println("Failed to forget event:", event, err)
+	}
+}
+
+// NewBackfiller initializes a new Backfiller.
+func NewBackfiller(opts Options) *Backfiller {
+	if opts.Logger == nil {
+		opts.Logger = log.New(io.Discard, "", log.LstdFlags)
+	}
+	return &Backfiller{
+		inspector: opts.Inspector,
+		charger:   opts.Charger,
+		events:    opts.Events,
+		since:     opts.Since,
+		logger:    opts.Logger,
+	}
+}
@@ -0,0 +1,202 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/replay"
+	"testing"
+	"time"
+)
+
+func chargeRequest(event, payment string) api.ChargeRequest {
+	return api.ChargeRequest{
+		Amount:      1000,
+		Currency:    "usd",
+		Customer:    "cus_123",
+		Service:     api.PaymentServiceStripe,
+		Application: "fuel",
+		Event:       event,
+		Payment:     payment,
+	}
+}
+
+// ledgerSince is the ledger cut-off used by the tests, the events are created after it.
+var ledgerSince = time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+
+func events() []adapter.ChargeEvent {
+	created := ledgerSince.Add(24 * time.Hour)
+	return []adapter.ChargeEvent{
+		{ID: "evt_1", Created: created, Charged: true, Request: chargeRequest("evt_1", "pi_1")},
+		{ID: "evt_2", Created: created, Request: chargeRequest("evt_2", "pi_2")},
+		{ID: "evt_3", Created: created, Err: errors.New("missing application")},
+		{ID: "evt_4", Created: created, Request: chargeRequest("evt_4", "pi_4")},
+		{ID: "evt_5", Created: created, Request: chargeRequest("evt_5", "pi_2")},
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListChargeEvents", mock.Anything, from, to).Return(events(), error(nil))
+
+	charger := &fake.Charger{}
+	charger.On("Charge", mock.Anything, chargeRequest("evt_2", "pi_2")).Return(api.ChargeResponse{}, error(nil))
+	charger.On("Charge", mock.Anything, chargeRequest("evt_4", "pi_4")).Return(api.ChargeResponse{}, error(nil))
+
+	b := NewBackfiller(Options{Inspector: inspector, Charger: charger, Since: ledgerSince})
+	report, err := b.Run(context.Background(), from, to, false)
+	require.NoError(t, err)
+
+	require.Len(t, report.Entries, 5)
+	assert.Equal(t, StatusSkipped, report.Entries[0].Status)
+	assert.Equal(t, StatusRecovered, report.Entries[1].Status)
+	assert.Equal(t, StatusInvalid, report.Entries[2].Status)
+	assert.Equal(t, "missing application", report.Entries[2].Error)
+	assert.Equal(t, StatusRecovered, report.Entries[3].Status)
+	assert.Equal(t, StatusSkipped, report.Entries[4].Status)
+	assert.Equal(t, 2, report.Count(StatusRecovered))
+
+	charger.AssertNumberOfCalls(t, "Charge", 2)
+}
+
+func TestBackfillDryRun(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListChargeEvents", mock.Anything, from, to).Return(events(), error(nil))
+
+	charger := &fake.Charger{}
+
+	b := NewBackfiller(Options{Inspector: inspector, Charger: charger, Since: ledgerSince})
+	report, err := b.Run(context.Background(), from, to, true)
+	require.NoError(t, err)
+
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 2, report.Count(StatusPending))
+	assert.Equal(t, 2, report.Count(StatusSkipped))
+	charger.AssertNotCalled(t, "Charge", mock.Anything, mock.Anything)
+}
+
+func TestBackfillIncomplete(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListChargeEvents", mock.Anything, from, to).Return(events(), error(nil))
+
+	charger := &fake.Charger{}
+	charger.On("Charge", mock.Anything, chargeRequest("evt_2", "pi_2")).Return(api.ChargeResponse{}, api.ErrUpstream)
+	charger.On("Charge", mock.Anything, chargeRequest("evt_4", "pi_4")).Return(api.ChargeResponse{}, error(nil))
+	charger.On("Charge", mock.Anything, chargeRequest("evt_5", "pi_2")).Return(api.ChargeResponse{}, error(nil))
+
+	b := NewBackfiller(Options{Inspector: inspector, Charger: charger, Since: ledgerSince})
+	report, err := b.Run(context.Background(), from, to, false)
+	assert.True(t, errors.Is(err, ErrIncomplete))
+	assert.Equal(t, StatusFailed, report.Entries[1].Status)
+
+	// The same payment is retried with the next event that references it.
+	assert.Equal(t, StatusRecovered, report.Entries[4].Status)
+}
+
+func TestBackfillEvents(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListChargeEvents", mock.Anything, from, to).Return(events(), error(nil))
+
+	charger := &fake.Charger{}
+	charger.On("Charge", mock.Anything, chargeRequest("evt_2", "pi_2")).Return(api.ChargeResponse{}, api.ErrUpstream).Once()
+	charger.On("Charge", mock.Anything, chargeRequest("evt_2", "pi_2")).Return(api.ChargeResponse{}, api.ErrAlreadyCharged)
+	charger.On("Charge", mock.Anything, chargeRequest("evt_4", "pi_4")).Return(api.ChargeResponse{}, error(nil))
+	charger.On("Charge", mock.Anything, chargeRequest("evt_5", "pi_2")).Return(api.ChargeResponse{}, error(nil))
+
+	// Backfills sharing the events tracker of the webhook handler, e.g. running in different replicas.
+	tracker := replay.NewTracker(replay.TrackerOptions{
+		Cache:         replay.NewMemoryCache(),
+		Window:        time.Hour,
+		ProcessingTTL: time.Minute,
+	})
+	opts := Options{Inspector: inspector, Charger: charger, Events: tracker, Since: ledgerSince}
+
+	// The webhook handler is processing evt_4.
+	require.NoError(t, tracker.Begin(context.Background(), "evt_4"))
+
+	report, err := NewBackfiller(opts).Run(context.Background(), from, to, false)
+	assert.True(t, errors.Is(err, ErrIncomplete))
+	assert.Equal(t, StatusFailed, report.Entries[1].Status)
+	assert.Equal(t, StatusSkipped, report.Entries[3].Status)
+
+	// The failed event is forgotten, so the payment is retried with the next event.
+	assert.Equal(t, StatusRecovered, report.Entries[4].Status)
+
+	require.NoError(t, tracker.Finish(context.Background(), "evt_4"))
+
+	// The payment of the failed event has been charged by the next one, the ledger keeps it from being charged again.
+	report, err = NewBackfiller(opts).Run(context.Background(), from, to, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Count(StatusRecovered))
+	assert.Equal(t, StatusSkipped, report.Entries[1].Status)
+	assert.Equal(t, StatusSkipped, report.Entries[3].Status)
+	assert.Equal(t, StatusSkipped, report.Entries[4].Status)
+	charger.AssertNumberOfCalls(t, "Charge", 3)
+	charger.AssertNotCalled(t, "Charge", mock.Anything, chargeRequest("evt_4", "pi_4"))
+}
+
+func TestBackfillAlreadyCharged(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListChargeEvents", mock.Anything, from, to).Return(events(), error(nil))
+
+	// The ledger holds the grant of pi_2, but marking it as charged in the payment service failed.
+	charger := &fake.Charger{}
+	charger.On("Charge", mock.Anything, chargeRequest("evt_2", "pi_2")).Return(api.ChargeResponse{}, api.ErrAlreadyCharged)
+	charger.On("Charge", mock.Anything, chargeRequest("evt_4", "pi_4")).Return(api.ChargeResponse{}, error(nil))
+
+	b := NewBackfiller(Options{Inspector: inspector, Charger: charger, Since: ledgerSince})
+	report, err := b.Run(context.Background(), from, to, false)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSkipped, report.Entries[1].Status)
+	assert.Equal(t, StatusRecovered, report.Entries[3].Status)
+	assert.Equal(t, StatusSkipped, report.Entries[4].Status)
+	charger.AssertNumberOfCalls(t, "Charge", 2)
+}
+
+func TestBackfillUnverified(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListChargeEvents", mock.Anything, from, to).Return(events(), error(nil))
+
+	charger := &fake.Charger{}
+	charger.On("Charge", mock.Anything, mock.Anything).Return(api.ChargeResponse{}, error(nil))
+
+	// Events created before the ledger cut-off are never charged, nor are any events without a cut-off.
+	for _, since := range []time.Time{{}, ledgerSince.Add(48 * time.Hour)} {
+		b := NewBackfiller(Options{Inspector: inspector, Charger: charger, Since: since})
+		report, err := b.Run(context.Background(), from, to, false)
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Count(StatusUnverified))
+		assert.Equal(t, ErrUnverified.Error(), report.Entries[1].Error)
+		assert.Equal(t, StatusSkipped, report.Entries[0].Status)
+		assert.Equal(t, StatusInvalid, report.Entries[2].Status)
+	}
+	charger.AssertNotCalled(t, "Charge", mock.Anything, mock.Anything)
+}
+
+func TestBackfillListFails(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListChargeEvents", mock.Anything, from, to).Return([]adapter.ChargeEvent(nil), errors.New("stripe failed"))
+
+	b := NewBackfiller(Options{Inspector: inspector, Charger: &fake.Charger{}})
+	_, err := b.Run(context.Background(), from, to, false)
+	assert.Error(t, err)
+}
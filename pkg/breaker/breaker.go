@@ -0,0 +1,275 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// StateClosed is the state of a breaker that lets every call through.
+	StateClosed State = iota
+
+	// StateOpen is the state of a breaker that rejects every call until its cool-down has passed.
+	StateOpen
+
+	// StateHalfOpen is the state of a breaker whose cool-down has passed. A single probe call is let through: the
+	// breaker is closed if it succeeds, and opened again otherwise.
+	StateHalfOpen
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// MarshalText encodes the state as its name.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a state from its name.
+func (s *State) UnmarshalText(text []byte) error {
+	for _, state := range []State{StateClosed, StateOpen, StateHalfOpen} {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid circuit breaker state: %s", text)
+}
+
+// ErrOpen is matched by the errors returned when a breaker rejects a call.
+var ErrOpen = errors.New("circuit breaker open")
+
+// OpenError is returned when a call is rejected because the breaker protecting a dependency is open. It matches
+// ErrOpen when used with errors.Is.
+type OpenError struct {
+	// Name is the name of the breaker.
+	Name string
+
+	// RetryAfter is the amount of time until the breaker lets a probe call through.
+	RetryAfter time.Duration
+}
+
+// Error returns the error message.
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%s: %s, retry after %s", ErrOpen, e.Name, e.RetryAfter)
+}
+
+// Is returns true if target is ErrOpen.
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// Options contains the configuration of a Breaker.
+type Options struct {
+	// Name identifies the dependency protected by the breaker in logs and snapshots. Example: credits
+	Name string
+
+	// Failures is the amount of consecutive failed calls that open the breaker. The breaker is disabled if set to 0.
+	Failures uint
+
+	// Cooldown is the amount of time an open breaker rejects calls before letting a probe call through.
+	Cooldown time.Duration
+
+	// IsFailure returns true if the given error means the dependency is failing. Errors caused by the request, such
+	// as a missing record, shouldn't count as failures. If set to nil, every error counts except canceled contexts.
+	IsFailure func(err error) bool
+
+	// Logger is used to log state changes. If set to nil, it defaults to a logger pointing to io.Discard.
+	Logger *log.Logger
+}
+
+// Snapshot contains the state of a breaker at a given time.
+type Snapshot struct {
+	// Name is the name of the breaker.
+	Name string `json:"name"`
+
+	// State is the state of the breaker: closed, open or half-open.
+	State State `json:"state"`
+
+	// Failures is the amount of consecutive failed calls.
+	Failures uint `json:"failures"`
+
+	// OpenedAt is the last time the breaker was opened. It's nil if the breaker is closed.
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+// Breaker is a circuit breaker. It rejects calls to a dependency after a number of consecutive failures, so a
+// failing dependency isn't called again until a cool-down has passed.
+type Breaker struct {
+	// lock is used to synchronize access to the state of the breaker.
+	lock sync.Mutex
+
+	// name identifies the dependency protected by the breaker.
+	name string
+
+	// threshold is the amount of consecutive failures that open the breaker. The breaker is disabled if 0.
+	threshold uint
+
+	// cooldown is the amount of time an open breaker rejects calls.
+	cooldown time.Duration
+
+	// isFailure returns true if the given error counts as a failure.
+	isFailure func(err error) bool
+
+	// logger is used to log state changes.
+	logger *log.Logger
+
+	// now returns the current time.
+	now func() time.Time
+
+	// state is the state of the breaker. Open breakers become half-open when a call is made after the cool-down.
+	state State
+
+	// failures is the amount of consecutive failures.
+	failures uint
+
+	// openedAt is the last time the breaker was opened.
+	openedAt time.Time
+
+	// probing is true while the probe call of a half-open breaker is running.
+	probing bool
+}
+
+// Name returns the name of the breaker.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// Do calls fn if the breaker lets the call through, and records its result. It returns an *OpenError without calling
+// fn if the breaker is open. Calls are not made if the given context is already done, as their failure wouldn't be
+// caused by the dependency.
+func (b *Breaker) Do(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	b.record(probe, err)
+	return err
+}
+
+// allow returns an *OpenError if a call can't be made. Open breakers become half-open once the cool-down has passed,
+// letting a single probe call through. It returns true if the call is the probe call.
+func (b *Breaker) allow() (bool, error) {
+	if b.threshold == 0 {
+		return false, nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		wait := b.openedAt.Add(b.cooldown).Sub(b.now())
+		if wait > 0 {
+			return false, &OpenError{Name: b.name, RetryAfter: wait}
+		}
+		b.state = StateHalfOpen
+		b.logger.Printf("Circuit breaker %s half-open, probing\n", b.name)
+		fallthrough
+	case StateHalfOpen:
+		if b.probing {
+			return false, &OpenError{Name: b.name, RetryAfter: b.cooldown}
+		}
+		b.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// record updates the state of the breaker with the result of a call. The result of the probe call of a half-open
+// breaker closes it or opens it again.
+func (b *Breaker) record(probe bool, err error) {
+	if b.threshold == 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	if err == nil || !b.isFailure(err) {
+		if b.state != StateClosed {
+			b.logger.Printf("Circuit breaker %s closed\n", b.name)
+		}
+		b.state = StateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if probe || (b.state == StateClosed && b.failures >= b.threshold) {
+		b.state = StateOpen
+		b.openedAt = b.now()
+		b.logger.Printf("Circuit breaker %s opened for %s after %d consecutive failures: %v\n",
+			b.name, b.cooldown, b.failures, err)
+	}
+}
+
+// Snapshot returns the current state of the breaker. Open breakers whose cool-down has passed are reported as
+// half-open, as the next call will be let through.
+func (b *Breaker) Snapshot() Snapshot {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	out := Snapshot{
+		Name:     b.name,
+		State:    b.state,
+		Failures: b.failures,
+	}
+	if b.state == StateOpen && !b.now().Before(b.openedAt.Add(b.cooldown)) {
+		out.State = StateHalfOpen
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		out.OpenedAt = &openedAt
+	}
+	return out
+}
+
+// isFailure is the default function used to classify errors. Every error counts as a failure except canceled
+// contexts, which are caused by the caller.
+func isFailure(err error) bool {
+	return !errors.Is(err, context.Canceled)
+}
+
+// NewBreaker initializes a new closed Breaker.
+func NewBreaker(opts Options) *Breaker {
+	if opts.Logger == nil {
+		opts.Logger = log.New(io.Discard, "", log.LstdFlags)
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isFailure
+	}
+	return &Breaker{
+		name:      opts.Name,
+		threshold: opts.Failures,
+		cooldown:  opts.Cooldown,
+		isFailure: opts.IsFailure,
+		logger:    opts.Logger,
+		now:       time.Now,
+	}
+}
@@ -0,0 +1,161 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var errFailure = errors.New("connection refused")
+
+// newTestBreaker returns a breaker opened by 2 consecutive failures, with a clock controlled by the returned function.
+func newTestBreaker(opts Options) (*Breaker, func(time.Duration)) {
+	now := time.Now()
+	opts.Name = "credits"
+	opts.Failures = 2
+	opts.Cooldown = time.Minute
+	b := NewBreaker(opts)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func fail() error {
+	return errFailure
+}
+
+func succeed() error {
+	return nil
+}
+
+func TestBreakerOpens(t *testing.T) {
+	b, advance := newTestBreaker(Options{})
+
+	assert.Equal(t, errFailure, b.Do(context.Background(), fail))
+	assert.Equal(t, StateClosed, b.Snapshot().State)
+	assert.Equal(t, errFailure, b.Do(context.Background(), fail))
+
+	snapshot := b.Snapshot()
+	assert.Equal(t, StateOpen, snapshot.State)
+	assert.Equal(t, uint(2), snapshot.Failures)
+	require.NotNil(t, snapshot.OpenedAt)
+
+	called := false
+	advance(20 * time.Second)
+	err := b.Do(context.Background(), func() error {
+		called = true
+		return nil
+	})
+	assert.False(t, called)
+	assert.ErrorIs(t, err, ErrOpen)
+
+	var open *OpenError
+	require.ErrorAs(t, err, &open)
+	assert.Equal(t, "credits", open.Name)
+	assert.Equal(t, 40*time.Second, open.RetryAfter)
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b, _ := newTestBreaker(Options{})
+
+	assert.Error(t, b.Do(context.Background(), fail))
+	assert.NoError(t, b.Do(context.Background(), succeed))
+	assert.Error(t, b.Do(context.Background(), fail))
+	assert.Equal(t, StateClosed, b.Snapshot().State)
+	assert.Equal(t, uint(1), b.Snapshot().Failures)
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b, advance := newTestBreaker(Options{})
+	b.Do(context.Background(), fail)
+	b.Do(context.Background(), fail)
+
+	advance(time.Minute)
+	assert.Equal(t, StateHalfOpen, b.Snapshot().State)
+
+	// The probe call fails, the breaker is opened again
+	assert.Equal(t, errFailure, b.Do(context.Background(), fail))
+	assert.Equal(t, StateOpen, b.Snapshot().State)
+	assert.ErrorIs(t, b.Do(context.Background(), succeed), ErrOpen)
+
+	// The probe call succeeds, the breaker is closed
+	advance(time.Minute)
+	assert.NoError(t, b.Do(context.Background(), succeed))
+	snapshot := b.Snapshot()
+	assert.Equal(t, StateClosed, snapshot.State)
+	assert.Zero(t, snapshot.Failures)
+	assert.Nil(t, snapshot.OpenedAt)
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	b, advance := newTestBreaker(Options{})
+	b.Do(context.Background(), fail)
+	b.Do(context.Background(), fail)
+	advance(time.Minute)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- b.Do(context.Background(), func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	// Calls made while the probe call is running are rejected
+	assert.ErrorIs(t, b.Do(context.Background(), succeed), ErrOpen)
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.NoError(t, b.Do(context.Background(), succeed))
+}
+
+func TestBreakerIgnoresRequestErrors(t *testing.T) {
+	notFound := errors.New("customer not found")
+	b, _ := newTestBreaker(Options{
+		IsFailure: func(err error) bool {
+			return err != notFound
+		},
+	})
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, notFound, b.Do(context.Background(), func() error { return notFound }))
+	}
+	assert.Equal(t, StateClosed, b.Snapshot().State)
+
+	// Canceled contexts are not failures by default
+	b, _ = newTestBreaker(Options{})
+	for i := 0; i < 5; i++ {
+		assert.ErrorIs(t, b.Do(context.Background(), func() error { return context.Canceled }), context.Canceled)
+	}
+	assert.Equal(t, StateClosed, b.Snapshot().State)
+}
+
+func TestBreakerContextDone(t *testing.T) {
+	b, _ := newTestBreaker(Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	// Calls are not made once the deadline has passed, so they don't count as failures
+	for i := 0; i < 5; i++ {
+		err := b.Do(ctx, func() error {
+			t.Fatal("call made after the deadline")
+			return nil
+		})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	assert.Equal(t, StateClosed, b.Snapshot().State)
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := NewBreaker(Options{Name: "credits"})
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, errFailure, b.Do(context.Background(), fail))
+	}
+	assert.Equal(t, StateClosed, b.Snapshot().State)
+}
@@ -0,0 +1,280 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/web/ign-go"
+)
+
+// creditsRequestErrors contains the errors returned by the credits service when a request is not valid.
+var creditsRequestErrors = []error{
+	credits.ErrHandleNotProvided,
+	credits.ErrInvalidAmount,
+	credits.ErrInvalidCurrencyFormat,
+	credits.ErrMissingApplication,
+}
+
+// customersRequestErrors contains the errors returned by the customers service when a request is not valid, or the
+// requested customer doesn't exist.
+var customersRequestErrors = []error{
+	customers.ErrCustomerNotFound,
+	customers.ErrCustomerMissingIdentityValue,
+	customers.ErrIdentityMissingApplication,
+	customers.ErrIdentityMissingService,
+}
+
+// IsCreditsFailure returns true if the given error returned by the credits service means the service is failing.
+// Errors caused by invalid requests are not failures.
+func IsCreditsFailure(err error) bool {
+	return isFailure(err) && !isRequestError(err, creditsRequestErrors)
+}
+
+// IsCustomersFailure returns true if the given error returned by the customers service means the service is failing.
+// Errors caused by invalid requests or missing customers are not failures.
+func IsCustomersFailure(err error) bool {
+	return isFailure(err) && !isRequestError(err, customersRequestErrors)
+}
+
+// IsProviderFailure returns true if the given error returned by a payment service adapter means the payment service
+// is failing. Declined cards, rate limits and invalid requests are not failures.
+func IsProviderFailure(err error) bool {
+	return errors.Is(err, adapter.ErrProviderUnavailable) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isRequestError returns true if the given error is one of the given request errors. The credits and customers
+// clients only keep the message of the errors returned by their services, so messages are compared.
+func isRequestError(err error, requestErrors []error) bool {
+	for _, target := range requestErrors {
+		if ign.IsError(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// creditsClient is a credits.CreditsV1 implementation that calls another implementation through a Breaker.
+type creditsClient struct {
+	breaker *Breaker
+	client  credits.CreditsV1
+}
+
+// IncreaseCredits increases the amount of credits of a given user.
+func (c *creditsClient) IncreaseCredits(ctx context.Context, req credits.IncreaseCreditsRequest) (out credits.IncreaseCreditsResponse, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		out, err = c.client.IncreaseCredits(ctx, req)
+		return err
+	})
+	return out, err
+}
+
+// DecreaseCredits decreases the amount of credits of a given user.
+func (c *creditsClient) DecreaseCredits(ctx context.Context, req credits.DecreaseCreditsRequest) (out credits.DecreaseCreditsResponse, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		out, err = c.client.DecreaseCredits(ctx, req)
+		return err
+	})
+	return out, err
+}
+
+// GetBalance returns the current amount of credits of a given user.
+func (c *creditsClient) GetBalance(ctx context.Context, req credits.GetBalanceRequest) (out credits.GetBalanceResponse, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		out, err = c.client.GetBalance(ctx, req)
+		return err
+	})
+	return out, err
+}
+
+// ConvertCurrency converts a certain amount of FIAT currency in USD to credits.
+func (c *creditsClient) ConvertCurrency(ctx context.Context, req credits.ConvertCurrencyRequest) (out credits.ConvertCurrencyResponse, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		out, err = c.client.ConvertCurrency(ctx, req)
+		return err
+	})
+	return out, err
+}
+
+// GetUnitPrice returns the amount of currency needed to buy 1 credit.
+func (c *creditsClient) GetUnitPrice(ctx context.Context, req credits.GetUnitPriceRequest) (out credits.GetUnitPriceResponse, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		out, err = c.client.GetUnitPrice(ctx, req)
+		return err
+	})
+	return out, err
+}
+
+// NewCredits wraps the given credits.CreditsV1 implementation so every call goes through the given Breaker.
+func NewCredits(b *Breaker, client credits.CreditsV1) credits.CreditsV1 {
+	return &creditsClient{
+		breaker: b,
+		client:  client,
+	}
+}
+
+// customersClient is a customers.CustomersV1 implementation that calls another implementation through a Breaker.
+type customersClient struct {
+	breaker *Breaker
+	client  customers.CustomersV1
+}
+
+// GetCustomerByHandle returns customer information based on the customer's application handle.
+func (c *customersClient) GetCustomerByHandle(ctx context.Context, req customers.GetCustomerByHandleRequest) (out customers.CustomerResponse, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		out, err = c.client.GetCustomerByHandle(ctx, req)
+		return err
+	})
+	return out, err
+}
+
+// GetCustomerByID returns customer information based on the customer's external service identity.
+func (c *customersClient) GetCustomerByID(ctx context.Context, req customers.GetCustomerByIDRequest) (out customers.CustomerResponse, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		out, err = c.client.GetCustomerByID(ctx, req)
+		return err
+	})
+	return out, err
+}
+
+// CreateCustomer creates a new customer for a certain application.
+func (c *customersClient) CreateCustomer(ctx context.Context, req customers.CreateCustomerRequest) (out customers.CustomerResponse, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		out, err = c.client.CreateCustomer(ctx, req)
+		return err
+	})
+	return out, err
+}
+
+// NewCustomers wraps the given customers.CustomersV1 implementation so every call goes through the given Breaker.
+func NewCustomers(b *Breaker, client customers.CustomersV1) customers.CustomersV1 {
+	return &customersClient{
+		breaker: b,
+		client:  client,
+	}
+}
+
+// adapterClient is an adapter.Client implementation that calls another implementation through a Breaker. Webhook
+// events are parsed without going through the breaker, as no request is made to the payment service.
+type adapterClient struct {
+	breaker *Breaker
+	client  adapter.Client
+}
+
+// CreateCustomer creates a customer in the context of the payment service.
+func (c *adapterClient) CreateCustomer(ctx context.Context, application, handle string) (id string, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		id, err = c.client.CreateCustomer(ctx, application, handle)
+		return err
+	})
+	return id, err
+}
+
+// DeleteCustomer deletes a customer created with CreateCustomer.
+func (c *adapterClient) DeleteCustomer(ctx context.Context, id string) error {
+	return c.breaker.Do(ctx, func() error {
+		return c.client.DeleteCustomer(ctx, id)
+	})
+}
+
+// CreateSession creates a session in the context of the payment service.
+func (c *adapterClient) CreateSession(ctx context.Context, req api.CreateSessionRequest, cus customers.CustomerResponse) (out api.CreateSessionResponse, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		out, err = c.client.CreateSession(ctx, req, cus)
+		return err
+	})
+	return out, err
+}
+
+// GenerateChargeRequest generates an api.ChargeRequest out from the given body and a set of parameters.
+func (c *adapterClient) GenerateChargeRequest(ctx context.Context, body []byte, params map[string][]string) (api.ChargeRequest, error) {
+	return c.client.GenerateChargeRequest(ctx, body, params)
+}
+
+// MarkCharged records in the payment service that the given charge has been processed.
+func (c *adapterClient) MarkCharged(ctx context.Context, req api.ChargeRequest) error {
+	return c.breaker.Do(ctx, func() error {
+		return c.client.MarkCharged(ctx, req)
+	})
+}
+
+// NewAdapter wraps the given adapter.Client implementation so every request made to the payment service goes through
+// the given Breaker.
+func NewAdapter(b *Breaker, client adapter.Client) adapter.Client {
+	return &adapterClient{
+		breaker: b,
+		client:  client,
+	}
+}
+
+// connector is an adapter.Connector implementation that calls another implementation through a Breaker. Webhook
+// events are parsed without going through the breaker, as no request is made to the payment service.
+type connector struct {
+	breaker   *Breaker
+	connector adapter.Connector
+}
+
+// CreateAccount creates a connected account for the given application.
+func (c *connector) CreateAccount(ctx context.Context, application string) (id string, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		id, err = c.connector.CreateAccount(ctx, application)
+		return err
+	})
+	return id, err
+}
+
+// CreateAccountLink returns the URL of the onboarding flow of the given connected account.
+func (c *connector) CreateAccountLink(ctx context.Context, id, refreshURL, returnURL string) (url string, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		url, err = c.connector.CreateAccountLink(ctx, id, refreshURL, returnURL)
+		return err
+	})
+	return url, err
+}
+
+// Capture captures the given authorized payment, keeping the application fee.
+func (c *connector) Capture(ctx context.Context, req api.CaptureRequest) error {
+	return c.breaker.Do(ctx, func() error {
+		return c.connector.Capture(ctx, req)
+	})
+}
+
+// GenerateConnectEvent generates an adapter.ConnectEvent out from the given body and a set of parameters.
+func (c *connector) GenerateConnectEvent(ctx context.Context, body []byte, params map[string][]string) (adapter.ConnectEvent, error) {
+	return c.connector.GenerateConnectEvent(ctx, body, params)
+}
+
+// NewConnector wraps the given adapter.Connector implementation so every request made to the payment service goes
+// through the given Breaker.
+func NewConnector(b *Breaker, c adapter.Connector) adapter.Connector {
+	return &connector{
+		breaker:   b,
+		connector: c,
+	}
+}
+
+// catalog is an adapter.Catalog implementation that looks up prices through a Breaker. Products and prices are only
+// changed by catalog syncs, which are not made by the server, so other calls don't go through the breaker.
+type catalog struct {
+	adapter.Catalog
+	breaker *Breaker
+}
+
+// GetPrice returns the active price with the given lookup key.
+func (c *catalog) GetPrice(ctx context.Context, lookupKey string) (out adapter.Price, err error) {
+	err = c.breaker.Do(ctx, func() error {
+		out, err = c.Catalog.GetPrice(ctx, lookupKey)
+		return err
+	})
+	return out, err
+}
+
+// NewCatalog wraps the given adapter.Catalog implementation so price lookups go through the given Breaker.
+func NewCatalog(b *Breaker, c adapter.Catalog) adapter.Catalog {
+	return &catalog{
+		Catalog: c,
+		breaker: b,
+	}
+}
@@ -0,0 +1,110 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	fakecredits "gitlab.com/ignitionrobotics/billing/credits/pkg/fake"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	fakecustomers "gitlab.com/ignitionrobotics/billing/customers/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"testing"
+)
+
+func TestIsFailure(t *testing.T) {
+	// The credits and customers clients return errors with the message of the error returned by the service.
+	assert.False(t, IsCustomersFailure(errors.New(customers.ErrCustomerNotFound.Error())))
+	assert.False(t, IsCustomersFailure(context.Canceled))
+	assert.True(t, IsCustomersFailure(errors.New("Internal Server Error")))
+	assert.True(t, IsCustomersFailure(context.DeadlineExceeded))
+
+	assert.False(t, IsCreditsFailure(errors.New(credits.ErrInvalidAmount.Error())))
+	assert.True(t, IsCreditsFailure(errors.New("connection refused")))
+
+	assert.True(t, IsProviderFailure(fmt.Errorf("%w: timeout", adapter.ErrProviderUnavailable)))
+	assert.False(t, IsProviderFailure(adapter.ErrCardDeclined))
+	assert.False(t, IsProviderFailure(adapter.ErrRateLimited))
+}
+
+func TestCreditsBreaker(t *testing.T) {
+	client := fakecredits.NewClient()
+	client.On("IncreaseCredits", mock.Anything, mock.Anything).Return(credits.IncreaseCreditsResponse{}, errors.New("connection refused"))
+
+	b, _ := newTestBreaker(Options{IsFailure: IsCreditsFailure})
+	c := NewCredits(b, client)
+	for i := 0; i < 5; i++ {
+		_, err := c.IncreaseCredits(context.Background(), credits.IncreaseCreditsRequest{})
+		assert.Error(t, err)
+	}
+
+	// Calls are not made once the breaker is open
+	client.AssertNumberOfCalls(t, "IncreaseCredits", 2)
+	_, err := c.IncreaseCredits(context.Background(), credits.IncreaseCreditsRequest{})
+	assert.ErrorIs(t, err, ErrOpen)
+}
+
+func TestCustomersBreaker(t *testing.T) {
+	client := &fakecustomers.Fake{}
+	client.On("GetCustomerByHandle", mock.Anything, mock.Anything).Return(customers.CustomerResponse{}, errors.New(customers.ErrCustomerNotFound.Error()))
+
+	b, _ := newTestBreaker(Options{IsFailure: IsCustomersFailure})
+	c := NewCustomers(b, client)
+	for i := 0; i < 5; i++ {
+		_, err := c.GetCustomerByHandle(context.Background(), customers.GetCustomerByHandleRequest{})
+		assert.Error(t, err)
+	}
+
+	// Missing customers don't open the breaker
+	client.AssertNumberOfCalls(t, "GetCustomerByHandle", 5)
+	assert.Equal(t, StateClosed, b.Snapshot().State)
+}
+
+func TestAdapterBreaker(t *testing.T) {
+	client := &fake.Adapter{}
+	client.On("CreateCustomer", mock.Anything, "fuel", "alice").Return("", fmt.Errorf("%w: 503", adapter.ErrProviderUnavailable))
+	client.On("GenerateChargeRequest", mock.Anything, mock.Anything, mock.Anything).Return(api.ChargeRequest{}, nil)
+
+	b, _ := newTestBreaker(Options{IsFailure: IsProviderFailure})
+	c := NewAdapter(b, client)
+	for i := 0; i < 3; i++ {
+		_, err := c.CreateCustomer(context.Background(), "fuel", "alice")
+		assert.Error(t, err)
+	}
+	client.AssertNumberOfCalls(t, "CreateCustomer", 2)
+
+	// Webhook events are still parsed while the breaker is open
+	_, err := c.GenerateChargeRequest(context.Background(), nil, nil)
+	assert.NoError(t, err)
+}
+
+func TestCatalogBreaker(t *testing.T) {
+	c := &fake.Catalog{}
+	c.On("GetPrice", mock.Anything, "credits_fuel_usd").Return(adapter.Price{}, fmt.Errorf("%w: 503", adapter.ErrProviderUnavailable))
+	c.On("GetPrice", mock.Anything, "credits_app_usd").Return(adapter.Price{}, adapter.ErrPriceNotFound)
+	c.On("ListProducts", mock.Anything).Return([]adapter.Product{}, nil)
+
+	b, _ := newTestBreaker(Options{IsFailure: IsProviderFailure})
+	catalog := NewCatalog(b, c)
+
+	// Missing prices don't open the breaker
+	for i := 0; i < 3; i++ {
+		_, err := catalog.GetPrice(context.Background(), "credits_app_usd")
+		assert.ErrorIs(t, err, adapter.ErrPriceNotFound)
+	}
+	assert.Equal(t, StateClosed, b.Snapshot().State)
+
+	for i := 0; i < 3; i++ {
+		_, err := catalog.GetPrice(context.Background(), "credits_fuel_usd")
+		assert.Error(t, err)
+	}
+	c.AssertNumberOfCalls(t, "GetPrice", 5)
+
+	// Syncs don't go through the breaker
+	_, err := catalog.ListProducts(context.Background())
+	assert.NoError(t, err)
+}
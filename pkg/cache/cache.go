@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options contains the configuration of a Cache.
+type Options struct {
+	// Name identifies the cache in stats and invalidation requests. Example: customers
+	Name string
+
+	// TTL is the amount of time values are kept. Values are not kept if set to 0, but concurrent lookups of the same
+	// key are still coalesced.
+	TTL time.Duration
+
+	// MaxEntries is the maximum amount of values kept. The values closest to expiring are evicted first when the cache
+	// is full. The amount of values is not limited if set to 0.
+	MaxEntries int
+}
+
+// Stats contains the usage statistics of a cache.
+type Stats struct {
+	// Name is the name of the cache.
+	Name string `json:"name"`
+
+	// Hits is the amount of lookups answered with a kept value.
+	Hits uint64 `json:"hits"`
+
+	// Misses is the amount of lookups that loaded the value.
+	Misses uint64 `json:"misses"`
+
+	// Coalesced is the amount of lookups that waited for a concurrent lookup of the same key to load the value.
+	Coalesced uint64 `json:"coalesced"`
+
+	// Entries is the amount of values currently kept.
+	Entries int `json:"entries"`
+}
+
+// LoadFunc loads the value of a key that is not in the cache.
+type LoadFunc func(ctx context.Context) (interface{}, error)
+
+// entry is a value kept in the cache.
+type entry struct {
+	// value is the loaded value.
+	value interface{}
+
+	// expires is the time the value stops being used.
+	expires time.Time
+}
+
+// call is a running load of a key. Lookups of the same key made while it's running wait for its result.
+type call struct {
+	// done is closed when the load has finished.
+	done chan struct{}
+
+	// value is the loaded value. It's only set once done is closed.
+	value interface{}
+
+	// err is the error returned by the load. It's only set once done is closed.
+	err error
+
+	// invalidated is true if the key was invalidated while the load was running. Its result is not kept, as it may
+	// have been read before the change that caused the invalidation.
+	invalidated bool
+}
+
+// Cache keeps the values returned by slow lookups for a period of time. Concurrent lookups of the same key are
+// coalesced into a single load. Errors are never kept, the next lookup of a key that failed loads it again.
+type Cache struct {
+	// lock is used to synchronize access to entries and calls.
+	lock sync.Mutex
+
+	// name identifies the cache.
+	name string
+
+	// ttl is the amount of time values are kept.
+	ttl time.Duration
+
+	// maxEntries is the maximum amount of values kept. It's not limited if 0.
+	maxEntries int
+
+	// now returns the current time.
+	now func() time.Time
+
+	// entries contains the kept values by key.
+	entries map[string]entry
+
+	// calls contains the running loads by key.
+	calls map[string]*call
+
+	// hits, misses and coalesced count lookups. They're accessed atomically.
+	hits, misses, coalesced uint64
+}
+
+// Name returns the name of the cache.
+func (c *Cache) Name() string {
+	return c.name
+}
+
+// Get returns the value of the given key. If it's not kept, it's loaded with the given function, unless another
+// lookup of the same key is already loading it, in which case its result is returned instead. Lookups waiting for
+// another one return when their context is done, and load the value themselves if the other lookup's context was
+// done first.
+func (c *Cache) Get(ctx context.Context, key string, load LoadFunc) (interface{}, error) {
+	for {
+		c.lock.Lock()
+		if e, ok := c.entries[key]; ok && c.now().Before(e.expires) {
+			c.lock.Unlock()
+			atomic.AddUint64(&c.hits, 1)
+			return e.value, nil
+		}
+
+		if running, ok := c.calls[key]; ok {
+			c.lock.Unlock()
+			atomic.AddUint64(&c.coalesced, 1)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-running.done:
+			}
+			if isContextError(running.err) && ctx.Err() == nil {
+				continue
+			}
+			return running.value, running.err
+		}
+
+		current := &call{done: make(chan struct{})}
+		c.calls[key] = current
+		c.lock.Unlock()
+
+		atomic.AddUint64(&c.misses, 1)
+		current.value, current.err = load(ctx)
+		c.finish(key, current)
+		return current.value, current.err
+	}
+}
+
+// finish records the result of the given load and wakes up the lookups waiting for it.
+func (c *Cache) finish(key string, current *call) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.calls[key] == current {
+		delete(c.calls, key)
+	}
+	if current.err == nil && !current.invalidated {
+		c.set(key, current.value)
+	}
+	close(current.done)
+}
+
+// Set keeps the given value, replacing the current value of the key. It's used to update a cache with the result of
+// a change, such as the creation of a record.
+func (c *Cache) Set(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if running, ok := c.calls[key]; ok {
+		running.invalidated = true
+		delete(c.calls, key)
+	}
+	c.set(key, value)
+}
+
+// set keeps the given value, evicting the values closest to expiring if the cache is full. It must be called with
+// the lock held.
+func (c *Cache) set(key string, value interface{}) {
+	if c.ttl <= 0 {
+		return
+	}
+	now := c.now()
+	if _, ok := c.entries[key]; !ok && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = entry{
+		value:   value,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// evict removes the expired values, or the value closest to expiring if none has expired. It must be called with the
+// lock held.
+func (c *Cache) evict(now time.Time) {
+	var oldest string
+	var oldestExpires time.Time
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+			continue
+		}
+		if len(oldest) == 0 || e.expires.Before(oldestExpires) {
+			oldest, oldestExpires = k, e.expires
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		delete(c.entries, oldest)
+	}
+}
+
+// Invalidate removes the value of the given key. Loads of the key running when it's called are not kept.
+func (c *Cache) Invalidate(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.entries, key)
+	if running, ok := c.calls[key]; ok {
+		running.invalidated = true
+		delete(c.calls, key)
+	}
+}
+
+// Purge removes every value. Loads running when it's called are not kept.
+func (c *Cache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[string]entry)
+	for key, running := range c.calls {
+		running.invalidated = true
+		delete(c.calls, key)
+	}
+}
+
+// Stats returns the usage statistics of the cache. Expired values that haven't been replaced yet are not counted as
+// entries.
+func (c *Cache) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	var entries int
+	for _, e := range c.entries {
+		if now.Before(e.expires) {
+			entries++
+		}
+	}
+	return Stats{
+		Name:      c.name,
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Coalesced: atomic.LoadUint64(&c.coalesced),
+		Entries:   entries,
+	}
+}
+
+// isContextError returns true if the given error was caused by a canceled context or a context that hit its deadline.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// NewCache initializes a new empty Cache.
+func NewCache(opts Options) *Cache {
+	return &Cache{
+		name:       opts.Name,
+		ttl:        opts.TTL,
+		maxEntries: opts.MaxEntries,
+		now:        time.Now,
+		entries:    make(map[string]entry),
+		calls:      make(map[string]*call),
+	}
+}
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCache returns a cache with a clock controlled by the returned function.
+func newTestCache(opts Options) (*Cache, func(time.Duration)) {
+	now := time.Now()
+	opts.Name = "unit_prices"
+	c := NewCache(opts)
+	c.now = func() time.Time { return now }
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+// counter returns a LoadFunc that returns the amount of times it has been called.
+func counter() LoadFunc {
+	var calls int
+	return func(ctx context.Context) (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c, advance := newTestCache(Options{TTL: time.Minute})
+	load := counter()
+
+	for i := 0; i < 3; i++ {
+		value, err := c.Get(context.Background(), "usd", load)
+		require.NoError(t, err)
+		assert.Equal(t, 1, value)
+	}
+
+	advance(time.Minute)
+	value, err := c.Get(context.Background(), "usd", load)
+	require.NoError(t, err)
+	assert.Equal(t, 2, value)
+
+	assert.Equal(t, Stats{Name: "unit_prices", Hits: 2, Misses: 2, Entries: 1}, c.Stats())
+}
+
+func TestCacheErrorsNotKept(t *testing.T) {
+	c, _ := newTestCache(Options{TTL: time.Minute})
+	failure := errors.New("connection refused")
+
+	_, err := c.Get(context.Background(), "usd", func(ctx context.Context) (interface{}, error) {
+		return nil, failure
+	})
+	assert.Equal(t, failure, err)
+
+	value, err := c.Get(context.Background(), "usd", counter())
+	require.NoError(t, err)
+	assert.Equal(t, 1, value)
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c, _ := newTestCache(Options{})
+	load := counter()
+
+	c.Get(context.Background(), "usd", load)
+	value, err := c.Get(context.Background(), "usd", load)
+	require.NoError(t, err)
+	assert.Equal(t, 2, value)
+	assert.Zero(t, c.Stats().Entries)
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	c, _ := newTestCache(Options{TTL: time.Minute})
+
+	release := make(chan struct{})
+	var calls int
+	load := func(ctx context.Context) (interface{}, error) {
+		calls++
+		<-release
+		return calls, nil
+	}
+
+	var wg sync.WaitGroup
+	values := make([]interface{}, 5)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = c.Get(context.Background(), "usd", load)
+		}(i)
+	}
+
+	// Wait until every lookup is either loading the value or waiting for it
+	require.Eventually(t, func() bool {
+		stats := c.Stats()
+		return stats.Misses+stats.Coalesced == 5
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, calls)
+	for _, value := range values {
+		assert.Equal(t, 1, value)
+	}
+	assert.Equal(t, uint64(1), c.Stats().Misses)
+	assert.Equal(t, uint64(4), c.Stats().Coalesced)
+}
+
+func TestCacheCoalescingContext(t *testing.T) {
+	c, _ := newTestCache(Options{TTL: time.Minute})
+
+	started := make(chan struct{})
+	first, cancel := context.WithCancel(context.Background())
+	go c.Get(first, "usd", func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	<-started
+
+	// Lookups waiting for another one return when their context is done
+	expired, cancelExpired := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelExpired()
+	_, err := c.Get(expired, "usd", counter())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Lookups waiting for another one load the value themselves if the other lookup is canceled
+	done := make(chan interface{})
+	go func() {
+		value, _ := c.Get(context.Background(), "usd", counter())
+		done <- value
+	}()
+	require.Eventually(t, func() bool { return c.Stats().Coalesced == 2 }, time.Second, time.Millisecond)
+	cancel()
+	assert.Equal(t, 1, <-done)
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c, _ := newTestCache(Options{TTL: time.Minute})
+	load := counter()
+
+	c.Get(context.Background(), "usd", load)
+	c.Get(context.Background(), "eur", load)
+
+	c.Invalidate("usd")
+	value, _ := c.Get(context.Background(), "usd", load)
+	assert.Equal(t, 3, value)
+	value, _ = c.Get(context.Background(), "eur", load)
+	assert.Equal(t, 2, value)
+
+	c.Purge()
+	assert.Zero(t, c.Stats().Entries)
+	value, _ = c.Get(context.Background(), "eur", load)
+	assert.Equal(t, 4, value)
+
+	// Values loaded while the key is invalidated are not kept
+	c.Get(context.Background(), "gbp", func(ctx context.Context) (interface{}, error) {
+		c.Invalidate("gbp")
+		return 0, nil
+	})
+	value, _ = c.Get(context.Background(), "gbp", load)
+	assert.Equal(t, 5, value)
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	c, advance := newTestCache(Options{TTL: time.Minute, MaxEntries: 2})
+	load := counter()
+
+	c.Get(context.Background(), "usd", load)
+	advance(time.Second)
+	c.Get(context.Background(), "eur", load)
+	advance(time.Second)
+	c.Get(context.Background(), "gbp", load)
+	assert.Equal(t, 2, c.Stats().Entries)
+
+	// The value closest to expiring is evicted first
+	value, _ := c.Get(context.Background(), "eur", load)
+	assert.Equal(t, 2, value)
+	value, _ = c.Get(context.Background(), "usd", load)
+	assert.Equal(t, 4, value)
+}
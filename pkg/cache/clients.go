@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+)
+
+// creditsClient is a credits.CreditsV1 implementation that keeps the unit prices returned by another implementation in
+// a Cache. Balance operations are not cached.
+type creditsClient struct {
+	credits.CreditsV1
+	cache *Cache
+}
+
+// GetUnitPrice returns the amount of currency needed to buy 1 credit.
+func (c *creditsClient) GetUnitPrice(ctx context.Context, req credits.GetUnitPriceRequest) (credits.GetUnitPriceResponse, error) {
+	out, err := c.cache.Get(ctx, UnitPriceKey(req.Currency), func(ctx context.Context) (interface{}, error) {
+		return c.CreditsV1.GetUnitPrice(ctx, req)
+	})
+	if err != nil {
+		return credits.GetUnitPriceResponse{}, err
+	}
+	return out.(credits.GetUnitPriceResponse), nil
+}
+
+// UnitPriceKey returns the key of the unit price of the given currency.
+func UnitPriceKey(currency string) string {
+	return currency
+}
+
+// NewCredits wraps the given credits.CreditsV1 implementation so unit prices are kept in the given Cache.
+func NewCredits(c *Cache, client credits.CreditsV1) credits.CreditsV1 {
+	return &creditsClient{
+		CreditsV1: client,
+		cache:     c,
+	}
+}
+
+// customersClient is a customers.CustomersV1 implementation that keeps the customers returned by another
+// implementation in a Cache. Missing customers are not cached, so they can be found as soon as they're created.
+type customersClient struct {
+	client customers.CustomersV1
+	cache  *Cache
+}
+
+// GetCustomerByHandle returns customer information based on the customer's application handle.
+func (c *customersClient) GetCustomerByHandle(ctx context.Context, req customers.GetCustomerByHandleRequest) (customers.CustomerResponse, error) {
+	key := HandleKey(req.Service, req.Application, req.Handle)
+	return c.get(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return c.client.GetCustomerByHandle(ctx, req)
+	})
+}
+
+// GetCustomerByID returns customer information based on the customer's external service identity.
+func (c *customersClient) GetCustomerByID(ctx context.Context, req customers.GetCustomerByIDRequest) (customers.CustomerResponse, error) {
+	key := IDKey(req.Service, req.Application, req.ID)
+	return c.get(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return c.client.GetCustomerByID(ctx, req)
+	})
+}
+
+// CreateCustomer creates a new customer for a certain application. The created customer is kept in the cache.
+func (c *customersClient) CreateCustomer(ctx context.Context, req customers.CreateCustomerRequest) (customers.CustomerResponse, error) {
+	out, err := c.client.CreateCustomer(ctx, req)
+	if err != nil {
+		return customers.CustomerResponse{}, err
+	}
+	c.cache.Set(HandleKey(out.Service, out.Application, out.Handle), out)
+	c.cache.Set(IDKey(out.Service, out.Application, out.ID), out)
+	return out, nil
+}
+
+// get returns the customer of the given key, loading it with the given function if it's not kept.
+func (c *customersClient) get(ctx context.Context, key string, load LoadFunc) (customers.CustomerResponse, error) {
+	out, err := c.cache.Get(ctx, key, load)
+	if err != nil {
+		return customers.CustomerResponse{}, err
+	}
+	return out.(customers.CustomerResponse), nil
+}
+
+// HandleKey returns the key of the customer with the given handle.
+func HandleKey(service, application, handle string) string {
+	return fmt.Sprintf("handle:%s:%s:%s", service, application, handle)
+}
+
+// IDKey returns the key of the customer with the given payment service ID.
+func IDKey(service, application, id string) string {
+	return fmt.Sprintf("id:%s:%s:%s", service, application, id)
+}
+
+// NewCustomers wraps the given customers.CustomersV1 implementation so customers are kept in the given Cache.
+func NewCustomers(c *Cache, client customers.CustomersV1) customers.CustomersV1 {
+	return &customersClient{
+		client: client,
+		cache:  c,
+	}
+}
+
+// missingPrice is kept in the cache in place of the prices that don't exist, so sessions of applications without a
+// synced price don't look it up every time.
+type missingPrice struct{}
+
+// catalog is an adapter.Catalog implementation that keeps the prices returned by another implementation in a Cache.
+// Missing prices are cached too. Products and prices are not cached when listed, as syncs need their current state.
+type catalog struct {
+	adapter.Catalog
+	cache *Cache
+}
+
+// GetPrice returns the active price with the given lookup key.
+func (c *catalog) GetPrice(ctx context.Context, lookupKey string) (adapter.Price, error) {
+	out, err := c.cache.Get(ctx, lookupKey, func(ctx context.Context) (interface{}, error) {
+		price, err := c.Catalog.GetPrice(ctx, lookupKey)
+		if errors.Is(err, adapter.ErrPriceNotFound) {
+			return missingPrice{}, nil
+		}
+		return price, err
+	})
+	if err != nil {
+		return adapter.Price{}, err
+	}
+	if _, ok := out.(missingPrice); ok {
+		return adapter.Price{}, adapter.ErrPriceNotFound
+	}
+	return out.(adapter.Price), nil
+}
+
+// InvalidatePrice forgets the price with the given lookup key, e.g. after the payment service rejected it because it
+// has been archived, so it's looked up again the next time.
+func (c *catalog) InvalidatePrice(lookupKey string) {
+	c.cache.Invalidate(lookupKey)
+}
+
+// NewCatalog wraps the given adapter.Catalog implementation so prices are kept in the given Cache, by lookup key.
+func NewCatalog(c *Cache, client adapter.Catalog) adapter.Catalog {
+	return &catalog{
+		Catalog: client,
+		cache:   c,
+	}
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	fakecredits "gitlab.com/ignitionrobotics/billing/credits/pkg/fake"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	fakecustomers "gitlab.com/ignitionrobotics/billing/customers/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"testing"
+	"time"
+)
+
+func TestCreditsCache(t *testing.T) {
+	client := fakecredits.NewClient()
+	client.On("GetUnitPrice", mock.Anything, credits.GetUnitPriceRequest{Currency: "usd"}).Return(credits.GetUnitPriceResponse{Amount: 100, Currency: "usd"}, nil)
+	client.On("IncreaseCredits", mock.Anything, mock.Anything).Return(credits.IncreaseCreditsResponse{}, nil)
+
+	c := NewCredits(NewCache(Options{TTL: time.Minute}), client)
+	for i := 0; i < 3; i++ {
+		out, err := c.GetUnitPrice(context.Background(), credits.GetUnitPriceRequest{Currency: "usd"})
+		require.NoError(t, err)
+		assert.Equal(t, uint(100), out.Amount)
+	}
+	client.AssertNumberOfCalls(t, "GetUnitPrice", 1)
+
+	// Balance operations are not cached
+	c.IncreaseCredits(context.Background(), credits.IncreaseCreditsRequest{})
+	c.IncreaseCredits(context.Background(), credits.IncreaseCreditsRequest{})
+	client.AssertNumberOfCalls(t, "IncreaseCredits", 2)
+}
+
+func TestCustomersCache(t *testing.T) {
+	alice := customers.CustomerResponse{ID: "cus_alice", Handle: "alice", Service: "stripe", Application: "fuel"}
+	bob := customers.CustomerResponse{ID: "cus_bob", Handle: "bob", Service: "stripe", Application: "fuel"}
+	byHandle := func(handle string) customers.GetCustomerByHandleRequest {
+		return customers.GetCustomerByHandleRequest{Handle: handle, Service: "stripe", Application: "fuel"}
+	}
+
+	client := &fakecustomers.Fake{}
+	client.On("GetCustomerByHandle", mock.Anything, byHandle("alice")).Return(alice, nil)
+	client.On("GetCustomerByHandle", mock.Anything, byHandle("bob")).Return(customers.CustomerResponse{}, errors.New(customers.ErrCustomerNotFound.Error()))
+	client.On("CreateCustomer", mock.Anything, mock.Anything).Return(bob, nil)
+
+	c := NewCustomers(NewCache(Options{TTL: time.Minute}), client)
+	for i := 0; i < 3; i++ {
+		out, err := c.GetCustomerByHandle(context.Background(), byHandle("alice"))
+		require.NoError(t, err)
+		assert.Equal(t, alice, out)
+	}
+	client.AssertNumberOfCalls(t, "GetCustomerByHandle", 1)
+
+	// Missing customers are not cached, created customers are
+	_, err := c.GetCustomerByHandle(context.Background(), byHandle("bob"))
+	assert.Error(t, err)
+	_, err = c.CreateCustomer(context.Background(), customers.CreateCustomerRequest{ID: "cus_bob", Handle: "bob", Service: "stripe", Application: "fuel"})
+	require.NoError(t, err)
+
+	out, err := c.GetCustomerByHandle(context.Background(), byHandle("bob"))
+	require.NoError(t, err)
+	assert.Equal(t, bob, out)
+	out, err = c.GetCustomerByID(context.Background(), customers.GetCustomerByIDRequest{ID: "cus_bob", Service: "stripe", Application: "fuel"})
+	require.NoError(t, err)
+	assert.Equal(t, bob, out)
+	client.AssertNumberOfCalls(t, "GetCustomerByHandle", 2)
+	client.AssertNotCalled(t, "GetCustomerByID", mock.Anything, mock.Anything)
+}
+
+func TestCatalogCache(t *testing.T) {
+	price := adapter.Price{ID: "price_123", LookupKey: "credits_fuel_usd", Currency: "usd", UnitAmount: 100, Active: true}
+	client := &fake.Catalog{}
+	client.On("GetPrice", mock.Anything, "credits_fuel_usd").Return(price, nil)
+	client.On("GetPrice", mock.Anything, "credits_app_usd").Return(adapter.Price{}, adapter.ErrPriceNotFound)
+	client.On("GetPrice", mock.Anything, "credits_app_eur").Return(adapter.Price{}, errors.New("stripe is down"))
+
+	c := NewCatalog(NewCache(Options{TTL: time.Minute}), client)
+	for i := 0; i < 3; i++ {
+		out, err := c.GetPrice(context.Background(), "credits_fuel_usd")
+		require.NoError(t, err)
+		assert.Equal(t, price, out)
+
+		// Missing prices are cached, errors are not
+		_, err = c.GetPrice(context.Background(), "credits_app_usd")
+		assert.ErrorIs(t, err, adapter.ErrPriceNotFound)
+		_, err = c.GetPrice(context.Background(), "credits_app_eur")
+		assert.Error(t, err)
+	}
+	client.AssertNumberOfCalls(t, "GetPrice", 5)
+
+	// Invalidated prices are looked up again
+	c.(*catalog).InvalidatePrice("credits_fuel_usd")
+	_, err := c.GetPrice(context.Background(), "credits_fuel_usd")
+	require.NoError(t, err)
+	client.AssertNumberOfCalls(t, "GetPrice", 6)
+}
@@ -0,0 +1,286 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"io"
+	"log"
+	"sort"
+)
+
+// ProductID returns the ID of the credits product of the given application.
+func ProductID(application string) string {
+	return "credits_" + application
+}
+
+// ProductName returns the name of the credits product of the given application.
+func ProductName(application string) string {
+	return fmt.Sprintf("Credits (%s)", application)
+}
+
+// LookupKey returns the lookup key of the current price of a credit of the given application in the given currency.
+func LookupKey(application, currency string) string {
+	return fmt.Sprintf("credits_%s_%s", application, currency)
+}
+
+// Action is the change made to a product or a price by a sync.
+type Action string
+
+const (
+	// ActionCreate is used for products and prices that didn't exist.
+	ActionCreate Action = "create"
+	// ActionUpdate is used for products whose name changed, or that have been restored.
+	ActionUpdate Action = "update"
+	// ActionArchive is used for products of applications that are no longer in the catalog, and for prices that have
+	// been replaced or whose currency is no longer in the catalog.
+	ActionArchive Action = "archive"
+)
+
+// Change is a change made to a product or a price by a sync.
+type Change struct {
+	// Action is the change made.
+	Action Action `json:"action"`
+
+	// Object is the type of the changed object: product or price.
+	Object string `json:"object"`
+
+	// ID is the identifier of the object in the payment service. It's empty for prices created by a dry run.
+	ID string `json:"id,omitempty"`
+
+	// Application is the application the product is sold in.
+	Application string `json:"application"`
+
+	// Currency holds the ISO 4217 currency value of a price in lowercase format.
+	Currency string `json:"currency,omitempty"`
+
+	// UnitAmount is the price of a credit in the minimum currency value (e.g. cents for USD).
+	UnitAmount uint `json:"unit_amount,omitempty"`
+}
+
+// Report contains the result of a sync.
+type Report struct {
+	// DryRun is true if no changes were made.
+	DryRun bool `json:"dry_run"`
+
+	// Changes contains the changes made, or that would have been made in a dry run.
+	Changes []Change `json:"changes"`
+}
+
+// Count returns the amount of changes with the given action.
+func (r Report) Count(action Action) int {
+	var n int
+	for _, c := range r.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Options contains a set of components needed to configure a Syncer.
+type Options struct {
+	// Catalog is used to read and change the products and prices of the payment service.
+	Catalog adapter.Catalog
+
+	// Credits is used to get the price of a credit in every currency.
+	Credits credits.CreditsV1
+
+	// Applications contains the applications that sell credits. Each one has a product.
+	Applications []string
+
+	// Currencies contains the currencies credits are sold in. Each product has a price per currency.
+	Currencies []string
+
+	// Logger contains a logger mechanism. If set to nil, it defaults to a logger pointing to io.Discard.
+	Logger *log.Logger
+}
+
+// applyFunc records the given change, and makes it with the given function unless the sync is a dry run. The function
+// returns the ID of the created object, if any.
+type applyFunc func(c Change, fn func() (string, error)) error
+
+// Syncer keeps the products and prices of a payment service in line with the catalog: a credits product per
+// application, with a price per currency matching the unit price defined in the credits service.
+type Syncer struct {
+	// catalog is used to read and change the products and prices of the payment service.
+	catalog adapter.Catalog
+
+	// credits is used to get the price of a credit in every currency.
+	credits credits.CreditsV1
+
+	// applications contains the applications that sell credits.
+	applications []string
+
+	// currencies contains the currencies credits are sold in.
+	currencies []string
+
+	// logger is used to log the changes made.
+	logger *log.Logger
+}
+
+// Sync creates the products and prices missing in the payment service, and updates the products that changed. Prices
+// can't be changed: prices whose amount differs from the unit price are replaced. Prices of currencies that are no
+// longer sold, and the products of applications that are no longer in the catalog, are archived. If dryRun is true,
+// changes are reported but not made.
+func (s *Syncer) Sync(ctx context.Context, dryRun bool) (Report, error) {
+	report := Report{DryRun: dryRun, Changes: []Change{}}
+
+	unitPrices := make(map[string]uint, len(s.currencies))
+	for _, currency := range s.currencies {
+		res, err := s.credits.GetUnitPrice(ctx, credits.GetUnitPriceRequest{Currency: currency})
+		if err != nil {
+			return report, fmt.Errorf("failed to get %s unit price: %w", currency, err)
+		}
+		unitPrices[currency] = res.Amount
+	}
+
+	products, err := s.catalog.ListProducts(ctx)
+	if err != nil {
+		return report, err
+	}
+	existing := make(map[string]adapter.Product, len(products))
+	for _, p := range products {
+		existing[p.ID] = p
+	}
+
+	apply := func(c Change, fn func() (string, error)) error {
+		if !dryRun {
+			id, err := fn()
+			if err != nil {
+				return err
+			}
+			if len(id) > 0 {
+				c.ID = id
+			}
+		}
+		s.logger.Printf("Catalog %s %s: %s %s %s %d\n", c.Action, c.Object, c.Application, c.ID, c.Currency, c.UnitAmount)
+		report.Changes = append(report.Changes, c)
+		return nil
+	}
+
+	listed := make(map[string]bool, len(s.applications))
+	for _, app := range s.applications {
+		listed[app] = true
+		product := adapter.Product{
+			ID:          ProductID(app),
+			Application: app,
+			Name:        ProductName(app),
+			Active:      true,
+		}
+
+		var prices []adapter.Price
+		current, ok := existing[product.ID]
+		switch {
+		case !ok:
+			err = apply(Change{Action: ActionCreate, Object: "product", ID: product.ID, Application: app}, func() (string, error) {
+				return "", s.catalog.CreateProduct(ctx, product)
+			})
+		case current != product:
+			err = apply(Change{Action: ActionUpdate, Object: "product", ID: product.ID, Application: app}, func() (string, error) {
+				return "", s.catalog.UpdateProduct(ctx, product)
+			})
+		}
+		if err != nil {
+			return report, err
+		}
+		if ok {
+			if prices, err = s.catalog.ListPrices(ctx, product.ID); err != nil {
+				return report, err
+			}
+		}
+
+		if err = s.syncPrices(ctx, app, prices, unitPrices, apply); err != nil {
+			return report, err
+		}
+	}
+
+	// Only the products created by a sync are archived, other products may have an application in their metadata.
+	for _, p := range products {
+		if listed[p.Application] || !p.Active || p.ID != ProductID(p.Application) {
+			continue
+		}
+		prices, err := s.catalog.ListPrices(ctx, p.ID)
+		if err != nil {
+			return report, err
+		}
+		if err = s.archivePrices(ctx, p.Application, prices, apply); err != nil {
+			return report, err
+		}
+		p.Active = false
+		err = apply(Change{Action: ActionArchive, Object: "product", ID: p.ID, Application: p.Application}, func() (string, error) {
+			return "", s.catalog.UpdateProduct(ctx, p)
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// syncPrices creates the prices of the given application that are missing or don't match the unit price, and archives
+// the active prices that are not current.
+func (s *Syncer) syncPrices(ctx context.Context, app string, prices []adapter.Price, unitPrices map[string]uint, apply applyFunc) error {
+	var stale []adapter.Price
+	current := make(map[string]bool)
+	for _, p := range prices {
+		amount, ok := unitPrices[p.Currency]
+		if ok && p.LookupKey == LookupKey(app, p.Currency) && p.UnitAmount == amount && !current[p.Currency] {
+			current[p.Currency] = true
+			continue
+		}
+		stale = append(stale, p)
+	}
+
+	for _, currency := range s.currencies {
+		if current[currency] {
+			continue
+		}
+		price := adapter.Price{
+			Product:    ProductID(app),
+			LookupKey:  LookupKey(app, currency),
+			Currency:   currency,
+			UnitAmount: unitPrices[currency],
+		}
+		c := Change{Action: ActionCreate, Object: "price", Application: app, Currency: currency, UnitAmount: price.UnitAmount}
+		err := apply(c, func() (string, error) {
+			created, err := s.catalog.CreatePrice(ctx, price)
+			return created.ID, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.archivePrices(ctx, app, stale, apply)
+}
+
+// archivePrices archives the given prices of the given application.
+func (s *Syncer) archivePrices(ctx context.Context, app string, prices []adapter.Price, apply applyFunc) error {
+	for _, p := range prices {
+		c := Change{Action: ActionArchive, Object: "price", ID: p.ID, Application: app, Currency: p.Currency, UnitAmount: p.UnitAmount}
+		if err := apply(c, func() (string, error) { return "", s.catalog.ArchivePrice(ctx, p.ID) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewSyncer initializes a new Syncer. Applications and currencies are sorted, so syncs report changes in a stable
+// order.
+func NewSyncer(opts Options) *Syncer {
+	if opts.Logger == nil {
+		opts.Logger = log.New(io.Discard, "", log.LstdFlags)
+	}
+	applications := append([]string{}, opts.Applications...)
+	sort.Strings(applications)
+	currencies := append([]string{}, opts.Currencies...)
+	sort.Strings(currencies)
+	return &Syncer{
+		catalog:      opts.Catalog,
+		credits:      opts.Credits,
+		applications: applications,
+		currencies:   currencies,
+		logger:       opts.Logger,
+	}
+}
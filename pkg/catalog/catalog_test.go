@@ -0,0 +1,135 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	credits "gitlab.com/ignitionrobotics/billing/credits/pkg/api"
+	fakecredits "gitlab.com/ignitionrobotics/billing/credits/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/stripetest"
+	"testing"
+)
+
+// unitPrices returns a credits client returning the given unit prices.
+func unitPrices(prices map[string]uint) *fakecredits.Fake {
+	client := fakecredits.NewClient()
+	for currency, amount := range prices {
+		client.On("GetUnitPrice", mock.Anything, credits.GetUnitPriceRequest{Currency: currency}).
+			Return(credits.GetUnitPriceResponse{Amount: amount, Currency: currency}, nil)
+	}
+	return client
+}
+
+func TestSync(t *testing.T) {
+	srv := stripetest.NewServer(stripetest.Options{})
+	defer srv.Close()
+	c := adapter.NewStripeCatalog(srv.Config())
+	ctx := context.Background()
+
+	syncer := NewSyncer(Options{
+		Catalog:      c,
+		Credits:      unitPrices(map[string]uint{"usd": 100, "eur": 90}),
+		Applications: []string{"fuel", "app"},
+		Currencies:   []string{"usd", "eur"},
+	})
+
+	// Dry runs don't make changes
+	report, err := syncer.Sync(ctx, true)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 6, report.Count(ActionCreate))
+	products, err := c.ListProducts(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, products)
+
+	report, err = syncer.Sync(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 6, report.Count(ActionCreate))
+	assert.Equal(t, Change{Action: ActionCreate, Object: "product", ID: "credits_app", Application: "app"}, report.Changes[0])
+
+	price, err := c.GetPrice(ctx, LookupKey("fuel", "usd"))
+	require.NoError(t, err)
+	assert.Equal(t, ProductID("fuel"), price.Product)
+	assert.Equal(t, uint(100), price.UnitAmount)
+
+	// Syncing again makes no changes
+	report, err = syncer.Sync(ctx, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Changes)
+
+	// Prices are replaced when the unit price changes, and products are archived when their application is removed
+	syncer = NewSyncer(Options{
+		Catalog:      c,
+		Credits:      unitPrices(map[string]uint{"usd": 120, "eur": 90}),
+		Applications: []string{"fuel"},
+		Currencies:   []string{"usd", "eur"},
+	})
+	report, err = syncer.Sync(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Count(ActionCreate))
+	assert.Equal(t, 4, report.Count(ActionArchive))
+
+	replaced, err := c.GetPrice(ctx, LookupKey("fuel", "usd"))
+	require.NoError(t, err)
+	assert.NotEqual(t, price.ID, replaced.ID)
+	assert.Equal(t, uint(120), replaced.UnitAmount)
+
+	_, err = c.GetPrice(ctx, LookupKey("app", "usd"))
+	assert.ErrorIs(t, err, adapter.ErrPriceNotFound)
+	products, err = c.ListProducts(ctx)
+	require.NoError(t, err)
+	require.Len(t, products, 2)
+	for _, p := range products {
+		assert.Equal(t, p.Application == "fuel", p.Active)
+	}
+
+	// Archived products are restored
+	syncer = NewSyncer(Options{
+		Catalog:      c,
+		Credits:      unitPrices(map[string]uint{"usd": 120}),
+		Applications: []string{"app", "fuel"},
+		Currencies:   []string{"usd"},
+	})
+	report, err = syncer.Sync(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Count(ActionUpdate))
+	assert.Equal(t, 1, report.Count(ActionCreate))
+	assert.Equal(t, 1, report.Count(ActionArchive))
+}
+
+func TestSyncFails(t *testing.T) {
+	c := &fake.Catalog{}
+	c.On("ListProducts", mock.Anything).Return([]adapter.Product{}, nil)
+	c.On("CreateProduct", mock.Anything, mock.Anything).Return(nil)
+	c.On("CreatePrice", mock.Anything, mock.Anything).Return(adapter.Price{}, errors.New("stripe is down"))
+
+	syncer := NewSyncer(Options{
+		Catalog:      c,
+		Credits:      unitPrices(map[string]uint{"usd": 100}),
+		Applications: []string{"fuel"},
+		Currencies:   []string{"usd"},
+	})
+	report, err := syncer.Sync(context.Background(), false)
+	assert.Error(t, err)
+
+	// Changes made before the error are reported
+	require.Len(t, report.Changes, 1)
+	assert.Equal(t, "product", report.Changes[0].Object)
+
+	// Sync fails before making changes if unit prices can't be read
+	client := fakecredits.NewClient()
+	client.On("GetUnitPrice", mock.Anything, mock.Anything).Return(credits.GetUnitPriceResponse{}, errors.New("credits is down"))
+	syncer = NewSyncer(Options{
+		Catalog:      c,
+		Credits:      client,
+		Applications: []string{"fuel"},
+		Currencies:   []string{"eur"},
+	})
+	_, err = syncer.Sync(context.Background(), false)
+	assert.Error(t, err)
+	c.AssertNumberOfCalls(t, "ListProducts", 1)
+}
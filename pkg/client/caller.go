@@ -0,0 +1,149 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/web/ign-go/net"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// httpCaller is a net.Caller implementation using HTTP as transport layer. Unlike the caller provided by ign-go,
+// it allows attaching credentials to every request.
+type httpCaller struct {
+	// client is the HTTP client used to send requests to the payments API.
+	client *http.Client
+
+	// baseURL is the base URL where all the requests should be routed to.
+	baseURL *url.URL
+
+	// endpoints contains the set of HTTP endpoints that this caller can communicate with.
+	endpoints map[string]net.EndpointHTTP
+
+	// signer is used to attach credentials to every request. No credentials are attached if nil.
+	signer auth.Signer
+
+	// retry defines how calls failing with a transient error are retried.
+	retry RetryPolicy
+}
+
+// Call sends the given body to the given endpoint and returns the response body. Calls failing with a transient
+// error are retried according to the caller's RetryPolicy, sending the same idempotency key in every attempt.
+func (h *httpCaller) Call(ctx context.Context, endpoint string, in []byte) ([]byte, error) {
+	e, ok := h.endpoints[endpoint]
+	if !ok {
+		return nil, errors.New("unknown endpoint: " + endpoint)
+	}
+
+	u, err := h.baseURL.Parse(e.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := idempotencyKeyFromContext(ctx)
+	if !ok && h.retry.enabled() {
+		if key, err = newIdempotencyKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		out, wait, retryable, err := h.attempt(ctx, e.Method, u.String(), key, in)
+		if err == nil || !retryable || attempt >= h.retry.MaxAttempts {
+			return out, err
+		}
+		d, ok := h.retry.backoff(attempt, wait)
+		if !ok {
+			return nil, err
+		}
+		if sleepErr := sleep(ctx, d); sleepErr != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt sends a single request with the given body to the given URL. If it fails, it returns whether the error is
+// transient, and the wait requested by the server before retrying.
+func (h *httpCaller) attempt(ctx context.Context, method, url, key string, in []byte) ([]byte, time.Duration, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(in))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(key) > 0 {
+		req.Header.Set(api.HeaderIdempotencyKey, key)
+	}
+
+	if h.signer != nil {
+		if err = h.signer.Sign(req, in); err != nil {
+			return nil, 0, false, err
+		}
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		// Network errors are transient, unless the caller gave up.
+		return nil, 0, ctx.Err() == nil, err
+	}
+	defer res.Body.Close()
+
+	out, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, ctx.Err() == nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, retryAfter(res), retryableStatus(res.StatusCode), decodeError(res.StatusCode, out)
+	}
+
+	return out, 0, false, nil
+}
+
+// decodeError converts the body of a failed response into an *api.Error. Bodies that don't contain an api.Error are
+// converted into an *api.Error using the given HTTP status code.
+func decodeError(status int, body []byte) error {
+	var apiErr api.Error
+	if err := json.Unmarshal(body, &apiErr); err == nil && len(apiErr.Code) > 0 {
+		return &apiErr
+	}
+
+	msg := strings.TrimRight(string(body), "\r\n")
+	switch status {
+	case http.StatusBadRequest:
+		return api.NewError(api.ErrorCodeMalformedRequest, msg)
+	case http.StatusUnauthorized:
+		return api.NewError(api.ErrorCodeUnauthenticated, msg)
+	case http.StatusForbidden:
+		return api.NewError(api.ErrorCodeForbidden, msg)
+	case http.StatusNotFound:
+		return api.NewError(api.ErrorCodeNotFound, msg)
+	case http.StatusConflict:
+		return api.NewError(api.ErrorCodeConflict, msg)
+	case http.StatusTooManyRequests:
+		return api.NewError(api.ErrorCodeRateLimited, msg)
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return api.NewError(api.ErrorCodeUpstream, msg)
+	case http.StatusGatewayTimeout:
+		return api.NewError(api.ErrorCodeTimeout, msg)
+	default:
+		return api.NewError(api.ErrorCodeInternal, msg)
+	}
+}
+
+// newCallerHTTP initializes a new HTTP net.Caller.
+func newCallerHTTP(baseURL *url.URL, endpoints map[string]net.EndpointHTTP, timeout time.Duration, signer auth.Signer, retry RetryPolicy) net.Caller {
+	return &httpCaller{
+		client:    &http.Client{Timeout: timeout},
+		baseURL:   baseURL,
+		endpoints: endpoints,
+		signer:    signer,
+		retry:     retry,
+	}
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
 	"gitlab.com/ignitionrobotics/web/ign-go/encoders"
 	"gitlab.com/ignitionrobotics/web/ign-go/net"
 	"net/http"
@@ -34,8 +35,41 @@ type Client interface {
 	api.PaymentsV1
 }
 
+// options contains optional settings used when initializing a Client.
+type options struct {
+	// signer is used to attach credentials to every request.
+	signer auth.Signer
+
+	// retry defines how calls failing with a transient error are retried. Calls are not retried by default.
+	retry RetryPolicy
+}
+
+// Option configures optional settings of a Client.
+type Option func(*options)
+
+// WithCredentials sets the auth.Signer used to attach credentials to every request sent to the payments API.
+func WithCredentials(signer auth.Signer) Option {
+	return func(o *options) {
+		o.signer = signer
+	}
+}
+
+// WithRetries sets the RetryPolicy used to retry calls failing with a transient error, such as a 502 returned by a
+// proxy. Every call gets an idempotency key, unless one is set with WithIdempotencyKey, so retries don't create more
+// than one session.
+func WithRetries(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = policy
+	}
+}
+
 // NewPaymentsClientV1 initializes a new api.PaymentsV1 client implementation using an HTTP client.
-func NewPaymentsClientV1(baseURL *url.URL, timeout time.Duration) Client {
+func NewPaymentsClientV1(baseURL *url.URL, timeout time.Duration, opts ...Option) Client {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	endpoints := map[string]net.EndpointHTTP{
 		"CreateSession": {
 			Method: http.MethodPost,
@@ -43,6 +77,6 @@ func NewPaymentsClientV1(baseURL *url.URL, timeout time.Duration) Client {
 		},
 	}
 	return &client{
-		client: net.NewClient(net.NewCallerHTTP(baseURL, endpoints, timeout), encoders.JSON),
+		client: net.NewClient(newCallerHTTP(baseURL, endpoints, timeout, o.signer, o.retry), encoders.JSON),
 	}
 }
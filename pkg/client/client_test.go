@@ -1 +1,215 @@
 package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCreateSessionWithCredentials(t *testing.T) {
+	authenticator := auth.NewHMACAuthenticator(map[string]string{"fuel": "secret"}, time.Minute)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/payments/session", r.URL.Path)
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		if _, err = authenticator.Authenticate(r, body); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var in api.CreateSessionRequest
+		require.NoError(t, json.Unmarshal(body, &in))
+
+		out, err := json.Marshal(api.CreateSessionResponse{Service: in.Service, Session: "cs_test"})
+		require.NoError(t, err)
+		_, err = w.Write(out)
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	req := api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		Handle:      "test",
+		Application: "fuel",
+	}
+
+	// Without credentials
+	c := NewPaymentsClientV1(u, time.Second)
+	_, err = c.CreateSession(context.Background(), req)
+	assert.Error(t, err)
+
+	// With credentials
+	c = NewPaymentsClientV1(u, time.Second, WithCredentials(auth.NewHMACSigner("fuel", "secret")))
+	res, err := c.CreateSession(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "cs_test", res.Session)
+}
+
+func TestCreateSessionDecodesErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := json.Marshal(api.Error{
+			Code:      api.ErrorCodeEmptyHandle,
+			Message:   api.ErrEmptyHandle.Error(),
+			RequestID: "test/1",
+		})
+		require.NoError(t, err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, err = w.Write(body)
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	_, err = NewPaymentsClientV1(u, time.Second).CreateSession(context.Background(), api.CreateSessionRequest{})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, api.ErrEmptyHandle)
+
+	var apiErr *api.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "test/1", apiErr.RequestID)
+}
+
+func TestCreateSessionDecodesPlainErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	_, err = NewPaymentsClientV1(u, time.Second).CreateSession(context.Background(), api.CreateSessionRequest{})
+	assert.ErrorIs(t, err, api.ErrUpstream)
+}
+
+// flakyServer returns a server that fails the first failures requests with the given status code, and records the
+// idempotency keys received.
+func flakyServer(t *testing.T, failures int, status int, header http.Header) (*url.URL, *[]string) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(api.HeaderIdempotencyKey))
+		if len(keys) <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		_, err := w.Write([]byte(`{"service": "stripe", "session": "cs_test"}`))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return u, &keys
+}
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     10 * time.Millisecond,
+}
+
+func TestCreateSessionRetries(t *testing.T) {
+	u, keys := flakyServer(t, 2, http.StatusBadGateway, nil)
+
+	c := NewPaymentsClientV1(u, time.Second, WithRetries(testRetryPolicy))
+	res, err := c.CreateSession(context.Background(), api.CreateSessionRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "cs_test", res.Session)
+
+	// Every attempt is sent with the same generated key.
+	require.Len(t, *keys, 3)
+	assert.NotEmpty(t, (*keys)[0])
+	assert.Equal(t, (*keys)[0], (*keys)[1])
+	assert.Equal(t, (*keys)[0], (*keys)[2])
+}
+
+func TestCreateSessionRetriesExhausted(t *testing.T) {
+	u, keys := flakyServer(t, 3, http.StatusServiceUnavailable, nil)
+
+	c := NewPaymentsClientV1(u, time.Second, WithRetries(testRetryPolicy))
+	_, err := c.CreateSession(context.Background(), api.CreateSessionRequest{})
+	assert.Equal(t, api.ErrorCodeUpstream, api.ErrorCodeOf(err))
+	assert.Len(t, *keys, 3)
+}
+
+func TestCreateSessionNotRetried(t *testing.T) {
+	// Errors that aren't transient are not retried
+	u, keys := flakyServer(t, 1, http.StatusUnprocessableEntity, nil)
+	c := NewPaymentsClientV1(u, time.Second, WithRetries(testRetryPolicy))
+	_, err := c.CreateSession(context.Background(), api.CreateSessionRequest{})
+	assert.Error(t, err)
+	assert.Len(t, *keys, 1)
+
+	// Waits longer than the maximum backoff are not honoured
+	u, keys = flakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}})
+	c = NewPaymentsClientV1(u, time.Second, WithRetries(testRetryPolicy))
+	_, err = c.CreateSession(context.Background(), api.CreateSessionRequest{})
+	assert.Equal(t, api.ErrorCodeRateLimited, api.ErrorCodeOf(err))
+	assert.Len(t, *keys, 1)
+
+	// Calls are not retried by default, and no key is generated
+	u, keys = flakyServer(t, 1, http.StatusBadGateway, nil)
+	c = NewPaymentsClientV1(u, time.Second)
+	_, err = c.CreateSession(context.Background(), api.CreateSessionRequest{})
+	assert.Error(t, err)
+	assert.Equal(t, []string{""}, *keys)
+}
+
+func TestCreateSessionIdempotencyKey(t *testing.T) {
+	u, keys := flakyServer(t, 1, http.StatusGatewayTimeout, nil)
+
+	c := NewPaymentsClientV1(u, time.Second, WithRetries(testRetryPolicy))
+	_, err := c.CreateSession(WithIdempotencyKey(context.Background(), "purchase-1"), api.CreateSessionRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"purchase-1", "purchase-1"}, *keys)
+}
+
+func TestCreateSessionRetriesCanceled(t *testing.T) {
+	u, keys := flakyServer(t, 3, http.StatusBadGateway, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := NewPaymentsClientV1(u, time.Second, WithRetries(testRetryPolicy))
+	_, err := c.CreateSession(ctx, api.CreateSessionRequest{})
+	assert.Error(t, err)
+	assert.Empty(t, *keys)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for retry, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: time.Second} {
+		d, ok := p.backoff(retry, 0)
+		require.True(t, ok)
+		assert.GreaterOrEqual(t, d, max/2)
+		assert.LessOrEqual(t, d, max)
+	}
+
+	d, ok := p.backoff(1, 500*time.Millisecond)
+	require.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, d)
+
+	_, ok = p.backoff(1, 2*time.Second)
+	assert.False(t, ok)
+}
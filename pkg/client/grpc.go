@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api/pb"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// grpcClient contains the gRPC client to connect to the payments API.
+type grpcClient struct {
+	client pb.PaymentsV1Client
+}
+
+// CreateSession performs a gRPC call to create a payment session in the Payments API.
+// The idempotency key set with WithIdempotencyKey is sent in the call metadata.
+func (c *grpcClient) CreateSession(ctx context.Context, in api.CreateSessionRequest) (api.CreateSessionResponse, error) {
+	if key, ok := idempotencyKeyFromContext(ctx); ok {
+		ctx = metadata.AppendToOutgoingContext(ctx, api.HeaderIdempotencyKey, key)
+	}
+	out, err := c.client.CreateSession(ctx, rpc.CreateSessionRequestToPB(in))
+	if err != nil {
+		return api.CreateSessionResponse{}, rpc.FromStatus(err)
+	}
+	return rpc.CreateSessionResponseFromPB(out), nil
+}
+
+// ListInvoices performs a gRPC call to list all the available invoices of a certain user.
+func (c *grpcClient) ListInvoices(ctx context.Context, req api.ListInvoicesRequest) (api.ListInvoicesResponse, error) {
+	panic("implement me")
+}
+
+// GRPCCredentials returns a grpc.DialOption that attaches credentials to every call using the given auth.Signer.
+func GRPCCredentials(signer auth.Signer) grpc.DialOption {
+	return grpc.WithUnaryInterceptor(rpc.UnaryClientInterceptor(signer))
+}
+
+// NewPaymentsClientV1GRPC initializes a new api.PaymentsV1 client implementation using the given gRPC connection.
+// Use GRPCCredentials when dialing the connection to authenticate calls.
+func NewPaymentsClientV1GRPC(conn grpc.ClientConnInterface) Client {
+	return &grpcClient{
+		client: pb.NewPaymentsV1Client(conn),
+	}
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy defines how calls that fail with a transient error are retried. Transient errors are network errors and
+// responses with a 429, 502, 503 or 504 status code.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum amount of times a call is attempted, including the first attempt. Calls are not
+	// retried if lower than 2.
+	MaxAttempts int
+
+	// InitialBackoff is the base wait before the first retry. It's doubled on every retry. Defaults to 100ms.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum wait between attempts. Defaults to 5s.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is a RetryPolicy suitable for most callers.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+}
+
+// enabled returns true if calls are retried.
+func (p RetryPolicy) enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+// backoff returns the time to wait before the given retry, starting at 1. Half of the wait is random, so clients that
+// failed at the same time don't retry at the same time. A Retry-After value sent by the server is honoured, and it
+// returns false if it's longer than MaxBackoff.
+func (p RetryPolicy) backoff(retry int, retryAfter time.Duration) (time.Duration, bool) {
+	initial, max := p.InitialBackoff, p.MaxBackoff
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 5 * time.Second
+	}
+	if retryAfter > max {
+		return 0, false
+	}
+
+	d := initial
+	for i := 1; i < retry && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	d = d/2 + jitter(d/2)
+
+	if retryAfter > d {
+		d = retryAfter
+	}
+	return d, true
+}
+
+var (
+	// jitterLock is used to synchronize access to jitterSource.
+	jitterLock sync.Mutex
+
+	// jitterSource is used to randomize backoffs.
+	jitterSource = mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter returns a random duration in [0, d].
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	jitterLock.Lock()
+	defer jitterLock.Unlock()
+	return time.Duration(jitterSource.Int63n(int64(d) + 1))
+}
+
+// retryableStatus returns true if a response with the given status code is caused by a transient error.
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter returns the wait requested by the server in the Retry-After header of the given response, in seconds.
+func retryAfter(res *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// sleep waits for the given amount of time, or until the given context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// idempotencyKey is the context key used to store the idempotency key of a call.
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a copy of the given context that sends the given idempotency key with the call it's
+// passed to. The payments API returns the original response when a call is repeated with the same key, instead of
+// creating another session. Keys must be unique per operation, such as a purchase ID.
+//
+// Clients created with WithRetries generate a random key for calls that don't have one, so a retry never performs the
+// operation twice.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// idempotencyKeyFromContext returns the idempotency key stored in the given context.
+func idempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKey{}).(string)
+	return key, ok && len(key) > 0
+}
+
+// newIdempotencyKey returns a random idempotency key.
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,61 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrAccountNotFound is returned when an application doesn't have a connected account.
+var ErrAccountNotFound = errors.New("connected account not found")
+
+// Account is a record of the connected account that receives the payments of an application. Each application has at
+// most one connected account.
+type Account struct {
+	// Application is the application the account receives payments for.
+	Application string `json:"application" gorm:"primarykey;size:255"`
+
+	// ID is the identifier of the account in the payment service.
+	ID string `json:"id" gorm:"uniqueIndex;size:255"`
+
+	// Service is the payment service the account has been created in.
+	Service string `json:"service" gorm:"size:64"`
+
+	// DetailsSubmitted is true if the application owner has completed the onboarding flow.
+	DetailsSubmitted bool `json:"details_submitted"`
+
+	// ChargesEnabled is true if the account can receive payments.
+	ChargesEnabled bool `json:"charges_enabled"`
+
+	// PayoutsEnabled is true if the payment service can pay the account out.
+	PayoutsEnabled bool `json:"payouts_enabled"`
+
+	// CreatedAt is the time the account was recorded.
+	CreatedAt time.Time `json:"created_at"`
+
+	// UpdatedAt is the time the account was last saved.
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// EventCreated is the creation time of the last payment service event applied to the account. It's used to ignore
+	// events received out of order.
+	EventCreated time.Time `json:"event_created"`
+}
+
+// TableName returns the name of the table used to store accounts.
+func (Account) TableName() string {
+	return "connected_accounts"
+}
+
+// Store persists the connected accounts of applications.
+type Store interface {
+	// Save creates or updates the given account.
+	Save(ctx context.Context, account Account) error
+
+	// Find returns the account of the given application. It returns ErrAccountNotFound if the application doesn't
+	// have an account.
+	Find(ctx context.Context, application string) (Account, error)
+
+	// FindByID returns the account with the given payment service identifier. It returns ErrAccountNotFound if the
+	// account doesn't exist.
+	FindByID(ctx context.Context, id string) (Account, error)
+}
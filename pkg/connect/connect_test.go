@@ -0,0 +1,57 @@
+package connect
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	_, err := store.Find(ctx, "fuel")
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+
+	require.NoError(t, store.Save(ctx, Account{Application: "fuel", ID: "acct_1", Service: "stripe"}))
+	require.NoError(t, store.Save(ctx, Account{Application: "cloudsim", ID: "acct_2", Service: "stripe"}))
+
+	account, err := store.Find(ctx, "fuel")
+	require.NoError(t, err)
+	assert.Equal(t, "acct_1", account.ID)
+	assert.False(t, account.PayoutsEnabled)
+	assert.False(t, account.CreatedAt.IsZero())
+
+	account.PayoutsEnabled = true
+	account.EventCreated = time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, store.Save(ctx, account))
+
+	account, err = store.FindByID(ctx, "acct_1")
+	require.NoError(t, err)
+	assert.Equal(t, "fuel", account.Application)
+	assert.True(t, account.PayoutsEnabled)
+	assert.True(t, account.EventCreated.Equal(time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)))
+
+	_, err = store.FindByID(ctx, "acct_3")
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestGormStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	store, err := NewGormStore(db)
+	require.NoError(t, err)
+
+	testStore(t, store)
+}
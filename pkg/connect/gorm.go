@@ -0,0 +1,52 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+)
+
+// gormStore is a Store implementation that persists accounts in an SQL database.
+type gormStore struct {
+	// db is the database connection.
+	db *gorm.DB
+}
+
+// Save creates or updates the given account in the database.
+func (s *gormStore) Save(ctx context.Context, account Account) error {
+	return s.db.WithContext(ctx).Save(&account).Error
+}
+
+// Find returns the account of the given application.
+func (s *gormStore) Find(ctx context.Context, application string) (Account, error) {
+	return s.first(ctx, "application = ?", application)
+}
+
+// FindByID returns the account with the given payment service identifier.
+func (s *gormStore) FindByID(ctx context.Context, id string) (Account, error) {
+	return s.first(ctx, "id = ?", id)
+}
+
+// first returns the first account that matches the given conditions.
+func (s *gormStore) first(ctx context.Context, query string, args ...interface{}) (Account, error) {
+	var out Account
+	err := s.db.WithContext(ctx).Where(query, args...).First(&out).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Account{}, ErrAccountNotFound
+	}
+	if err != nil {
+		return Account{}, err
+	}
+	return out, nil
+}
+
+// NewGormStore initializes a new Store using the given database connection. The accounts table is migrated
+// automatically.
+func NewGormStore(db *gorm.DB) (Store, error) {
+	if err := db.AutoMigrate(&Account{}); err != nil {
+		return nil, err
+	}
+	return &gormStore{
+		db: db,
+	}, nil
+}
@@ -0,0 +1,68 @@
+package connect
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStore is an in-memory Store implementation. It's intended to be used in tests, accounts are lost on restart.
+type memoryStore struct {
+	// accounts contains the accounts indexed by application.
+	accounts map[string]Account
+
+	// lock is used to access accounts from multiple goroutines.
+	lock sync.RWMutex
+
+	// now returns the current time, it can be replaced in tests.
+	now func() time.Time
+}
+
+// Save creates or updates the given account in memory.
+func (s *memoryStore) Save(_ context.Context, account Account) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	if existing, ok := s.accounts[account.Application]; ok {
+		account.CreatedAt = existing.CreatedAt
+	} else if account.CreatedAt.IsZero() {
+		account.CreatedAt = now
+	}
+	account.UpdatedAt = now
+	s.accounts[account.Application] = account
+	return nil
+}
+
+// Find returns the account of the given application.
+func (s *memoryStore) Find(_ context.Context, application string) (Account, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	account, ok := s.accounts[application]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return account, nil
+}
+
+// FindByID returns the account with the given payment service identifier.
+func (s *memoryStore) FindByID(_ context.Context, id string) (Account, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, account := range s.accounts {
+		if account.ID == id {
+			return account, nil
+		}
+	}
+	return Account{}, ErrAccountNotFound
+}
+
+// NewMemoryStore initializes a new Store that keeps accounts in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		accounts: make(map[string]Account),
+		now:      time.Now,
+	}
+}
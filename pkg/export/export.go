@@ -0,0 +1,182 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"io"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrInvalidFormat is returned when an unsupported export format is requested.
+	ErrInvalidFormat = errors.New("invalid format")
+
+	// ErrInvalidTime is returned when a time value can't be parsed.
+	ErrInvalidTime = errors.New("invalid time, expected RFC 3339 or YYYY-MM-DD")
+
+	// ErrInvalidRange is returned when the end of a time window is before its beginning.
+	ErrInvalidRange = errors.New("invalid time range")
+)
+
+// Format is the file format used to export payments.
+type Format string
+
+const (
+	// FormatCSV exports payments as comma-separated values with a header row.
+	FormatCSV Format = "csv"
+	// FormatJSONL exports payments as JSON Lines, one JSON object per payment.
+	FormatJSONL Format = "jsonl"
+)
+
+// Validate returns an error if the format is not supported.
+func (f Format) Validate() error {
+	if f != FormatCSV && f != FormatJSONL {
+		return ErrInvalidFormat
+	}
+	return nil
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == FormatJSONL {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// Writer writes payments to an output in a certain Format.
+type Writer interface {
+	// Write writes the given payment.
+	Write(p adapter.Payment) error
+
+	// Flush writes any buffered data to the underlying output. CSV writers also write the header if no payment has
+	// been written yet.
+	Flush() error
+}
+
+// NewWriter initializes a new Writer for the given format.
+func NewWriter(format Format, out io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(out)}, nil
+	case FormatJSONL:
+		return &jsonlWriter{enc: json.NewEncoder(out)}, nil
+	}
+	return nil, ErrInvalidFormat
+}
+
+// csvHeader contains the column names of the CSV export.
+var csvHeader = []string{
+	"id", "charge", "balance_transaction", "session", "customer", "handle", "application",
+	"gross", "fee", "net", "tax", "currency", "settlement_currency", "refunded", "refund_status",
+	"created", "paid", "available",
+}
+
+// csvWriter is a Writer implementation for FormatCSV.
+type csvWriter struct {
+	// w is the underlying CSV writer.
+	w *csv.Writer
+
+	// header is true if the header row has already been written.
+	header bool
+}
+
+// Write writes the given payment as a CSV row. The header row is written first.
+func (c *csvWriter) Write(p adapter.Payment) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.w.Write([]string{
+		p.ID,
+		p.Charge,
+		p.BalanceTransaction,
+		p.Session,
+		p.Customer,
+		p.Handle,
+		p.Application,
+		strconv.FormatInt(p.Gross, 10),
+		strconv.FormatInt(p.Fee, 10),
+		strconv.FormatInt(p.Net, 10),
+		strconv.FormatInt(p.Tax, 10),
+		p.Currency,
+		p.SettlementCurrency,
+		strconv.FormatInt(p.Refunded, 10),
+		p.RefundStatus,
+		formatTime(p.Created),
+		formatTime(p.Paid),
+		formatTime(p.Available),
+	})
+}
+
+// Flush writes the buffered rows to the underlying output.
+func (c *csvWriter) Flush() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// writeHeader writes the header row if it hasn't been written yet.
+func (c *csvWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	return c.w.Write(csvHeader)
+}
+
+// jsonlWriter is a Writer implementation for FormatJSONL.
+type jsonlWriter struct {
+	// enc is used to write one JSON object per line.
+	enc *json.Encoder
+}
+
+// Write writes the given payment as a JSON object followed by a newline.
+func (j *jsonlWriter) Write(p adapter.Payment) error {
+	return j.enc.Encode(p)
+}
+
+// Flush is a no-op, payments are written as soon as they're received.
+func (j *jsonlWriter) Flush() error {
+	return nil
+}
+
+// formatTime formats the given time as RFC 3339. Zero times are formatted as an empty string.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ParseTime parses a time in RFC 3339 format, or a date in YYYY-MM-DD format in UTC.
+func ParseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, ErrInvalidTime
+}
+
+// ParseRange parses the beginning and end of a time window using ParseTime. The end of the window is exclusive, so
+// dates such as 2021-11-01 and 2021-12-01 export the payments of November.
+func ParseRange(from, to string) (time.Time, time.Time, error) {
+	start, err := ParseTime(from)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, err := ParseTime(to)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, ErrInvalidRange
+	}
+	return start, end.Add(-time.Second), nil
+}
@@ -0,0 +1,97 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"strings"
+	"testing"
+	"time"
+)
+
+func payment() adapter.Payment {
+	return adapter.Payment{
+		ID:                 "pi_123",
+		Charge:             "ch_123",
+		BalanceTransaction: "txn_123",
+		Session:            "cs_123",
+		Customer:           "cus_123",
+		Handle:             "test",
+		Application:        "fuel",
+		Gross:              1100,
+		Fee:                62,
+		Net:                1038,
+		Tax:                100,
+		Currency:           "usd",
+		SettlementCurrency: "usd",
+		RefundStatus:       adapter.RefundStatusNone,
+		Created:            time.Date(2021, 11, 2, 10, 0, 0, 0, time.UTC),
+		Paid:               time.Date(2021, 11, 2, 10, 1, 0, 0, time.UTC),
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatCSV, &buf)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(payment()))
+	require.NoError(t, w.Flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, strings.Join(csvHeader, ","), lines[0])
+	assert.Equal(t, "pi_123,ch_123,txn_123,cs_123,cus_123,test,fuel,1100,62,1038,100,usd,usd,0,none,2021-11-02T10:00:00Z,2021-11-02T10:01:00Z,", lines[1])
+}
+
+func TestCSVWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatCSV, &buf)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Flush())
+	assert.Equal(t, strings.Join(csvHeader, ",")+"\n", buf.String())
+}
+
+func TestJSONLWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatJSONL, &buf)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(payment()))
+	require.NoError(t, w.Write(payment()))
+	require.NoError(t, w.Flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var p adapter.Payment
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &p))
+	assert.Equal(t, payment(), p)
+}
+
+func TestInvalidFormat(t *testing.T) {
+	_, err := NewWriter("xlsx", &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrInvalidFormat)
+	assert.ErrorIs(t, Format("xlsx").Validate(), ErrInvalidFormat)
+	assert.NoError(t, FormatJSONL.Validate())
+}
+
+func TestParseRange(t *testing.T) {
+	from, to, err := ParseRange("2021-11-01", "2021-12-01")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2021, 11, 30, 23, 59, 59, 0, time.UTC), to)
+
+	from, _, err = ParseRange("2021-11-01T12:00:00+02:00", "2021-12-01")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 11, 1, 10, 0, 0, 0, time.UTC), from.UTC())
+
+	_, _, err = ParseRange("November", "2021-12-01")
+	assert.ErrorIs(t, err, ErrInvalidTime)
+
+	_, _, err = ParseRange("2021-12-01", "2021-11-01")
+	assert.ErrorIs(t, err, ErrInvalidRange)
+}
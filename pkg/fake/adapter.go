@@ -1,6 +1,7 @@
 package fake
 
 import (
+	"context"
 	"github.com/stretchr/testify/mock"
 	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
 	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
@@ -15,21 +16,33 @@ type Adapter struct {
 }
 
 // CreateCustomer mocks a CreateCustomer call.
-func (a *Adapter) CreateCustomer(application, handle string) (string, error) {
-	args := a.Called(application, handle)
+func (a *Adapter) CreateCustomer(ctx context.Context, application, handle string) (string, error) {
+	args := a.Called(ctx, application, handle)
 	return args.String(0), args.Error(1)
 }
 
+// DeleteCustomer mocks a DeleteCustomer call.
+func (a *Adapter) DeleteCustomer(ctx context.Context, id string) error {
+	args := a.Called(ctx, id)
+	return args.Error(0)
+}
+
 // CreateSession mocks a CreateSession call.
-func (a *Adapter) CreateSession(req api.CreateSessionRequest, cus customers.CustomerResponse) (api.CreateSessionResponse, error) {
-	args := a.Called(req, cus)
+func (a *Adapter) CreateSession(ctx context.Context, req api.CreateSessionRequest, cus customers.CustomerResponse) (api.CreateSessionResponse, error) {
+	args := a.Called(ctx, req, cus)
 	res := args.Get(0).(api.CreateSessionResponse)
 	return res, args.Error(1)
 }
 
 // GenerateChargeRequest mocks a GenerateChargeRequest call.
-func (a *Adapter) GenerateChargeRequest(body []byte, params map[string][]string) (api.ChargeRequest, error) {
-	args := a.Called(body, params)
+func (a *Adapter) GenerateChargeRequest(ctx context.Context, body []byte, params map[string][]string) (api.ChargeRequest, error) {
+	args := a.Called(ctx, body, params)
 	res := args.Get(0).(api.ChargeRequest)
 	return res, args.Error(1)
 }
+
+// MarkCharged mocks a MarkCharged call.
+func (a *Adapter) MarkCharged(ctx context.Context, req api.ChargeRequest) error {
+	args := a.Called(ctx, req)
+	return args.Error(0)
+}
@@ -0,0 +1,60 @@
+package fake
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+)
+
+var _ adapter.Catalog = (*Catalog)(nil)
+
+// Catalog is a fake implementation of adapter.Catalog.
+type Catalog struct {
+	mock.Mock
+}
+
+// ListProducts mocks a ListProducts call.
+func (c *Catalog) ListProducts(ctx context.Context) ([]adapter.Product, error) {
+	args := c.Called(ctx)
+	res := args.Get(0).([]adapter.Product)
+	return res, args.Error(1)
+}
+
+// CreateProduct mocks a CreateProduct call.
+func (c *Catalog) CreateProduct(ctx context.Context, product adapter.Product) error {
+	args := c.Called(ctx, product)
+	return args.Error(0)
+}
+
+// UpdateProduct mocks an UpdateProduct call.
+func (c *Catalog) UpdateProduct(ctx context.Context, product adapter.Product) error {
+	args := c.Called(ctx, product)
+	return args.Error(0)
+}
+
+// ListPrices mocks a ListPrices call.
+func (c *Catalog) ListPrices(ctx context.Context, product string) ([]adapter.Price, error) {
+	args := c.Called(ctx, product)
+	res := args.Get(0).([]adapter.Price)
+	return res, args.Error(1)
+}
+
+// CreatePrice mocks a CreatePrice call.
+func (c *Catalog) CreatePrice(ctx context.Context, price adapter.Price) (adapter.Price, error) {
+	args := c.Called(ctx, price)
+	res := args.Get(0).(adapter.Price)
+	return res, args.Error(1)
+}
+
+// ArchivePrice mocks an ArchivePrice call.
+func (c *Catalog) ArchivePrice(ctx context.Context, id string) error {
+	args := c.Called(ctx, id)
+	return args.Error(0)
+}
+
+// GetPrice mocks a GetPrice call.
+func (c *Catalog) GetPrice(ctx context.Context, lookupKey string) (adapter.Price, error) {
+	args := c.Called(ctx, lookupKey)
+	res := args.Get(0).(adapter.Price)
+	return res, args.Error(1)
+}
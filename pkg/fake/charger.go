@@ -0,0 +1,21 @@
+package fake
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+)
+
+var _ api.ChargerV1 = (*Charger)(nil)
+
+// Charger is a fake implementation of api.ChargerV1.
+type Charger struct {
+	mock.Mock
+}
+
+// Charge mocks a Charge call.
+func (c *Charger) Charge(ctx context.Context, req api.ChargeRequest) (api.ChargeResponse, error) {
+	args := c.Called(ctx, req)
+	res := args.Get(0).(api.ChargeResponse)
+	return res, args.Error(1)
+}
@@ -0,0 +1,40 @@
+package fake
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+)
+
+var _ adapter.Connector = (*Connector)(nil)
+
+// Connector is a fake implementation of adapter.Connector.
+type Connector struct {
+	mock.Mock
+}
+
+// CreateAccount mocks a CreateAccount call.
+func (c *Connector) CreateAccount(ctx context.Context, application string) (string, error) {
+	args := c.Called(ctx, application)
+	return args.String(0), args.Error(1)
+}
+
+// CreateAccountLink mocks a CreateAccountLink call.
+func (c *Connector) CreateAccountLink(ctx context.Context, id, refreshURL, returnURL string) (string, error) {
+	args := c.Called(ctx, id, refreshURL, returnURL)
+	return args.String(0), args.Error(1)
+}
+
+// Capture mocks a Capture call.
+func (c *Connector) Capture(ctx context.Context, req api.CaptureRequest) error {
+	args := c.Called(ctx, req)
+	return args.Error(0)
+}
+
+// GenerateConnectEvent mocks a GenerateConnectEvent call.
+func (c *Connector) GenerateConnectEvent(ctx context.Context, body []byte, params map[string][]string) (adapter.ConnectEvent, error) {
+	args := c.Called(ctx, body, params)
+	res := args.Get(0).(adapter.ConnectEvent)
+	return res, args.Error(1)
+}
@@ -0,0 +1,62 @@
+package fake
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"time"
+)
+
+var _ adapter.Inspector = (*Inspector)(nil)
+
+// Inspector is a fake implementation of adapter.Inspector.
+type Inspector struct {
+	mock.Mock
+}
+
+// GetSession mocks a GetSession call.
+func (i *Inspector) GetSession(ctx context.Context, id string) (adapter.Session, error) {
+	args := i.Called(ctx, id)
+	res := args.Get(0).(adapter.Session)
+	return res, args.Error(1)
+}
+
+// GetPaymentIntent mocks a GetPaymentIntent call.
+func (i *Inspector) GetPaymentIntent(ctx context.Context, id string) (adapter.PaymentIntent, error) {
+	args := i.Called(ctx, id)
+	res := args.Get(0).(adapter.PaymentIntent)
+	return res, args.Error(1)
+}
+
+// ListCharges mocks a ListCharges call.
+func (i *Inspector) ListCharges(ctx context.Context, from, to time.Time, limit int) ([]adapter.Charge, error) {
+	args := i.Called(ctx, from, to, limit)
+	res := args.Get(0).([]adapter.Charge)
+	return res, args.Error(1)
+}
+
+// GetChargeRequest mocks a GetChargeRequest call.
+func (i *Inspector) GetChargeRequest(ctx context.Context, eventID string) (api.ChargeRequest, error) {
+	args := i.Called(ctx, eventID)
+	res := args.Get(0).(api.ChargeRequest)
+	return res, args.Error(1)
+}
+
+// ListChargeEvents mocks a ListChargeEvents call.
+func (i *Inspector) ListChargeEvents(ctx context.Context, from, to time.Time) ([]adapter.ChargeEvent, error) {
+	args := i.Called(ctx, from, to)
+	res := args.Get(0).([]adapter.ChargeEvent)
+	return res, args.Error(1)
+}
+
+// ListPayments mocks a ListPayments call. The payments returned by the mock are passed to fn.
+func (i *Inspector) ListPayments(ctx context.Context, from, to time.Time, application string, fn func(adapter.Payment) error) error {
+	args := i.Called(ctx, from, to, application, fn)
+	for _, p := range args.Get(0).([]adapter.Payment) {
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
@@ -0,0 +1,29 @@
+package fake
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+)
+
+var _ api.PaymentsV1 = (*Payments)(nil)
+
+// Payments is a fake implementation of api.PaymentsV1. It can be used in place of a client.Client to test services
+// that depend on the payments API.
+type Payments struct {
+	mock.Mock
+}
+
+// CreateSession mocks a CreateSession call.
+func (p *Payments) CreateSession(ctx context.Context, req api.CreateSessionRequest) (api.CreateSessionResponse, error) {
+	args := p.Called(ctx, req)
+	res := args.Get(0).(api.CreateSessionResponse)
+	return res, args.Error(1)
+}
+
+// ListInvoices mocks a ListInvoices call.
+func (p *Payments) ListInvoices(ctx context.Context, req api.ListInvoicesRequest) (api.ListInvoicesResponse, error) {
+	args := p.Called(ctx, req)
+	res := args.Get(0).(api.ListInvoicesResponse)
+	return res, args.Error(1)
+}
@@ -0,0 +1,133 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync"
+	"time"
+)
+
+// Entry is a record stored in an SQL database.
+type Entry struct {
+	// ID is the idempotency key.
+	ID string `gorm:"primarykey;size:255"`
+
+	// Fingerprint identifies the request made with the key.
+	Fingerprint string `gorm:"size:64"`
+
+	// Response is the response returned to the request. It's empty while the request is being processed.
+	Response []byte
+
+	// ExpiresAt is the time the key is forgotten.
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// TableName returns the name of the table used to store entries.
+func (Entry) TableName() string {
+	return "idempotency_keys"
+}
+
+// gormStore is a Store implementation that records keys in an SQL database, allowing replicas to share them.
+type gormStore struct {
+	// db is the database connection.
+	db *gorm.DB
+
+	// now returns the current time.
+	now func() time.Time
+
+	// lock is used to synchronize access to pruned.
+	lock sync.Mutex
+
+	// pruned is the last time expired entries were deleted.
+	pruned time.Time
+}
+
+// gormPruneInterval is the time between consecutive deletions of expired entries from the database.
+const gormPruneInterval = time.Hour
+
+// Begin reserves the given key in the database. Expired entries of the same key are reused.
+func (s *gormStore) Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (Record, bool, error) {
+	now := s.now()
+	if err := s.prune(ctx, now); err != nil {
+		return Record{}, false, err
+	}
+	db := s.db.WithContext(ctx)
+
+	entry := Entry{ID: key, Fingerprint: fingerprint, ExpiresAt: now.Add(ttl)}
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry)
+	if res.Error != nil {
+		return Record{}, false, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return Record{}, true, nil
+	}
+
+	res = db.Model(&Entry{}).
+		Where("id = ? AND expires_at <= ?", key, now).
+		Updates(map[string]interface{}{
+			"fingerprint": fingerprint,
+			"response":    nil,
+			"expires_at":  now.Add(ttl),
+		})
+	if res.Error != nil {
+		return Record{}, false, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return Record{}, true, nil
+	}
+
+	var existing Entry
+	err := db.Where("id = ?", key).Take(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The key has been removed in the meantime, the request that held it failed.
+		return s.Begin(ctx, key, fingerprint, ttl)
+	}
+	if err != nil {
+		return Record{}, false, err
+	}
+	return Record{Fingerprint: existing.Fingerprint, Response: existing.Response}, false, nil
+}
+
+// Complete records the response of the given key in the database.
+func (s *gormStore) Complete(ctx context.Context, key string, response []byte, ttl time.Duration) error {
+	return s.db.WithContext(ctx).Model(&Entry{}).
+		Where("id = ?", key).
+		Updates(map[string]interface{}{
+			"response":   response,
+			"expires_at": s.now().Add(ttl),
+		}).Error
+}
+
+// Remove deletes the given key from the database.
+func (s *gormStore) Remove(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Delete(&Entry{}, "id = ?", key).Error
+}
+
+// prune deletes the expired entries from the database if they haven't been deleted recently.
+func (s *gormStore) prune(ctx context.Context, now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if now.Sub(s.pruned) < gormPruneInterval {
+		return nil
+	}
+	if err := s.db.WithContext(ctx).Delete(&Entry{}, "expires_at <= ?", now).Error; err != nil {
+		return err
+	}
+	s.pruned = now
+	return nil
+}
+
+// NewGormStore initializes a new Store using the given database connection. The entries table is migrated
+// automatically.
+func NewGormStore(db *gorm.DB) (Store, error) {
+	if err := db.AutoMigrate(&Entry{}); err != nil {
+		return nil, err
+	}
+	return &gormStore{
+		db:  db,
+		now: time.Now,
+	}, nil
+}
@@ -0,0 +1,37 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// Record is the outcome of a request made with an idempotency key.
+type Record struct {
+	// Fingerprint identifies the request made with the key. It's used to detect keys reused for different requests.
+	Fingerprint string
+
+	// Response is the response returned to the request. It's empty while the request is being processed.
+	Response []byte
+}
+
+// Completed returns true if the request has been processed and its response recorded.
+func (r Record) Completed() bool {
+	return len(r.Response) > 0
+}
+
+// Store remembers the responses returned to requests made with an idempotency key for a limited amount of time, so
+// clients retrying a request get the original response instead of performing the operation twice. Implementations
+// backed by a shared database allow detecting retries across multiple replicas.
+type Store interface {
+	// Begin reserves the given key for a request with the given fingerprint for the given amount of time. It returns
+	// true if the key was free. Otherwise, it returns false and the record of the request that holds the key.
+	// Reserving a key is atomic: only one of multiple concurrent calls with the same key returns true.
+	Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (Record, bool, error)
+
+	// Complete records the response of the request that holds the given key, and keeps it for the given amount of
+	// time.
+	Complete(ctx context.Context, key string, response []byte, ttl time.Duration) error
+
+	// Remove frees the given key. It's used when a request fails, so it can be retried with the same key.
+	Remove(ctx context.Context, key string) error
+}
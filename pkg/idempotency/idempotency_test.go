@@ -0,0 +1,106 @@
+package idempotency
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, store Store, now *time.Time) {
+	ctx := context.Background()
+
+	_, started, err := store.Begin(ctx, "key_1", "fp_1", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, started)
+
+	rec, started, err := store.Begin(ctx, "key_1", "fp_1", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, started)
+	assert.Equal(t, "fp_1", rec.Fingerprint)
+	assert.False(t, rec.Completed())
+
+	require.NoError(t, store.Complete(ctx, "key_1", []byte(`{"session":"cs_1"}`), time.Hour))
+	rec, started, err = store.Begin(ctx, "key_1", "fp_2", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, started)
+	assert.Equal(t, "fp_1", rec.Fingerprint)
+	assert.True(t, rec.Completed())
+	assert.Equal(t, `{"session":"cs_1"}`, string(rec.Response))
+
+	_, started, err = store.Begin(ctx, "key_2", "fp_2", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, started)
+	require.NoError(t, store.Remove(ctx, "key_2"))
+	_, started, err = store.Begin(ctx, "key_2", "fp_2", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, started)
+
+	// Completed keys are kept for the TTL given to Complete.
+	*now = now.Add(time.Minute)
+	_, started, err = store.Begin(ctx, "key_1", "fp_1", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, started)
+
+	*now = now.Add(time.Hour)
+	rec, started, err = store.Begin(ctx, "key_1", "fp_3", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, started)
+	assert.Equal(t, Record{}, rec)
+
+	rec, started, err = store.Begin(ctx, "key_1", "fp_3", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, started)
+	assert.Equal(t, "fp_3", rec.Fingerprint)
+	assert.False(t, rec.Completed())
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore().(*memoryStore)
+	store.now = func() time.Time {
+		return now
+	}
+
+	testStore(t, store, &now)
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore().(*memoryStore)
+	store.now = func() time.Time {
+		return now
+	}
+
+	_, _, err := store.Begin(context.Background(), "key_1", "fp_1", time.Second)
+	require.NoError(t, err)
+
+	now = now.Add(pruneInterval)
+	_, _, err = store.Begin(context.Background(), "key_2", "fp_2", time.Second)
+	require.NoError(t, err)
+
+	assert.Len(t, store.entries, 1)
+	assert.Contains(t, store.entries, "key_2")
+}
+
+func TestGormStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	s, err := NewGormStore(db)
+	require.NoError(t, err)
+
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	store := s.(*gormStore)
+	store.now = func() time.Time {
+		return now
+	}
+
+	testStore(t, store, &now)
+}
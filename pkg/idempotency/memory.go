@@ -0,0 +1,91 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryEntry is a record kept in memory.
+type memoryEntry struct {
+	record  Record
+	expires time.Time
+}
+
+// memoryStore is a Store implementation that keeps records in memory. Retries are only detected per replica.
+type memoryStore struct {
+	// lock is used to synchronize access to entries.
+	lock sync.Mutex
+
+	// entries contains the recorded keys.
+	entries map[string]memoryEntry
+
+	// pruned is the last time expired entries were removed.
+	pruned time.Time
+
+	// now returns the current time.
+	now func() time.Time
+}
+
+// pruneInterval is the time between consecutive removals of expired entries.
+const pruneInterval = time.Minute
+
+// Begin reserves the given key in memory.
+func (m *memoryStore) Begin(_ context.Context, key, fingerprint string, ttl time.Duration) (Record, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now()
+	m.prune(now)
+
+	if e, ok := m.entries[key]; ok && now.Before(e.expires) {
+		return e.record, false, nil
+	}
+	m.entries[key] = memoryEntry{
+		record:  Record{Fingerprint: fingerprint},
+		expires: now.Add(ttl),
+	}
+	return Record{}, true, nil
+}
+
+// Complete records the response of the given key in memory.
+func (m *memoryStore) Complete(_ context.Context, key string, response []byte, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e := m.entries[key]
+	e.record.Response = response
+	e.expires = m.now().Add(ttl)
+	m.entries[key] = e
+	return nil
+}
+
+// Remove forgets the given key.
+func (m *memoryStore) Remove(_ context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// prune removes expired entries.
+func (m *memoryStore) prune(now time.Time) {
+	if now.Sub(m.pruned) < pruneInterval {
+		return
+	}
+	for k, e := range m.entries {
+		if !now.Before(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+	m.pruned = now
+}
+
+// NewMemoryStore initializes a new Store that keeps records in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+	}
+}
@@ -0,0 +1,68 @@
+package ledger
+
+import (
+	"context"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"time"
+)
+
+// gormStore is a Store implementation that persists grants in an SQL database.
+type gormStore struct {
+	// db is the database connection.
+	db *gorm.DB
+}
+
+// Record persists the given grant in the database.
+func (s *gormStore) Record(ctx context.Context, grant Grant) error {
+	return s.db.WithContext(ctx).Create(&grant).Error
+}
+
+// List returns the grants recorded within the given time window.
+func (s *gormStore) List(ctx context.Context, from, to time.Time) ([]Grant, error) {
+	var out []Grant
+	err := s.db.WithContext(ctx).Model(&Grant{}).
+		Where("created_at >= ? AND created_at <= ?", from, to).
+		Order("created_at, id").
+		Find(&out).Error
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FindByPayment returns the grants recorded for the given payment.
+func (s *gormStore) FindByPayment(ctx context.Context, payment string) ([]Grant, error) {
+	var out []Grant
+	err := s.db.WithContext(ctx).Model(&Grant{}).
+		Where("payment = ?", payment).
+		Order("created_at, id").
+		Find(&out).Error
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// NewGormStore initializes a new Store using the given database connection. The grants table is migrated
+// automatically.
+func NewGormStore(db *gorm.DB) (Store, error) {
+	if err := db.AutoMigrate(&Grant{}); err != nil {
+		return nil, err
+	}
+	return &gormStore{
+		db: db,
+	}, nil
+}
+
+// OpenConn opens a database connection using the config provided from conf.Database.
+func OpenConn(config conf.Database) (*gorm.DB, error) {
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN: config.ToDSN(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
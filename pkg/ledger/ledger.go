@@ -0,0 +1,52 @@
+package ledger
+
+import (
+	"context"
+	"time"
+)
+
+// Grant is a record of the credits granted to a customer after a successful payment. A grant is recorded every
+// time the payments service calls the credits service to increase the credits of a customer.
+type Grant struct {
+	// ID is the identifier of the grant.
+	ID uint `json:"id" gorm:"primarykey"`
+
+	// CreatedAt is the time the grant was recorded.
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+
+	// Payment contains the identifier of the payment in the payment service. E.g. a Stripe payment intent.
+	Payment string `json:"payment" gorm:"index;size:255"`
+
+	// Event contains the identifier of the payment service event that originated this grant.
+	Event string `json:"event" gorm:"size:255"`
+
+	// Service is the payment service used to perform the payment.
+	Service string `json:"service" gorm:"size:64"`
+
+	// Customer is the identifier of the customer in the payment service.
+	Customer string `json:"customer" gorm:"size:255"`
+
+	// Handle is the customer identity in the context of the application.
+	Handle string `json:"handle" gorm:"size:255"`
+
+	// Application is the application the credits have been granted for.
+	Application string `json:"application" gorm:"size:255"`
+
+	// Amount is the amount paid in cents that has been converted to credits.
+	Amount uint `json:"amount"`
+
+	// Currency holds the ISO 4217 currency value in lowercase format.
+	Currency string `json:"currency" gorm:"size:3"`
+}
+
+// Store persists the grants sent to the credits service.
+type Store interface {
+	// Record persists the given grant.
+	Record(ctx context.Context, grant Grant) error
+
+	// List returns the grants recorded within the given time window, oldest first.
+	List(ctx context.Context, from, to time.Time) ([]Grant, error)
+
+	// FindByPayment returns the grants recorded for the given payment, oldest first.
+	FindByPayment(ctx context.Context, payment string) ([]Grant, error)
+}
@@ -0,0 +1,73 @@
+package ledger
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	grants := []Grant{
+		{CreatedAt: now.Add(-2 * time.Hour), Payment: "pi_1", Event: "evt_1", Handle: "test", Application: "fuel", Amount: 100, Currency: "usd"},
+		{CreatedAt: now.Add(-time.Hour), Payment: "pi_2", Event: "evt_2", Handle: "test", Application: "fuel", Amount: 200, Currency: "usd"},
+		{CreatedAt: now, Payment: "pi_2", Event: "evt_3", Handle: "test", Application: "fuel", Amount: 200, Currency: "usd"},
+	}
+	for _, g := range grants {
+		require.NoError(t, store.Record(ctx, g))
+	}
+
+	list, err := store.List(ctx, now.Add(-90*time.Minute), now)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "evt_2", list[0].Event)
+	assert.Equal(t, "evt_3", list[1].Event)
+	assert.NotZero(t, list[0].ID)
+
+	found, err := store.FindByPayment(ctx, "pi_2")
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, uint(200), found[0].Amount)
+
+	found, err = store.FindByPayment(ctx, "pi_3")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestMemoryStoreCreatedAt(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore().(*memoryStore)
+	store.now = func() time.Time {
+		return now
+	}
+
+	require.NoError(t, store.Record(context.Background(), Grant{Payment: "pi_1"}))
+
+	list, err := store.List(context.Background(), now, now)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, now, list[0].CreatedAt)
+}
+
+func TestGormStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	store, err := NewGormStore(db)
+	require.NoError(t, err)
+
+	testStore(t, store)
+}
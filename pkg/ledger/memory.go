@@ -0,0 +1,69 @@
+package ledger
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStore is an in-memory Store implementation. It's intended to be used in tests and single instance
+// deployments where losing the grant records on restart is acceptable.
+type memoryStore struct {
+	// grants contains the recorded grants in the order they were recorded.
+	grants []Grant
+
+	// lock is used to access grants from multiple goroutines.
+	lock sync.RWMutex
+
+	// now returns the current time, it can be replaced in tests.
+	now func() time.Time
+}
+
+// Record persists the given grant in memory.
+func (s *memoryStore) Record(_ context.Context, grant Grant) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	grant.ID = uint(len(s.grants) + 1)
+	if grant.CreatedAt.IsZero() {
+		grant.CreatedAt = s.now()
+	}
+	s.grants = append(s.grants, grant)
+	return nil
+}
+
+// List returns the grants recorded within the given time window.
+func (s *memoryStore) List(_ context.Context, from, to time.Time) ([]Grant, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var out []Grant
+	for _, g := range s.grants {
+		if g.CreatedAt.Before(from) || g.CreatedAt.After(to) {
+			continue
+		}
+		out = append(out, g)
+	}
+	return out, nil
+}
+
+// FindByPayment returns the grants recorded for the given payment.
+func (s *memoryStore) FindByPayment(_ context.Context, payment string) ([]Grant, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var out []Grant
+	for _, g := range s.grants {
+		if g.Payment == payment {
+			out = append(out, g)
+		}
+	}
+	return out, nil
+}
+
+// NewMemoryStore initializes a new in-memory Store.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		now: time.Now,
+	}
+}
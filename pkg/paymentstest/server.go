@@ -0,0 +1,487 @@
+// Package paymentstest provides an in-memory payments API test server. It serves the same HTTP routes as the payments
+// service and records the checkout sessions created through it, so services that depend on the payments API can test
+// their billing flows without running the payments service or a payment service such as Stripe.
+//
+// Tests create sessions with the client returned by Server.Client, or with any client pointed to Server.URL, and
+// simulate customers paying them with Server.Complete.
+package paymentstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/client"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/export"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultUnitPrice is the amount of cents a credit costs when Options.UnitPrice is not set.
+const DefaultUnitPrice = 100
+
+// Options contains the configuration of a Server.
+type Options struct {
+	// UnitPrice is the amount of cents a credit costs. Defaults to DefaultUnitPrice.
+	UnitPrice uint
+
+	// Authenticator is used to authenticate requests. Requests are not authenticated if nil.
+	Authenticator auth.Authenticator
+
+	// OnComplete is called when a session is completed with Server.Complete, as the payments service grants credits
+	// when a payment succeeds. An error returned by OnComplete is returned by Server.Complete, and the session is
+	// left open.
+	OnComplete func(Session) error
+}
+
+// Session is a checkout session created through the test server.
+type Session struct {
+	// ID is the session identifier returned to the caller.
+	ID string `json:"id"`
+
+	// Request is the request used to create the session. Its UnitPrice is filled by the server.
+	Request api.CreateSessionRequest `json:"request"`
+
+	// Created is the time the session was created.
+	Created time.Time `json:"created"`
+
+	// Completed is true if the session has been paid.
+	Completed bool `json:"completed"`
+
+	// CompletedAt is the time the session was paid.
+	CompletedAt time.Time `json:"completed_at"`
+
+	// Quantity is the amount of credits bought.
+	Quantity uint `json:"quantity"`
+
+	// Amount is the amount paid in cents.
+	Amount uint `json:"amount"`
+
+	// Currency holds the ISO 4217 currency value in lowercase format.
+	Currency string `json:"currency"`
+}
+
+// Server is an in-memory payments API test server. The OpenAPI specification is the only route of the payments
+// service it doesn't serve, and webhook events are acknowledged but ignored: payments are simulated with Complete.
+type Server struct {
+	// URL is the base URL of the server.
+	URL string
+
+	opts   Options
+	router chi.Router
+	server *httptest.Server
+
+	lock     sync.Mutex
+	seq      int
+	sessions map[string]*Session
+	order    []string
+	accounts map[string]api.Account
+	keys     map[string]string
+}
+
+// NewServer starts a new Server. It must be closed with Close when it's no longer needed.
+func NewServer(opts Options) *Server {
+	if opts.UnitPrice == 0 {
+		opts.UnitPrice = DefaultUnitPrice
+	}
+
+	s := &Server{
+		opts:     opts,
+		sessions: make(map[string]*Session),
+		accounts: make(map[string]api.Account),
+		keys:     make(map[string]string),
+	}
+
+	s.router = chi.NewRouter()
+	s.router.Use(middleware.RequestID)
+	s.router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, api.ErrNotFound)
+	})
+	s.router.Route("/payments", func(r chi.Router) {
+		r.Post("/webhooks/stripe", s.webhook)
+		r.Post("/webhooks/stripe/connect", s.webhook)
+		r.With(s.authenticate).Post("/session", s.createSession)
+		r.With(s.authenticate).Get("/export", s.exportPayments)
+		r.With(s.authenticate).Post("/connect/accounts", s.createAccountLink)
+		r.With(s.authenticate).Get("/connect/accounts/{application}", s.getAccount)
+	})
+
+	s.server = httptest.NewServer(s.router)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Handler returns the HTTP handler of the server, to be used without starting an HTTP server.
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Client returns a payments API client connected to the server.
+func (s *Server) Client(opts ...client.Option) client.Client {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		panic(fmt.Sprintf("paymentstest: invalid server URL %s: %v", s.URL, err))
+	}
+	return client.NewPaymentsClientV1(u, 10*time.Second, opts...)
+}
+
+// Sessions returns the sessions created through the server, oldest first.
+func (s *Server) Sessions() []Session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	out := make([]Session, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, *s.sessions[id])
+	}
+	return out
+}
+
+// Session returns the session identified by the given ID.
+func (s *Server) Session(id string) (Session, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	return *session, true
+}
+
+// Complete simulates a customer paying the given session, buying the given quantity of credits.
+func (s *Server) Complete(id string, quantity uint) (Session, error) {
+	if quantity == 0 {
+		return Session{}, errors.New("quantity must be greater than 0")
+	}
+
+	s.lock.Lock()
+	session, ok := s.sessions[id]
+	if !ok {
+		s.lock.Unlock()
+		return Session{}, fmt.Errorf("session not found: %s", id)
+	}
+	if session.Completed {
+		s.lock.Unlock()
+		return Session{}, fmt.Errorf("session %s has already been completed", id)
+	}
+	completed := *session
+	s.lock.Unlock()
+
+	completed.Completed = true
+	completed.CompletedAt = time.Now().UTC()
+	completed.Quantity = quantity
+	completed.Amount = quantity * completed.Request.UnitPrice
+	if s.opts.OnComplete != nil {
+		if err := s.opts.OnComplete(completed); err != nil {
+			return Session{}, err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	*s.sessions[id] = completed
+	return completed, nil
+}
+
+// SetAccount sets the connected account of an application, as if its owner had gone through the onboarding flow.
+func (s *Server) SetAccount(account api.Account) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accounts[account.Application] = account
+}
+
+// newID returns a new identifier with the given prefix. It must be called with the lock held.
+func (s *Server) newID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s_paymentstest%08d", prefix, s.seq)
+}
+
+// createSession records a new checkout session. Requests repeated with the same idempotency key get the session
+// created by the first request, as the payments service does.
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	var in api.CreateSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, r, api.WrapError(api.ErrorCodeMalformedRequest, err))
+		return
+	}
+	if err := s.authorize(r.Context(), in.Application); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	in.UnitPrice = s.opts.UnitPrice
+	if err := in.Validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.lock.Lock()
+	key := r.Header.Get(api.HeaderIdempotencyKey)
+	if id, ok := s.keys[key]; ok && len(key) > 0 {
+		session := *s.sessions[id]
+		s.lock.Unlock()
+		if session.Request != in {
+			writeError(w, r, api.WrapError(api.ErrorCodeConflict, errors.New("idempotency key has already been used for a different request")))
+			return
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeJSON(w, api.CreateSessionResponse{
+			Service: in.Service,
+			Session: session.ID,
+		})
+		return
+	}
+	session := &Session{
+		ID:       s.newID("cs"),
+		Request:  in,
+		Created:  time.Now().UTC(),
+		Currency: "usd",
+	}
+	s.sessions[session.ID] = session
+	s.order = append(s.order, session.ID)
+	if len(key) > 0 {
+		s.keys[key] = session.ID
+	}
+	s.lock.Unlock()
+
+	writeJSON(w, api.CreateSessionResponse{
+		Service: in.Service,
+		Session: session.ID,
+	})
+}
+
+// webhook acknowledges webhook events without processing them.
+func (s *Server) webhook(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(fmt.Sprintf("%s - Event ignored", http.StatusText(http.StatusOK))))
+}
+
+// exportPayments exports the completed sessions as payments, in the same formats as the payments service.
+func (s *Server) exportPayments(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, err := export.ParseRange(q.Get("from"), q.Get("to"))
+	if err != nil {
+		writeError(w, r, api.WrapError(api.ErrorCodeMalformedRequest, err))
+		return
+	}
+
+	format := export.FormatCSV
+	if f := q.Get("format"); len(f) > 0 {
+		format = export.Format(f)
+	}
+	if err = format.Validate(); err != nil {
+		writeError(w, r, api.WrapError(api.ErrorCodeMalformedRequest, err))
+		return
+	}
+
+	application := q.Get("application")
+	if err = s.authorize(r.Context(), application); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	writer, err := export.NewWriter(format, &buf)
+	if err != nil {
+		writeError(w, r, api.WrapError(api.ErrorCodeInternal, err))
+		return
+	}
+
+	var count int
+	for _, session := range s.Sessions() {
+		if !session.Completed || session.CompletedAt.Before(from) || !session.CompletedAt.Before(to) {
+			continue
+		}
+		if len(application) > 0 && session.Request.Application != application {
+			continue
+		}
+		if err = writer.Write(payment(session)); err != nil {
+			writeError(w, r, api.WrapError(api.ErrorCodeInternal, err))
+			return
+		}
+		count++
+	}
+	if count == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err = writer.Flush(); err != nil {
+		writeError(w, r, api.WrapError(api.ErrorCodeInternal, err))
+		return
+	}
+
+	name := fmt.Sprintf("payments-%s-%s.%s", from.Format("20060102"), to.Format("20060102"), format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// payment converts the given completed session into the payment exported by the payments service.
+func payment(session Session) adapter.Payment {
+	return adapter.Payment{
+		ID:                 "pi" + session.ID[2:],
+		Session:            session.ID,
+		Handle:             session.Request.Handle,
+		Application:        session.Request.Application,
+		Gross:              int64(session.Amount),
+		Net:                int64(session.Amount),
+		Currency:           session.Currency,
+		SettlementCurrency: session.Currency,
+		RefundStatus:       adapter.RefundStatusNone,
+		Created:            session.Created,
+		Paid:               session.CompletedAt,
+	}
+}
+
+// createAccountLink creates the connected account of an application if it doesn't exist yet.
+func (s *Server) createAccountLink(w http.ResponseWriter, r *http.Request) {
+	var in api.CreateAccountLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, r, api.WrapError(api.ErrorCodeMalformedRequest, err))
+		return
+	}
+	if err := s.authorize(r.Context(), in.Application); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := in.Validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.lock.Lock()
+	account, ok := s.accounts[in.Application]
+	if !ok {
+		account = api.Account{
+			Application: in.Application,
+			ID:          s.newID("acct"),
+		}
+		s.accounts[in.Application] = account
+	}
+	s.lock.Unlock()
+
+	writeJSON(w, api.CreateAccountLinkResponse{
+		Account: account,
+		URL:     "https://connect.example.com/setup/" + account.ID,
+	})
+}
+
+// getAccount returns the connected account of an application.
+func (s *Server) getAccount(w http.ResponseWriter, r *http.Request) {
+	application := chi.URLParam(r, "application")
+	if err := s.authorize(r.Context(), application); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.lock.Lock()
+	account, ok := s.accounts[application]
+	s.lock.Unlock()
+	if !ok {
+		writeError(w, r, api.ErrNotFound)
+		return
+	}
+	writeJSON(w, account)
+}
+
+// authenticate authenticates requests using Options.Authenticator, if set.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.Authenticator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, r, api.WrapError(api.ErrorCodeMalformedRequest, err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		id, err := s.opts.Authenticator.Authenticate(r, body)
+		if err != nil {
+			writeError(w, r, api.WrapError(api.ErrorCodeUnauthenticated, err))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+	})
+}
+
+// authorize returns an error if the caller can't act on behalf of the given application.
+func (s *Server) authorize(ctx context.Context, application string) error {
+	if s.opts.Authenticator == nil {
+		return nil
+	}
+	id, ok := auth.IdentityFromContext(ctx)
+	if !ok || !id.CanActFor(application) {
+		return api.WrapError(api.ErrorCodeForbidden, auth.ErrForbiddenApplication)
+	}
+	return nil
+}
+
+// writeJSON writes the given value as a JSON response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the given error as a JSON api.Error body, as the payments service does.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := api.ErrorCodeOf(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode(code))
+	_ = json.NewEncoder(w).Encode(api.Error{
+		Code:      code,
+		Message:   api.Message(err),
+		RequestID: middleware.GetReqID(r.Context()),
+	})
+}
+
+// statusCodes maps api.ErrorCode values to HTTP status codes, as the payments service does.
+var statusCodes = map[api.ErrorCode]int{
+	api.ErrorCodeEmptyService:       http.StatusUnprocessableEntity,
+	api.ErrorCodeInvalidService:     http.StatusUnprocessableEntity,
+	api.ErrorCodeEmptyCallbacks:     http.StatusUnprocessableEntity,
+	api.ErrorCodeInvalidURL:         http.StatusUnprocessableEntity,
+	api.ErrorCodeEmptyHandle:        http.StatusUnprocessableEntity,
+	api.ErrorCodeEmptyApplication:   http.StatusUnprocessableEntity,
+	api.ErrorCodeInvalidUnitPrice:   http.StatusBadGateway,
+	api.ErrorCodeConnectUnavailable: http.StatusUnprocessableEntity,
+	api.ErrorCodeMalformedRequest:   http.StatusBadRequest,
+	api.ErrorCodeUnauthenticated:    http.StatusUnauthorized,
+	api.ErrorCodeForbidden:          http.StatusForbidden,
+	api.ErrorCodeNotFound:           http.StatusNotFound,
+	api.ErrorCodeConflict:           http.StatusConflict,
+	api.ErrorCodeRateLimited:        http.StatusTooManyRequests,
+	api.ErrorCodeUpstream:           http.StatusBadGateway,
+	api.ErrorCodeProvider:           http.StatusBadGateway,
+	api.ErrorCodeTimeout:            http.StatusGatewayTimeout,
+	api.ErrorCodeInternal:           http.StatusInternalServerError,
+}
+
+// statusCode returns the HTTP status code for the given api.ErrorCode.
+func statusCode(code api.ErrorCode) int {
+	status, ok := statusCodes[code]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return status
+}
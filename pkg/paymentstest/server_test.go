@@ -0,0 +1,171 @@
+package paymentstest
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/client"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func sessionRequest(application string) api.CreateSessionRequest {
+	return api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  "https://app.example.com/success",
+		CancelURL:   "https://app.example.com/cancel",
+		Handle:      "alice",
+		Application: application,
+	}
+}
+
+func TestCreateAndCompleteSession(t *testing.T) {
+	var completed []Session
+	s := NewServer(Options{
+		UnitPrice: 150,
+		OnComplete: func(session Session) error {
+			completed = append(completed, session)
+			return nil
+		},
+	})
+	defer s.Close()
+
+	res, err := s.Client().CreateSession(context.Background(), sessionRequest("app"))
+	require.NoError(t, err)
+	assert.Equal(t, api.PaymentServiceStripe, res.Service)
+	assert.NotEmpty(t, res.Session)
+
+	sessions := s.Sessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, res.Session, sessions[0].ID)
+	assert.Equal(t, uint(150), sessions[0].Request.UnitPrice)
+	assert.False(t, sessions[0].Completed)
+
+	session, err := s.Complete(res.Session, 3)
+	require.NoError(t, err)
+	assert.True(t, session.Completed)
+	assert.Equal(t, uint(450), session.Amount)
+	require.Len(t, completed, 1)
+	assert.Equal(t, session, completed[0])
+
+	_, err = s.Complete(res.Session, 3)
+	assert.Error(t, err)
+
+	_, err = s.Complete("cs_unknown", 1)
+	assert.Error(t, err)
+}
+
+func TestCompleteError(t *testing.T) {
+	s := NewServer(Options{
+		OnComplete: func(Session) error {
+			return errors.New("credits unavailable")
+		},
+	})
+	defer s.Close()
+
+	res, err := s.Client().CreateSession(context.Background(), sessionRequest("app"))
+	require.NoError(t, err)
+
+	_, err = s.Complete(res.Session, 1)
+	assert.Error(t, err)
+
+	session, ok := s.Session(res.Session)
+	require.True(t, ok)
+	assert.False(t, session.Completed)
+}
+
+func TestCreateSessionInvalid(t *testing.T) {
+	s := NewServer(Options{})
+	defer s.Close()
+
+	req := sessionRequest("app")
+	req.Handle = ""
+	_, err := s.Client().CreateSession(context.Background(), req)
+	assert.Equal(t, api.ErrorCodeEmptyHandle, api.ErrorCodeOf(err))
+	assert.Empty(t, s.Sessions())
+}
+
+func TestAuthentication(t *testing.T) {
+	s := NewServer(Options{
+		Authenticator: auth.NewAPIKeyAuthenticator(map[string]string{"key": "app"}),
+	})
+	defer s.Close()
+
+	_, err := s.Client().CreateSession(context.Background(), sessionRequest("app"))
+	assert.Equal(t, api.ErrorCodeUnauthenticated, api.ErrorCodeOf(err))
+
+	c := s.Client(client.WithCredentials(auth.NewAPIKeySigner("key")))
+	_, err = c.CreateSession(context.Background(), sessionRequest("other"))
+	assert.Equal(t, api.ErrorCodeForbidden, api.ErrorCodeOf(err))
+
+	_, err = c.CreateSession(context.Background(), sessionRequest("app"))
+	assert.NoError(t, err)
+}
+
+func TestExport(t *testing.T) {
+	s := NewServer(Options{})
+	defer s.Close()
+
+	res, err := s.Client().CreateSession(context.Background(), sessionRequest("app"))
+	require.NoError(t, err)
+	_, err = s.Client().CreateSession(context.Background(), sessionRequest("app"))
+	require.NoError(t, err)
+	_, err = s.Complete(res.Session, 2)
+	require.NoError(t, err)
+
+	day := time.Now().UTC().Format("2006-01-02")
+	next := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+	r, err := http.Get(s.URL + "/payments/export?format=csv&from=" + day + "&to=" + next)
+	require.NoError(t, err)
+	defer r.Body.Close()
+	require.Equal(t, http.StatusOK, r.StatusCode)
+
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), res.Session)
+	assert.Contains(t, string(body), "alice")
+}
+
+func TestConnectAccounts(t *testing.T) {
+	s := NewServer(Options{})
+	defer s.Close()
+
+	r, err := http.Get(s.URL + "/payments/connect/accounts/app")
+	require.NoError(t, err)
+	r.Body.Close()
+	assert.Equal(t, http.StatusNotFound, r.StatusCode)
+
+	s.SetAccount(api.Account{Application: "app", ID: "acct_app"})
+	r, err = http.Get(s.URL + "/payments/connect/accounts/app")
+	require.NoError(t, err)
+	r.Body.Close()
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+}
+
+func TestStatusCode(t *testing.T) {
+	for _, code := range api.ErrorCodes() {
+		_, ok := statusCodes[code]
+		assert.True(t, ok, "missing HTTP status code for %s", code)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	s := NewServer(Options{})
+	defer s.Close()
+
+	ctx := client.WithIdempotencyKey(context.Background(), "purchase-1")
+	first, err := s.Client().CreateSession(ctx, sessionRequest("app"))
+	require.NoError(t, err)
+	second, err := s.Client().CreateSession(ctx, sessionRequest("app"))
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Len(t, s.Sessions(), 1)
+
+	_, err = s.Client().CreateSession(ctx, sessionRequest("other"))
+	assert.Equal(t, api.ErrorCodeConflict, api.ErrorCodeOf(err))
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry is a token bucket stored in an SQL database.
+type Entry struct {
+	// ID is the key of the bucket.
+	ID string `gorm:"primarykey;size:255"`
+
+	// Tokens is the amount of tokens available at the time of the last update.
+	Tokens float64
+
+	// Refilled is the last time the bucket was updated.
+	Refilled time.Time
+
+	// FullAt is the time the bucket becomes full again, when it's equivalent to a missing bucket.
+	FullAt time.Time `gorm:"index"`
+}
+
+// TableName returns the name of the table used to store entries.
+func (Entry) TableName() string {
+	return "rate_limit_buckets"
+}
+
+// gormStore is a Store implementation that keeps buckets in an SQL database, allowing replicas to share them.
+type gormStore struct {
+	// db is the database connection.
+	db *gorm.DB
+
+	// now returns the current time.
+	now func() time.Time
+
+	// lock is used to synchronize access to pruned.
+	lock sync.Mutex
+
+	// pruned is the last time full buckets were deleted.
+	pruned time.Time
+}
+
+// Take consumes a token from each of the buckets identified by the given keys. Buckets are locked until the
+// transaction finishes, so concurrent requests of different replicas are serialized.
+func (s *gormStore) Take(ctx context.Context, buckets ...Bucket) error {
+	now := s.now()
+	if err := s.prune(ctx, now); err != nil {
+		return err
+	}
+
+	// Rows are always inserted and locked in the same order to avoid deadlocks.
+	sorted := make([]Bucket, len(buckets))
+	copy(sorted, buckets)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	keys := make([]string, len(sorted))
+	for i, b := range sorted {
+		keys[i] = b.Key
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, b := range sorted {
+			entry := Entry{ID: b.Key, Tokens: float64(b.Limit.Burst), Refilled: now, FullAt: now}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error; err != nil {
+				return err
+			}
+		}
+
+		var entries []Entry
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", keys).Order("id").Find(&entries).Error
+		if err != nil {
+			return err
+		}
+		byKey := make(map[string]Entry, len(entries))
+		for _, e := range entries {
+			byKey[e.ID] = e
+		}
+
+		bs := make([]*bucket, len(buckets))
+		for i, b := range buckets {
+			bs[i] = &bucket{tokens: float64(b.Limit.Burst), updated: now, limit: b.Limit}
+			// Buckets may have been pruned in the meantime, which is equivalent to a full bucket.
+			if e, ok := byKey[b.Key]; ok {
+				bs[i].tokens, bs[i].updated = e.Tokens, e.Refilled
+			}
+		}
+		if err = takeAll(now, bs, buckets); err != nil {
+			return err
+		}
+
+		for i, b := range bs {
+			fullAt := now.Add(time.Duration((float64(b.limit.Burst) - b.tokens) / b.limit.Rate * float64(time.Second)))
+			entry := Entry{ID: buckets[i].Key, Tokens: b.tokens, Refilled: now, FullAt: fullAt}
+			if err = tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&entry).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// prune deletes the full buckets from the database if they haven't been deleted recently.
+func (s *gormStore) prune(ctx context.Context, now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if now.Sub(s.pruned) < pruneInterval {
+		return nil
+	}
+	if err := s.db.WithContext(ctx).Delete(&Entry{}, "full_at <= ?", now).Error; err != nil {
+		return err
+	}
+	s.pruned = now
+	return nil
+}
+
+// NewGormStore initializes a new Store that keeps token buckets in the given database. The buckets table is migrated
+// automatically.
+func NewGormStore(db *gorm.DB) (Store, error) {
+	if err := db.AutoMigrate(&Entry{}); err != nil {
+		return nil, err
+	}
+	return &gormStore{
+		db:  db,
+		now: time.Now,
+	}, nil
+}
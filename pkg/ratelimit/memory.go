@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// bucket is an in-memory token bucket.
+type bucket struct {
+	// tokens is the amount of tokens available at the time of the last update.
+	tokens float64
+
+	// updated is the last time the bucket was updated.
+	updated time.Time
+
+	// limit is the limit used the last time the bucket was updated.
+	limit Limit
+}
+
+// refill adds the tokens accumulated since the last update.
+func (b *bucket) refill(now time.Time, limit Limit) {
+	elapsed := now.Sub(b.updated).Seconds()
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	b.updated = now
+	b.limit = limit
+}
+
+// full returns true if the bucket would be full at the given time.
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
+
+// wait returns the amount of time until the bucket has a token available.
+func (b *bucket) wait() time.Duration {
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// takeAll refills the given buckets and consumes a token from each of them, as long as all of them have one.
+func takeAll(now time.Time, buckets []*bucket, limits []Bucket) error {
+	for i, b := range buckets {
+		b.refill(now, limits[i].Limit)
+		if b.tokens < 1 {
+			return &ExceededError{
+				Key:        limits[i].Key,
+				RetryAfter: b.wait(),
+			}
+		}
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return nil
+}
+
+// memoryStore is a Store implementation that keeps buckets in memory. Limits are enforced per replica.
+type memoryStore struct {
+	// lock is used to synchronize access to buckets.
+	lock sync.Mutex
+
+	// buckets contains the buckets indexed by key.
+	buckets map[string]*bucket
+
+	// pruned is the last time full buckets were removed.
+	pruned time.Time
+
+	// now returns the current time.
+	now func() time.Time
+}
+
+// pruneInterval is the time between consecutive removals of full buckets.
+const pruneInterval = time.Minute
+
+// Take consumes a token from each of the buckets identified by the given keys.
+func (m *memoryStore) Take(_ context.Context, buckets ...Bucket) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now()
+	m.prune(now)
+
+	bs := make([]*bucket, len(buckets))
+	for i, l := range buckets {
+		b, ok := m.buckets[l.Key]
+		if !ok {
+			b = &bucket{tokens: float64(l.Limit.Burst), updated: now, limit: l.Limit}
+			m.buckets[l.Key] = b
+		}
+		bs[i] = b
+	}
+	return takeAll(now, bs, buckets)
+}
+
+// prune removes full buckets, as they are equivalent to a missing bucket.
+func (m *memoryStore) prune(now time.Time) {
+	if now.Sub(m.pruned) < pruneInterval {
+		return
+	}
+	for k, b := range m.buckets {
+		if b.full(now) {
+			delete(m.buckets, k)
+		}
+	}
+	m.pruned = now
+}
+
+// NewMemoryStore initializes a new Store that keeps token buckets in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Limit defines the size and refill rate of a token bucket.
+type Limit struct {
+	// Rate is the amount of tokens added to the bucket every second.
+	Rate float64
+
+	// Burst is the maximum amount of tokens the bucket can hold.
+	Burst int
+}
+
+// Enabled returns true if the current limit should be enforced.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// PerMinute returns a Limit that allows the given amount of requests per minute with the given burst.
+func PerMinute(requests uint, burst uint) Limit {
+	return Limit{
+		Rate:  float64(requests) / 60,
+		Burst: int(burst),
+	}
+}
+
+// Bucket identifies a token bucket and the limit applied to it.
+type Bucket struct {
+	// Key identifies the bucket.
+	Key string
+
+	// Limit is the size and refill rate of the bucket.
+	Limit Limit
+}
+
+// Store keeps track of token buckets. Implementations backed by a shared database allow enforcing limits across
+// multiple replicas.
+type Store interface {
+	// Take consumes a token from each of the given buckets, creating full buckets if they don't exist. Tokens are only
+	// consumed if every bucket has one available, otherwise it returns an *ExceededError for the first empty bucket.
+	Take(ctx context.Context, buckets ...Bucket) error
+}
+
+// ExceededError is returned when a rate limit has been exceeded.
+type ExceededError struct {
+	// Key identifies the bucket that has been exhausted.
+	Key string
+
+	// RetryAfter is the amount of time until the next request is allowed.
+	RetryAfter time.Duration
+}
+
+// Error returns the error message.
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s, retry after %s", e.Key, e.RetryAfter)
+}
+
+// Limiter enforces rate limits per application and per application handle.
+type Limiter struct {
+	// store keeps track of token buckets.
+	store Store
+
+	// application is the limit applied to each application.
+	application Limit
+
+	// handle is the limit applied to each handle within an application.
+	handle Limit
+}
+
+// Allow consumes a token from the application and the handle buckets. It returns an *ExceededError if any of the
+// limits has been exceeded, in which case no token is consumed.
+func (l *Limiter) Allow(ctx context.Context, application, handle string) error {
+	var buckets []Bucket
+	if l.application.Enabled() {
+		buckets = append(buckets, Bucket{Key: "application:" + application, Limit: l.application})
+	}
+	if l.handle.Enabled() {
+		buckets = append(buckets, Bucket{Key: fmt.Sprintf("handle:%s/%s", application, handle), Limit: l.handle})
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	return l.store.Take(ctx, buckets...)
+}
+
+// NewLimiter initializes a new Limiter using the given store. Limits that are not enabled are not enforced.
+func NewLimiter(store Store, application, handle Limit) *Limiter {
+	return &Limiter{
+		store:       store,
+		application: application,
+		handle:      handle,
+	}
+}
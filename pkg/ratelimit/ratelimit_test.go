@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+	"time"
+)
+
+func testLimiterPerHandle(t *testing.T, store Store, now *time.Time) {
+	l := NewLimiter(store, Limit{}, PerMinute(60, 2))
+	ctx := context.Background()
+
+	require.NoError(t, l.Allow(ctx, "fuel", "test"))
+	require.NoError(t, l.Allow(ctx, "fuel", "test"))
+
+	err := l.Allow(ctx, "fuel", "test")
+	var exceeded *ExceededError
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, "handle:fuel/test", exceeded.Key)
+	assert.Equal(t, time.Second, exceeded.RetryAfter)
+
+	// Other handles are not affected
+	assert.NoError(t, l.Allow(ctx, "fuel", "other"))
+
+	// A token is added every second
+	*now = now.Add(time.Second)
+	assert.NoError(t, l.Allow(ctx, "fuel", "test"))
+	assert.Error(t, l.Allow(ctx, "fuel", "test"))
+}
+
+func testLimiterDenied(t *testing.T, store Store) {
+	l := NewLimiter(store, PerMinute(1, 2), PerMinute(1, 1))
+	ctx := context.Background()
+
+	require.NoError(t, l.Allow(ctx, "fuel", "test"))
+
+	// The application token is not spent when the handle limit denies the request.
+	err := l.Allow(ctx, "fuel", "test")
+	var exceeded *ExceededError
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, "handle:fuel/test", exceeded.Key)
+
+	require.NoError(t, l.Allow(ctx, "fuel", "other"))
+	err = l.Allow(ctx, "fuel", "another")
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, "application:fuel", exceeded.Key)
+}
+
+func TestLimiterPerHandle(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore().(*memoryStore)
+	store.now = func() time.Time { return now }
+
+	testLimiterPerHandle(t, store, &now)
+}
+
+func TestLimiterDenied(t *testing.T) {
+	testLimiterDenied(t, NewMemoryStore())
+}
+
+func TestLimiterPerApplication(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), PerMinute(1, 1), Limit{})
+	ctx := context.Background()
+
+	require.NoError(t, l.Allow(ctx, "fuel", "test"))
+
+	err := l.Allow(ctx, "fuel", "other")
+	var exceeded *ExceededError
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, "application:fuel", exceeded.Key)
+
+	assert.NoError(t, l.Allow(ctx, "cloudsim", "test"))
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), Limit{}, Limit{})
+	for i := 0; i < 100; i++ {
+		require.NoError(t, l.Allow(context.Background(), "fuel", "test"))
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore().(*memoryStore)
+	store.now = func() time.Time { return now }
+
+	err := store.Take(context.Background(), Bucket{Key: "test", Limit: PerMinute(60, 1)})
+	require.NoError(t, err)
+	assert.Len(t, store.buckets, 1)
+
+	now = now.Add(2 * pruneInterval)
+	err = store.Take(context.Background(), Bucket{Key: "other", Limit: PerMinute(60, 1)})
+	require.NoError(t, err)
+	assert.Len(t, store.buckets, 1)
+}
+
+func newGormStore(t *testing.T, now *time.Time) *gormStore {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	s, err := NewGormStore(db)
+	require.NoError(t, err)
+
+	store := s.(*gormStore)
+	store.now = func() time.Time { return *now }
+	return store
+}
+
+func TestGormStore(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	testLimiterPerHandle(t, newGormStore(t, &now), &now)
+}
+
+func TestGormStoreDenied(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	testLimiterDenied(t, newGormStore(t, &now))
+}
+
+func TestGormStorePrune(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	store := newGormStore(t, &now)
+
+	require.NoError(t, store.Take(context.Background(), Bucket{Key: "test", Limit: PerMinute(60, 1)}))
+
+	now = now.Add(2 * pruneInterval)
+	require.NoError(t, store.Take(context.Background(), Bucket{Key: "other", Limit: PerMinute(60, 1)}))
+
+	var count int64
+	require.NoError(t, store.db.Model(&Entry{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestGormStoreLockOrder(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	store := newGormStore(t, &now)
+
+	// Buckets are inserted in the same order whatever the order of the caller, they're updated once locked
+	var inserted []string
+	require.NoError(t, store.db.Callback().Create().Before("gorm:create").Register("test:order", func(db *gorm.DB) {
+		if e, ok := db.Statement.Dest.(*Entry); ok {
+			inserted = append(inserted, e.ID)
+		}
+	}))
+	require.NoError(t, store.Take(context.Background(),
+		Bucket{Key: "c", Limit: PerMinute(60, 3)},
+		Bucket{Key: "a", Limit: PerMinute(60, 1)},
+		Bucket{Key: "b", Limit: PerMinute(60, 2)},
+	))
+	require.Len(t, inserted, 6)
+	assert.Equal(t, []string{"a", "b", "c"}, inserted[:3])
+}
@@ -0,0 +1,43 @@
+package reconcile
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// csvHeader contains the column names of the CSV report.
+var csvHeader = []string{
+	"kind", "payment", "charge", "customer", "handle", "application", "currency",
+	"charged", "refunded", "granted", "grants", "repaired", "error",
+}
+
+// WriteCSV writes the entries of the given report to out in CSV format, including a header row.
+func WriteCSV(out io.Writer, report Report) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range report.Entries {
+		err := w.Write([]string{
+			string(e.Kind),
+			e.Payment,
+			e.Charge,
+			e.Customer,
+			e.Handle,
+			e.Application,
+			e.Currency,
+			strconv.FormatInt(e.Charged, 10),
+			strconv.FormatInt(e.Refunded, 10),
+			strconv.FormatUint(uint64(e.Granted), 10),
+			strconv.Itoa(e.Grants),
+			strconv.FormatBool(e.Repaired),
+			e.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,378 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ledger"
+	"io"
+	"log"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrMissingApplication is returned when a missing grant cannot be repaired because the payment doesn't include
+	// the application that originated it.
+	ErrMissingApplication = errors.New("missing application")
+
+	// ErrAlreadyCharged is returned when a missing grant is not repaired because the payment has been marked as
+	// charged in the payment service, e.g. because its grant couldn't be recorded in the ledger.
+	ErrAlreadyCharged = errors.New("payment already charged")
+
+	// ErrBeforeLedger is returned when a missing grant is not repaired because the payment has been created before the
+	// ledger started recording every grant. Its grant may be missing because it was never recorded, and the payment
+	// may not have been marked as charged either, so it must be checked manually.
+	ErrBeforeLedger = errors.New("payment created before the ledger cut-off")
+
+	// ErrRefunded is returned when a missing grant is not repaired because the payment has been refunded, fully or
+	// partially.
+	ErrRefunded = errors.New("payment refunded")
+)
+
+// statusSucceeded is the status of charges and payments that have been completed.
+const statusSucceeded = "succeeded"
+
+// Kind identifies the type of discrepancy found between payments and grants.
+type Kind string
+
+const (
+	// KindMissingGrant is used for successful payments that didn't produce any grant.
+	KindMissingGrant Kind = "missing_grant"
+	// KindMissingPayment is used for grants that don't have a successful payment behind them.
+	KindMissingPayment Kind = "missing_payment"
+	// KindDuplicatedGrant is used for payments that produced more than one grant.
+	KindDuplicatedGrant Kind = "duplicated_grant"
+	// KindAmountMismatch is used for payments whose grant doesn't match the amount charged.
+	KindAmountMismatch Kind = "amount_mismatch"
+	// KindRefunded is used for payments that have been refunded after credits were granted.
+	KindRefunded Kind = "refunded"
+)
+
+// Entry is a discrepancy found between a payment and its grants.
+type Entry struct {
+	// Kind is the type of discrepancy.
+	Kind Kind `json:"kind"`
+
+	// Payment contains the identifier of the payment in the payment service. E.g. a Stripe payment intent.
+	Payment string `json:"payment"`
+
+	// Charge contains the identifier of the charge in the payment service, if any.
+	Charge string `json:"charge,omitempty"`
+
+	// Customer is the identifier of the customer in the payment service.
+	Customer string `json:"customer,omitempty"`
+
+	// Handle is the customer identity in the context of the application.
+	Handle string `json:"handle,omitempty"`
+
+	// Application is the application that originated the payment.
+	Application string `json:"application,omitempty"`
+
+	// Currency holds the ISO 4217 currency value in lowercase format.
+	Currency string `json:"currency,omitempty"`
+
+	// Charged is the amount charged in cents.
+	Charged int64 `json:"charged"`
+
+	// Refunded is the amount refunded in cents.
+	Refunded int64 `json:"refunded"`
+
+	// Granted is the total amount in cents that has been converted to credits.
+	Granted uint `json:"granted"`
+
+	// Grants is the amount of grants recorded for the payment.
+	Grants int `json:"grants"`
+
+	// Repaired is true if the discrepancy has been repaired.
+	Repaired bool `json:"repaired"`
+
+	// Error contains the reason why the discrepancy couldn't be checked or repaired.
+	Error string `json:"error,omitempty"`
+}
+
+// Report contains the result of a reconciliation run.
+type Report struct {
+	// From is the beginning of the time window checked.
+	From time.Time `json:"from"`
+
+	// To is the end of the time window checked.
+	To time.Time `json:"to"`
+
+	// Charges is the amount of successful charges found in the time window.
+	Charges int `json:"charges"`
+
+	// Grants is the amount of grants recorded in the time window.
+	Grants int `json:"grants"`
+
+	// Entries contains the discrepancies found.
+	Entries []Entry `json:"entries"`
+}
+
+// Unresolved returns the amount of discrepancies that haven't been repaired.
+func (r Report) Unresolved() int {
+	var n int
+	for _, e := range r.Entries {
+		if !e.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Options contains a set of components needed to configure a Reconciler.
+type Options struct {
+	// Inspector is used to read the charges and payments from the payment service.
+	Inspector adapter.Inspector
+
+	// Ledger contains the grants sent to the credits service.
+	Ledger ledger.Store
+
+	// Charger is used to repair missing grants. It's usually the same application.Service used by the webhook
+	// handler.
+	Charger api.ChargerV1
+
+	// Since is the time since which every grant has been recorded in the ledger. Missing grants of payments created
+	// before it are not repaired. If zero, no missing grant is repaired.
+	Since time.Time
+
+	// Logger contains a logger mechanism. If set to nil, it defaults to a logger pointing to io.Discard.
+	Logger *log.Logger
+}
+
+// Reconciler compares the payments performed in a payment service with the credits granted to customers.
+type Reconciler struct {
+	// inspector is used to read the charges and payments from the payment service.
+	inspector adapter.Inspector
+
+	// ledger contains the grants sent to the credits service.
+	ledger ledger.Store
+
+	// charger is used to repair missing grants.
+	charger api.ChargerV1
+
+	// since is the time since which every grant has been recorded in the ledger.
+	since time.Time
+
+	// logger is used to log relevant information when running a reconciliation.
+	logger *log.Logger
+}
+
+// Run compares the successful charges performed within the given time window with the grants recorded in the ledger.
+// If repair is true, missing grants are processed using the Charger, unless the payment has been refunded, created
+// before the ledger cut-off or already charged.
+func (r *Reconciler) Run(ctx context.Context, from, to time.Time, repair bool) (Report, error) {
+	r.logger.Printf("Running reconciliation from %s to %s (repair: %t)\n", from.Format(time.RFC3339), to.Format(time.RFC3339), repair)
+
+	charges, err := r.inspector.ListCharges(ctx, from, to, 0)
+	if err != nil {
+		return Report{}, err
+	}
+
+	grants, err := r.ledger.List(ctx, from, to)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{
+		From:    from,
+		To:      to,
+		Grants:  len(grants),
+		Entries: []Entry{},
+	}
+
+	byPayment := make(map[string][]ledger.Grant)
+	for _, g := range grants {
+		byPayment[g.Payment] = append(byPayment[g.Payment], g)
+	}
+
+	checked := make(map[string]bool)
+	for _, c := range charges {
+		if c.Status != statusSucceeded || len(c.PaymentIntent) == 0 {
+			continue
+		}
+		report.Charges++
+		checked[c.PaymentIntent] = true
+
+		// Grants may have been recorded after the time window, e.g. if the event was backfilled.
+		gs, ok := byPayment[c.PaymentIntent]
+		if !ok {
+			if gs, err = r.ledger.FindByPayment(ctx, c.PaymentIntent); err != nil {
+				return Report{}, err
+			}
+		}
+
+		for _, e := range r.checkCharge(c, gs) {
+			if e.Kind == KindMissingGrant && repair {
+				r.repair(ctx, c, &e)
+			}
+			report.Entries = append(report.Entries, e)
+		}
+	}
+
+	// Grants of payments that weren't charged within the time window
+	payments := make([]string, 0, len(byPayment))
+	for p := range byPayment {
+		if !checked[p] {
+			payments = append(payments, p)
+		}
+	}
+	sort.Strings(payments)
+
+	for _, p := range payments {
+		e, err := r.checkGrants(ctx, p)
+		if err != nil {
+			return Report{}, err
+		}
+		if e != nil {
+			report.Entries = append(report.Entries, *e)
+		}
+	}
+
+	r.logger.Printf("Reconciliation finished. Charges: %d, Grants: %d, Discrepancies: %d, Unresolved: %d\n",
+		report.Charges, report.Grants, len(report.Entries), report.Unresolved())
+
+	return report, nil
+}
+
+// checkCharge returns the discrepancies found between the given charge and the grants of its payment.
+func (r *Reconciler) checkCharge(c adapter.Charge, grants []ledger.Grant) []Entry {
+	base := Entry{
+		Payment:     c.PaymentIntent,
+		Charge:      c.ID,
+		Customer:    c.Customer,
+		Handle:      c.Metadata["handle"],
+		Application: c.Metadata["application"],
+		Currency:    c.Currency,
+		Charged:     c.Amount,
+		Refunded:    c.AmountRefunded,
+		Grants:      len(grants),
+	}
+	for _, g := range grants {
+		base.Granted += g.Amount
+		if len(g.Handle) > 0 {
+			base.Handle = g.Handle
+		}
+	}
+
+	var out []Entry
+	add := func(kind Kind) {
+		e := base
+		e.Kind = kind
+		out = append(out, e)
+	}
+
+	switch {
+	case len(grants) == 0:
+		add(KindMissingGrant)
+		return out
+	case len(grants) > 1:
+		add(KindDuplicatedGrant)
+	case int64(grants[0].Amount) != c.Amount || grants[0].Currency != c.Currency:
+		add(KindAmountMismatch)
+	}
+
+	if c.AmountRefunded > 0 {
+		add(KindRefunded)
+	}
+	return out
+}
+
+// checkGrants checks the grants of a payment that hasn't been charged within the reconciliation time window.
+func (r *Reconciler) checkGrants(ctx context.Context, payment string) (*Entry, error) {
+	grants, err := r.ledger.FindByPayment(ctx, payment)
+	if err != nil {
+		return nil, err
+	}
+
+	e := Entry{
+		Payment: payment,
+		Grants:  len(grants),
+	}
+	for _, g := range grants {
+		e.Granted += g.Amount
+		e.Customer = g.Customer
+		e.Handle = g.Handle
+		e.Application = g.Application
+		e.Currency = g.Currency
+	}
+
+	pi, err := r.inspector.GetPaymentIntent(ctx, payment)
+	switch {
+	case err != nil:
+		e.Kind = KindMissingPayment
+		e.Error = err.Error()
+	case pi.Status != statusSucceeded:
+		e.Kind = KindMissingPayment
+		e.Charged = 0
+	case len(grants) > 1:
+		e.Kind = KindDuplicatedGrant
+		e.Charged = pi.Amount
+	default:
+		return nil, nil
+	}
+	return &e, nil
+}
+
+// repair processes the charge of a payment that didn't produce any grant.
+func (r *Reconciler) repair(ctx context.Context, c adapter.Charge, e *Entry) {
+	if len(e.Application) == 0 {
+		e.Error = ErrMissingApplication.Error()
+		return
+	}
+	if c.Refunded || c.AmountRefunded > 0 {
+		e.Error = ErrRefunded.Error()
+		return
+	}
+	if r.since.IsZero() || c.Created.Before(r.since) {
+		e.Error = ErrBeforeLedger.Error()
+		return
+	}
+
+	// Grants may fail to be recorded after the credits have been granted, so the payment is checked to avoid granting
+	// credits twice.
+	pi, err := r.inspector.GetPaymentIntent(ctx, c.PaymentIntent)
+	if err != nil {
+		r.logger.Println("Failed to read payment of missing grant:", c.PaymentIntent, err)
+		e.Error = err.Error()
+		return
+	}
+	if event, ok := pi.Metadata[adapter.MetadataChargedEvent]; ok {
+		e.Error = fmt.Errorf("%w by event %s", ErrAlreadyCharged, event).Error()
+		return
+	}
+
+	// Charges repaired by reconciliation are not originated by an event, the charge ID is used instead.
+	_, err = r.charger.Charge(ctx, api.ChargeRequest{
+		Amount:      uint(c.Amount),
+		Currency:    c.Currency,
+		Customer:    c.Customer,
+		Service:     api.PaymentServiceStripe,
+		Application: e.Application,
+		Event:       c.ID,
+		Payment:     c.PaymentIntent,
+	})
+	if err != nil {
+		r.logger.Println("Failed to repair missing grant:", c.PaymentIntent, err)
+		e.Error = err.Error()
+		return
+	}
+	r.logger.Println("Repaired missing grant:", c.PaymentIntent)
+	e.Repaired = true
+}
+
+// NewReconciler initializes a new Reconciler.
+func NewReconciler(opts Options) *Reconciler {
+	if opts.Logger == nil {
+		opts.Logger = log.New(io.Discard, "", log.LstdFlags)
+	}
+	return &Reconciler{
+		inspector: opts.Inspector,
+		ledger:    opts.Ledger,
+		charger:   opts.Charger,
+		since:     opts.Since,
+		logger:    opts.Logger,
+	}
+}
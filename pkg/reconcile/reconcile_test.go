@@ -0,0 +1,266 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/fake"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ledger"
+	"strings"
+	"testing"
+	"time"
+)
+
+func charge(id, payment string, amount int64) adapter.Charge {
+	return adapter.Charge{
+		ID:            id,
+		Customer:      "cus_123",
+		PaymentIntent: payment,
+		Status:        "succeeded",
+		Amount:        amount,
+		Currency:      "usd",
+		Metadata:      map[string]string{"application": "fuel", "handle": "test"},
+		Created:       time.Now(),
+	}
+}
+
+func grant(payment string, amount uint, created time.Time) ledger.Grant {
+	return ledger.Grant{
+		CreatedAt:   created,
+		Payment:     payment,
+		Customer:    "cus_123",
+		Handle:      "test",
+		Application: "fuel",
+		Amount:      amount,
+		Currency:    "usd",
+	}
+}
+
+type setup struct {
+	from, to  time.Time
+	since     time.Time
+	inspector *fake.Inspector
+	ledger    ledger.Store
+	charger   *fake.Charger
+}
+
+func newSetup(t *testing.T) setup {
+	to := time.Now().UTC().Truncate(time.Second)
+	from := to.Add(-24 * time.Hour)
+
+	refunded := charge("ch_5", "pi_5", 1000)
+	refunded.AmountRefunded = 1000
+	refunded.Refunded = true
+
+	charges := []adapter.Charge{
+		charge("ch_1", "pi_1", 1000), // OK
+		charge("ch_2", "pi_2", 1000), // Missing grant
+		charge("ch_3", "pi_3", 1000), // Duplicated grant
+		charge("ch_4", "pi_4", 1000), // Amount mismatch
+		refunded,
+		{ID: "ch_6", PaymentIntent: "pi_6", Status: "failed", Amount: 1000}, // Ignored
+		charge("ch_8", "pi_8", 1000),                                        // Granted after the time window
+	}
+
+	store := ledger.NewMemoryStore()
+	grants := []ledger.Grant{
+		grant("pi_1", 1000, from.Add(time.Hour)),
+		grant("pi_3", 1000, from.Add(time.Hour)),
+		grant("pi_3", 1000, from.Add(2*time.Hour)),
+		grant("pi_4", 500, from.Add(time.Hour)),
+		grant("pi_5", 1000, from.Add(time.Hour)),
+		grant("pi_7", 1000, from.Add(time.Hour)), // Missing payment
+		grant("pi_8", 1000, to.Add(time.Hour)),
+		grant("pi_9", 1000, from.Add(time.Hour)), // Charged before the time window
+	}
+	for _, g := range grants {
+		require.NoError(t, store.Record(context.Background(), g))
+	}
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListCharges", mock.Anything, from, to, 0).Return(charges, error(nil))
+	inspector.On("GetPaymentIntent", mock.Anything, "pi_7").Return(adapter.PaymentIntent{}, errors.New("no such payment_intent"))
+	inspector.On("GetPaymentIntent", mock.Anything, "pi_9").Return(adapter.PaymentIntent{ID: "pi_9", Status: "succeeded", Amount: 1000}, error(nil))
+
+	return setup{
+		from:      from,
+		to:        to,
+		since:     from.Add(-24 * time.Hour),
+		inspector: inspector,
+		ledger:    store,
+		charger:   &fake.Charger{},
+	}
+}
+
+func (s setup) reconciler() *Reconciler {
+	return NewReconciler(Options{
+		Inspector: s.inspector,
+		Ledger:    s.ledger,
+		Charger:   s.charger,
+		Since:     s.since,
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	s := newSetup(t)
+
+	report, err := s.reconciler().Run(context.Background(), s.from, s.to, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, 6, report.Charges)
+	assert.Equal(t, 7, report.Grants)
+	require.Len(t, report.Entries, 5)
+
+	assert.Equal(t, KindMissingGrant, report.Entries[0].Kind)
+	assert.Equal(t, "pi_2", report.Entries[0].Payment)
+	assert.Equal(t, "fuel", report.Entries[0].Application)
+	assert.False(t, report.Entries[0].Repaired)
+
+	assert.Equal(t, KindDuplicatedGrant, report.Entries[1].Kind)
+	assert.Equal(t, "pi_3", report.Entries[1].Payment)
+	assert.Equal(t, 2, report.Entries[1].Grants)
+	assert.Equal(t, uint(2000), report.Entries[1].Granted)
+
+	assert.Equal(t, KindAmountMismatch, report.Entries[2].Kind)
+	assert.Equal(t, "pi_4", report.Entries[2].Payment)
+	assert.Equal(t, int64(1000), report.Entries[2].Charged)
+	assert.Equal(t, uint(500), report.Entries[2].Granted)
+
+	assert.Equal(t, KindRefunded, report.Entries[3].Kind)
+	assert.Equal(t, "pi_5", report.Entries[3].Payment)
+	assert.Equal(t, int64(1000), report.Entries[3].Refunded)
+
+	assert.Equal(t, KindMissingPayment, report.Entries[4].Kind)
+	assert.Equal(t, "pi_7", report.Entries[4].Payment)
+	assert.NotEmpty(t, report.Entries[4].Error)
+
+	assert.Equal(t, 5, report.Unresolved())
+	s.charger.AssertNotCalled(t, "Charge", mock.Anything, mock.Anything)
+}
+
+func TestReconcileRepair(t *testing.T) {
+	s := newSetup(t)
+	s.inspector.On("GetPaymentIntent", mock.Anything, "pi_2").Return(adapter.PaymentIntent{ID: "pi_2", Status: "succeeded", Amount: 1000}, error(nil))
+
+	s.charger.On("Charge", mock.Anything, api.ChargeRequest{
+		Amount:      1000,
+		Currency:    "usd",
+		Customer:    "cus_123",
+		Service:     api.PaymentServiceStripe,
+		Application: "fuel",
+		Event:       "ch_2",
+		Payment:     "pi_2",
+	}).Return(api.ChargeResponse{}, error(nil))
+
+	report, err := s.reconciler().Run(context.Background(), s.from, s.to, true)
+	require.NoError(t, err)
+
+	require.Len(t, report.Entries, 5)
+	assert.Equal(t, KindMissingGrant, report.Entries[0].Kind)
+	assert.True(t, report.Entries[0].Repaired)
+	assert.Empty(t, report.Entries[0].Error)
+	assert.Equal(t, 4, report.Unresolved())
+
+	s.charger.AssertNumberOfCalls(t, "Charge", 1)
+}
+
+func TestReconcileRepairFailed(t *testing.T) {
+	s := newSetup(t)
+	s.inspector.On("GetPaymentIntent", mock.Anything, "pi_2").Return(adapter.PaymentIntent{ID: "pi_2", Status: "succeeded", Amount: 1000}, error(nil))
+
+	s.charger.On("Charge", mock.Anything, mock.Anything).Return(api.ChargeResponse{}, errors.New("credits unavailable"))
+
+	report, err := s.reconciler().Run(context.Background(), s.from, s.to, true)
+	require.NoError(t, err)
+
+	assert.False(t, report.Entries[0].Repaired)
+	assert.Equal(t, "credits unavailable", report.Entries[0].Error)
+}
+
+func TestReconcileRepairAlreadyCharged(t *testing.T) {
+	s := newSetup(t)
+	s.inspector.On("GetPaymentIntent", mock.Anything, "pi_2").Return(adapter.PaymentIntent{
+		ID:       "pi_2",
+		Status:   "succeeded",
+		Amount:   1000,
+		Metadata: map[string]string{adapter.MetadataChargedEvent: "evt_2"},
+	}, error(nil))
+
+	report, err := s.reconciler().Run(context.Background(), s.from, s.to, true)
+	require.NoError(t, err)
+
+	assert.Equal(t, KindMissingGrant, report.Entries[0].Kind)
+	assert.False(t, report.Entries[0].Repaired)
+	assert.Equal(t, "payment already charged by event evt_2", report.Entries[0].Error)
+	s.charger.AssertNotCalled(t, "Charge", mock.Anything, mock.Anything)
+}
+
+func TestReconcileRepairBeforeLedger(t *testing.T) {
+	s := newSetup(t)
+
+	// Payments created before the ledger cut-off are never repaired, nor are any payments without a cut-off.
+	for _, since := range []time.Time{{}, s.to.Add(time.Hour)} {
+		s.since = since
+		report, err := s.reconciler().Run(context.Background(), s.from, s.to, true)
+		require.NoError(t, err)
+
+		assert.Equal(t, KindMissingGrant, report.Entries[0].Kind)
+		assert.False(t, report.Entries[0].Repaired)
+		assert.Equal(t, ErrBeforeLedger.Error(), report.Entries[0].Error)
+	}
+	s.charger.AssertNotCalled(t, "Charge", mock.Anything, mock.Anything)
+	s.inspector.AssertNotCalled(t, "GetPaymentIntent", mock.Anything, "pi_2")
+}
+
+func TestReconcileRepairRefunded(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	refunded := charge("ch_1", "pi_1", 1000)
+	refunded.AmountRefunded = 400
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListCharges", mock.Anything, from, to, 0).Return([]adapter.Charge{refunded}, error(nil))
+	charger := &fake.Charger{}
+
+	r := NewReconciler(Options{Inspector: inspector, Ledger: ledger.NewMemoryStore(), Charger: charger})
+	report, err := r.Run(context.Background(), from, to, true)
+	require.NoError(t, err)
+
+	require.Len(t, report.Entries, 1)
+	assert.Equal(t, KindMissingGrant, report.Entries[0].Kind)
+	assert.False(t, report.Entries[0].Repaired)
+	assert.Equal(t, ErrRefunded.Error(), report.Entries[0].Error)
+	charger.AssertNotCalled(t, "Charge", mock.Anything, mock.Anything)
+	inspector.AssertNotCalled(t, "GetPaymentIntent", mock.Anything, mock.Anything)
+}
+
+func TestReconcileListChargesFailed(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour), time.Now()
+
+	inspector := &fake.Inspector{}
+	inspector.On("ListCharges", mock.Anything, from, to, 0).Return([]adapter.Charge(nil), errors.New("stripe unavailable"))
+
+	r := NewReconciler(Options{Inspector: inspector, Ledger: ledger.NewMemoryStore()})
+	_, err := r.Run(context.Background(), from, to, false)
+	assert.Error(t, err)
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, Report{
+		Entries: []Entry{
+			{Kind: KindAmountMismatch, Payment: "pi_1", Charge: "ch_1", Currency: "usd", Charged: 1000, Granted: 500, Grants: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, "kind,payment,charge,customer,handle,application,currency,charged,refunded,granted,grants,repaired,error", lines[0])
+	assert.Equal(t, "amount_mismatch,pi_1,ch_1,,,,usd,1000,0,500,1,false,", lines[1])
+}
@@ -0,0 +1,110 @@
+package replay
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync"
+	"time"
+)
+
+// Entry is a key recorded in an SQL database.
+type Entry struct {
+	// ID is the recorded key.
+	ID string `gorm:"primarykey;size:255"`
+
+	// ExpiresAt is the time the key is forgotten.
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// TableName returns the name of the table used to store entries.
+func (Entry) TableName() string {
+	return "webhook_replays"
+}
+
+// gormCache is a Cache implementation that records keys in an SQL database, allowing replicas to share them.
+type gormCache struct {
+	// db is the database connection.
+	db *gorm.DB
+
+	// now returns the current time.
+	now func() time.Time
+
+	// lock is used to synchronize access to pruned.
+	lock sync.Mutex
+
+	// pruned is the last time expired entries were deleted.
+	pruned time.Time
+}
+
+// gormPruneInterval is the time between consecutive deletions of expired entries from the database.
+const gormPruneInterval = time.Hour
+
+// Add records the given key in the database. Expired entries of the same key are reused.
+func (c *gormCache) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	now := c.now()
+	if err := c.prune(ctx, now); err != nil {
+		return false, err
+	}
+	db := c.db.WithContext(ctx)
+
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Entry{ID: key, ExpiresAt: now.Add(ttl)})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return true, nil
+	}
+
+	res = db.Model(&Entry{}).
+		Where("id = ? AND expires_at <= ?", key, now).
+		Update("expires_at", now.Add(ttl))
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// Contains returns true if the given key is recorded in the database and hasn't expired yet.
+func (c *gormCache) Contains(ctx context.Context, key string) (bool, error) {
+	var count int64
+	err := c.db.WithContext(ctx).Model(&Entry{}).
+		Where("id = ? AND expires_at > ?", key, c.now()).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Remove deletes the given key from the database.
+func (c *gormCache) Remove(ctx context.Context, key string) error {
+	return c.db.WithContext(ctx).Delete(&Entry{}, "id = ?", key).Error
+}
+
+// prune deletes the expired entries from the database if they haven't been deleted recently.
+func (c *gormCache) prune(ctx context.Context, now time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if now.Sub(c.pruned) < gormPruneInterval {
+		return nil
+	}
+	if err := c.db.WithContext(ctx).Delete(&Entry{}, "expires_at <= ?", now).Error; err != nil {
+		return err
+	}
+	c.pruned = now
+	return nil
+}
+
+// NewGormCache initializes a new Cache using the given database connection. The entries table is migrated
+// automatically.
+func NewGormCache(db *gorm.DB) (Cache, error) {
+	if err := db.AutoMigrate(&Entry{}); err != nil {
+		return nil, err
+	}
+	return &gormCache{
+		db:  db,
+		now: time.Now,
+	}, nil
+}
@@ -0,0 +1,79 @@
+package replay
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryCache is a Cache implementation that keeps keys in memory. Replays are only detected per replica.
+type memoryCache struct {
+	// lock is used to synchronize access to keys.
+	lock sync.Mutex
+
+	// keys contains the expiration time of the recorded keys.
+	keys map[string]time.Time
+
+	// pruned is the last time expired keys were removed.
+	pruned time.Time
+
+	// now returns the current time.
+	now func() time.Time
+}
+
+// pruneInterval is the time between consecutive removals of expired keys.
+const pruneInterval = time.Minute
+
+// Add records the given key in memory.
+func (m *memoryCache) Add(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now()
+	m.prune(now)
+
+	if expires, ok := m.keys[key]; ok && now.Before(expires) {
+		return false, nil
+	}
+	m.keys[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Contains returns true if the given key is kept in memory and hasn't expired yet.
+func (m *memoryCache) Contains(_ context.Context, key string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	expires, ok := m.keys[key]
+	return ok && m.now().Before(expires), nil
+}
+
+// Remove forgets the given key.
+func (m *memoryCache) Remove(_ context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.keys, key)
+	return nil
+}
+
+// prune removes expired keys.
+func (m *memoryCache) prune(now time.Time) {
+	if now.Sub(m.pruned) < pruneInterval {
+		return
+	}
+	for k, expires := range m.keys {
+		if !now.Before(expires) {
+			delete(m.keys, k)
+		}
+	}
+	m.pruned = now
+}
+
+// NewMemoryCache initializes a new Cache that keeps keys in memory.
+func NewMemoryCache() Cache {
+	return &memoryCache{
+		keys: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
@@ -0,0 +1,23 @@
+package replay
+
+import (
+	"context"
+	"time"
+)
+
+// Cache remembers keys for a limited amount of time. It's used to detect webhook events that have already been
+// received, such as captured payloads sent again by a third party. Implementations backed by a shared database allow
+// detecting replays across multiple replicas.
+type Cache interface {
+	// Add records the given key for the given amount of time. It returns false if the key had already been recorded
+	// and hasn't expired yet. Adding a key is atomic: only one of multiple concurrent calls with the same key returns
+	// true.
+	Add(ctx context.Context, key string, ttl time.Duration) (bool, error)
+
+	// Contains returns true if the given key has been recorded and hasn't expired yet.
+	Contains(ctx context.Context, key string) (bool, error)
+
+	// Remove forgets the given key, so it can be added again. It's used when processing an event fails and the
+	// payment service is expected to send it again.
+	Remove(ctx context.Context, key string) error
+}
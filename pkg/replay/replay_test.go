@@ -0,0 +1,94 @@
+package replay
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+	"time"
+)
+
+func testCache(t *testing.T, cache Cache, now *time.Time) {
+	ctx := context.Background()
+
+	added, err := cache.Add(ctx, "evt_1", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	found, err := cache.Contains(ctx, "evt_1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	found, err = cache.Contains(ctx, "evt_3")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	added, err = cache.Add(ctx, "evt_1", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, added)
+
+	added, err = cache.Add(ctx, "evt_2", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	require.NoError(t, cache.Remove(ctx, "evt_2"))
+	added, err = cache.Add(ctx, "evt_2", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	*now = now.Add(time.Minute)
+	found, err = cache.Contains(ctx, "evt_1")
+	require.NoError(t, err)
+	assert.False(t, found)
+	added, err = cache.Add(ctx, "evt_1", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, added)
+}
+
+func TestMemoryCache(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewMemoryCache().(*memoryCache)
+	cache.now = func() time.Time {
+		return now
+	}
+
+	testCache(t, cache, &now)
+}
+
+func TestMemoryCachePrune(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewMemoryCache().(*memoryCache)
+	cache.now = func() time.Time {
+		return now
+	}
+
+	_, err := cache.Add(context.Background(), "evt_1", time.Second)
+	require.NoError(t, err)
+
+	now = now.Add(pruneInterval)
+	_, err = cache.Add(context.Background(), "evt_2", time.Second)
+	require.NoError(t, err)
+
+	assert.Len(t, cache.keys, 1)
+	assert.Contains(t, cache.keys, "evt_2")
+}
+
+func TestGormCache(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	c, err := NewGormCache(db)
+	require.NoError(t, err)
+
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	cache := c.(*gormCache)
+	cache.now = func() time.Time {
+		return now
+	}
+
+	testCache(t, cache, &now)
+}
@@ -0,0 +1,105 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrProcessed is returned when an event has already been processed.
+	ErrProcessed = errors.New("event already processed")
+
+	// ErrInProgress is returned when an event is being processed, e.g. by another delivery of the same event received
+	// by a different replica.
+	ErrInProgress = errors.New("event is being processed")
+)
+
+// ProcessingMargin is added to the timeout of the operations run by an event to get the amount of time the event is
+// considered to be in progress once its processing begins.
+const ProcessingMargin = time.Minute
+
+// TrackerOptions contains a set of components needed to configure a Tracker.
+type TrackerOptions struct {
+	// Cache is used to record the events. It should be shared by all replicas.
+	Cache Cache
+
+	// Window is the amount of time processed events are remembered. Processed events are not remembered if set to 0,
+	// events are only kept from being processed concurrently.
+	Window time.Duration
+
+	// ProcessingTTL is the amount of time an event is considered to be in progress once its processing begins. It
+	// must be longer than the time it takes to process an event, events whose processing is interrupted without
+	// being finished nor aborted, e.g. because the process has been killed, can be processed again once it expires.
+	ProcessingTTL time.Duration
+}
+
+// Tracker records the payment service events that are being processed and the ones that have been processed, so an
+// event delivered more than once is only processed once. An event is only remembered as processed after its processing
+// succeeds: events whose processing is interrupted are processed again by later deliveries.
+type Tracker struct {
+	// cache is used to record the events.
+	cache Cache
+
+	// window is the amount of time processed events are remembered.
+	window time.Duration
+
+	// processingTTL is the amount of time an event is considered to be in progress once its processing begins.
+	processingTTL time.Duration
+}
+
+// Begin marks the given event as being processed. It returns ErrProcessed if the event has already been processed,
+// and ErrInProgress if it's being processed.
+func (t *Tracker) Begin(ctx context.Context, event string) error {
+	processed, err := t.cache.Contains(ctx, processedKey(event))
+	if err != nil {
+		return err
+	}
+	if processed {
+		return ErrProcessed
+	}
+
+	claimed, err := t.cache.Add(ctx, processingKey(event), t.processingTTL)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrInProgress
+	}
+	return nil
+}
+
+// Finish remembers the given event as processed. Its processing mark is kept until it expires, so deliveries that
+// checked the event before it was processed are still rejected.
+func (t *Tracker) Finish(ctx context.Context, event string) error {
+	if t.window <= 0 {
+		return nil
+	}
+	_, err := t.cache.Add(ctx, processedKey(event), t.window)
+	return err
+}
+
+// Abort forgets that the given event is being processed after its processing failed, so it's processed again when
+// the payment service delivers it again.
+func (t *Tracker) Abort(ctx context.Context, event string) error {
+	return t.cache.Remove(ctx, processingKey(event))
+}
+
+// processedKey returns the key used to remember that the given event has been processed.
+func processedKey(event string) string {
+	return "event:" + event
+}
+
+// processingKey returns the key used to remember that the given event is being processed.
+func processingKey(event string) string {
+	return "event:" + event + ":processing"
+}
+
+// NewTracker initializes a new Tracker.
+func NewTracker(opts TrackerOptions) *Tracker {
+	return &Tracker{
+		cache:         opts.Cache,
+		window:        opts.Window,
+		processingTTL: opts.ProcessingTTL,
+	}
+}
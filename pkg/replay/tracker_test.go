@@ -0,0 +1,63 @@
+package replay
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewMemoryCache().(*memoryCache)
+	cache.now = func() time.Time {
+		return now
+	}
+	tracker := NewTracker(TrackerOptions{Cache: cache, Window: time.Hour, ProcessingTTL: time.Minute})
+	ctx := context.Background()
+
+	require.NoError(t, tracker.Begin(ctx, "evt_1"))
+	assert.ErrorIs(t, tracker.Begin(ctx, "evt_1"), ErrInProgress)
+
+	// Processed events are remembered for the whole window
+	require.NoError(t, tracker.Finish(ctx, "evt_1"))
+	assert.ErrorIs(t, tracker.Begin(ctx, "evt_1"), ErrProcessed)
+	now = now.Add(30 * time.Minute)
+	assert.ErrorIs(t, tracker.Begin(ctx, "evt_1"), ErrProcessed)
+	now = now.Add(time.Hour)
+	require.NoError(t, tracker.Begin(ctx, "evt_1"))
+
+	// Events that failed to be processed can be processed again
+	require.NoError(t, tracker.Begin(ctx, "evt_2"))
+	require.NoError(t, tracker.Abort(ctx, "evt_2"))
+	require.NoError(t, tracker.Begin(ctx, "evt_2"))
+}
+
+func TestTrackerInterrupted(t *testing.T) {
+	now := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewMemoryCache().(*memoryCache)
+	cache.now = func() time.Time {
+		return now
+	}
+	tracker := NewTracker(TrackerOptions{Cache: cache, Window: 72 * time.Hour, ProcessingTTL: time.Minute})
+	ctx := context.Background()
+
+	// The processing of an event is neither finished nor aborted, e.g. because the process has been killed
+	require.NoError(t, tracker.Begin(ctx, "evt_1"))
+	assert.ErrorIs(t, tracker.Begin(ctx, "evt_1"), ErrInProgress)
+
+	// It's processed again once the processing mark expires, instead of waiting for the whole window
+	now = now.Add(time.Minute)
+	require.NoError(t, tracker.Begin(ctx, "evt_1"))
+}
+
+func TestTrackerWithoutWindow(t *testing.T) {
+	tracker := NewTracker(TrackerOptions{Cache: NewMemoryCache(), ProcessingTTL: time.Minute})
+	ctx := context.Background()
+
+	require.NoError(t, tracker.Begin(ctx, "evt_1"))
+	assert.ErrorIs(t, tracker.Begin(ctx, "evt_1"), ErrInProgress)
+	require.NoError(t, tracker.Finish(ctx, "evt_1"))
+	assert.ErrorIs(t, tracker.Begin(ctx, "evt_1"), ErrInProgress)
+}
@@ -0,0 +1,96 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidMessage is returned when a gRPC request is not a protobuf message.
+var ErrInvalidMessage = errors.New("request is not a protobuf message")
+
+// marshal encodes the given request deterministically, so signatures computed by clients match the ones computed by
+// the server.
+func marshal(req interface{}) ([]byte, error) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil, ErrInvalidMessage
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+// newRequest represents a gRPC call as an HTTP request, so the same auth.Signer and auth.Authenticator
+// implementations can be used with both transports. The full gRPC method name is used as the request path.
+func newRequest(ctx context.Context, method string, body []byte, md metadata.MD) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range md {
+		for _, v := range values {
+			r.Header.Add(k, v)
+		}
+	}
+	return r, nil
+}
+
+// UnaryClientInterceptor returns a gRPC interceptor that attaches credentials to every call using the given signer.
+func UnaryClientInterceptor(signer auth.Signer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		body, err := marshal(req)
+		if err != nil {
+			return err
+		}
+
+		r, err := newRequest(ctx, method, body, nil)
+		if err != nil {
+			return err
+		}
+
+		if err = signer.Sign(r, body); err != nil {
+			return err
+		}
+
+		var kv []string
+		for k, values := range r.Header {
+			for _, v := range values {
+				kv = append(kv, strings.ToLower(k), v)
+			}
+		}
+		return invoker(metadata.AppendToOutgoingContext(ctx, kv...), method, req, reply, cc, opts...)
+	}
+}
+
+// UnaryServerInterceptor returns a gRPC interceptor that verifies the credentials of every call using the given
+// authenticator. The identity of the caller is stored in the context passed to the handler. Errors include the request
+// ID stored in the context by a previous interceptor, if any.
+func UnaryServerInterceptor(authenticator auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		requestID := middleware.GetReqID(ctx)
+		body, err := marshal(req)
+		if err != nil {
+			return nil, ToStatus(api.WrapError(api.ErrorCodeMalformedRequest, err), requestID)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		r, err := newRequest(ctx, info.FullMethod, body, md)
+		if err != nil {
+			return nil, ToStatus(api.WrapError(api.ErrorCodeMalformedRequest, err), requestID)
+		}
+
+		id, err := authenticator.Authenticate(r, body)
+		if err != nil {
+			return nil, ToStatus(api.WrapError(api.ErrorCodeUnauthenticated, err), requestID)
+		}
+
+		return handler(auth.WithIdentity(ctx, id), req)
+	}
+}
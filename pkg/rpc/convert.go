@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api/pb"
+)
+
+// CreateSessionRequestToPB converts the given api.CreateSessionRequest into its protobuf representation.
+func CreateSessionRequestToPB(req api.CreateSessionRequest) *pb.CreateSessionRequest {
+	return &pb.CreateSessionRequest{
+		Service:     string(req.Service),
+		SuccessUrl:  req.SuccessURL,
+		CancelUrl:   req.CancelURL,
+		Handle:      req.Handle,
+		Application: req.Application,
+	}
+}
+
+// CreateSessionRequestFromPB converts the given protobuf message into an api.CreateSessionRequest.
+func CreateSessionRequestFromPB(req *pb.CreateSessionRequest) api.CreateSessionRequest {
+	return api.CreateSessionRequest{
+		Service:     api.PaymentService(req.GetService()),
+		SuccessURL:  req.GetSuccessUrl(),
+		CancelURL:   req.GetCancelUrl(),
+		Handle:      req.GetHandle(),
+		Application: req.GetApplication(),
+	}
+}
+
+// CreateSessionResponseToPB converts the given api.CreateSessionResponse into its protobuf representation.
+func CreateSessionResponseToPB(res api.CreateSessionResponse) *pb.CreateSessionResponse {
+	return &pb.CreateSessionResponse{
+		Service: string(res.Service),
+		Session: res.Session,
+	}
+}
+
+// CreateSessionResponseFromPB converts the given protobuf message into an api.CreateSessionResponse.
+func CreateSessionResponseFromPB(res *pb.CreateSessionResponse) api.CreateSessionResponse {
+	return api.CreateSessionResponse{
+		Service: api.PaymentService(res.GetService()),
+		Session: res.GetSession(),
+	}
+}
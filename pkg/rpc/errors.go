@@ -0,0 +1,124 @@
+package rpc
+
+import (
+	"errors"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ratelimit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ErrorDomain is the domain used in the errdetails.ErrorInfo attached to the errors returned by the payments service.
+const ErrorDomain = "payments.ignitionrobotics.org"
+
+// statusCodes maps api.ErrorCode values to gRPC status codes.
+var statusCodes = map[api.ErrorCode]codes.Code{
+	api.ErrorCodeEmptyService:       codes.InvalidArgument,
+	api.ErrorCodeInvalidService:     codes.InvalidArgument,
+	api.ErrorCodeEmptyCallbacks:     codes.InvalidArgument,
+	api.ErrorCodeInvalidURL:         codes.InvalidArgument,
+	api.ErrorCodeEmptyHandle:        codes.InvalidArgument,
+	api.ErrorCodeEmptyApplication:   codes.InvalidArgument,
+	api.ErrorCodeInvalidUnitPrice:   codes.Unavailable,
+	api.ErrorCodeConnectUnavailable: codes.FailedPrecondition,
+	api.ErrorCodeMalformedRequest:   codes.InvalidArgument,
+	api.ErrorCodeUnauthenticated:    codes.Unauthenticated,
+	api.ErrorCodeForbidden:          codes.PermissionDenied,
+	api.ErrorCodeNotFound:           codes.NotFound,
+	api.ErrorCodeConflict:           codes.AlreadyExists,
+	api.ErrorCodeRateLimited:        codes.ResourceExhausted,
+	api.ErrorCodeUpstream:           codes.Unavailable,
+	api.ErrorCodeProvider:           codes.Unavailable,
+	api.ErrorCodeTimeout:            codes.DeadlineExceeded,
+	api.ErrorCodeInternal:           codes.Internal,
+}
+
+// StatusCode returns the gRPC status code for the given api.ErrorCode.
+func StatusCode(code api.ErrorCode) codes.Code {
+	c, ok := statusCodes[code]
+	if !ok {
+		return codes.Internal
+	}
+	return c
+}
+
+// ToStatus converts the given error into a gRPC status error. The api.ErrorCode and the request ID are attached as an
+// errdetails.ErrorInfo so clients can convert the status back using FromStatus. The message is the one returned by
+// api.Message, so internal details are not sent to clients.
+func ToStatus(err error, requestID string) error {
+	if err == nil {
+		return nil
+	}
+	code := api.ErrorCodeOf(err)
+	st := status.New(StatusCode(code), api.Message(err))
+
+	info := &errdetails.ErrorInfo{
+		Reason:   string(code),
+		Domain:   ErrorDomain,
+		Metadata: map[string]string{"request_id": requestID},
+	}
+
+	var withDetails *status.Status
+	var detailsErr error
+	var exceeded *ratelimit.ExceededError
+	if errors.As(err, &exceeded) {
+		withDetails, detailsErr = st.WithDetails(info, &errdetails.RetryInfo{RetryDelay: durationpb.New(exceeded.RetryAfter)})
+	} else {
+		withDetails, detailsErr = st.WithDetails(info)
+	}
+	if detailsErr != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// FromStatus converts the given gRPC status error into an *api.Error. Errors that are not gRPC status errors are
+// returned unchanged.
+func FromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	for _, d := range st.Details() {
+		info, ok := d.(*errdetails.ErrorInfo)
+		if !ok || info.GetDomain() != ErrorDomain {
+			continue
+		}
+		apiErr := api.NewError(api.ErrorCode(info.GetReason()), st.Message())
+		apiErr.RequestID = info.GetMetadata()["request_id"]
+		return apiErr
+	}
+
+	return api.NewError(errorCode(st.Code()), st.Message())
+}
+
+// errorCode returns the api.ErrorCode that better represents the given gRPC status code. It's used when the status
+// doesn't include an errdetails.ErrorInfo, e.g. when the error is originated by the gRPC transport itself.
+func errorCode(code codes.Code) api.ErrorCode {
+	switch code {
+	case codes.InvalidArgument:
+		return api.ErrorCodeMalformedRequest
+	case codes.Unauthenticated:
+		return api.ErrorCodeUnauthenticated
+	case codes.PermissionDenied:
+		return api.ErrorCodeForbidden
+	case codes.NotFound:
+		return api.ErrorCodeNotFound
+	case codes.AlreadyExists:
+		return api.ErrorCodeConflict
+	case codes.ResourceExhausted:
+		return api.ErrorCodeRateLimited
+	case codes.Unavailable:
+		return api.ErrorCodeUpstream
+	case codes.DeadlineExceeded:
+		return api.ErrorCodeTimeout
+	default:
+		return api.ErrorCodeInternal
+	}
+}
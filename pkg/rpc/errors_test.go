@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/ratelimit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestStatusCode(t *testing.T) {
+	for _, code := range api.ErrorCodes() {
+		_, ok := statusCodes[code]
+		assert.True(t, ok, "missing gRPC status code for %s", code)
+	}
+	assert.Equal(t, codes.Internal, StatusCode("unknown"))
+}
+
+func TestToStatusNil(t *testing.T) {
+	assert.NoError(t, ToStatus(nil, ""))
+	assert.NoError(t, FromStatus(nil))
+}
+
+func TestToStatusRoundTrip(t *testing.T) {
+	err := ToStatus(api.ErrEmptyHandle, "req-1")
+
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+
+	out := FromStatus(err)
+	assert.True(t, errors.Is(out, api.ErrEmptyHandle))
+
+	var apiErr *api.Error
+	require.True(t, errors.As(out, &apiErr))
+	assert.Equal(t, api.ErrorCodeEmptyHandle, apiErr.Code)
+	assert.Equal(t, "req-1", apiErr.RequestID)
+	assert.Equal(t, api.ErrEmptyHandle.Error(), apiErr.Message)
+}
+
+func TestToStatusRateLimited(t *testing.T) {
+	cause := &ratelimit.ExceededError{Key: "handle:test/test", RetryAfter: 30 * time.Second}
+	err := ToStatus(api.WrapError(api.ErrorCodeRateLimited, cause), "")
+
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if r, ok := d.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	require.NotNil(t, retry)
+	assert.Equal(t, 30*time.Second, retry.GetRetryDelay().AsDuration())
+
+	assert.True(t, errors.Is(FromStatus(err), api.ErrRateLimited))
+}
+
+func TestFromStatusWithoutDetails(t *testing.T) {
+	err := FromStatus(status.Error(codes.Unavailable, "connection refused"))
+	assert.Equal(t, api.ErrorCodeUpstream, api.ErrorCodeOf(err))
+
+	plain := errors.New("plain error")
+	assert.Equal(t, plain, FromStatus(plain))
+}
@@ -0,0 +1,238 @@
+package stripetest
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/stripe/stripe-go/v72"
+	"net/http"
+)
+
+// createProduct implements https://stripe.com/docs/api/products/create
+func (s *Server) createProduct(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(r.Form.Get("name")) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_missing", "Missing required param: name")
+		return
+	}
+	id := r.Form.Get("id")
+	if len(id) == 0 {
+		id = s.newID("prod")
+	}
+	if _, ok := s.products[id]; ok {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "resource_already_exists", "Product already exists.")
+		return
+	}
+
+	p := &stripe.Product{
+		ID:       id,
+		Object:   "product",
+		Active:   r.Form.Get("active") != "false",
+		Created:  s.now().Unix(),
+		Livemode: s.livemode(),
+		Metadata: formMap(r.Form, "metadata"),
+		Name:     r.Form.Get("name"),
+	}
+	s.products[p.ID] = p
+	writeJSON(w, p)
+}
+
+// listProducts implements https://stripe.com/docs/api/products/list
+func (s *Server) listProducts(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	active := r.Form.Get("active")
+	ids := make([]string, 0, len(s.products))
+	for id, p := range s.products {
+		if len(active) > 0 && fmt.Sprint(p.Active) != active {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	out := make([]*stripe.Product, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		out = append(out, s.products[id])
+	}
+	writeList(w, r, out)
+}
+
+// updateProduct implements https://stripe.com/docs/api/products/update. Only the name, the metadata and the active
+// flag can be updated.
+func (s *Server) updateProduct(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	p, ok := s.products[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+
+	if name := r.Form.Get("name"); len(name) > 0 {
+		p.Name = name
+	}
+	switch r.Form.Get("active") {
+	case "true":
+		p.Active = true
+	case "false":
+		p.Active = false
+	}
+	if p.Metadata == nil {
+		p.Metadata = make(map[string]string)
+	}
+	for k, v := range formMap(r.Form, "metadata") {
+		p.Metadata[k] = v
+	}
+	writeJSON(w, p)
+}
+
+// createPrice implements https://stripe.com/docs/api/prices/create. Only one-time prices with a unit amount are
+// supported. A lookup key in use by another price is rejected unless transfer_lookup_key is set.
+func (s *Server) createPrice(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, key := range []string{"product", "currency", "unit_amount"} {
+		if len(r.Form.Get(key)) == 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_missing", "Missing required param: "+key)
+			return
+		}
+	}
+	product := r.Form.Get("product")
+	if _, ok := s.products[product]; !ok {
+		writeMissing(w, product)
+		return
+	}
+	unitAmount, err := formInt(r.Form, "unit_amount")
+	if err != nil || unitAmount < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", "Invalid unit_amount")
+		return
+	}
+
+	lookupKey := r.Form.Get("lookup_key")
+	if previous := s.priceByLookupKey(lookupKey); previous != nil {
+		if r.Form.Get("transfer_lookup_key") != "true" {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "lookup_key_already_exists",
+				fmt.Sprintf("A price (`%s`) already uses that lookup key.", previous.ID))
+			return
+		}
+		previous.LookupKey = ""
+	}
+
+	p := &stripe.Price{
+		ID:         s.newID("price"),
+		Object:     "price",
+		Active:     r.Form.Get("active") != "false",
+		Created:    s.now().Unix(),
+		Currency:   stripe.Currency(r.Form.Get("currency")),
+		Livemode:   s.livemode(),
+		LookupKey:  lookupKey,
+		Metadata:   formMap(r.Form, "metadata"),
+		Product:    &stripe.Product{ID: product},
+		Type:       stripe.PriceTypeOneTime,
+		UnitAmount: unitAmount,
+	}
+	s.prices[p.ID] = p
+	writeJSON(w, p)
+}
+
+// listPrices implements https://stripe.com/docs/api/prices/list, filtering by active, product and lookup_keys.
+func (s *Server) listPrices(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	active := r.Form.Get("active")
+	product := r.Form.Get("product")
+	lookupKeys := formSlice(r.Form, "lookup_keys")
+	ids := make([]string, 0, len(s.prices))
+	for id, p := range s.prices {
+		if len(active) > 0 && fmt.Sprint(p.Active) != active {
+			continue
+		}
+		if len(product) > 0 && p.Product.ID != product {
+			continue
+		}
+		if len(lookupKeys) > 0 && !contains(lookupKeys, p.LookupKey) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	out := make([]*stripe.Price, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		out = append(out, s.prices[id])
+	}
+	writeList(w, r, out)
+}
+
+// getPrice implements https://stripe.com/docs/api/prices/retrieve
+func (s *Server) getPrice(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	p, ok := s.prices[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+	writeJSON(w, p)
+}
+
+// updatePrice implements https://stripe.com/docs/api/prices/update. Only the active flag, the lookup key and the
+// metadata can be updated, as Stripe doesn't allow changing the amount of a price.
+func (s *Server) updatePrice(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	p, ok := s.prices[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+
+	switch r.Form.Get("active") {
+	case "true":
+		p.Active = true
+	case "false":
+		p.Active = false
+	}
+	if _, ok := r.Form["lookup_key"]; ok {
+		lookupKey := r.Form.Get("lookup_key")
+		if previous := s.priceByLookupKey(lookupKey); previous != nil && previous != p {
+			if r.Form.Get("transfer_lookup_key") != "true" {
+				writeError(w, http.StatusBadRequest, "invalid_request_error", "lookup_key_already_exists",
+					fmt.Sprintf("A price (`%s`) already uses that lookup key.", previous.ID))
+				return
+			}
+			previous.LookupKey = ""
+		}
+		p.LookupKey = lookupKey
+	}
+	if p.Metadata == nil {
+		p.Metadata = make(map[string]string)
+	}
+	for k, v := range formMap(r.Form, "metadata") {
+		p.Metadata[k] = v
+	}
+	writeJSON(w, p)
+}
+
+// priceByLookupKey returns the price with the given lookup key, or nil if there's none. It must be called with the
+// lock held.
+func (s *Server) priceByLookupKey(lookupKey string) *stripe.Price {
+	if len(lookupKey) == 0 {
+		return nil
+	}
+	for _, p := range s.prices {
+		if p.LookupKey == lookupKey {
+			return p
+		}
+	}
+	return nil
+}
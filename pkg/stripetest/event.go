@@ -0,0 +1,281 @@
+package stripetest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"net/http"
+	"time"
+)
+
+// Event is a Stripe webhook event built from typed inputs. Use the PaymentIntentSucceeded, ChargeRefunded and other
+// builders to create events, and Sign to turn them into webhook requests accepted by the payments service.
+type Event struct {
+	// ID is the event ID.
+	ID string
+
+	// Type is the type of the event, such as payment_intent.succeeded.
+	Type string
+
+	// Created is the time the event was created.
+	Created time.Time
+
+	// Livemode is true for events generated with live keys.
+	Livemode bool
+
+	// Account is the connected account the event belongs to. It's only set for Connect events.
+	Account string
+
+	// Object is the Stripe object included in the event.
+	Object interface{}
+}
+
+// PaymentIntent contains the fields of the payment intents included in payment intent events.
+type PaymentIntent struct {
+	// ID is the payment intent ID. A random ID is used if empty.
+	ID string
+
+	// Customer is the ID of the customer paying.
+	Customer string
+
+	// Amount is the amount paid in cents.
+	Amount int64
+
+	// Currency holds the ISO 4217 currency value in lowercase format. Defaults to usd.
+	Currency string
+
+	// Application is set as the application metadata value.
+	Application string
+
+	// Handle is set as the handle metadata value.
+	Handle string
+
+	// Metadata contains additional metadata values.
+	Metadata map[string]string
+}
+
+// Charge contains the fields of the charges included in charge events.
+type Charge struct {
+	// ID is the charge ID. A random ID is used if empty.
+	ID string
+
+	// PaymentIntent is the ID of the payment intent the charge belongs to.
+	PaymentIntent string
+
+	// Customer is the ID of the customer charged.
+	Customer string
+
+	// Amount is the amount charged in cents.
+	Amount int64
+
+	// AmountRefunded is the amount refunded in cents. ChargeRefunded refunds the whole Amount if it's 0.
+	AmountRefunded int64
+
+	// Currency holds the ISO 4217 currency value in lowercase format. Defaults to usd.
+	Currency string
+
+	// Metadata contains the metadata values of the charge.
+	Metadata map[string]string
+}
+
+// Account contains the fields of the connected accounts included in account events.
+type Account struct {
+	// ID is the connected account ID. A random ID is used if empty.
+	ID string
+
+	// ChargesEnabled is true if the account can receive payments.
+	ChargesEnabled bool
+
+	// PayoutsEnabled is true if the account can receive payouts.
+	PayoutsEnabled bool
+
+	// DetailsSubmitted is true if the account owner has completed the onboarding flow.
+	DetailsSubmitted bool
+
+	// Metadata contains the metadata values of the account.
+	Metadata map[string]string
+}
+
+// SignedEvent is the body of a webhook request and its Stripe-Signature header.
+type SignedEvent struct {
+	// Body is the JSON encoded event.
+	Body []byte
+
+	// Signature is the value of the Stripe-Signature header.
+	Signature string
+}
+
+// Header returns the HTTP headers of the webhook request.
+func (s SignedEvent) Header() http.Header {
+	return http.Header{
+		"Content-Type":     {"application/json"},
+		"Stripe-Signature": {s.Signature},
+	}
+}
+
+// PaymentIntentSucceeded returns a payment_intent.succeeded event for the given payment intent.
+func PaymentIntentSucceeded(in PaymentIntent) Event {
+	pi := paymentIntent(in)
+	pi.Status = stripe.PaymentIntentStatusSucceeded
+	pi.AmountReceived = pi.Amount
+	return newEvent("payment_intent.succeeded", pi)
+}
+
+// PaymentIntentAmountCapturableUpdated returns a payment_intent.amount_capturable_updated event for the given payment
+// intent, as sent when a payment made with manual capture is authorized.
+func PaymentIntentAmountCapturableUpdated(in PaymentIntent) Event {
+	pi := paymentIntent(in)
+	pi.Status = stripe.PaymentIntentStatusRequiresCapture
+	pi.CaptureMethod = stripe.PaymentIntentCaptureMethodManual
+	pi.AmountCapturable = pi.Amount
+	return newEvent("payment_intent.amount_capturable_updated", pi)
+}
+
+// ChargeSucceeded returns a charge.succeeded event for the given charge.
+func ChargeSucceeded(in Charge) Event {
+	ch := charge(in)
+	ch.AmountRefunded = 0
+	return newEvent("charge.succeeded", ch)
+}
+
+// ChargeRefunded returns a charge.refunded event for the given charge.
+func ChargeRefunded(in Charge) Event {
+	ch := charge(in)
+	if ch.AmountRefunded == 0 {
+		ch.AmountRefunded = ch.Amount
+	}
+	ch.Refunded = ch.AmountRefunded >= ch.Amount
+	return newEvent("charge.refunded", ch)
+}
+
+// AccountUpdated returns an account.updated event for the given connected account. The event belongs to the account.
+func AccountUpdated(in Account) Event {
+	if len(in.ID) == 0 {
+		in.ID = randomID("acct")
+	}
+	e := newEvent("account.updated", &stripe.Account{
+		ID:               in.ID,
+		ChargesEnabled:   in.ChargesEnabled,
+		PayoutsEnabled:   in.PayoutsEnabled,
+		DetailsSubmitted: in.DetailsSubmitted,
+		Metadata:         in.Metadata,
+		Type:             stripe.AccountTypeExpress,
+	})
+	e.Account = in.ID
+	return e
+}
+
+// Payload returns the JSON encoded event, as sent by Stripe.
+func (e Event) Payload() ([]byte, error) {
+	raw, err := json.Marshal(e.Object)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling %s event object: %w", e.Type, err)
+	}
+	return json.Marshal(&stripe.Event{
+		Account:  e.Account,
+		Created:  e.Created.Unix(),
+		Data:     &stripe.EventData{Raw: raw},
+		ID:       e.ID,
+		Livemode: e.Livemode,
+		Type:     e.Type,
+	})
+}
+
+// Sign encodes the event and signs it with the given webhook signing secret at the given time.
+func (e Event) Sign(secret string, t time.Time) (SignedEvent, error) {
+	body, err := e.Payload()
+	if err != nil {
+		return SignedEvent{}, err
+	}
+	return SignedEvent{
+		Body:      body,
+		Signature: SignatureHeader(body, secret, t),
+	}, nil
+}
+
+// newEvent returns a new event of the given type containing the given object, created now.
+func newEvent(typ string, object interface{}) Event {
+	return Event{
+		ID:      randomID("evt"),
+		Type:    typ,
+		Created: time.Now(),
+		Object:  object,
+	}
+}
+
+// paymentIntent converts the given typed input into a Stripe payment intent.
+func paymentIntent(in PaymentIntent) *stripe.PaymentIntent {
+	if len(in.ID) == 0 {
+		in.ID = randomID("pi")
+	}
+	metadata := make(map[string]string, len(in.Metadata)+2)
+	for k, v := range in.Metadata {
+		metadata[k] = v
+	}
+	if len(in.Application) > 0 {
+		metadata["application"] = in.Application
+	}
+	if len(in.Handle) > 0 {
+		metadata["handle"] = in.Handle
+	}
+
+	pi := &stripe.PaymentIntent{
+		ID:            in.ID,
+		Amount:        in.Amount,
+		CaptureMethod: stripe.PaymentIntentCaptureMethodAutomatic,
+		Created:       time.Now().Unix(),
+		Currency:      currency(in.Currency),
+		Metadata:      metadata,
+	}
+	if len(in.Customer) > 0 {
+		pi.Customer = &stripe.Customer{ID: in.Customer}
+	}
+	return pi
+}
+
+// charge converts the given typed input into a Stripe charge.
+func charge(in Charge) *stripe.Charge {
+	if len(in.ID) == 0 {
+		in.ID = randomID("ch")
+	}
+	ch := &stripe.Charge{
+		ID:             in.ID,
+		Object:         "charge",
+		Amount:         in.Amount,
+		AmountCaptured: in.Amount,
+		AmountRefunded: in.AmountRefunded,
+		Captured:       true,
+		Created:        time.Now().Unix(),
+		Currency:       stripe.Currency(currency(in.Currency)),
+		Metadata:       in.Metadata,
+		Paid:           true,
+		Status:         string(stripe.PaymentIntentStatusSucceeded),
+	}
+	if len(in.PaymentIntent) > 0 {
+		ch.PaymentIntent = &stripe.PaymentIntent{ID: in.PaymentIntent}
+	}
+	if len(in.Customer) > 0 {
+		ch.Customer = &stripe.Customer{ID: in.Customer}
+	}
+	return ch
+}
+
+// currency returns the given currency, or usd if it's empty.
+func currency(c string) string {
+	if len(c) == 0 {
+		return string(stripe.CurrencyUSD)
+	}
+	return c
+}
+
+// randomID returns a random identifier with the given prefix. Unlike the identifiers generated by Server, they're
+// unique across processes, so events sent to a running payments service are not deduplicated.
+func randomID(prefix string) string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("stripetest: generating random ID: %v", err))
+	}
+	return prefix + "_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,93 @@
+package stripetest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+)
+
+// idempotentResponse is the response recorded for a request made with an idempotency key.
+type idempotentResponse struct {
+	// fingerprint identifies the request that used the key.
+	fingerprint string
+
+	// done is false while the request is being processed.
+	done bool
+
+	// status is the status code of the response.
+	status int
+
+	// header contains the headers of the response.
+	header http.Header
+
+	// body is the body of the response.
+	body []byte
+}
+
+// idempotent replays the responses of POST requests made with an Idempotency-Key header that has already been used, as
+// Stripe does. Reusing a key with different parameters returns an idempotency_error, and using a key while its first
+// request is being processed returns an idempotency_key_in_use error.
+// Stripe docs: https://stripe.com/docs/api/idempotent_requests
+func (s *Server) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || len(key) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fingerprint := r.Method + " " + r.URL.Path + "?" + r.PostForm.Encode()
+
+		s.idempotencyLock.Lock()
+		var res idempotentResponse
+		recorded, ok := s.idempotencyKeys[key]
+		if ok {
+			res = *recorded
+		} else {
+			s.idempotencyKeys[key] = &idempotentResponse{fingerprint: fingerprint}
+		}
+		s.idempotencyLock.Unlock()
+
+		if ok {
+			switch {
+			case res.fingerprint != fingerprint:
+				writeError(w, http.StatusBadRequest, "idempotency_error", "", fmt.Sprintf("Keys for idempotent requests can only be used with the same parameters they were first used with. Try using a key other than '%s' if you meant to execute a different request.", key))
+			case !res.done:
+				writeError(w, http.StatusConflict, "invalid_request_error", "idempotency_key_in_use", "There is currently another in-progress request using this Stripe token or idempotency key.")
+			default:
+				for k, v := range res.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("Idempotency-Key", key)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(res.status)
+				_, _ = w.Write(res.body)
+			}
+			return
+		}
+
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+
+		s.idempotencyLock.Lock()
+		if rec.Code >= http.StatusInternalServerError {
+			// Requests that failed because of the server can be retried.
+			delete(s.idempotencyKeys, key)
+		} else {
+			s.idempotencyKeys[key] = &idempotentResponse{
+				fingerprint: fingerprint,
+				done:        true,
+				status:      rec.Code,
+				header:      rec.Header().Clone(),
+				body:        rec.Body.Bytes(),
+			}
+		}
+		s.idempotencyLock.Unlock()
+
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Idempotency-Key", key)
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(rec.Body.Bytes())
+	})
+}
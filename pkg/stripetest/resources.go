@@ -0,0 +1,714 @@
+package stripetest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/stripe/stripe-go/v72"
+	"net/http"
+	"time"
+)
+
+// Stripe processing fees applied to charges: 2.9% + 30 cents.
+const (
+	feePerMille = 29
+	feeFixed    = 30
+)
+
+// createCustomer implements https://stripe.com/docs/api/customers/create
+func (s *Server) createCustomer(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c := &stripe.Customer{
+		ID:          s.newID("cus"),
+		Object:      "customer",
+		Created:     s.now().Unix(),
+		Description: r.Form.Get("description"),
+		Email:       r.Form.Get("email"),
+		Name:        r.Form.Get("name"),
+		Metadata:    formMap(r.Form, "metadata"),
+		Livemode:    s.livemode(),
+	}
+	s.customers[c.ID] = c
+	writeJSON(w, c)
+}
+
+// getCustomer implements https://stripe.com/docs/api/customers/retrieve
+func (s *Server) getCustomer(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	c, ok := s.customers[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+	writeJSON(w, c)
+}
+
+// deleteCustomer implements https://stripe.com/docs/api/customers/delete
+func (s *Server) deleteCustomer(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	if _, ok := s.customers[id]; !ok {
+		writeMissing(w, id)
+		return
+	}
+	delete(s.customers, id)
+	writeJSON(w, &stripe.Customer{ID: id, Object: "customer", Deleted: true})
+}
+
+// createSession implements https://stripe.com/docs/api/checkout/sessions/create. The payment intent of the session is
+// created along with it, and it's paid with Server.Pay. Only the first line item is used, with either an active price
+// or inline price data.
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	required := []string{"success_url", "cancel_url", "mode", "line_items[0][price_data][currency]", "line_items[0][price_data][unit_amount]"}
+	if len(r.Form.Get("line_items[0][price]")) > 0 {
+		required = required[:3]
+	}
+	for _, key := range required {
+		if len(r.Form.Get(key)) == 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_missing", "Missing required param: "+key)
+			return
+		}
+	}
+	if mode := r.Form.Get("mode"); mode != string(stripe.CheckoutSessionModePayment) {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", "Unsupported mode: "+mode)
+		return
+	}
+
+	var customer *stripe.Customer
+	if id := r.Form.Get("customer"); len(id) > 0 {
+		if _, ok := s.customers[id]; !ok {
+			writeMissing(w, id)
+			return
+		}
+		customer = &stripe.Customer{ID: id}
+	}
+
+	price := &stripe.Price{
+		Object:   "price",
+		Currency: stripe.Currency(r.Form.Get("line_items[0][price_data][currency]")),
+	}
+	description := r.Form.Get("line_items[0][price_data][product_data][name]")
+	if id := r.Form.Get("line_items[0][price]"); len(id) > 0 {
+		p, ok := s.prices[id]
+		if !ok {
+			writeParamError(w, http.StatusBadRequest, "invalid_request_error", "resource_missing", "line_items[0][price]", fmt.Sprintf("No such price: '%s'", id))
+			return
+		}
+		if !p.Active {
+			writeParamError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", "line_items[0][price]", fmt.Sprintf("The price `%s` is not active.", id))
+			return
+		}
+		c := *p
+		price = &c
+		if product, ok := s.products[p.Product.ID]; ok {
+			description = product.Name
+		}
+	} else {
+		var err error
+		if price.UnitAmount, err = formInt(r.Form, "line_items[0][price_data][unit_amount]"); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", err.Error())
+			return
+		}
+	}
+	unitAmount := price.UnitAmount
+	quantity, err := formInt(r.Form, "line_items[0][quantity]")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", err.Error())
+		return
+	}
+	if quantity <= 0 {
+		quantity = 1
+	}
+	currency := string(price.Currency)
+	amount := unitAmount * quantity
+	now := s.now()
+
+	pi := &stripe.PaymentIntent{
+		ID:                 s.newID("pi"),
+		Amount:             amount,
+		CaptureMethod:      stripe.PaymentIntentCaptureMethodAutomatic,
+		Created:            now.Unix(),
+		Currency:           currency,
+		Customer:           customer,
+		Livemode:           s.livemode(),
+		Metadata:           formMap(r.Form, "payment_intent_data[metadata]"),
+		PaymentMethodTypes: formSlice(r.Form, "payment_method_types"),
+		Status:             stripe.PaymentIntentStatusRequiresPaymentMethod,
+	}
+	if method := r.Form.Get("payment_intent_data[capture_method]"); len(method) > 0 {
+		pi.CaptureMethod = stripe.PaymentIntentCaptureMethod(method)
+	}
+	if destination := r.Form.Get("payment_intent_data[transfer_data][destination]"); len(destination) > 0 {
+		pi.TransferData = &stripe.PaymentIntentTransferData{
+			Destination: &stripe.Account{ID: destination},
+		}
+	}
+	s.paymentIntents[pi.ID] = pi
+
+	session := &stripe.CheckoutSession{
+		ID:             s.newID("cs"),
+		Object:         "checkout.session",
+		AmountSubtotal: amount,
+		AmountTotal:    amount,
+		CancelURL:      r.Form.Get("cancel_url"),
+		Currency:       stripe.Currency(currency),
+		Customer:       customer,
+		ExpiresAt:      now.Add(24 * time.Hour).Unix(),
+		LineItems: &stripe.LineItemList{
+			Data: []*stripe.LineItem{
+				{
+					Object:         "item",
+					Description:    description,
+					Currency:       stripe.Currency(currency),
+					Quantity:       quantity,
+					Price:          price,
+					AmountSubtotal: amount,
+					AmountTotal:    amount,
+				},
+			},
+		},
+		Livemode:           s.livemode(),
+		Metadata:           formMap(r.Form, "metadata"),
+		Mode:               stripe.CheckoutSessionModePayment,
+		PaymentIntent:      &stripe.PaymentIntent{ID: pi.ID},
+		PaymentMethodTypes: pi.PaymentMethodTypes,
+		PaymentStatus:      stripe.CheckoutSessionPaymentStatusUnpaid,
+		SuccessURL:         r.Form.Get("success_url"),
+		TotalDetails:       &stripe.CheckoutSessionTotalDetails{},
+	}
+	s.sessions[session.ID] = session
+	writeJSON(w, session)
+}
+
+// listSessions implements https://stripe.com/docs/api/checkout/sessions/list
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pi := r.Form.Get("payment_intent")
+	ids := make([]string, 0, len(s.sessions))
+	for id, session := range s.sessions {
+		if len(pi) > 0 && session.PaymentIntent.ID != pi {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	out := make([]*stripe.CheckoutSession, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		out = append(out, s.sessions[id])
+	}
+	writeList(w, r, out)
+}
+
+// getSession implements https://stripe.com/docs/api/checkout/sessions/retrieve
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	session, ok := s.sessions[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+	writeJSON(w, session)
+}
+
+// listPaymentIntents implements https://stripe.com/docs/api/payment_intents/list. Charges and their balance
+// transactions are always expanded.
+func (s *Server) listPaymentIntents(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	created := createdFilter(r.Form)
+	customer := r.Form.Get("customer")
+	ids := make([]string, 0, len(s.paymentIntents))
+	for id, pi := range s.paymentIntents {
+		if !created(pi.Created) || (len(customer) > 0 && (pi.Customer == nil || pi.Customer.ID != customer)) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	out := make([]stripe.PaymentIntent, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		out = append(out, s.renderPaymentIntent(s.paymentIntents[id]))
+	}
+	writeList(w, r, out)
+}
+
+// getPaymentIntent implements https://stripe.com/docs/api/payment_intents/retrieve
+func (s *Server) getPaymentIntent(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	pi, ok := s.paymentIntents[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+	writeJSON(w, s.renderPaymentIntent(pi))
+}
+
+// updatePaymentIntent implements https://stripe.com/docs/api/payment_intents/update. Only the metadata and the
+// description can be updated. Metadata keys set to an empty value are removed.
+func (s *Server) updatePaymentIntent(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	pi, ok := s.paymentIntents[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+
+	if pi.Metadata == nil {
+		pi.Metadata = make(map[string]string)
+	}
+	for k, v := range formMap(r.Form, "metadata") {
+		if len(v) == 0 {
+			delete(pi.Metadata, k)
+			continue
+		}
+		pi.Metadata[k] = v
+	}
+	if _, ok := r.Form["description"]; ok {
+		pi.Description = r.Form.Get("description")
+	}
+	writeJSON(w, s.renderPaymentIntent(pi))
+}
+
+// capturePaymentIntent implements https://stripe.com/docs/api/payment_intents/capture. The amount that isn't captured
+// is refunded, and a payment_intent.succeeded event is sent.
+func (s *Server) capturePaymentIntent(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	pi, ok := s.paymentIntents[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "payment_intent_unexpected_state",
+			fmt.Sprintf("This PaymentIntent could not be captured because it has a status of %s.", pi.Status))
+		return
+	}
+
+	amount, err := formInt(r.Form, "amount_to_capture")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", err.Error())
+		return
+	}
+	if amount == 0 {
+		amount = pi.AmountCapturable
+	}
+	if amount < 0 || amount > pi.AmountCapturable {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "amount_too_large", "The amount to capture is greater than the amount capturable.")
+		return
+	}
+	fee, err := formInt(r.Form, "application_fee_amount")
+	if err != nil || fee < 0 || fee > amount {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", "Invalid application_fee_amount")
+		return
+	}
+
+	for _, ch := range s.paymentCharges(pi.ID) {
+		if ch.Captured {
+			continue
+		}
+		ch.Captured = true
+		ch.AmountCaptured = amount
+		ch.AmountRefunded = ch.Amount - amount
+		ch.ApplicationFeeAmount = fee
+		ch.BalanceTransaction = s.newBalanceTransaction(amount, ch.Currency)
+	}
+	pi.Status = stripe.PaymentIntentStatusSucceeded
+	pi.AmountReceived = amount
+	pi.AmountCapturable = 0
+	pi.ApplicationFeeAmount = fee
+
+	out := s.renderPaymentIntent(pi)
+	s.deliverLater(s.newEvent("payment_intent.succeeded", out))
+	writeJSON(w, out)
+}
+
+// listCharges implements https://stripe.com/docs/api/charges/list. Payment intents are always expanded.
+func (s *Server) listCharges(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	created := createdFilter(r.Form)
+	customer := r.Form.Get("customer")
+	pi := r.Form.Get("payment_intent")
+	ids := make([]string, 0, len(s.charges))
+	for id, ch := range s.charges {
+		if !created(ch.Created) || (len(customer) > 0 && (ch.Customer == nil || ch.Customer.ID != customer)) {
+			continue
+		}
+		if len(pi) > 0 && ch.PaymentIntent.ID != pi {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	out := make([]stripe.Charge, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		out = append(out, s.renderCharge(s.charges[id]))
+	}
+	writeList(w, r, out)
+}
+
+// getCharge implements https://stripe.com/docs/api/charges/retrieve
+func (s *Server) getCharge(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	ch, ok := s.charges[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+	writeJSON(w, s.renderCharge(ch))
+}
+
+// createRefund implements https://stripe.com/docs/api/refunds/create. Refunds succeed immediately, and a
+// charge.refunded event is sent.
+func (s *Server) createRefund(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var ch *stripe.Charge
+	switch {
+	case len(r.Form.Get("charge")) > 0:
+		id := r.Form.Get("charge")
+		var ok bool
+		if ch, ok = s.charges[id]; !ok {
+			writeMissing(w, id)
+			return
+		}
+	case len(r.Form.Get("payment_intent")) > 0:
+		id := r.Form.Get("payment_intent")
+		if _, ok := s.paymentIntents[id]; !ok {
+			writeMissing(w, id)
+			return
+		}
+		for _, c := range s.paymentCharges(id) {
+			if c.Captured {
+				ch = c
+			}
+		}
+		if ch == nil {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "charge_not_captured", "This PaymentIntent does not have a successful charge to refund.")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_missing", "One of the following params should be provided for this request: payment_intent or charge.")
+		return
+	}
+
+	if !ch.Captured {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "charge_not_captured", fmt.Sprintf("Charge %s has not been captured.", ch.ID))
+		return
+	}
+	remaining := ch.Amount - ch.AmountRefunded
+	if remaining <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "charge_already_refunded", fmt.Sprintf("Charge %s has already been refunded.", ch.ID))
+		return
+	}
+	amount, err := formInt(r.Form, "amount")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", err.Error())
+		return
+	}
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount < 0 || amount > remaining {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "amount_too_large",
+			fmt.Sprintf("Refund amount (%d) is greater than unrefunded amount on charge (%d)", amount, remaining))
+		return
+	}
+
+	refund := &stripe.Refund{
+		ID:            s.newID("re"),
+		Object:        "refund",
+		Amount:        amount,
+		Charge:        &stripe.Charge{ID: ch.ID},
+		Created:       s.now().Unix(),
+		Currency:      ch.Currency,
+		Metadata:      formMap(r.Form, "metadata"),
+		PaymentIntent: ch.PaymentIntent,
+		Reason:        stripe.RefundReason(r.Form.Get("reason")),
+		Status:        stripe.RefundStatusSucceeded,
+	}
+	s.refunds[refund.ID] = refund
+
+	ch.AmountRefunded += amount
+	ch.Refunded = ch.AmountRefunded == ch.Amount
+
+	s.deliverLater(s.newEvent("charge.refunded", s.renderCharge(ch)))
+	writeJSON(w, refund)
+}
+
+// getRefund implements https://stripe.com/docs/api/refunds/retrieve
+func (s *Server) getRefund(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	refund, ok := s.refunds[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+	writeJSON(w, refund)
+}
+
+// listEvents implements https://stripe.com/docs/api/events/list
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	created := createdFilter(r.Form)
+	types := formSlice(r.Form, "types")
+	if typ := r.Form.Get("type"); len(typ) > 0 {
+		types = append(types, typ)
+	}
+
+	ids := make([]string, 0, len(s.events))
+	for id, e := range s.events {
+		if !created(e.Created) || (len(types) > 0 && !contains(types, e.Type)) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	out := make([]*stripe.Event, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		out = append(out, s.events[id])
+	}
+	writeList(w, r, out)
+}
+
+// getEvent implements https://stripe.com/docs/api/events/retrieve
+func (s *Server) getEvent(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	e, ok := s.events[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+	writeJSON(w, e)
+}
+
+// createWebhookEndpoint implements https://stripe.com/docs/api/webhook_endpoints/create. Events are sent to the
+// endpoint signed with the returned secret.
+func (s *Server) createWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	events := formSlice(r.Form, "enabled_events")
+	if len(r.Form.Get("url")) == 0 || len(events) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_missing", "Missing required param: url or enabled_events")
+		return
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		writeError(w, http.StatusInternalServerError, "api_error", "", err.Error())
+		return
+	}
+
+	e := &stripe.WebhookEndpoint{
+		ID:            s.newID("we"),
+		Object:        "webhook_endpoint",
+		APIVersion:    r.Form.Get("api_version"),
+		Created:       s.now().Unix(),
+		Description:   r.Form.Get("description"),
+		EnabledEvents: events,
+		Livemode:      s.livemode(),
+		Metadata:      formMap(r.Form, "metadata"),
+		Secret:        "whsec_" + hex.EncodeToString(secret),
+		Status:        "enabled",
+		URL:           r.Form.Get("url"),
+	}
+	s.endpoints[e.ID] = e
+	writeJSON(w, e)
+}
+
+// listWebhookEndpoints implements https://stripe.com/docs/api/webhook_endpoints/list. Secrets are not returned.
+func (s *Server) listWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ids := make([]string, 0, len(s.endpoints))
+	for id := range s.endpoints {
+		ids = append(ids, id)
+	}
+
+	out := make([]stripe.WebhookEndpoint, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		e := *s.endpoints[id]
+		e.Secret = ""
+		out = append(out, e)
+	}
+	writeList(w, r, out)
+}
+
+// getWebhookEndpoint implements https://stripe.com/docs/api/webhook_endpoints/retrieve. The secret is not returned.
+func (s *Server) getWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	e, ok := s.endpoints[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+	out := *e
+	out.Secret = ""
+	writeJSON(w, out)
+}
+
+// deleteWebhookEndpoint implements https://stripe.com/docs/api/webhook_endpoints/delete
+func (s *Server) deleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	if _, ok := s.endpoints[id]; !ok {
+		writeMissing(w, id)
+		return
+	}
+	delete(s.endpoints, id)
+	writeJSON(w, &stripe.WebhookEndpoint{ID: id, Object: "webhook_endpoint", Deleted: true})
+}
+
+// updateWebhookEndpoint implements https://stripe.com/docs/api/webhook_endpoints/update
+func (s *Server) updateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := chi.URLParam(r, "id")
+	e, ok := s.endpoints[id]
+	if !ok {
+		writeMissing(w, id)
+		return
+	}
+
+	if events := formSlice(r.Form, "enabled_events"); len(events) > 0 {
+		e.EnabledEvents = events
+	}
+	if url := r.Form.Get("url"); len(url) > 0 {
+		e.URL = url
+	}
+	if _, ok := r.Form["description"]; ok {
+		e.Description = r.Form.Get("description")
+	}
+	switch r.Form.Get("disabled") {
+	case "true":
+		e.Status = "disabled"
+	case "false":
+		e.Status = "enabled"
+	}
+
+	out := *e
+	out.Secret = ""
+	writeJSON(w, out)
+}
+
+// paymentCharges returns the charges of the given payment intent. It must be called with the lock held.
+func (s *Server) paymentCharges(pi string) []*stripe.Charge {
+	var ids []string
+	for id, ch := range s.charges {
+		if ch.PaymentIntent.ID == pi {
+			ids = append(ids, id)
+		}
+	}
+
+	out := make([]*stripe.Charge, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		out = append(out, s.charges[id])
+	}
+	return out
+}
+
+// newBalanceTransaction creates the balance transaction of a charge of the given amount, applying Stripe fees. It
+// must be called with the lock held.
+func (s *Server) newBalanceTransaction(amount int64, currency stripe.Currency) *stripe.BalanceTransaction {
+	now := s.now()
+	fee := (amount*feePerMille+500)/1000 + feeFixed
+	return &stripe.BalanceTransaction{
+		ID:          s.newID("txn"),
+		Object:      "balance_transaction",
+		Amount:      amount,
+		AvailableOn: now.Add(48 * time.Hour).Unix(),
+		Created:     now.Unix(),
+		Currency:    currency,
+		Fee:         fee,
+		Net:         amount - fee,
+		Status:      stripe.BalanceTransactionStatusPending,
+		Type:        stripe.BalanceTransactionTypeCharge,
+	}
+}
+
+// renderPaymentIntent returns a copy of the given payment intent with its charges expanded. It must be called with the
+// lock held.
+func (s *Server) renderPaymentIntent(pi *stripe.PaymentIntent) stripe.PaymentIntent {
+	out := *pi
+	out.Charges = &stripe.ChargeList{
+		ListMeta: stripe.ListMeta{URL: "/v1/charges?payment_intent=" + pi.ID},
+	}
+	for _, ch := range s.paymentCharges(pi.ID) {
+		c := *ch
+		out.Charges.Data = append(out.Charges.Data, &c)
+	}
+	return out
+}
+
+// renderCharge returns a copy of the given charge with its payment intent expanded. It must be called with the lock
+// held.
+func (s *Server) renderCharge(ch *stripe.Charge) stripe.Charge {
+	out := *ch
+	if pi, ok := s.paymentIntents[ch.PaymentIntent.ID]; ok {
+		p := *pi
+		out.PaymentIntent = &p
+	}
+	return out
+}
+
+// livemode returns true if the server emulates live mode, based on its secret key.
+func (s *Server) livemode() bool {
+	return s.Config().Livemode()
+}
+
+// contains returns true if the given list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+// Package stripetest provides an in-process emulator of the subset of the Stripe API used by the payments service.
+// It's meant to test the whole payment flow offline: point conf.Stripe.URL to a Server, create checkout sessions
+// through the adapter, and pay them with Server.Pay to have correctly signed webhook events sent to the payments
+// service.
+package stripetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/stripe/stripe-go/v72"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSecretKey is the secret key accepted by a Server when Options.SecretKey is empty.
+	DefaultSecretKey = "sk_test_stripetest"
+
+	// DefaultSigningSecret is the secret used to sign the events sent to Options.WebhookURL when
+	// Options.SigningSecret is empty.
+	DefaultSigningSecret = "whsec_stripetest"
+)
+
+// Options contains the configuration of a Server.
+type Options struct {
+	// SecretKey is the only API key accepted by the server. Defaults to DefaultSecretKey.
+	SecretKey string
+
+	// SigningSecret is the secret used to sign the events sent to WebhookURL. Defaults to DefaultSigningSecret.
+	SigningSecret string
+
+	// WebhookURL is the URL events are sent to, in addition to the webhook endpoints created through the API.
+	WebhookURL string
+
+	// WebhookEvents contains the types of the events sent to WebhookURL. Every event is sent if it's empty.
+	WebhookEvents []string
+}
+
+// Server is an in-process Stripe API emulator. It supports customers, products, prices, checkout sessions, payment
+// intents, charges, refunds, events, webhook endpoints and idempotent requests. Every object is kept in memory and is
+// lost when the server is closed.
+type Server struct {
+	// URL is the base URL of the server, to be used as conf.Stripe.URL.
+	URL string
+
+	opts   Options
+	server *httptest.Server
+	client *http.Client
+	now    func() time.Time
+
+	// pending counts the events being sent in the background.
+	pending sync.WaitGroup
+
+	lock           sync.Mutex
+	seq            int
+	customers      map[string]*stripe.Customer
+	products       map[string]*stripe.Product
+	prices         map[string]*stripe.Price
+	sessions       map[string]*stripe.CheckoutSession
+	paymentIntents map[string]*stripe.PaymentIntent
+	charges        map[string]*stripe.Charge
+	refunds        map[string]*stripe.Refund
+	events         map[string]*stripe.Event
+	endpoints      map[string]*stripe.WebhookEndpoint
+	deliveries     []Delivery
+	lastSigned     int64
+
+	// idempotencyLock is used to synchronize access to idempotencyKeys.
+	idempotencyLock sync.Mutex
+	// idempotencyKeys contains the responses recorded for every idempotency key.
+	idempotencyKeys map[string]*idempotentResponse
+}
+
+// NewServer starts a new Server. It must be closed with Close when it's no longer needed.
+func NewServer(opts Options) *Server {
+	if len(opts.SecretKey) == 0 {
+		opts.SecretKey = DefaultSecretKey
+	}
+	if len(opts.SigningSecret) == 0 {
+		opts.SigningSecret = DefaultSigningSecret
+	}
+
+	s := &Server{
+		opts:           opts,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		now:            time.Now,
+		customers:      make(map[string]*stripe.Customer),
+		products:       make(map[string]*stripe.Product),
+		prices:         make(map[string]*stripe.Price),
+		sessions:       make(map[string]*stripe.CheckoutSession),
+		paymentIntents: make(map[string]*stripe.PaymentIntent),
+		charges:        make(map[string]*stripe.Charge),
+		refunds:        make(map[string]*stripe.Refund),
+		events:         make(map[string]*stripe.Event),
+		endpoints:      make(map[string]*stripe.WebhookEndpoint),
+
+		idempotencyKeys: make(map[string]*idempotentResponse),
+	}
+	s.server = httptest.NewServer(s.routes())
+	s.URL = s.server.URL
+	return s
+}
+
+// Close waits for the events being sent and shuts the server down.
+func (s *Server) Close() {
+	s.pending.Wait()
+	s.server.Close()
+}
+
+// Config returns a conf.Stripe that points the Stripe adapter to the server.
+func (s *Server) Config() conf.Stripe {
+	return conf.Stripe{
+		SigningKey: s.opts.SigningSecret,
+		SecretKey:  s.opts.SecretKey,
+		URL:        s.URL,
+	}
+}
+
+// routes returns the handler of the Stripe API endpoints.
+func (s *Server) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(s.authenticate)
+	r.Use(s.idempotent)
+
+	r.Post("/v1/customers", s.createCustomer)
+	r.Get("/v1/customers/{id}", s.getCustomer)
+	r.Delete("/v1/customers/{id}", s.deleteCustomer)
+
+	r.Post("/v1/products", s.createProduct)
+	r.Get("/v1/products", s.listProducts)
+	r.Post("/v1/products/{id}", s.updateProduct)
+
+	r.Post("/v1/prices", s.createPrice)
+	r.Get("/v1/prices", s.listPrices)
+	r.Get("/v1/prices/{id}", s.getPrice)
+	r.Post("/v1/prices/{id}", s.updatePrice)
+
+	r.Post("/v1/checkout/sessions", s.createSession)
+	r.Get("/v1/checkout/sessions", s.listSessions)
+	r.Get("/v1/checkout/sessions/{id}", s.getSession)
+
+	r.Get("/v1/payment_intents", s.listPaymentIntents)
+	r.Get("/v1/payment_intents/{id}", s.getPaymentIntent)
+	r.Post("/v1/payment_intents/{id}", s.updatePaymentIntent)
+	r.Post("/v1/payment_intents/{id}/capture", s.capturePaymentIntent)
+
+	r.Get("/v1/charges", s.listCharges)
+	r.Get("/v1/charges/{id}", s.getCharge)
+
+	r.Post("/v1/refunds", s.createRefund)
+	r.Get("/v1/refunds/{id}", s.getRefund)
+
+	r.Get("/v1/events", s.listEvents)
+	r.Get("/v1/events/{id}", s.getEvent)
+
+	r.Post("/v1/webhook_endpoints", s.createWebhookEndpoint)
+	r.Get("/v1/webhook_endpoints", s.listWebhookEndpoints)
+	r.Get("/v1/webhook_endpoints/{id}", s.getWebhookEndpoint)
+	r.Post("/v1/webhook_endpoints/{id}", s.updateWebhookEndpoint)
+	r.Delete("/v1/webhook_endpoints/{id}", s.deleteWebhookEndpoint)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "", fmt.Sprintf("Unrecognized request URL (%s: %s)", r.Method, r.URL.Path))
+	})
+	return r
+}
+
+// authenticate rejects requests that don't use the configured secret key.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+s.opts.SecretKey {
+			writeError(w, http.StatusUnauthorized, "invalid_request_error", "", "Invalid API Key provided")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newID returns a new object identifier with the given prefix. It must be called with the lock held.
+func (s *Server) newID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s_stripetest%08d", prefix, s.seq)
+}
+
+// writeJSON writes the given object as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a Stripe API error.
+func writeError(w http.ResponseWriter, status int, typ, code, message string) {
+	writeParamError(w, status, typ, code, "", message)
+}
+
+// writeParamError writes a Stripe error caused by the given request parameter.
+func writeParamError(w http.ResponseWriter, status int, typ, code, param, message string) {
+	body := map[string]string{
+		"type":    typ,
+		"code":    code,
+		"message": message,
+	}
+	if len(param) > 0 {
+		body["param"] = param
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
+
+// writeMissing writes the error returned by Stripe when an object doesn't exist.
+func writeMissing(w http.ResponseWriter, id string) {
+	writeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", fmt.Sprintf("No such object: '%s'", id))
+}
+
+// writeList writes a Stripe list object containing the given objects. Every object is returned in a single page.
+func writeList(w http.ResponseWriter, r *http.Request, data interface{}) {
+	writeJSON(w, map[string]interface{}{
+		"object":   "list",
+		"url":      r.URL.Path,
+		"has_more": false,
+		"data":     data,
+	})
+}
+
+// formMap returns the values of the form keys of the form prefix[key], such as metadata[application].
+func formMap(form url.Values, prefix string) map[string]string {
+	out := make(map[string]string)
+	for k, v := range form {
+		if !strings.HasPrefix(k, prefix+"[") || !strings.HasSuffix(k, "]") || len(v) == 0 {
+			continue
+		}
+		key := k[len(prefix)+1 : len(k)-1]
+		if strings.ContainsAny(key, "[]") {
+			continue
+		}
+		out[key] = v[0]
+	}
+	return out
+}
+
+// formSlice returns the values of the form keys of the form prefix[0], prefix[1]... or prefix[].
+func formSlice(form url.Values, prefix string) []string {
+	if v, ok := form[prefix+"[]"]; ok {
+		return v
+	}
+	var out []string
+	for i := 0; ; i++ {
+		v, ok := form[fmt.Sprintf("%s[%d]", prefix, i)]
+		if !ok || len(v) == 0 {
+			return out
+		}
+		out = append(out, v[0])
+	}
+}
+
+// formInt returns the integer value of the given form key, or 0 if it's not set.
+func formInt(form url.Values, key string) (int64, error) {
+	v := form.Get(key)
+	if len(v) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer for %s: %s", key, v)
+	}
+	return n, nil
+}
+
+// createdFilter returns a function that reports whether a creation time matches the created[gte], created[gt],
+// created[lte] and created[lt] filters of the given list request.
+func createdFilter(form url.Values) func(created int64) bool {
+	bounds := make(map[string]int64)
+	for _, op := range []string{"gte", "gt", "lte", "lt"} {
+		if n, err := formInt(form, "created["+op+"]"); err == nil && len(form.Get("created["+op+"]")) > 0 {
+			bounds[op] = n
+		}
+	}
+	return func(created int64) bool {
+		if n, ok := bounds["gte"]; ok && created < n {
+			return false
+		}
+		if n, ok := bounds["gt"]; ok && created <= n {
+			return false
+		}
+		if n, ok := bounds["lte"]; ok && created > n {
+			return false
+		}
+		if n, ok := bounds["lt"]; ok && created >= n {
+			return false
+		}
+		return true
+	}
+}
+
+// sortedIDs returns the given IDs sorted newest first, as returned by Stripe list endpoints. IDs are generated from a
+// sequence, so they sort in creation order.
+func sortedIDs(ids []string) []string {
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids
+}
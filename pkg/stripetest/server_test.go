@@ -0,0 +1,431 @@
+package stripetest
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/client"
+	customers "gitlab.com/ignitionrobotics/billing/customers/pkg/api"
+	"gitlab.com/ignitionrobotics/billing/payments/internal/conf"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/adapter"
+	"gitlab.com/ignitionrobotics/billing/payments/pkg/api"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder is a webhook endpoint that records the received events.
+type webhookRecorder struct {
+	lock       sync.Mutex
+	bodies     [][]byte
+	signatures []string
+	server     *httptest.Server
+}
+
+func newWebhookRecorder(t *testing.T) *webhookRecorder {
+	rec := &webhookRecorder{}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		rec.lock.Lock()
+		defer rec.lock.Unlock()
+		rec.bodies = append(rec.bodies, body)
+		rec.signatures = append(rec.signatures, r.Header.Get("Stripe-Signature"))
+	}))
+	t.Cleanup(rec.server.Close)
+	return rec
+}
+
+func createSession(t *testing.T, client adapter.Client, req api.CreateSessionRequest) string {
+	cus, err := client.CreateCustomer(context.Background(), req.Application, req.Handle)
+	require.NoError(t, err)
+	require.NotEmpty(t, cus)
+
+	res, err := client.CreateSession(context.Background(), req, customers.CustomerResponse{ID: cus})
+	require.NoError(t, err)
+	require.NotEmpty(t, res.Session)
+	return res.Session
+}
+
+func TestCheckoutFlow(t *testing.T) {
+	var received []api.ChargeRequest
+	var client adapter.Client
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req, err := client.GenerateChargeRequest(context.Background(), body, r.Header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		received = append(received, req)
+		require.NoError(t, client.MarkCharged(context.Background(), req))
+	}))
+	defer target.Close()
+
+	srv := NewServer(Options{
+		WebhookURL:    target.URL,
+		WebhookEvents: adapter.WebhookEvents,
+	})
+	defer srv.Close()
+	client = adapter.NewStripeAdapter(srv.Config())
+
+	session := createSession(t, client, api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  "https://example.com/success",
+		CancelURL:   "https://example.com/cancel",
+		Handle:      "alice",
+		Application: "fuel",
+		UnitPrice:   100,
+		Currency:    "usd",
+	})
+
+	pi, err := srv.Pay(session, 5)
+	require.NoError(t, err)
+	assert.Equal(t, stripe.PaymentIntentStatusSucceeded, pi.Status)
+
+	require.Len(t, received, 1)
+	assert.Equal(t, uint(500), received[0].Amount)
+	assert.Equal(t, "usd", received[0].Currency)
+	assert.Equal(t, "fuel", received[0].Application)
+	assert.Equal(t, pi.ID, received[0].Payment)
+	assert.Equal(t, pi.Customer.ID, received[0].Customer)
+
+	marked, ok := srv.PaymentIntent(pi.ID)
+	require.True(t, ok)
+	assert.Equal(t, received[0].Event, marked.Metadata[adapter.MetadataChargedEvent])
+
+	_, err = srv.Pay(session, 5)
+	assert.Error(t, err)
+
+	inspector := adapter.NewStripeInspector(srv.Config())
+	s, err := inspector.GetSession(context.Background(), session)
+	require.NoError(t, err)
+	assert.Equal(t, "paid", s.PaymentStatus)
+	assert.Equal(t, int64(500), s.AmountTotal)
+	assert.Equal(t, pi.ID, s.PaymentIntent)
+
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	events, err := inspector.ListChargeEvents(context.Background(), from, to)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.True(t, events[0].Charged)
+
+	var payments []adapter.Payment
+	require.NoError(t, inspector.ListPayments(context.Background(), from, to, "", func(p adapter.Payment) error {
+		payments = append(payments, p)
+		return nil
+	}))
+	require.Len(t, payments, 1)
+	assert.Equal(t, session, payments[0].Session)
+	assert.Equal(t, int64(500), payments[0].Gross)
+	assert.Equal(t, int64(45), payments[0].Fee)
+	assert.Equal(t, int64(455), payments[0].Net)
+	assert.Equal(t, "alice", payments[0].Handle)
+}
+
+func TestCatalog(t *testing.T) {
+	srv := NewServer(Options{})
+	defer srv.Close()
+	catalog := adapter.NewStripeCatalog(srv.Config())
+	ctx := context.Background()
+
+	product := adapter.Product{ID: "credits_fuel", Application: "fuel", Name: "Credits (fuel)", Active: true}
+	require.NoError(t, catalog.CreateProduct(ctx, product))
+	products, err := catalog.ListProducts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []adapter.Product{product}, products)
+
+	price := adapter.Price{Product: product.ID, LookupKey: "credits_fuel_usd", Currency: "usd", UnitAmount: 100}
+	first, err := catalog.CreatePrice(ctx, price)
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.ID)
+
+	// The lookup key is transferred to the new price
+	price.UnitAmount = 120
+	second, err := catalog.CreatePrice(ctx, price)
+	require.NoError(t, err)
+	found, err := catalog.GetPrice(ctx, price.LookupKey)
+	require.NoError(t, err)
+	assert.Equal(t, second, found)
+	require.NoError(t, catalog.ArchivePrice(ctx, first.ID))
+
+	prices, err := catalog.ListPrices(ctx, product.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []adapter.Price{second}, prices)
+
+	client := adapter.NewStripeAdapter(srv.Config())
+	session := createSession(t, client, api.CreateSessionRequest{
+		Service:     api.PaymentServiceStripe,
+		SuccessURL:  "https://example.com/success",
+		CancelURL:   "https://example.com/cancel",
+		Handle:      "alice",
+		Application: "fuel",
+		UnitPrice:   120,
+		Currency:    "usd",
+		Price:       second.ID,
+	})
+	pi, err := srv.Pay(session, 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(600), pi.Amount)
+
+	// Archived prices can't be used in new sessions
+	cus, err := client.CreateCustomer(ctx, "fuel", "bob")
+	require.NoError(t, err)
+	_, err = client.CreateSession(ctx, api.CreateSessionRequest{
+		SuccessURL:  "https://example.com/success",
+		CancelURL:   "https://example.com/cancel",
+		Handle:      "bob",
+		Application: "fuel",
+		UnitPrice:   100,
+		Currency:    "usd",
+		Price:       first.ID,
+	}, customers.CustomerResponse{ID: cus})
+	assert.ErrorIs(t, err, adapter.ErrPriceRejected)
+	assert.False(t, adapter.Retryable(err))
+}
+
+func TestWebhookDeliveryFailure(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer target.Close()
+
+	srv := NewServer(Options{WebhookURL: target.URL})
+	defer srv.Close()
+	client := adapter.NewStripeAdapter(srv.Config())
+
+	session := createSession(t, client, api.CreateSessionRequest{
+		Handle:      "alice",
+		Application: "fuel",
+		SuccessURL:  "https://example.com/success",
+		CancelURL:   "https://example.com/cancel",
+		UnitPrice:   100,
+		Currency:    "usd",
+	})
+
+	_, err := srv.Pay(session, 1)
+	assert.Error(t, err)
+
+	deliveries := srv.Deliveries()
+	require.Len(t, deliveries, 3)
+	assert.Equal(t, http.StatusServiceUnavailable, deliveries[1].StatusCode)
+	assert.Equal(t, "payment_intent.succeeded", deliveries[1].Type)
+
+	assert.Error(t, srv.Resend(deliveries[1].Event))
+	assert.Len(t, srv.Deliveries(), 4)
+}
+
+func TestWebhookEndpoint(t *testing.T) {
+	rec := newWebhookRecorder(t)
+	srv := NewServer(Options{})
+	defer srv.Close()
+
+	cfg := srv.Config()
+	cfg.WebhookURL = rec.server.URL
+	endpoint, err := adapter.EnsureStripeWebhookEndpoint(context.Background(), cfg, adapter.WebhookEndpointOptions{URL: cfg.WebhookURL, Events: adapter.WebhookEvents})
+	require.NoError(t, err)
+	assert.True(t, endpoint.Created)
+	require.NotEmpty(t, endpoint.Secret)
+	secret := endpoint.Secret
+
+	endpoint, err = adapter.EnsureStripeWebhookEndpoint(context.Background(), cfg, adapter.WebhookEndpointOptions{URL: cfg.WebhookURL, Events: adapter.WebhookEvents})
+	require.NoError(t, err)
+	assert.False(t, endpoint.Created)
+	assert.False(t, endpoint.Updated)
+
+	session := createSession(t, adapter.NewStripeAdapter(cfg), api.CreateSessionRequest{
+		Handle:      "alice",
+		Application: "fuel",
+		SuccessURL:  "https://example.com/success",
+		CancelURL:   "https://example.com/cancel",
+		UnitPrice:   100,
+		Currency:    "usd",
+	})
+	_, err = srv.Pay(session, 2)
+	require.NoError(t, err)
+
+	// Events are signed with the endpoint secret
+	require.Len(t, rec.bodies, 1)
+	deliveries := srv.Deliveries()
+	require.Len(t, deliveries, 1)
+
+	cfg.SigningKey = secret
+	req, err := adapter.NewStripeAdapter(cfg).GenerateChargeRequest(context.Background(), rec.bodies[0], map[string][]string{
+		"Stripe-Signature": {rec.signatures[0]},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(200), req.Amount)
+}
+
+func TestCaptureAndRefund(t *testing.T) {
+	rec := newWebhookRecorder(t)
+	srv := NewServer(Options{WebhookURL: rec.server.URL})
+	defer srv.Close()
+
+	session := createSession(t, adapter.NewStripeAdapter(srv.Config()), api.CreateSessionRequest{
+		Handle:                "alice",
+		Application:           "fuel",
+		SuccessURL:            "https://example.com/success",
+		CancelURL:             "https://example.com/cancel",
+		UnitPrice:             100,
+		Currency:              "usd",
+		Destination:           "acct_1",
+		ApplicationFeePercent: 10,
+	})
+	pi, err := srv.Pay(session, 3)
+	require.NoError(t, err)
+	assert.Equal(t, stripe.PaymentIntentStatusRequiresCapture, pi.Status)
+	assert.Equal(t, int64(300), pi.AmountCapturable)
+	assert.Equal(t, "acct_1", pi.TransferData.Destination.ID)
+
+	connector := adapter.NewStripeConnector(srv.Config(), conf.Connect{})
+	require.NoError(t, connector.Capture(context.Background(), api.CaptureRequest{
+		Payment:               pi.ID,
+		Amount:                300,
+		ApplicationFeePercent: 10,
+	}))
+	srv.Wait()
+
+	deliveries := srv.Deliveries()
+	require.Len(t, deliveries, 4)
+	assert.Equal(t, "payment_intent.amount_capturable_updated", deliveries[1].Type)
+	assert.Equal(t, "payment_intent.succeeded", deliveries[3].Type)
+
+	sc := client.New(DefaultSecretKey, &stripe.Backends{
+		API: stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{URL: stripe.String(srv.URL)}),
+	})
+	refund, err := sc.Refunds.New(&stripe.RefundParams{
+		PaymentIntent: stripe.String(pi.ID),
+		Amount:        stripe.Int64(100),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, stripe.RefundStatusSucceeded, refund.Status)
+
+	_, err = sc.Refunds.New(&stripe.RefundParams{
+		PaymentIntent: stripe.String(pi.ID),
+		Amount:        stripe.Int64(300),
+	})
+	assert.Error(t, err)
+	srv.Wait()
+
+	captured, ok := srv.PaymentIntent(pi.ID)
+	require.True(t, ok)
+	assert.Equal(t, stripe.PaymentIntentStatusSucceeded, captured.Status)
+	require.Len(t, captured.Charges.Data, 1)
+	assert.Equal(t, int64(30), captured.Charges.Data[0].ApplicationFeeAmount)
+	assert.Equal(t, int64(100), captured.Charges.Data[0].AmountRefunded)
+	assert.Equal(t, "charge.refunded", srv.Deliveries()[4].Type)
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv := NewServer(Options{})
+	defer srv.Close()
+
+	cfg := srv.Config()
+	cfg.SecretKey = "sk_test_other"
+	_, err := adapter.NewStripeInspector(cfg).GetSession(context.Background(), "cs_1")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, adapter.ErrAuthentication)
+	var stripeErr *stripe.Error
+	require.ErrorAs(t, err, &stripeErr)
+	assert.Equal(t, http.StatusUnauthorized, stripeErr.HTTPStatusCode)
+}
+
+func TestIdempotentRequests(t *testing.T) {
+	srv := NewServer(Options{})
+	defer srv.Close()
+
+	post := func(key, description string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/customers", strings.NewReader(url.Values{
+			"description": {description},
+		}.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+DefaultSecretKey)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if len(key) > 0 {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+	decode := func(res *http.Response) stripe.Customer {
+		var c stripe.Customer
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&c))
+		return c
+	}
+
+	first := post("key-1", "alice")
+	require.Equal(t, http.StatusOK, first.StatusCode)
+	assert.Empty(t, first.Header.Get("Idempotent-Replayed"))
+
+	// Repeated requests get the recorded response.
+	replayed := post("key-1", "alice")
+	require.Equal(t, http.StatusOK, replayed.StatusCode)
+	assert.Equal(t, "true", replayed.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, decode(first).ID, decode(replayed).ID)
+
+	// Keys can't be reused with different parameters.
+	reused := post("key-1", "bob")
+	require.Equal(t, http.StatusBadRequest, reused.StatusCode)
+	var body struct {
+		Error stripe.Error `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(reused.Body).Decode(&body))
+	assert.Equal(t, stripe.ErrorTypeIdempotency, body.Error.Type)
+
+	// Requests without a key are never deduplicated.
+	a, b := post("", "alice"), post("", "alice")
+	assert.NotEqual(t, decode(a).ID, decode(b).ID)
+}
+
+func TestSignedEvent(t *testing.T) {
+	a := adapter.NewStripeAdapter(conf.Stripe{SigningKey: DefaultSigningSecret, SecretKey: DefaultSecretKey})
+
+	event := PaymentIntentSucceeded(PaymentIntent{
+		ID:          "pi_signed",
+		Customer:    "cus_signed",
+		Amount:      1500,
+		Application: "app",
+		Handle:      "alice",
+	})
+	signed, err := event.Sign(DefaultSigningSecret, time.Now())
+	require.NoError(t, err)
+
+	req, err := a.GenerateChargeRequest(context.Background(), signed.Body, signed.Header())
+	require.NoError(t, err)
+	assert.Equal(t, uint(1500), req.Amount)
+	assert.Equal(t, "usd", req.Currency)
+	assert.Equal(t, "cus_signed", req.Customer)
+	assert.Equal(t, "app", req.Application)
+	assert.Equal(t, event.ID, req.Event)
+	assert.Equal(t, "pi_signed", req.Payment)
+
+	signed, err = event.Sign("whsec_unknown", time.Now())
+	require.NoError(t, err)
+	_, err = a.GenerateChargeRequest(context.Background(), signed.Body, signed.Header())
+	assert.Error(t, err)
+
+	refund, err := ChargeRefunded(Charge{PaymentIntent: "pi_signed", Amount: 1500}).Sign(DefaultSigningSecret, time.Now())
+	require.NoError(t, err)
+	_, err = a.GenerateChargeRequest(context.Background(), refund.Body, refund.Header())
+	assert.ErrorIs(t, err, adapter.ErrUnhandledEvent)
+
+	var ch stripe.Charge
+	var e stripe.Event
+	require.NoError(t, json.Unmarshal(refund.Body, &e))
+	require.NoError(t, json.Unmarshal(e.Data.Raw, &ch))
+	assert.Equal(t, "charge.refunded", e.Type)
+	assert.True(t, ch.Refunded)
+	assert.Equal(t, int64(1500), ch.AmountRefunded)
+	assert.Equal(t, "pi_signed", ch.PaymentIntent.ID)
+}
@@ -0,0 +1,302 @@
+package stripetest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Delivery is the result of sending an event to a webhook endpoint.
+type Delivery struct {
+	// Event is the ID of the event.
+	Event string
+
+	// Type is the type of the event.
+	Type string
+
+	// URL is the URL the event has been sent to.
+	URL string
+
+	// StatusCode is the HTTP status code returned by the endpoint. It's 0 if the request failed.
+	StatusCode int
+
+	// Err is set if the request failed or the endpoint didn't return a 2xx status code.
+	Err error
+}
+
+// target is a URL events are sent to, and the secret used to sign them.
+type target struct {
+	url    string
+	secret string
+}
+
+// Pay pays the given checkout session as a customer would do in the checkout page, buying the given quantity of the
+// session's line item. If quantity is 0, the quantity set when creating the session is used. The payment intent of
+// the session succeeds, or requires capture if it has been created with manual capture, and the resulting events are
+// sent to the webhook endpoints before returning. The returned error reports any failed delivery.
+func (s *Server) Pay(session string, quantity int64) (stripe.PaymentIntent, error) {
+	s.lock.Lock()
+
+	cs, ok := s.sessions[session]
+	if !ok {
+		s.lock.Unlock()
+		return stripe.PaymentIntent{}, fmt.Errorf("no such checkout session: %s", session)
+	}
+	if cs.PaymentStatus != stripe.CheckoutSessionPaymentStatusUnpaid {
+		s.lock.Unlock()
+		return stripe.PaymentIntent{}, fmt.Errorf("checkout session %s has already been paid", session)
+	}
+
+	item := cs.LineItems.Data[0]
+	if quantity <= 0 {
+		quantity = item.Quantity
+	}
+	amount := item.Price.UnitAmount * quantity
+	item.Quantity = quantity
+	item.AmountSubtotal = amount
+	item.AmountTotal = amount
+	cs.AmountSubtotal = amount
+	cs.AmountTotal = amount
+	cs.PaymentStatus = stripe.CheckoutSessionPaymentStatusPaid
+
+	pi := s.paymentIntents[cs.PaymentIntent.ID]
+	pi.Amount = amount
+
+	ch := &stripe.Charge{
+		ID:            s.newID("ch"),
+		Object:        "charge",
+		Amount:        amount,
+		Created:       s.now().Unix(),
+		Currency:      stripe.Currency(pi.Currency),
+		Customer:      pi.Customer,
+		Livemode:      s.livemode(),
+		Paid:          true,
+		PaymentIntent: &stripe.PaymentIntent{ID: pi.ID},
+		Status:        string(stripe.PaymentIntentStatusSucceeded),
+	}
+	s.charges[ch.ID] = ch
+
+	paymentEvent := "payment_intent.succeeded"
+	if pi.CaptureMethod == stripe.PaymentIntentCaptureMethodManual {
+		paymentEvent = "payment_intent.amount_capturable_updated"
+		pi.Status = stripe.PaymentIntentStatusRequiresCapture
+		pi.AmountCapturable = amount
+	} else {
+		ch.Captured = true
+		ch.AmountCaptured = amount
+		ch.BalanceTransaction = s.newBalanceTransaction(amount, ch.Currency)
+		pi.Status = stripe.PaymentIntentStatusSucceeded
+		pi.AmountReceived = amount
+	}
+
+	out := s.renderPaymentIntent(pi)
+	completed := *cs
+	events := []*stripe.Event{
+		s.newEvent("charge.succeeded", s.renderCharge(ch)),
+		s.newEvent(paymentEvent, out),
+		s.newEvent("checkout.session.completed", completed),
+	}
+	targets := make([][]target, len(events))
+	for i, e := range events {
+		targets[i] = s.targets(e)
+	}
+	s.lock.Unlock()
+
+	var err error
+	for i, e := range events {
+		if deliveryErr := s.deliver(e, targets[i]); deliveryErr != nil && err == nil {
+			err = deliveryErr
+		}
+	}
+	return out, err
+}
+
+// Resend sends the given event to the webhook endpoints again. The returned error reports any failed delivery.
+func (s *Server) Resend(event string) error {
+	s.lock.Lock()
+	e, ok := s.events[event]
+	if !ok {
+		s.lock.Unlock()
+		return fmt.Errorf("no such event: %s", event)
+	}
+	targets := s.targets(e)
+	s.lock.Unlock()
+
+	return s.deliver(e, targets)
+}
+
+// Deliveries returns the result of every event sent to the webhook endpoints, oldest first.
+func (s *Server) Deliveries() []Delivery {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	out := make([]Delivery, len(s.deliveries))
+	copy(out, s.deliveries)
+	return out
+}
+
+// Wait waits for the events triggered by API requests, such as payment intent captures and refunds, to be sent.
+func (s *Server) Wait() {
+	s.pending.Wait()
+}
+
+// PaymentIntent returns the payment intent identified by the given ID, with its charges expanded.
+func (s *Server) PaymentIntent(id string) (stripe.PaymentIntent, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pi, ok := s.paymentIntents[id]
+	if !ok {
+		return stripe.PaymentIntent{}, false
+	}
+	return s.renderPaymentIntent(pi), true
+}
+
+// Customers returns the customers that have not been deleted, sorted by ID.
+func (s *Server) Customers() []stripe.Customer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ids := make([]string, 0, len(s.customers))
+	for id := range s.customers {
+		ids = append(ids, id)
+	}
+	out := make([]stripe.Customer, 0, len(ids))
+	for _, id := range sortedIDs(ids) {
+		out = append(out, *s.customers[id])
+	}
+	return out
+}
+
+// newEvent records a new event of the given type containing the given object. It must be called with the lock held.
+func (s *Server) newEvent(typ string, object interface{}) *stripe.Event {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		panic(fmt.Sprintf("stripetest: marshaling %s event: %v", typ, err))
+	}
+	e := &stripe.Event{
+		ID:       s.newID("evt"),
+		Created:  s.now().Unix(),
+		Data:     &stripe.EventData{Raw: raw},
+		Livemode: s.livemode(),
+		Type:     typ,
+	}
+	s.events[e.ID] = e
+	return e
+}
+
+// targets returns where the given event must be sent: Options.WebhookURL and the enabled webhook endpoints subscribed
+// to the event type. It must be called with the lock held.
+func (s *Server) targets(e *stripe.Event) []target {
+	var out []target
+	if len(s.opts.WebhookURL) > 0 && (len(s.opts.WebhookEvents) == 0 || contains(s.opts.WebhookEvents, e.Type)) {
+		out = append(out, target{url: s.opts.WebhookURL, secret: s.opts.SigningSecret})
+	}
+	for _, id := range sortedIDs(s.endpointIDs()) {
+		endpoint := s.endpoints[id]
+		if endpoint.Status != "enabled" {
+			continue
+		}
+		if contains(endpoint.EnabledEvents, e.Type) || contains(endpoint.EnabledEvents, "*") {
+			out = append(out, target{url: endpoint.URL, secret: endpoint.Secret})
+		}
+	}
+	return out
+}
+
+// endpointIDs returns the IDs of the webhook endpoints. It must be called with the lock held.
+func (s *Server) endpointIDs() []string {
+	ids := make([]string, 0, len(s.endpoints))
+	for id := range s.endpoints {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// deliverLater sends the given event in the background, as Stripe does with the events triggered by API requests. It
+// must be called with the lock held.
+func (s *Server) deliverLater(e *stripe.Event) {
+	targets := s.targets(e)
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		_ = s.deliver(e, targets)
+	}()
+}
+
+// deliver sends the given event to the given targets, signing it with the Stripe-Signature header. It returns an
+// error if any target couldn't receive it. It must be called without the lock held, as webhook handlers usually call
+// the API back.
+func (s *Server) deliver(e *stripe.Event, targets []target) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	var first error
+	for _, t := range targets {
+		d := Delivery{
+			Event: e.ID,
+			Type:  e.Type,
+			URL:   t.url,
+		}
+		d.StatusCode, d.Err = s.post(t, body)
+		if d.Err != nil && first == nil {
+			first = d.Err
+		}
+
+		s.lock.Lock()
+		s.deliveries = append(s.deliveries, d)
+		s.lock.Unlock()
+	}
+	return first
+}
+
+// post sends a signed event body to the given target.
+func (s *Server) post(t target, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Stripe-Signature", SignatureHeader(body, t.secret, s.signatureTime()))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("%s returned %d: %s", t.url, res.StatusCode, bytes.TrimSpace(msg))
+	}
+	return res.StatusCode, nil
+}
+
+// signatureTime returns the timestamp used to sign a delivery. Every delivery gets a later timestamp than the previous
+// one, as Stripe does when retrying events, so resent events don't share a signature.
+func (s *Server) signatureTime() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	t := s.now().Unix()
+	if t <= s.lastSigned {
+		t = s.lastSigned + 1
+	}
+	s.lastSigned = t
+	return time.Unix(t, 0)
+}
+
+// SignatureHeader returns the Stripe-Signature header of the given webhook event body, signed with the given secret at
+// the given time.
+func SignatureHeader(body []byte, secret string, t time.Time) string {
+	sig := webhook.ComputeSignature(t, body, secret)
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(sig))
+}